@@ -0,0 +1,450 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"sigma/config"
+	"sigma/handlers"
+	"sigma/migrations"
+	"sigma/models"
+	"sigma/utils"
+)
+
+// runDiagnose 诊断图片 URL 问题（生图后不显示图片、端口变化后图片失效等）
+func runDiagnose(args []string) error {
+	fs := newFlagSet("diagnose", "[-limit 10]")
+	limit := fs.Int("limit", 10, "列出最近记录的条数")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := openDB(true); err != nil {
+		return err
+	}
+
+	printHeader("图片 URL 诊断")
+	fmt.Println("输出目录:", config.OutputDir)
+	fmt.Println("上传目录:", config.UploadDir)
+	fmt.Println("后端端口:", config.ServerPort)
+	fmt.Println()
+
+	// URL 格式统计
+	var imageURLs []string
+	if err := config.DB.Model(&models.GenerationHistory{}).Where("image_url != ''").Pluck("image_url", &imageURLs).Error; err != nil {
+		return fmt.Errorf("查询历史记录失败: %w", err)
+	}
+	var relative, absolute int
+	ports := make(map[string]int)
+	for _, imageURL := range imageURLs {
+		if !isAbsoluteURL(imageURL) {
+			relative++
+			continue
+		}
+		absolute++
+		if u, err := url.Parse(imageURL); err == nil {
+			ports[u.Port()]++
+		}
+	}
+	fmt.Println("图片 URL 格式：")
+	fmt.Printf("  相对路径: %d\n", relative)
+	fmt.Printf("  完整 URL: %d\n", absolute)
+	for _, port := range sortedKeys(ports) {
+		label := port
+		if label == "" {
+			label = "默认"
+		}
+		fmt.Printf("    端口 %s: %d\n", label, ports[port])
+	}
+	fmt.Println()
+
+	// 最近记录的文件状态
+	var recent []models.GenerationHistory
+	config.DB.Where("image_url != ''").Order("created_at DESC").Limit(*limit).Find(&recent)
+	fmt.Printf("最近 %d 条记录的图片 URL 分析：\n", len(recent))
+	printRule()
+	for i, h := range recent {
+		fmt.Printf("\n记录 #%d (ID: %d)\n", i+1, h.ID)
+		fmt.Printf("  提示词: %s\n", truncate(h.Prompt, 50))
+		fmt.Printf("  创建时间: %s\n", h.CreatedAt.Format("2006-01-02 15:04:05"))
+		fmt.Printf("  图片 URL: %s\n", h.ImageURL)
+		if isAbsoluteURL(h.ImageURL) {
+			fmt.Println("  URL 类型: 完整 URL")
+		} else {
+			fmt.Println("  URL 类型: 相对路径")
+		}
+		path := filepath.Join(config.OutputDir, filepath.Base(utils.ToRelativePath(h.ImageURL)))
+		switch {
+		case h.ImageDeleted:
+			fmt.Println("  文件状态: 已删除")
+		case fileExists(path):
+			fmt.Printf("  文件状态: ✓ 存在 (%s)\n", path)
+		default:
+			fmt.Printf("  文件状态: ✗ 不存在 (%s)\n", path)
+		}
+	}
+	if len(recent) == 0 {
+		fmt.Println("没有找到历史记录")
+	}
+	fmt.Println()
+	printRule()
+
+	// 失效引用和孤立文件
+	report, err := handlers.BuildStorageReport()
+	if err != nil {
+		return fmt.Errorf("扫描存储失败: %w", err)
+	}
+	fmt.Println("\n存储检查：")
+	fmt.Printf("  失效引用（记录指向的文件不存在）: %d\n", report.Dangling.Count)
+	fmt.Printf("  孤立文件（没有记录引用）: %d (%s)\n", report.Orphans.Files, formatBytes(report.Orphans.Bytes))
+
+	fmt.Println("\n诊断建议：")
+	if absolute == 0 && report.Dangling.Count == 0 && report.Orphans.Files == 0 {
+		fmt.Println("  未发现问题")
+	}
+	if absolute > 0 {
+		fmt.Println("  - 存在完整 URL，端口变化后图片将无法显示，运行 sigma-admin fix-urls 转换为相对路径")
+	}
+	if report.Dangling.Count > 0 {
+		fmt.Println("  - 存在失效引用，运行 sigma-admin gc -repair-dangling 修复")
+	}
+	if report.Orphans.Files > 0 {
+		fmt.Println("  - 存在孤立文件，运行 sigma-admin gc -remove-orphans 清理")
+	}
+	return nil
+}
+
+// runFixURLs 将完整图片 URL 转换为相对路径（与数据库迁移 003 相同，用于迁移后又写入的旧格式数据）
+func runFixURLs(args []string) error {
+	fs := newFlagSet("fix-urls", "[-dry-run]")
+	dryRun := fs.Bool("dry-run", false, "只统计需要修改的记录，不修改数据库")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := openDB(true); err != nil {
+		return err
+	}
+
+	printHeader("图片 URL 修复")
+	fixed, err := migrations.FixImageURLs(config.DB, *dryRun)
+	if err != nil {
+		return fmt.Errorf("修复图片 URL 失败（已修复 %d 条）: %w", fixed, err)
+	}
+	if *dryRun {
+		fmt.Printf("需要修复的记录: %d（试运行，未修改数据库）\n", fixed)
+	} else {
+		fmt.Printf("✓ 已修复 %d 条记录\n", fixed)
+	}
+	return nil
+}
+
+// runRestore 从备份压缩包恢复，替换当前数据库内容
+func runRestore(args []string) error {
+	fs := newFlagSet("restore", "[-yes] <备份文件.zip>")
+	yes := fs.Bool("yes", false, "确认替换当前数据库和媒体文件")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errUsage
+	}
+	if !*yes {
+		return fmt.Errorf("恢复会替换当前数据库内容，请先停止后端服务，确认后加 -yes 参数重新运行")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err := openDB(false); err != nil {
+		return err
+	}
+	// 旧版本数据库先升级到当前版本，恢复时只复制备份中存在的列
+	if _, err := migrations.Run(config.DB, false); err != nil {
+		return fmt.Errorf("数据库迁移失败: %w", err)
+	}
+
+	printHeader("恢复备份")
+	result, err := handlers.RestoreBackup(f, info.Size())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("备份时间: %s（数据库版本 %d）\n", result.Manifest.CreatedAt.Local().Format("2006-01-02 15:04:05"), result.Manifest.SchemaVersion)
+	fmt.Printf("恢复文件: %d\n", result.Files)
+	fmt.Printf("转换路径的记录: %d\n", result.RewrittenRecords)
+	fmt.Println("恢复的表:")
+	for _, table := range sortedKeys(result.Tables) {
+		fmt.Printf("  %-24s %d\n", table, result.Tables[table])
+	}
+	fmt.Println("✓ 恢复完成")
+	return nil
+}
+
+// runMigrate 执行、试运行或查看数据库迁移
+func runMigrate(args []string) error {
+	fs := newFlagSet("migrate", "[-dry-run] [-status]")
+	dryRun := fs.Bool("dry-run", false, "执行待执行的迁移后回滚，检查迁移能否成功")
+	status := fs.Bool("status", false, "只列出已执行和待执行的迁移")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := openDB(false); err != nil {
+		return err
+	}
+
+	printHeader("数据库迁移")
+	if *status {
+		applied, err := migrations.Applied(config.DB)
+		if err != nil {
+			return err
+		}
+		pending, err := migrations.Pending(config.DB)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("已执行: %d 个\n", len(applied))
+		for _, m := range applied {
+			fmt.Printf("  %03d %-32s %s\n", m.Version, m.Name, m.AppliedAt.Local().Format("2006-01-02 15:04:05"))
+		}
+		fmt.Printf("待执行: %d 个\n", len(pending))
+		for _, m := range pending {
+			fmt.Printf("  %03d %s\n", m.Version, m.Name)
+		}
+		return nil
+	}
+
+	ran, err := migrations.Run(config.DB, *dryRun)
+	for _, m := range ran {
+		fmt.Printf("  ✓ %03d %s\n", m.Version, m.Name)
+	}
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(ran) == 0:
+		fmt.Printf("数据库已是最新版本: %d\n", migrations.Latest())
+	case *dryRun:
+		fmt.Printf("试运行成功，%d 个迁移可以执行（已回滚，未修改数据库）\n", len(ran))
+	default:
+		fmt.Printf("✓ 已执行 %d 个迁移，数据库版本: %d\n", len(ran), migrations.Latest())
+	}
+	return nil
+}
+
+// runGC 不带操作参数时输出存储占用报告，否则删除孤立文件、修复失效引用
+func runGC(args []string) error {
+	fs := newFlagSet("gc", "[-remove-orphans] [-repair-dangling] [-dry-run] [-min-age-hours 24]")
+	removeOrphans := fs.Bool("remove-orphans", false, "删除孤立文件")
+	repairDangling := fs.Bool("repair-dangling", false, "修复失效引用")
+	dryRun := fs.Bool("dry-run", false, "只统计将要执行的操作，不修改文件和数据库")
+	minAgeHours := fs.Int("min-age-hours", 24, "孤立文件的最短保留时间（小时）")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := openDB(true); err != nil {
+		return err
+	}
+
+	if !*removeOrphans && !*repairDangling {
+		printHeader("存储占用报告")
+		return printStorageReport()
+	}
+
+	printHeader("存储清理")
+	result, err := handlers.RunStorageGC(handlers.StorageGCRequest{
+		RemoveOrphans:  *removeOrphans,
+		RepairDangling: *repairDangling,
+		DryRun:         *dryRun,
+		MinAgeHours:    minAgeHours,
+	})
+	if err != nil {
+		return err
+	}
+	if *removeOrphans {
+		fmt.Printf("删除孤立文件: %d (%s)\n", result.RemovedFiles, formatBytes(result.ReclaimedBytes))
+		fmt.Printf("未到保留时间而保留: %d\n", result.SkippedRecent)
+	}
+	if *repairDangling {
+		fmt.Printf("修复历史记录: %d\n", result.RepairedHistories)
+		fmt.Printf("修复任务: %d\n", result.RepairedTasks)
+		fmt.Printf("删除失效的文件记录: %d\n", result.RemovedStoredFiles)
+	}
+	if *dryRun {
+		fmt.Println("（试运行，未修改文件和数据库）")
+	}
+	return nil
+}
+
+// printStorageReport 输出存储占用报告
+func printStorageReport() error {
+	report, err := handlers.BuildStorageReport()
+	if err != nil {
+		return fmt.Errorf("生成存储报告失败: %w", err)
+	}
+
+	fmt.Printf("总计: %d 个文件, %s\n", report.Total.Files, formatBytes(report.Total.Bytes))
+	for _, kind := range sortedKeys(report.ByKind) {
+		u := report.ByKind[kind]
+		fmt.Printf("  %-8s %6d 个文件 %10s（孤立 %d 个, %s）\n", kind, u.Files, formatBytes(u.Bytes), u.Orphaned.Files, formatBytes(u.Orphaned.Bytes))
+	}
+	fmt.Println("\n按生成类型：")
+	for _, u := range report.ByType {
+		fmt.Printf("  %-20s %6d 个文件 %10s\n", u.Type, u.Files, formatBytes(u.Bytes))
+	}
+	fmt.Println("\n按月份：")
+	for _, u := range report.ByMonth {
+		fmt.Printf("  %s %6d 个文件 %10s\n", u.Month, u.Files, formatBytes(u.Bytes))
+	}
+
+	fmt.Printf("\n孤立文件: %d (%s)\n", report.Orphans.Files, formatBytes(report.Orphans.Bytes))
+	for _, f := range report.Orphans.Items {
+		fmt.Printf("  %s %s %s\n", f.Path, formatBytes(f.Size), f.ModifiedAt.Local().Format("2006-01-02 15:04"))
+	}
+	fmt.Printf("失效引用: %d（失效的文件记录 %d）\n", report.Dangling.Count, report.Dangling.StoredFiles)
+	for _, d := range report.Dangling.Items {
+		fmt.Printf("  %s #%d %s: %s\n", d.Record, d.ID, d.Field, d.Path)
+	}
+	return nil
+}
+
+// runBackup 备份数据库和媒体文件
+func runBackup(args []string) error {
+	fs := newFlagSet("backup", "[-o 备份文件.zip]")
+	output := fs.String("o", "", "备份文件路径，默认为当前目录下的 sigma-backup-<时间>.zip")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := openDB(true); err != nil {
+		return err
+	}
+	path := *output
+	if path == "" {
+		path = fmt.Sprintf("sigma-backup-%s.zip", time.Now().Format("20060102-150405"))
+	}
+
+	printHeader("备份")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	manifest, err := handlers.WriteBackup(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("备份失败: %w", err)
+	}
+
+	for _, dir := range sortedKeys(manifest.Files) {
+		fmt.Printf("  %-8s %d 个文件\n", dir, manifest.Files[dir])
+	}
+	if info, err := os.Stat(path); err == nil {
+		fmt.Printf("✓ 已备份到 %s (%s)\n", path, formatBytes(info.Size()))
+	}
+	return nil
+}
+
+// runStats 输出历史记录、任务和存储统计
+func runStats(args []string) error {
+	fs := newFlagSet("stats", "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := openDB(true); err != nil {
+		return err
+	}
+
+	printHeader("统计")
+
+	var total, favorites, trashed, imageDeleted int64
+	config.DB.Model(&models.GenerationHistory{}).Count(&total)
+	config.DB.Model(&models.GenerationHistory{}).Where("favorite = ?", true).Count(&favorites)
+	config.DB.Model(&models.GenerationHistory{}).Where("trashed_at IS NOT NULL").Count(&trashed)
+	config.DB.Model(&models.GenerationHistory{}).Where("image_deleted = ?", true).Count(&imageDeleted)
+	fmt.Printf("历史记录: %d（收藏 %d，回收站 %d，图片已删除 %d）\n", total, favorites, trashed, imageDeleted)
+
+	type groupCount struct {
+		Name  string
+		Count int64
+	}
+	var byType []groupCount
+	config.DB.Model(&models.GenerationHistory{}).Select("type AS name, COUNT(*) AS count").
+		Group("type").Order("count DESC").Scan(&byType)
+	for _, g := range byType {
+		fmt.Printf("  %-20s %d\n", g.Name, g.Count)
+	}
+
+	var byStatus []groupCount
+	config.DB.Model(&models.GenerationTask{}).Select("status AS name, COUNT(*) AS count").
+		Group("status").Order("count DESC").Scan(&byStatus)
+	var tasks int64
+	for _, g := range byStatus {
+		tasks += g.Count
+	}
+	fmt.Printf("\n生成任务: %d\n", tasks)
+	for _, g := range byStatus {
+		fmt.Printf("  %-20s %d\n", g.Name, g.Count)
+	}
+
+	summary, err := handlers.BuildStatsSummary(nil, nil, "type")
+	if err != nil {
+		return fmt.Errorf("统计任务失败: %w", err)
+	}
+	fmt.Printf("成功率: %.1f%%，平均耗时: %.1f 秒\n", summary.Totals.SuccessRate*100, float64(summary.Totals.AvgLatencyMs)/1000)
+
+	generated, err := handlers.CountGeneratedImages()
+	if err != nil {
+		return fmt.Errorf("统计生成计数失败: %w", err)
+	}
+	fmt.Printf("\n累计生成张数（4K 计为 2 张）: %d\n", generated)
+
+	report, err := handlers.BuildStorageReport()
+	if err != nil {
+		return fmt.Errorf("扫描存储失败: %w", err)
+	}
+	fmt.Printf("存储占用: %d 个文件, %s（孤立 %d 个, %s）\n", report.Total.Files, formatBytes(report.Total.Bytes),
+		report.Orphans.Files, formatBytes(report.Orphans.Bytes))
+	return nil
+}
+
+// isAbsoluteURL 是否为带协议和主机的完整 URL
+func isAbsoluteURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// fileExists 文件是否存在
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// truncate 截断过长的文本（按字符）
+func truncate(s string, maxLen int) string {
+	runes := []rune(s)
+	if len(runes) <= maxLen {
+		return s
+	}
+	return string(runes[:maxLen]) + "..."
+}
+
+// sortedKeys 按字母顺序返回 map 的键
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,156 @@
+// sigma-admin 数据库和存储维护命令
+//
+// 与后端使用相同的配置（环境变量 OUTPUT_DIR、UPLOAD_DIR、TRASH_DIR、DB_PATH 等），
+// 修改数据库的命令执行前请先停止后端服务并备份数据库。
+//
+//	go run ./cmd/sigma-admin <命令> [参数]
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"sigma/config"
+	"sigma/migrations"
+)
+
+// command 一个子命令
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"diagnose", "诊断图片 URL 格式、缺失的文件和存储占用", runDiagnose},
+	{"fix-urls", "将历史记录中的完整图片 URL 转换为相对路径", runFixURLs},
+	{"restore", "从备份压缩包恢复数据库和媒体文件", runRestore},
+	{"migrate", "执行或检查数据库迁移", runMigrate},
+	{"gc", "存储占用报告，删除孤立文件、修复失效引用", runGC},
+	{"backup", "备份数据库和媒体文件到压缩包", runBackup},
+	{"stats", "历史记录、任务和存储统计", runStats},
+}
+
+// errUsage 参数错误，已输出用法
+var errUsage = errors.New("参数错误")
+
+func main() {
+	config.Init()
+
+	// 全局参数覆盖环境变量中的路径
+	flag.StringVar(&config.DBPath, "db", config.DBPath, "数据库路径（DB_PATH）")
+	flag.StringVar(&config.OutputDir, "output", config.OutputDir, "输出目录（OUTPUT_DIR）")
+	flag.StringVar(&config.UploadDir, "uploads", config.UploadDir, "上传目录（UPLOAD_DIR）")
+	flag.StringVar(&config.TrashDir, "trash", config.TrashDir, "回收站目录（TRASH_DIR）")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	name := flag.Arg(0)
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		if err := cmd.run(flag.Args()[1:]); err != nil {
+			if errors.Is(err, errUsage) || errors.Is(err, flag.ErrHelp) {
+				os.Exit(2)
+			}
+			fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "未知命令: %s\n\n", name)
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintln(out, "用法: sigma-admin [全局参数] <命令> [参数]")
+	fmt.Fprintln(out, "\n命令:")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(out, "\n全局参数:")
+	flag.PrintDefaults()
+	fmt.Fprintln(out, "\n查看命令参数: sigma-admin <命令> -h")
+}
+
+// newFlagSet 创建子命令的参数集
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "用法: sigma-admin %s %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// openDB 打开数据库
+// 数据库文件不存在时报错（避免路径错误时创建空数据库）；requireMigrated 时要求数据库已是最新版本
+func openDB(requireMigrated bool) error {
+	if _, err := os.Stat(config.DBPath); err != nil {
+		return fmt.Errorf("数据库 %s 不存在: %w", config.DBPath, err)
+	}
+
+	var err error
+	config.DB, err = gorm.Open(sqlite.Open(config.DBPath), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return fmt.Errorf("无法连接数据库: %w", err)
+	}
+	if !requireMigrated {
+		return nil
+	}
+
+	pending, err := migrations.Pending(config.DB)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("数据库有 %d 个待执行的迁移，请先运行 sigma-admin migrate", len(pending))
+	}
+	// 读取数据库中的配置（端口转换等依赖当前配置）
+	if err := config.LoadPersistentConfig(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// formatBytes 以易读的单位显示字节数
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGT"[exp])
+}
+
+// printHeader 输出命令标题
+func printHeader(title string) {
+	fmt.Printf("=== %s ===\n", title)
+	fmt.Println("数据库路径:", config.DBPath)
+	fmt.Println()
+}
+
+// printRule 输出分隔线
+func printRule() {
+	fmt.Println(strings.Repeat("-", 80))
+}
@@ -51,9 +51,18 @@ var (
 	// UploadDir 上传目录
 	UploadDir string
 
+	// TrashDir 回收站目录（删除的图片先移到这里，保留期后清除）
+	TrashDir string
+
+	// TrashRetentionDays 回收站保留天数
+	TrashRetentionDays int
+
 	// DBPath 数据库路径
 	DBPath string
 
+	// MigrateDryRun 只检查待执行的数据库迁移（执行后回滚），不启动服务
+	MigrateDryRun bool
+
 	// ServerPort 服务器端口（配置值）
 	ServerPort string
 
@@ -83,8 +92,62 @@ var (
 
 	// SensitiveKeywords 敏感关键词列表（用于过滤错误信息）
 	SensitiveKeywords []string
+
+	// RetryMaxAttempts AI 调用最大尝试次数（含首次）
+	RetryMaxAttempts int
+
+	// RetryBaseDelay 重试退避基础间隔
+	RetryBaseDelay time.Duration
+
+	// RetryMaxDelay 重试退避最大间隔
+	RetryMaxDelay time.Duration
+
+	// WorkerPoolSize 生成任务工作池大小（全局并发上限）
+	WorkerPoolSize int
+
+	// TypeConcurrencyLimits 按生成类型的并发上限（未配置的类型只受全局上限约束）
+	TypeConcurrencyLimits map[string]int
+
+	// LedgerBalanceSnapshots 生成前后查询余额并记录到消耗台账
+	LedgerBalanceSnapshots bool
+
+	// MaxImageCount 单次请求最多生成的图片数量
+	MaxImageCount int
+
+	// BudgetUnit 预算计量单位：images（按张数）或 credits（按消耗，4K 图片计为 2）
+	BudgetUnit string
+
+	// BudgetDailyLimit 每日预算（0 表示不限）
+	BudgetDailyLimit int
+
+	// BudgetMonthlyLimit 每月预算（0 表示不限）
+	BudgetMonthlyLimit int
+
+	// BudgetTypeDailyLimits 按生成类型的每日预算
+	BudgetTypeDailyLimits map[string]int
+
+	// BudgetTypeMonthlyLimits 按生成类型的每月预算
+	BudgetTypeMonthlyLimits map[string]int
+
+	// BudgetWarnPercent 预算使用达到该百分比时在配置检查中提示
+	BudgetWarnPercent int
 )
 
+// DefaultMaxImageCount 单次请求最多生成图片数量的默认值
+const DefaultMaxImageCount = 50
+
+// DefaultTrashRetentionDays 回收站保留天数的默认值
+const DefaultTrashRetentionDays = 30
+
+// 预算计量单位
+const (
+	BudgetUnitImages  = "images"
+	BudgetUnitCredits = "credits"
+)
+
+// DefaultBudgetWarnPercent 预算提示阈值的默认值
+const DefaultBudgetWarnPercent = 80
+
 // AppConfig 应用配置数据库模型
 type AppConfig struct {
 	ID             uint   `gorm:"primaryKey"`
@@ -206,13 +269,20 @@ func Init() {
 	UploadDir = utils.GetEnvOrDefault("UPLOAD_DIR", "./uploads")
 	DBPath = utils.GetEnvOrDefault("DB_PATH", "./history.db")
 	ServerPort = utils.GetEnvOrDefault("PORT", "8080")
+	// 回收站默认与输出目录同级
+	TrashDir = utils.GetEnvOrDefault("TRASH_DIR", filepath.Join(filepath.Dir(filepath.Clean(OutputDir)), "trash"))
 
 	configLog("环境变量配置:")
 	configLog("  OUTPUT_DIR: %s (env: %s)", OutputDir, os.Getenv("OUTPUT_DIR"))
 	configLog("  UPLOAD_DIR: %s (env: %s)", UploadDir, os.Getenv("UPLOAD_DIR"))
+	configLog("  TRASH_DIR: %s (env: %s)", TrashDir, os.Getenv("TRASH_DIR"))
 	configLog("  DB_PATH: %s (env: %s)", DBPath, os.Getenv("DB_PATH"))
+
 	configLog("  PORT: %s (env: %s)", ServerPort, os.Getenv("PORT"))
 
+	migrateDryRunStr := utils.GetEnvOrDefault("MIGRATE_DRY_RUN", "false")
+	MigrateDryRun = migrateDryRunStr == "true" || migrateDryRunStr == "1"
+
 	// 先从环境变量读取（作为默认值）
 	envAPIKey := os.Getenv("API_KEY")
 	APIToken = envAPIKey
@@ -260,11 +330,87 @@ func Init() {
 	// 默认平台为 VectorEngine
 	APIPlatform = PlatformVectorEngine
 
+	// AI 调用重试策略
+	RetryMaxAttempts = utils.GetEnvIntOrDefault("AI_RETRY_MAX_ATTEMPTS", 3)
+	if RetryMaxAttempts < 1 {
+		RetryMaxAttempts = 1
+	}
+	RetryBaseDelay = time.Duration(utils.GetEnvIntOrDefault("AI_RETRY_BASE_DELAY_MS", 2000)) * time.Millisecond
+	RetryMaxDelay = time.Duration(utils.GetEnvIntOrDefault("AI_RETRY_MAX_DELAY_MS", 30000)) * time.Millisecond
+	configLog("AI 重试策略: 最多 %d 次, 基础间隔 %v, 最大间隔 %v", RetryMaxAttempts, RetryBaseDelay, RetryMaxDelay)
+
+	// 任务队列工作池
+	WorkerPoolSize = utils.GetEnvIntOrDefault("WORKER_POOL_SIZE", 4)
+	if WorkerPoolSize < 1 {
+		WorkerPoolSize = 1
+	}
+	TypeConcurrencyLimits = parseTypeLimits(os.Getenv("QUEUE_TYPE_LIMITS"))
+	configLog("任务队列: 工作池 %d, 类型并发上限 %v", WorkerPoolSize, TypeConcurrencyLimits)
+
+	// 单次请求图片数量上限（多图批次拆分为子任务经工作池执行）
+	MaxImageCount = utils.GetEnvIntOrDefault("MAX_IMAGE_COUNT", DefaultMaxImageCount)
+	if MaxImageCount < 1 {
+		MaxImageCount = 1
+	}
+	configLog("单次请求图片数量上限: %d", MaxImageCount)
+
+	// 回收站保留天数（0 表示下次清理时立即清除）
+	TrashRetentionDays = utils.GetEnvIntOrDefault("TRASH_RETENTION_DAYS", DefaultTrashRetentionDays)
+	if TrashRetentionDays < 0 {
+		TrashRetentionDays = 0
+	}
+	configLog("回收站保留天数: %d", TrashRetentionDays)
+
+	// 消耗台账余额快照（每个任务额外查询两次余额，默认关闭）
+	ledgerSnapshotStr := utils.GetEnvOrDefault("LEDGER_BALANCE_SNAPSHOTS", "false")
+	LedgerBalanceSnapshots = ledgerSnapshotStr == "true" || ledgerSnapshotStr == "1"
+	configLog("消耗台账余额快照: %v", LedgerBalanceSnapshots)
+
+	// 预算限制（0 表示不限）
+	BudgetUnit = utils.GetEnvOrDefault("BUDGET_UNIT", BudgetUnitImages)
+	if BudgetUnit != BudgetUnitImages && BudgetUnit != BudgetUnitCredits {
+		configLog("无效的预算计量单位 %q，使用 %s", BudgetUnit, BudgetUnitImages)
+		BudgetUnit = BudgetUnitImages
+	}
+	BudgetDailyLimit = utils.GetEnvIntOrDefault("BUDGET_DAILY_LIMIT", 0)
+	if BudgetDailyLimit < 0 {
+		BudgetDailyLimit = 0
+	}
+	BudgetMonthlyLimit = utils.GetEnvIntOrDefault("BUDGET_MONTHLY_LIMIT", 0)
+	if BudgetMonthlyLimit < 0 {
+		BudgetMonthlyLimit = 0
+	}
+	BudgetTypeDailyLimits = parseTypeLimits(os.Getenv("BUDGET_TYPE_DAILY_LIMITS"))
+	BudgetTypeMonthlyLimits = parseTypeLimits(os.Getenv("BUDGET_TYPE_MONTHLY_LIMITS"))
+	BudgetWarnPercent = utils.GetEnvIntOrDefault("BUDGET_WARN_PERCENT", DefaultBudgetWarnPercent)
+	if BudgetWarnPercent < 1 || BudgetWarnPercent > 100 {
+		BudgetWarnPercent = DefaultBudgetWarnPercent
+	}
+	configLog("预算限制(%s): 每日 %d, 每月 %d, 类型每日 %v, 类型每月 %v, 提示阈值 %d%%",
+		BudgetUnit, BudgetDailyLimit, BudgetMonthlyLimit, BudgetTypeDailyLimits, BudgetTypeMonthlyLimits, BudgetWarnPercent)
+
 	configLog("========================================")
 	configLog("配置初始化完成")
 	configLog("========================================")
 }
 
+// parseTypeLimits 解析 "create=2,white_background=1" 格式的类型并发上限
+func parseTypeLimits(value string) map[string]int {
+	limits := make(map[string]int)
+	for _, item := range strings.Split(value, ",") {
+		key, limitStr, found := strings.Cut(strings.TrimSpace(item), "=")
+		if !found {
+			continue
+		}
+		var limit int
+		if _, err := fmt.Sscanf(strings.TrimSpace(limitStr), "%d", &limit); err != nil || limit < 1 {
+			continue
+		}
+		limits[strings.TrimSpace(key)] = limit
+	}
+	return limits
+}
+
 // maskAPIKey 遮蔽 API Key 用于日志显示
 func maskAPIKey(key string) string {
 	if key == "" {
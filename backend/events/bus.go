@@ -0,0 +1,140 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// 任务生命周期事件类型
+const (
+	TaskQueued    = "task.queued"    // 任务已入队
+	TaskStarted   = "task.started"   // 任务开始处理
+	TaskImage     = "task.image"     // 任务（或批次内某张）图片已保存
+	TaskCompleted = "task.completed" // 任务完成
+	TaskFailed    = "task.failed"    // 任务失败
+	TaskCancelled = "task.cancelled" // 任务已取消
+
+	// Resync 客户端请求的 Last-Event-ID 已不在缓冲区内（或服务已重启），需要重新拉取任务列表
+	Resync = "resync"
+)
+
+// DefaultBufferSize 默认保留的历史事件数量，用于断线重连后补发
+const DefaultBufferSize = 1000
+
+// subscriberBuffer 每个订阅者的通道缓冲大小
+// 订阅者消费过慢导致缓冲写满时会被断开，客户端可携带 Last-Event-ID 重连补发
+const subscriberBuffer = 64
+
+// Event 一条任务事件
+type Event struct {
+	ID     int64       `json:"id"`
+	Type   string      `json:"type"`
+	TaskID string      `json:"task_id"`
+	Data   interface{} `json:"data,omitempty"`
+	At     time.Time   `json:"at"`
+}
+
+// Bus 进程内事件总线
+// 事件按发布顺序分配递增 ID，并保存在环形缓冲区中，支持按 Last-Event-ID 补发
+type Bus struct {
+	mu     sync.Mutex
+	nextID int64
+	buffer []Event // 环形缓冲区
+	start  int     // 最早事件在 buffer 中的位置
+	count  int
+	subs   map[chan Event]struct{}
+}
+
+// NewBus 创建事件总线，size 为保留的历史事件数量
+func NewBus(size int) *Bus {
+	if size < 1 {
+		size = DefaultBufferSize
+	}
+	return &Bus{
+		nextID: 1,
+		buffer: make([]Event, size),
+		subs:   make(map[chan Event]struct{}),
+	}
+}
+
+var defaultBus = NewBus(DefaultBufferSize)
+
+// Default 返回全局事件总线
+func Default() *Bus {
+	return defaultBus
+}
+
+// Publish 发布事件到全局事件总线
+func Publish(eventType, taskID string, data interface{}) Event {
+	return defaultBus.Publish(eventType, taskID, data)
+}
+
+// Publish 发布事件并推送给所有订阅者，返回带 ID 的事件
+func (b *Bus) Publish(eventType, taskID string, data interface{}) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	event := Event{
+		ID:     b.nextID,
+		Type:   eventType,
+		TaskID: taskID,
+		Data:   data,
+		At:     time.Now(),
+	}
+	b.nextID++
+
+	// 写入环形缓冲区，满时覆盖最早的事件
+	pos := (b.start + b.count) % len(b.buffer)
+	b.buffer[pos] = event
+	if b.count < len(b.buffer) {
+		b.count++
+	} else {
+		b.start = (b.start + 1) % len(b.buffer)
+	}
+
+	for ch := range b.subs {
+		select {
+		case ch <- event:
+		default:
+			// 订阅者跟不上，断开后由客户端重连补发
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+	return event
+}
+
+// Subscribe 订阅事件
+// lastID 为客户端已收到的最后一个事件 ID（0 表示不补发），返回需要补发的事件、
+// 是否需要全量同步（lastID 早于缓冲区或晚于当前最新事件），以及实时事件通道。
+// 通道被关闭表示订阅已断开；调用方结束时必须调用 unsubscribe。
+func (b *Bus) Subscribe(lastID int64) (backlog []Event, resync bool, ch <-chan Event, unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if lastID > 0 {
+		oldest := b.nextID - int64(b.count)
+		switch {
+		case lastID >= b.nextID, lastID < oldest-1:
+			resync = true
+		default:
+			for i := 0; i < b.count; i++ {
+				event := b.buffer[(b.start+i)%len(b.buffer)]
+				if event.ID > lastID {
+					backlog = append(backlog, event)
+				}
+			}
+		}
+	}
+
+	sub := make(chan Event, subscriberBuffer)
+	b.subs[sub] = struct{}{}
+	return backlog, resync, sub, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[sub]; ok {
+			delete(b.subs, sub)
+			close(sub)
+		}
+	}
+}
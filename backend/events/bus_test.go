@@ -0,0 +1,75 @@
+package events
+
+import (
+	"testing"
+)
+
+func TestBus_ResumeFromLastEventID(t *testing.T) {
+	bus := NewBus(10)
+	for i := 0; i < 5; i++ {
+		bus.Publish(TaskQueued, "task", nil)
+	}
+
+	backlog, resync, _, unsubscribe := bus.Subscribe(3)
+	defer unsubscribe()
+	if resync {
+		t.Fatal("缓冲区内的 Last-Event-ID 不应要求全量同步")
+	}
+	if len(backlog) != 2 || backlog[0].ID != 4 || backlog[1].ID != 5 {
+		t.Errorf("补发事件不正确: %+v", backlog)
+	}
+
+	backlog, resync, _, unsubscribe2 := bus.Subscribe(5)
+	defer unsubscribe2()
+	if resync || len(backlog) != 0 {
+		t.Errorf("已是最新时不应补发，实际 resync=%v backlog=%d", resync, len(backlog))
+	}
+}
+
+func TestBus_ResyncWhenOutOfBuffer(t *testing.T) {
+	bus := NewBus(3)
+	for i := 0; i < 10; i++ {
+		bus.Publish(TaskQueued, "task", nil)
+	}
+
+	// 事件 4-7 已被覆盖
+	if _, resync, _, unsubscribe := bus.Subscribe(3); !resync {
+		t.Error("过旧的 Last-Event-ID 应要求全量同步")
+	} else {
+		unsubscribe()
+	}
+
+	// 服务重启后 ID 重新计数，客户端的 ID 比当前最新事件还大
+	if _, resync, _, unsubscribe := bus.Subscribe(42); !resync {
+		t.Error("超前的 Last-Event-ID 应要求全量同步")
+	} else {
+		unsubscribe()
+	}
+}
+
+func TestBus_LiveDeliveryAndSlowSubscriber(t *testing.T) {
+	bus := NewBus(DefaultBufferSize)
+	_, _, ch, unsubscribe := bus.Subscribe(0)
+	defer unsubscribe()
+
+	published := bus.Publish(TaskCompleted, "task-1", map[string]string{"image_url": "x"})
+	got := <-ch
+	if got.ID != published.ID || got.Type != TaskCompleted || got.TaskID != "task-1" {
+		t.Errorf("收到的事件不正确: %+v", got)
+	}
+
+	// 不消费的订阅者在缓冲写满后被断开
+	for i := 0; i < subscriberBuffer+1; i++ {
+		bus.Publish(TaskQueued, "task", nil)
+	}
+	closed := false
+	for range subscriberBuffer + 1 {
+		if _, ok := <-ch; !ok {
+			closed = true
+			break
+		}
+	}
+	if !closed {
+		t.Error("缓冲写满的订阅者应被断开")
+	}
+}
@@ -0,0 +1,512 @@
+package handlers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"sigma/config"
+	"sigma/migrations"
+	"sigma/models"
+	"sigma/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// backupFormatVersion 备份压缩包的格式版本
+const backupFormatVersion = 1
+
+// 备份压缩包内的文件
+const (
+	backupManifestName = "backup.json"
+	backupDBName       = "history.db"
+)
+
+// backupMediaDirs 压缩包内的媒体目录 -> 本机目录（恢复时写入当前配置的目录）
+func backupMediaDirs() map[string]string {
+	return map[string]string{
+		"output/":  config.OutputDir,
+		"uploads/": config.UploadDir,
+		"trash/":   config.TrashDir,
+	}
+}
+
+// backupMu 备份和恢复互斥执行
+var backupMu sync.Mutex
+
+// ErrInvalidBackup 备份文件无效或版本不兼容
+var ErrInvalidBackup = errors.New("无效的备份文件")
+
+// ErrRestoreTasksActive 有排队或执行中的任务，不能恢复
+var ErrRestoreTasksActive = errors.New("有排队或执行中的任务，请等待任务完成或取消后再恢复")
+
+// BackupManifest 备份信息（压缩包中的 backup.json）
+type BackupManifest struct {
+	FormatVersion int            `json:"format_version"`
+	SchemaVersion int            `json:"schema_version"`
+	CreatedAt     time.Time      `json:"created_at"`
+	OutputDir     string         `json:"output_dir"` // 备份时的目录，恢复时用于转换旧数据中的文件路径
+	UploadDir     string         `json:"upload_dir"`
+	TrashDir      string         `json:"trash_dir"`
+	Files         map[string]int `json:"files"` // 各媒体目录的文件数
+}
+
+// RestoreResult 恢复结果
+type RestoreResult struct {
+	Manifest         *BackupManifest  `json:"manifest"`
+	Tables           map[string]int64 `json:"tables"` // 恢复的表 -> 记录数
+	Files            int              `json:"files"`
+	RewrittenRecords int64            `json:"rewritten_records"` // 图片路径转换为相对路径的记录数
+}
+
+// WriteBackup 将数据库快照和媒体目录写入 zip
+// 数据库使用 VACUUM INTO 生成一致的快照，不影响正在进行的读写
+func WriteBackup(w io.Writer) (*BackupManifest, error) {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+
+	tmpDir, err := os.MkdirTemp("", "sigma-backup-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	snapshot := filepath.Join(tmpDir, backupDBName)
+	if err := config.DB.Exec("VACUUM INTO ?", snapshot).Error; err != nil {
+		return nil, fmt.Errorf("生成数据库快照失败: %w", err)
+	}
+
+	manifest := &BackupManifest{
+		FormatVersion: backupFormatVersion,
+		SchemaVersion: migrations.Latest(),
+		CreatedAt:     time.Now(),
+		OutputDir:     config.OutputDir,
+		UploadDir:     config.UploadDir,
+		TrashDir:      config.TrashDir,
+		Files:         make(map[string]int),
+	}
+
+	zw := zip.NewWriter(w)
+	if _, err := addExportFile(zw, backupDBName, snapshot); err != nil {
+		return nil, err
+	}
+	for prefix, dir := range backupMediaDirs() {
+		entries, err := os.ReadDir(dir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		for _, entry := range entries {
+			// 跳过子目录和写入中的临时文件
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".tmp-") {
+				continue
+			}
+			found, err := addExportFile(zw, prefix+entry.Name(), filepath.Join(dir, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+			if found {
+				manifest.Files[strings.TrimSuffix(prefix, "/")]++
+			}
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := addExportData(zw, backupManifestName, data); err != nil {
+		return nil, err
+	}
+	return manifest, zw.Close()
+}
+
+// readBackupManifest 读取并校验备份信息
+func readBackupManifest(zr *zip.Reader) (*BackupManifest, error) {
+	f, err := zr.Open(backupManifestName)
+	if err != nil {
+		return nil, fmt.Errorf("%w: 缺少 %s", ErrInvalidBackup, backupManifestName)
+	}
+	defer f.Close()
+
+	var manifest BackupManifest
+	if err := json.NewDecoder(f).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("%w: 无法解析 %s", ErrInvalidBackup, backupManifestName)
+	}
+	if manifest.FormatVersion != backupFormatVersion {
+		return nil, fmt.Errorf("%w: 不支持的备份格式版本 %d", ErrInvalidBackup, manifest.FormatVersion)
+	}
+	// 较低版本的备份在恢复前执行之后的迁移（见 migrateBackupSnapshot）
+	if manifest.SchemaVersion > migrations.Latest() {
+		return nil, fmt.Errorf("%w: 备份的数据库版本 %d 高于当前版本 %d，请先升级程序", ErrInvalidBackup, manifest.SchemaVersion, migrations.Latest())
+	}
+	return &manifest, nil
+}
+
+// RestoreBackup 从备份恢复数据库和媒体文件
+// 媒体文件写入当前配置的输出、上传和回收站目录（已有的同名文件被覆盖）；数据库中所有表的内容替换为备份中的内容，
+// 较旧备份的数据库先执行之后的迁移再复制；旧数据中的完整 URL 和备份机器上的文件路径转换为相对路径
+func RestoreBackup(r io.ReaderAt, size int64) (*RestoreResult, error) {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	manifest, err := readBackupManifest(zr)
+	if err != nil {
+		return nil, err
+	}
+
+	var active int64
+	config.DB.Model(&models.GenerationTask{}).
+		Where("status IN ?", []models.TaskStatus{models.TaskStatusQueued, models.TaskStatusProcessing}).
+		Count(&active)
+	if active > 0 {
+		return nil, ErrRestoreTasksActive
+	}
+
+	tmpDir, err := os.MkdirTemp("", "sigma-restore-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	snapshot := filepath.Join(tmpDir, backupDBName)
+	if err := extractZipFile(zr, backupDBName, snapshot); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: 缺少 %s", ErrInvalidBackup, backupDBName)
+		}
+		return nil, err
+	}
+
+	if err := migrateBackupSnapshot(snapshot); err != nil {
+		return nil, err
+	}
+
+	result := &RestoreResult{Manifest: manifest}
+
+	// 先检查全部媒体文件名，再写入媒体文件：数据库恢复失败时多出的文件只是孤立文件，可由存储清理回收
+	type mediaFile struct{ name, dst string }
+	var media []mediaFile
+	mediaDirs := backupMediaDirs()
+	for _, f := range zr.File {
+		dir, name := path.Split(f.Name)
+		target, ok := mediaDirs[dir]
+		if !ok || f.FileInfo().IsDir() {
+			continue
+		}
+		if !safeBackupFileName(name) {
+			return nil, fmt.Errorf("%w: 不安全的文件名 %q", ErrInvalidBackup, f.Name)
+		}
+		media = append(media, mediaFile{name: f.Name, dst: filepath.Join(target, name)})
+	}
+	for _, m := range media {
+		if err := os.MkdirAll(filepath.Dir(m.dst), 0755); err != nil {
+			return nil, err
+		}
+		if err := extractZipFile(zr, m.name, m.dst); err != nil {
+			return nil, err
+		}
+		result.Files++
+	}
+
+	if result.Tables, err = restoreDatabase(snapshot); err != nil {
+		return nil, err
+	}
+
+	if result.RewrittenRecords, err = rewriteRestoredPaths(manifest); err != nil {
+		return nil, err
+	}
+
+	// 恢复后的数据库可能缺少内置类型和模板，重新写入并刷新依赖数据库的状态
+	if err := SeedGenerationTypes(); err != nil {
+		utils.LogAPI("恢复后写入内置生成类型失败: %v", err)
+	}
+	if err := SeedPromptTemplates(); err != nil {
+		utils.LogAPI("恢复后写入内置提示词模板失败: %v", err)
+	}
+	if err := config.LoadPersistentConfig(); err != nil {
+		utils.LogAPI("恢复后加载配置失败: %v", err)
+	}
+	if historySearchFTS {
+		if err := config.DB.Exec("INSERT INTO history_fts(history_fts) VALUES ('rebuild')").Error; err != nil {
+			utils.LogAPI("恢复后重建全文索引失败: %v", err)
+		}
+	}
+	return result, nil
+}
+
+// safeBackupFileName 媒体文件名是否只是单个文件名
+// 拒绝 ..、带反斜杠或盘符的名称，避免在 Windows 上写到目标目录之外
+func safeBackupFileName(name string) bool {
+	return name != "" && filepath.IsLocal(name) && !strings.ContainsAny(name, `\/:`) && filepath.Base(name) == name
+}
+
+// extractZipFile 将压缩包中的文件写入 dst
+func extractZipFile(zr *zip.Reader, name, dst string) error {
+	src, err := zr.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, src)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// migrateBackupSnapshot 对备份的数据库快照执行尚未执行的迁移（含数据转换），升级到当前版本后再复制
+func migrateBackupSnapshot(snapshot string) error {
+	db, err := gorm.Open(sqlite.Open(snapshot), &gorm.Config{})
+	if err != nil {
+		return fmt.Errorf("%w: 无法打开备份数据库: %v", ErrInvalidBackup, err)
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+	if _, err := migrations.Run(db, false); err != nil {
+		return fmt.Errorf("%w: 升级备份数据库失败: %v", ErrInvalidBackup, err)
+	}
+	return nil
+}
+
+// restorableTable 是否恢复该表
+// SQLite 内部表、全文索引表（恢复后重建）和迁移记录（保持当前数据库的结构版本）除外
+func restorableTable(name string) bool {
+	return !strings.HasPrefix(name, "sqlite_") && !strings.HasPrefix(name, "history_fts") && name != "schema_migrations"
+}
+
+// restoreDatabase 用快照中的数据替换当前数据库各表的内容，返回每个表恢复的记录数
+// 在同一个连接上附加快照数据库并在一个事务中完成，失败时不修改当前数据
+func restoreDatabase(snapshot string) (map[string]int64, error) {
+	tables := make(map[string]int64)
+	err := config.DB.Connection(func(conn *gorm.DB) error {
+		if err := conn.Exec("ATTACH DATABASE ? AS backup_src", snapshot).Error; err != nil {
+			return fmt.Errorf("%w: 无法打开备份数据库: %v", ErrInvalidBackup, err)
+		}
+		defer conn.Exec("DETACH DATABASE backup_src")
+
+		var check string
+		if err := conn.Raw("PRAGMA backup_src.quick_check").Scan(&check).Error; err != nil || check != "ok" {
+			return fmt.Errorf("%w: 备份数据库已损坏", ErrInvalidBackup)
+		}
+
+		var srcTables, dstTables []string
+		conn.Raw("SELECT name FROM backup_src.sqlite_master WHERE type = 'table'").Scan(&srcTables)
+		conn.Raw("SELECT name FROM main.sqlite_master WHERE type = 'table'").Scan(&dstTables)
+		inBackup := make(map[string]bool, len(srcTables))
+		for _, name := range srcTables {
+			inBackup[name] = true
+		}
+		if !inBackup["generation_histories"] {
+			return fmt.Errorf("%w: 备份数据库中没有历史记录表", ErrInvalidBackup)
+		}
+
+		return conn.Transaction(func(tx *gorm.DB) error {
+			for _, table := range dstTables {
+				if !restorableTable(table) {
+					continue
+				}
+				if err := tx.Exec(fmt.Sprintf(`DELETE FROM main."%s"`, table)).Error; err != nil {
+					return err
+				}
+				if !inBackup[table] {
+					continue
+				}
+
+				columns, err := commonColumns(tx, table)
+				if err != nil {
+					return err
+				}
+				if len(columns) == 0 {
+					continue
+				}
+				list := `"` + strings.Join(columns, `", "`) + `"`
+				result := tx.Exec(fmt.Sprintf(`INSERT INTO main."%s" (%s) SELECT %s FROM backup_src."%s"`, table, list, list, table))
+				if result.Error != nil {
+					return fmt.Errorf("恢复表 %s 失败: %w", table, result.Error)
+				}
+				tables[table] = result.RowsAffected
+			}
+			return cancelRestoredTasks(tx)
+		})
+	})
+	return tables, err
+}
+
+// restoredTaskCancelledMessage 备份中未完成的任务恢复后的错误信息
+const restoredTaskCancelledMessage = "备份时任务尚未完成，恢复后已取消"
+
+// cancelRestoredTasks 将备份中排队和执行中的任务标记为已取消
+// 这些任务在备份的机器上可能已经执行过，恢复后不再由队列重新执行，避免重复调用 AI API
+func cancelRestoredTasks(tx *gorm.DB) error {
+	return tx.Model(&models.GenerationTask{}).
+		Where("status IN ?", []models.TaskStatus{models.TaskStatusQueued, models.TaskStatusProcessing}).
+		UpdateColumns(map[string]interface{}{
+			"status":      models.TaskStatusCancelled,
+			"error_msg":   restoredTaskCancelledMessage,
+			"finished_at": time.Now(),
+		}).Error
+}
+
+// commonColumns 当前数据库和备份数据库中同一个表都有的列
+func commonColumns(tx *gorm.DB, table string) ([]string, error) {
+	var srcColumns, dstColumns []string
+	if err := tx.Raw("SELECT name FROM pragma_table_info(?, 'backup_src')", table).Scan(&srcColumns).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Raw("SELECT name FROM pragma_table_info(?, 'main')", table).Scan(&dstColumns).Error; err != nil {
+		return nil, err
+	}
+	inBackup := make(map[string]bool, len(srcColumns))
+	for _, name := range srcColumns {
+		inBackup[name] = true
+	}
+	columns := make([]string, 0, len(dstColumns))
+	for _, name := range dstColumns {
+		if inBackup[name] {
+			columns = append(columns, name)
+		}
+	}
+	return columns, nil
+}
+
+// restoredMediaPath 将恢复的路径转换为相对路径（images/xxx 或 uploads/xxx）
+// 兼容旧数据中的完整 URL 和备份机器上输出、上传目录中的文件路径
+func restoredMediaPath(value string, manifest *BackupManifest) string {
+	if value == "" {
+		return value
+	}
+	if strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://") {
+		return utils.ToRelativePath(value)
+	}
+	for dir, prefix := range map[string]string{manifest.OutputDir: "images/", manifest.UploadDir: "uploads/"} {
+		if dir == "" || !filepath.IsAbs(value) {
+			continue
+		}
+		if rel, err := filepath.Rel(dir, value); err == nil && rel == filepath.Base(value) {
+			return prefix + rel
+		}
+	}
+	return value
+}
+
+// restoredRefImages 转换 RefImages JSON 中的路径
+func restoredRefImages(refImagesJSON string, manifest *BackupManifest) string {
+	var refs []string
+	if refImagesJSON == "" || json.Unmarshal([]byte(refImagesJSON), &refs) != nil {
+		return refImagesJSON
+	}
+	changed := false
+	for i, ref := range refs {
+		if converted := restoredMediaPath(ref, manifest); converted != ref {
+			refs[i] = converted
+			changed = true
+		}
+	}
+	if !changed {
+		return refImagesJSON
+	}
+	data, _ := json.Marshal(refs)
+	return string(data)
+}
+
+// rewriteRestoredPaths 转换恢复后任务和历史记录中的图片路径，返回修改的记录数
+func rewriteRestoredPaths(manifest *BackupManifest) (int64, error) {
+	var rewritten int64
+	for _, model := range []interface{}{&models.GenerationHistory{}, &models.GenerationTask{}} {
+		type pathRow struct {
+			ID        uint
+			ImageURL  string
+			RefImages string
+		}
+		var rows []pathRow
+		err := config.DB.Model(model).Select("id", "image_url", "ref_images").
+			Where("image_url LIKE 'http%' OR image_url LIKE '/%' OR ref_images LIKE '%http%' OR ref_images LIKE '%\"/%'").
+			Find(&rows).Error
+		if err != nil {
+			return rewritten, err
+		}
+		for _, row := range rows {
+			imageURL := restoredMediaPath(row.ImageURL, manifest)
+			refImages := restoredRefImages(row.RefImages, manifest)
+			if imageURL == row.ImageURL && refImages == row.RefImages {
+				continue
+			}
+			err := config.DB.Model(model).Where("id = ?", row.ID).
+				UpdateColumns(map[string]interface{}{"image_url": imageURL, "ref_images": refImages}).Error
+			if err != nil {
+				return rewritten, err
+			}
+			rewritten++
+		}
+	}
+	return rewritten, nil
+}
+
+// BackupHandler 下载完整备份（数据库快照、输出目录、上传目录和回收站）
+// POST /backup
+func BackupHandler(c *gin.Context) {
+	fileName := fmt.Sprintf("backup_%s.zip", time.Now().Format("20060102_150405"))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", fileName))
+	c.Header("Content-Type", "application/zip")
+	c.Status(200)
+
+	manifest, err := WriteBackup(c.Writer)
+	if err != nil {
+		// 响应已开始发送，只能记录日志并中断压缩包
+		utils.LogAPI("备份失败: %v", err)
+		return
+	}
+	utils.LogAPI("备份完成: %s (文件数 %v)", fileName, manifest.Files)
+}
+
+// RestoreBackupHandler 从上传的备份恢复
+// POST /backup/restore (multipart/form-data, file)
+func RestoreBackupHandler(c *gin.Context) {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(400, gin.H{"error": "请上传备份文件"})
+		return
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(400, gin.H{"error": "无法读取备份文件"})
+		return
+	}
+	defer file.Close()
+
+	result, err := RestoreBackup(file, fileHeader.Size)
+	switch {
+	case errors.Is(err, ErrInvalidBackup):
+		c.JSON(400, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrRestoreTasksActive):
+		c.JSON(409, gin.H{"error": err.Error()})
+	case err != nil:
+		utils.LogAPI("恢复备份失败: %v", err)
+		c.JSON(500, gin.H{"error": "恢复备份失败"})
+	default:
+		utils.LogAPI("恢复备份完成: %d 个文件，%d 个表", result.Files, len(result.Tables))
+		c.JSON(200, gin.H{
+			"message": "恢复完成",
+			"result":  result,
+		})
+	}
+}
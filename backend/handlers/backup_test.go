@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"sigma/config"
+	"sigma/migrations"
+	"sigma/models"
+)
+
+func setupBackupTest(t *testing.T) {
+	var err error
+	config.DB, err = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("无法创建测试数据库: %v", err)
+	}
+	// 内存数据库每个连接各自独立，快照和恢复须使用同一个连接
+	sqlDB, _ := config.DB.DB()
+	sqlDB.SetMaxOpenConns(1)
+	config.DB.AutoMigrate(&models.GenerationHistory{}, &models.GenerationTask{}, &models.StoredFile{}, &models.HistoryTag{},
+		&models.Collection{}, &models.CollectionItem{}, &models.GenerationTypeDef{}, &models.PromptTemplate{}, &config.AppConfig{})
+	t.Cleanup(func() { sqlDB.Close() })
+
+	config.OutputDir = t.TempDir()
+	config.UploadDir = t.TempDir()
+	config.TrashDir = t.TempDir()
+}
+
+func TestBackup_RestoreIntoNewDirectories(t *testing.T) {
+	setupBackupTest(t)
+
+	os.WriteFile(filepath.Join(config.OutputDir, "a.png"), []byte("image-a"), 0644)
+	os.WriteFile(filepath.Join(config.UploadDir, "ref.png"), []byte("ref"), 0644)
+	history := models.GenerationHistory{
+		Prompt:    "猫咪",
+		ImageURL:  "http://localhost:9000/images/a.png",
+		RefImages: `["` + filepath.ToSlash(filepath.Join(config.UploadDir, "ref.png")) + `"]`,
+		Type:      models.GenerationTypeCreate,
+	}
+	config.DB.Create(&history)
+	config.DB.Create(&models.HistoryTag{HistoryID: history.ID, Tag: "客户A"})
+
+	var buf bytes.Buffer
+	manifest, err := WriteBackup(&buf)
+	if err != nil {
+		t.Fatalf("备份失败: %v", err)
+	}
+	if manifest.Files["output"] != 1 || manifest.Files["uploads"] != 1 {
+		t.Errorf("备份的文件数不正确: %v", manifest.Files)
+	}
+
+	// 备份后的修改在恢复时被替换
+	config.DB.Create(&models.GenerationHistory{Prompt: "备份后新增", Type: models.GenerationTypeCreate})
+	config.DB.Model(&history).Update("prompt", "已修改")
+
+	// 恢复到新的目录
+	config.OutputDir = t.TempDir()
+	config.UploadDir = t.TempDir()
+	result, err := RestoreBackup(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("恢复失败: %v", err)
+	}
+	if result.Files != 2 || result.Tables["generation_histories"] != 1 || result.Tables["history_tags"] != 1 {
+		t.Errorf("恢复结果不正确: %+v", result)
+	}
+
+	var restored []models.GenerationHistory
+	config.DB.Find(&restored)
+	if len(restored) != 1 || restored[0].Prompt != "猫咪" {
+		t.Fatalf("数据库应恢复为备份时的内容: %+v", restored)
+	}
+	if restored[0].ImageURL != "images/a.png" || restored[0].RefImages != `["uploads/ref.png"]` {
+		t.Errorf("图片路径应转换为相对路径: %s %s", restored[0].ImageURL, restored[0].RefImages)
+	}
+	if data, err := os.ReadFile(filepath.Join(config.OutputDir, "a.png")); err != nil || string(data) != "image-a" {
+		t.Errorf("图片应恢复到新的输出目录: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(config.UploadDir, "ref.png")); err != nil {
+		t.Errorf("参考图应恢复到新的上传目录: %v", err)
+	}
+	var typeCount int64
+	config.DB.Model(&models.GenerationTypeDef{}).Count(&typeCount)
+	if typeCount == 0 {
+		t.Errorf("恢复后应重新写入内置生成类型")
+	}
+}
+
+func TestBackup_RejectsNewerSchemaAndActiveTasks(t *testing.T) {
+	setupBackupTest(t)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create(backupManifestName)
+	w.Write([]byte(`{"format_version": 1, "schema_version": 999}`))
+	zw.Close()
+	if _, err := RestoreBackup(bytes.NewReader(buf.Bytes()), int64(buf.Len())); !errors.Is(err, ErrInvalidBackup) {
+		t.Errorf("更高版本的备份应被拒绝，实际为 %v", err)
+	}
+	if _, err := RestoreBackup(bytes.NewReader([]byte("not a zip")), 9); !errors.Is(err, ErrInvalidBackup) {
+		t.Errorf("无效的压缩包应被拒绝，实际为 %v", err)
+	}
+
+	buf.Reset()
+	if _, err := WriteBackup(&buf); err != nil {
+		t.Fatalf("备份失败: %v", err)
+	}
+	config.DB.Create(&models.GenerationTask{TaskID: "t1", Type: models.GenerationTypeCreate, Status: models.TaskStatusQueued})
+	if _, err := RestoreBackup(bytes.NewReader(buf.Bytes()), int64(buf.Len())); !errors.Is(err, ErrRestoreTasksActive) {
+		t.Errorf("有排队任务时应拒绝恢复，实际为 %v", err)
+	}
+}
+
+func TestBackup_RejectsUnsafeMediaNames(t *testing.T) {
+	setupBackupTest(t)
+
+	var backup bytes.Buffer
+	if _, err := WriteBackup(&backup); err != nil {
+		t.Fatalf("备份失败: %v", err)
+	}
+	zr, _ := zip.NewReader(bytes.NewReader(backup.Bytes()), int64(backup.Len()))
+
+	for _, name := range []string{`output/..\evil.png`, `uploads/C:evil.png`, "trash/.."} {
+		// 复制正常的备份并加入一个恶意文件名
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for _, f := range zr.File {
+			w, _ := zw.Create(f.Name)
+			src, _ := f.Open()
+			io.Copy(w, src)
+			src.Close()
+		}
+		w, _ := zw.Create(name)
+		w.Write([]byte("evil"))
+		zw.Close()
+
+		if _, err := RestoreBackup(bytes.NewReader(buf.Bytes()), int64(buf.Len())); !errors.Is(err, ErrInvalidBackup) {
+			t.Errorf("文件名 %q 应被拒绝，实际为 %v", name, err)
+		}
+		if entries, _ := os.ReadDir(filepath.Dir(config.OutputDir)); len(entries) != 3 {
+			t.Errorf("不应在媒体目录之外写入文件: %d", len(entries))
+		}
+	}
+}
+
+func TestBackup_MigratesOlderSnapshotAndCancelsUnfinishedTasks(t *testing.T) {
+	setupBackupTest(t)
+	config.DB.AutoMigrate(&models.CreditLedgerEntry{})
+
+	// 版本 3 的备份：已结束的任务没有结束时间，计价未知的台账记录单价为 0，还有一个排队中的任务
+	snapshot := filepath.Join(t.TempDir(), backupDBName)
+	old, err := gorm.Open(sqlite.Open(snapshot), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("创建备份数据库失败: %v", err)
+	}
+	if _, err := migrations.Run(old, false); err != nil {
+		t.Fatalf("迁移失败: %v", err)
+	}
+	old.Create(&models.GenerationHistory{Prompt: "p", ImageURL: "images/a.png"})
+	old.Exec("INSERT INTO generation_tasks (task_id, type, status, started_at, updated_at) VALUES ('t1', 'create', 'completed', '2025-01-01 00:00:00', '2025-01-01 00:01:00')")
+	old.Exec("INSERT INTO generation_tasks (task_id, type, status, started_at) VALUES ('t2', 'create', 'queued', '2025-01-01 00:00:00')")
+	old.Exec("INSERT INTO credit_ledger_entries (task_id, credits, unit_price, cost) VALUES ('t1', 1, 0, 0)")
+	old.Exec("DELETE FROM schema_migrations WHERE version > 3")
+	oldDB, _ := old.DB()
+	oldDB.Close()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create(backupManifestName)
+	w.Write([]byte(`{"format_version": 1, "schema_version": 3}`))
+	w, _ = zw.Create(backupDBName)
+	data, _ := os.ReadFile(snapshot)
+	w.Write(data)
+	zw.Close()
+
+	if _, err := RestoreBackup(bytes.NewReader(buf.Bytes()), int64(buf.Len())); err != nil {
+		t.Fatalf("恢复失败: %v", err)
+	}
+	var task models.GenerationTask
+	config.DB.Where("task_id = ?", "t1").First(&task)
+	if task.FinishedAt == nil {
+		t.Error("恢复旧备份时应补全已结束任务的结束时间")
+	}
+	// 备份中排队的任务恢复后取消，不再重新执行
+	var queued models.GenerationTask
+	config.DB.Where("task_id = ?", "t2").First(&queued)
+	if queued.Status != models.TaskStatusCancelled || queued.ErrorMsg == "" || queued.FinishedAt == nil {
+		t.Errorf("备份中未完成的任务恢复后应取消: %+v", queued)
+	}
+	var entry models.CreditLedgerEntry
+	config.DB.Where("task_id = ?", "t1").First(&entry)
+	if entry.ID == 0 || entry.UnitPrice != nil || entry.Cost != nil {
+		t.Errorf("恢复旧备份时计价未知的台账记录单价应为空: %+v", entry)
+	}
+}
@@ -0,0 +1,384 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"sigma/config"
+	"sigma/events"
+	"sigma/models"
+	"sigma/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// batchItem 批次内单张图片的生成参数
+type batchItem struct {
+	Prompt      string
+	AspectRatio string
+	ImageSize   string
+	Variation   string // 提示词矩阵组合（JSON），普通多图批次为空
+}
+
+// uniformBatchItems 普通多图批次：每张图片使用与父任务相同的参数
+func uniformBatchItems(parent *models.GenerationTask) []batchItem {
+	items := make([]batchItem, parent.ImageCount)
+	for i := range items {
+		items[i] = batchItem{Prompt: parent.Prompt, AspectRatio: parent.AspectRatio, ImageSize: parent.ImageSize}
+	}
+	return items
+}
+
+// createBatchTasks 创建多图批次：父任务记录批次整体状态，每张图片对应一个排队中的子任务
+// 子任务与单图任务一样由工作池调度，服务重启后可以继续执行
+func createBatchTasks(parent *models.GenerationTask, items []batchItem) ([]models.GenerationTask, error) {
+	parent.Status = models.TaskStatusProcessing
+	parent.BatchID = uuid.New().String()
+	parent.ImageCount = len(items)
+
+	children := make([]models.GenerationTask, len(items))
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(parent).Error; err != nil {
+			return err
+		}
+		for i, item := range items {
+			index := i
+			children[i] = models.GenerationTask{
+				TaskID:       uuid.New().String(),
+				Status:       models.TaskStatusQueued,
+				Type:         parent.Type,
+				Prompt:       item.Prompt,
+				RefImages:    parent.RefImages,
+				StartedAt:    parent.StartedAt,
+				ImageCount:   1,
+				AspectRatio:  item.AspectRatio,
+				ImageSize:    item.ImageSize,
+				BatchID:      parent.BatchID,
+				ParentTaskID: parent.TaskID,
+				BatchIndex:   &index,
+				Variation:    item.Variation,
+				// 提示词矩阵的组合各自渲染，模板原文与父任务相同
+				TemplateID:     parent.TemplateID,
+				OriginalPrompt: parent.OriginalPrompt,
+			}
+			if err := tx.Create(&children[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	publishTaskEvent(events.TaskStarted, parent)
+	for i := range children {
+		publishTaskEvent(events.TaskQueued, &children[i])
+	}
+	getTaskQueue().Notify()
+	return children, nil
+}
+
+// loadBatchChildren 按批次内序号读取父任务的所有子任务
+func loadBatchChildren(parentTaskID string) ([]models.GenerationTask, error) {
+	var children []models.GenerationTask
+	err := config.DB.Where("parent_task_id = ?", parentTaskID).Order("batch_index asc").Find(&children).Error
+	return children, err
+}
+
+// loadBatchChildrenPage 分页读取父任务的子任务（按批次内序号）
+func loadBatchChildrenPage(parentTaskID string, page, pageSize int) ([]models.GenerationTask, error) {
+	var children []models.GenerationTask
+	err := config.DB.Where("parent_task_id = ?", parentTaskID).
+		Order("batch_index asc").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&children).Error
+	return children, err
+}
+
+// batchInfoFor 返回子任务对应的历史记录批次信息，非批次任务返回 nil
+func batchInfoFor(task *models.GenerationTask) *batchInfo {
+	if task.ParentTaskID == "" || task.BatchIndex == nil {
+		return nil
+	}
+	info := &batchInfo{ID: task.BatchID, Index: *task.BatchIndex, Variation: task.Variation}
+	var parent models.GenerationTask
+	if config.DB.Where("task_id = ?", task.ParentTaskID).First(&parent).Error == nil {
+		info.Total = parent.ImageCount
+	}
+	return info
+}
+
+// batchProgress 将子任务转换为逐张进度（URL 转换为当前端口的绝对路径）
+func batchProgress(children []models.GenerationTask) []models.BatchImageProgress {
+	progress := make([]models.BatchImageProgress, 0, len(children))
+	for _, child := range children {
+		item := models.BatchImageProgress{
+			TaskID:    child.TaskID,
+			Status:    child.Status,
+			ErrorMsg:  child.ErrorMsg,
+			Variation: child.Variation,
+		}
+		if child.BatchIndex != nil {
+			item.Index = *child.BatchIndex
+		}
+		if child.ImageURL != "" {
+			item.ImageURL = utils.ToAbsoluteURL(child.ImageURL, config.ServerPort)
+		}
+		progress = append(progress, item)
+	}
+	return progress
+}
+
+// refreshBatchParent 根据子任务状态汇总父任务
+// 所有子任务结束后：至少一张成功则父任务完成（image_url 取第一张成功的图片），否则失败
+func refreshBatchParent(parentTaskID string) {
+	var parent models.GenerationTask
+	if err := config.DB.Where("task_id = ?", parentTaskID).First(&parent).Error; err != nil || parent.IsFinished() {
+		return
+	}
+	children, err := loadBatchChildren(parentTaskID)
+	if err != nil || len(children) == 0 {
+		return
+	}
+
+	attempts := 0
+	firstSuccessURL := ""
+	for _, child := range children {
+		if !child.IsFinished() {
+			return
+		}
+		attempts += child.Attempts
+		if child.Status == models.TaskStatusCompleted && firstSuccessURL == "" {
+			firstSuccessURL = child.ImageURL
+		}
+	}
+
+	if firstSuccessURL != "" {
+		parent.CompleteTask(firstSuccessURL)
+	} else {
+		parent.FailTask("所有图片生成失败")
+	}
+	parent.Attempts = attempts
+
+	// 多个子任务可能同时结束，只有第一个完成汇总的更新生效
+	result := config.DB.Model(&models.GenerationTask{}).
+		Where("task_id = ? AND status = ?", parentTaskID, models.TaskStatusProcessing).
+		Updates(map[string]interface{}{
+			"status":      parent.Status,
+			"image_url":   parent.ImageURL,
+			"error_msg":   parent.ErrorMsg,
+			"attempts":    parent.Attempts,
+			"finished_at": parent.FinishedAt,
+		})
+	if result.Error != nil || result.RowsAffected != 1 {
+		return
+	}
+
+	if parent.Status == models.TaskStatusCompleted {
+		publishTaskEvent(events.TaskCompleted, &parent)
+	} else {
+		publishTaskEvent(events.TaskFailed, &parent)
+	}
+	utils.LogAPI("批次 %s 结束: %s", parent.BatchID, parent.Status)
+}
+
+// cancelBatchChildren 取消批次中尚未结束的子任务，返回成功取消的数量
+func cancelBatchChildren(parentTaskID string) int {
+	var children []models.GenerationTask
+	config.DB.Where("parent_task_id = ? AND status IN ?", parentTaskID,
+		[]models.TaskStatus{models.TaskStatusQueued, models.TaskStatusProcessing}).Find(&children)
+
+	cancelled := 0
+	for i := range children {
+		if cancelTask(&children[i]) {
+			cancelled++
+		}
+	}
+	return cancelled
+}
+
+// batchItemEvent 将子任务结果转换为前端响应格式
+func batchItemEvent(index int, child *models.GenerationTask) gin.H {
+	if child == nil || child.Status != models.TaskStatusCompleted {
+		errMsg := models.TaskCancelledMessage
+		if child != nil && child.ErrorMsg != "" {
+			errMsg = child.ErrorMsg
+		}
+		return gin.H{
+			"error": errMsg,
+			"index": index,
+		}
+	}
+	// 转换相对路径为完整 URL 返回给前端
+	return gin.H{
+		"image_url": utils.ToAbsoluteURL(child.ImageURL, config.ServerPort),
+		"index":     index,
+	}
+}
+
+// batchSubscription 批次进度流使用的事件订阅，订阅被断开时自动重新订阅
+type batchSubscription struct {
+	taskIDs     map[string]bool
+	ch          <-chan events.Event
+	unsubscribe func()
+}
+
+func newBatchSubscription(parent *models.GenerationTask, children []models.GenerationTask) *batchSubscription {
+	sub := &batchSubscription{taskIDs: map[string]bool{parent.TaskID: true}}
+	for _, child := range children {
+		sub.taskIDs[child.TaskID] = true
+	}
+	_, _, sub.ch, sub.unsubscribe = events.Default().Subscribe(0)
+	return sub
+}
+
+// wait 等待与批次相关的事件或轮询间隔到达，ctx 结束时返回 false
+func (s *batchSubscription) wait(ctx context.Context, ticker *time.Ticker) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+			return true
+		case event, ok := <-s.ch:
+			if !ok {
+				// 消费过慢被断开：重新订阅，由调用方从数据库重新同步状态
+				_, _, s.ch, s.unsubscribe = events.Default().Subscribe(0)
+				return true
+			}
+			if s.taskIDs[event.TaskID] {
+				return true
+			}
+		}
+	}
+}
+
+func (s *batchSubscription) close() {
+	s.unsubscribe()
+}
+
+// streamBatchProgress 以 SSE 推送批次进度（兼容原有的 start / image / complete 事件格式）
+// 进度以数据库中的子任务状态为准，事件总线只用于及时唤醒
+func streamBatchProgress(c *gin.Context, parent *models.GenerationTask, children []models.GenerationTask, absoluteRefImages []string) {
+	sub := newBatchSubscription(parent, children)
+	defer sub.close()
+
+	// 设置 SSE 响应头
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("Access-Control-Allow-Origin", "*")
+
+	// 发送初始事件，告知前端批次信息
+	writeBatchSSE(c, gin.H{
+		"type":         "start",
+		"task_id":      parent.TaskID,
+		"batch_id":     parent.BatchID,
+		"count":        parent.ImageCount,
+		"prompt":       parent.Prompt,
+		"ref_images":   absoluteRefImages,
+		"aspect_ratio": parent.AspectRatio,
+		"image_size":   parent.ImageSize,
+	})
+
+	ticker := time.NewTicker(queuePollInterval)
+	defer ticker.Stop()
+
+	sent := make(map[int]bool)
+	for {
+		var current models.GenerationTask
+		if err := config.DB.Where("task_id = ?", parent.TaskID).First(&current).Error; err != nil {
+			utils.LogAPI("读取批次任务失败: %v", err)
+			return
+		}
+		latest, err := loadBatchChildren(parent.TaskID)
+		if err != nil {
+			utils.LogAPI("读取批次子任务失败: %v", err)
+			return
+		}
+
+		// 流式返回每个新结束的图片
+		byIndex := make(map[int]*models.GenerationTask, len(latest))
+		successCount := 0
+		for i := range latest {
+			child := &latest[i]
+			if child.BatchIndex == nil {
+				continue
+			}
+			index := *child.BatchIndex
+			byIndex[index] = child
+			if child.Status == models.TaskStatusCompleted {
+				successCount++
+			}
+			if !child.IsFinished() || sent[index] {
+				continue
+			}
+			sent[index] = true
+			eventData := batchItemEvent(index, child)
+			eventData["type"] = "image"
+			eventData["batch_id"] = parent.BatchID
+			eventData["completed"] = len(sent)
+			eventData["total"] = parent.ImageCount
+			utils.LogAPI("发送 SSE image 事件: index=%d, completed=%d/%d, hasError=%v", index, len(sent), parent.ImageCount, child.Status != models.TaskStatusCompleted)
+			writeBatchSSE(c, eventData)
+		}
+
+		if current.IsFinished() {
+			images := make([]gin.H, parent.ImageCount)
+			for i := range images {
+				images[i] = batchItemEvent(i, byIndex[i])
+			}
+
+			status := "success"
+			switch {
+			case current.Status == models.TaskStatusCancelled:
+				status = string(models.TaskStatusCancelled)
+			case successCount == 0:
+				status = "failed"
+			case successCount < parent.ImageCount:
+				status = "partial"
+			}
+
+			// 发送完成事件
+			utils.LogAPI("发送 SSE complete 事件: status=%s, success_count=%d, total_count=%d", status, successCount, parent.ImageCount)
+			writeBatchSSE(c, gin.H{
+				"type":          "complete",
+				"status":        status,
+				"task_id":       parent.TaskID,
+				"batch_id":      parent.BatchID,
+				"images":        images,
+				"ref_images":    absoluteRefImages,
+				"success_count": successCount,
+				"total_count":   parent.ImageCount,
+			})
+
+			// 明确结束 SSE 流
+			c.Writer.Write([]byte("\n\n"))
+			if flusher, ok := c.Writer.(http.Flusher); ok {
+				flusher.Flush()
+			}
+			c.Header("Connection", "close")
+			utils.LogAPI("SSE 流已结束，连接即将关闭")
+			return
+		}
+
+		if !sub.wait(c.Request.Context(), ticker) {
+			// 连接断开不影响批次继续执行，前端可通过 GET /tasks/:id 恢复进度
+			utils.LogAPI("批次 %s 的 SSE 连接已断开，任务继续在后台执行", parent.BatchID)
+			return
+		}
+	}
+}
+
+// writeBatchSSE 发送一条批次 SSE 消息
+func writeBatchSSE(c *gin.Context, data gin.H) {
+	payload, _ := json.Marshal(data)
+	c.SSEvent("message", string(payload))
+	c.Writer.Flush()
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"sigma/config"
+	"sigma/models"
+)
+
+// newTestBatch 创建一个 count 张图片的批次
+func newTestBatch(t *testing.T, count int) (*models.GenerationTask, []models.GenerationTask) {
+	parent := &models.GenerationTask{
+		TaskID:     uuid.New().String(),
+		Type:       models.GenerationTypeCreate,
+		Prompt:     "batch prompt",
+		StartedAt:  time.Now(),
+		ImageCount: count,
+	}
+	children, err := createBatchTasks(parent, uniformBatchItems(parent))
+	if err != nil {
+		t.Fatalf("创建批次失败: %v", err)
+	}
+	return parent, children
+}
+
+// finishChild 直接将子任务置为结束状态并触发父任务汇总
+func finishChild(child *models.GenerationTask, status models.TaskStatus, imageURL string) {
+	child.Status = status
+	child.ImageURL = imageURL
+	if status != models.TaskStatusCompleted {
+		child.ErrorMsg = "生成失败"
+	}
+	config.DB.Save(child)
+	refreshBatchParent(child.ParentTaskID)
+}
+
+func TestCreateBatchTasks_QueuesOneChildPerImage(t *testing.T) {
+	cleanup := setupTaskTestDB(t)
+	defer cleanup()
+
+	parent, children := newTestBatch(t, 3)
+	if parent.Status != models.TaskStatusProcessing || parent.BatchID == "" {
+		t.Errorf("父任务应为 processing 且有 batch_id，实际为 %s / %q", parent.Status, parent.BatchID)
+	}
+	if len(children) != 3 {
+		t.Fatalf("期望 3 个子任务，实际为 %d", len(children))
+	}
+	for i, child := range children {
+		if child.Status != models.TaskStatusQueued || child.ParentTaskID != parent.TaskID || child.BatchIndex == nil || *child.BatchIndex != i {
+			t.Errorf("子任务 %d 不正确: %+v", i, child)
+		}
+	}
+
+	// 处理中列表只返回父任务
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/tasks/processing", GetProcessingTasks)
+	r.GET("/tasks/:id", GetTaskStatus)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/tasks/processing", nil)
+	r.ServeHTTP(w, req)
+	var processing []models.TaskResponse
+	json.Unmarshal(w.Body.Bytes(), &processing)
+	if len(processing) != 1 || processing[0].TaskID != parent.TaskID {
+		t.Errorf("处理中列表应只包含父任务，实际为 %+v", processing)
+	}
+
+	// 父任务返回逐张进度
+	finishChild(&children[1], models.TaskStatusCompleted, "images/b.png")
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/tasks/"+parent.TaskID, nil)
+	r.ServeHTTP(w, req)
+	var status models.TaskResponse
+	json.Unmarshal(w.Body.Bytes(), &status)
+	if len(status.Images) != 3 {
+		t.Fatalf("期望返回 3 张图片的进度，实际为 %d", len(status.Images))
+	}
+	if status.Images[1].Status != models.TaskStatusCompleted || !strings.HasSuffix(status.Images[1].ImageURL, "images/b.png") {
+		t.Errorf("第 2 张图片进度不正确: %+v", status.Images[1])
+	}
+	if status.Images[0].Status != models.TaskStatusQueued {
+		t.Errorf("第 1 张图片应仍在排队，实际为 %s", status.Images[0].Status)
+	}
+}
+
+func TestRefreshBatchParent_AggregatesChildren(t *testing.T) {
+	cleanup := setupTaskTestDB(t)
+	defer cleanup()
+
+	parent, children := newTestBatch(t, 2)
+	finishChild(&children[0], models.TaskStatusFailed, "")
+
+	var stored models.GenerationTask
+	config.DB.Where("task_id = ?", parent.TaskID).First(&stored)
+	if stored.Status != models.TaskStatusProcessing {
+		t.Fatalf("还有子任务未结束时父任务应保持 processing，实际为 %s", stored.Status)
+	}
+
+	finishChild(&children[1], models.TaskStatusCompleted, "images/second.png")
+	stored = models.GenerationTask{}
+	config.DB.Where("task_id = ?", parent.TaskID).First(&stored)
+	if stored.Status != models.TaskStatusCompleted || stored.ImageURL != "images/second.png" {
+		t.Errorf("部分成功时父任务应完成并使用第一张成功的图片，实际为 %s / %s", stored.Status, stored.ImageURL)
+	}
+
+	parent, children = newTestBatch(t, 2)
+	finishChild(&children[0], models.TaskStatusFailed, "")
+	finishChild(&children[1], models.TaskStatusCancelled, "")
+	stored = models.GenerationTask{}
+	config.DB.Where("task_id = ?", parent.TaskID).First(&stored)
+	if stored.Status != models.TaskStatusFailed {
+		t.Errorf("全部失败时父任务应失败，实际为 %s", stored.Status)
+	}
+}
+
+func TestCancelTask_BatchParentCancelsChildren(t *testing.T) {
+	cleanup := setupTaskTestDB(t)
+	defer cleanup()
+
+	parent, children := newTestBatch(t, 3)
+	finishChild(&children[0], models.TaskStatusCompleted, "images/a.png")
+
+	if !cancelTask(parent) {
+		t.Fatal("取消批次失败")
+	}
+
+	latest, _ := loadBatchChildren(parent.TaskID)
+	if latest[0].Status != models.TaskStatusCompleted {
+		t.Errorf("已完成的图片应保留，实际为 %s", latest[0].Status)
+	}
+	for _, child := range latest[1:] {
+		if child.Status != models.TaskStatusCancelled {
+			t.Errorf("未结束的子任务应被取消，实际为 %s", child.Status)
+		}
+	}
+}
+
+func TestGenerateMultipleImages_StreamsFinishedBatch(t *testing.T) {
+	cleanup := setupTaskTestDB(t)
+	defer cleanup()
+
+	gin.SetMode(gin.TestMode)
+
+	parent, children := newTestBatch(t, 2)
+	finishChild(&children[0], models.TaskStatusCompleted, "images/a.png")
+	finishChild(&children[1], models.TaskStatusFailed, "")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/generate", strings.NewReader("stream=true"))
+	c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	generateMultipleImages(c, parent, children, nil)
+
+	body := w.Body.String()
+	for _, want := range []string{`"type":"start"`, `"type":"image"`, `"type":"complete"`, `"status":"partial"`, `"success_count":1`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("SSE 响应缺少 %s，实际内容: %s", want, body)
+		}
+	}
+}
+
+func TestGenerateMultipleImages_ReturnsBatchImmediatelyByDefault(t *testing.T) {
+	cleanup := setupTaskTestDB(t)
+	defer cleanup()
+
+	gin.SetMode(gin.TestMode)
+
+	parent, children := newTestBatch(t, 2)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/generate", nil)
+	generateMultipleImages(c, parent, children, nil)
+
+	if ct := w.Header().Get("Content-Type"); strings.Contains(ct, "text/event-stream") {
+		t.Fatalf("未指定 stream 时不应保持 SSE 连接，实际 Content-Type: %s", ct)
+	}
+	var response struct {
+		TaskID  string `json:"task_id"`
+		BatchID string `json:"batch_id"`
+		Count   int    `json:"count"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("应立即返回 JSON: %v, %s", err, w.Body.String())
+	}
+	if response.TaskID != parent.TaskID || response.BatchID != parent.BatchID || response.Count != 2 {
+		t.Errorf("应返回批次父任务信息，实际为 %+v", response)
+	}
+}
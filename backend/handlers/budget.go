@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"sigma/config"
+	"sigma/models"
+	"sigma/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// budgetItem 请求中待生成的一张图片
+type budgetItem struct {
+	Type      string
+	ImageSize string
+}
+
+// budgetUnit 预算计量单位（未初始化配置时按张数）
+func budgetUnit() string {
+	if config.BudgetUnit == config.BudgetUnitCredits {
+		return config.BudgetUnitCredits
+	}
+	return config.BudgetUnitImages
+}
+
+// budgetWarnPercent 预算提示阈值（未初始化配置时使用默认值）
+func budgetWarnPercent() int {
+	if config.BudgetWarnPercent < 1 {
+		return config.DefaultBudgetWarnPercent
+	}
+	return config.BudgetWarnPercent
+}
+
+// budgetAmount 一张图片按预算单位计的消耗：按张数时为 1，按消耗时 4K 图片计为 2
+func budgetAmount(imageSize string) float64 {
+	if budgetUnit() == config.BudgetUnitCredits {
+		return sizeCreditMultiplier(imageSize)
+	}
+	return 1
+}
+
+// budgetUnitLabel 预算单位在提示信息中的名称
+func budgetUnitLabel() string {
+	if budgetUnit() == config.BudgetUnitCredits {
+		return "额度"
+	}
+	return "张"
+}
+
+// budgetLimit 一项预算及本期的使用情况
+type budgetLimit struct {
+	Period    string  `json:"period"`         // day | month
+	Type      string  `json:"type,omitempty"` // 生成类型，为空表示全部类型
+	Limit     float64 `json:"limit"`
+	Used      float64 `json:"used"`                // 本期已成功生成（消耗台账）
+	Reserved  float64 `json:"reserved"`            // 排队中和处理中的任务，执行成功后计入
+	Remaining float64 `json:"remaining"`           // limit - used - reserved，不小于 0
+	Percent   float64 `json:"percent"`             // (used + reserved) / limit × 100
+	Warning   bool    `json:"warning"`             // 达到提示阈值
+	Requested float64 `json:"requested,omitempty"` // 被拒绝的请求需要的数量
+
+	since time.Time
+}
+
+// matches 生成类型是否计入该预算
+func (l *budgetLimit) matches(generationType string) bool {
+	return l.Type == "" || l.Type == generationType
+}
+
+// finish 计算剩余数量、使用百分比和是否达到提示阈值
+func (l *budgetLimit) finish() {
+	spent := l.Used + l.Reserved
+	l.Remaining = math.Max(l.Limit-spent, 0)
+	l.Percent = math.Round(spent/l.Limit*10000) / 100
+	l.Warning = l.Percent >= float64(budgetWarnPercent())
+}
+
+// description 预算的中文描述，如"每日预算"、"create 类型的每月预算"
+func (l *budgetLimit) description() string {
+	period := "每日"
+	if l.Period == "month" {
+		period = "每月"
+	}
+	if l.Type == "" {
+		return period + "预算"
+	}
+	return fmt.Sprintf("%s 类型的%s预算", l.Type, period)
+}
+
+// budgetPeriodStart 本期开始时间（本地时区的当天零点或当月 1 日零点）
+func budgetPeriodStart(period string, now time.Time) time.Time {
+	now = now.Local()
+	if period == "month" {
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	}
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+}
+
+// configuredBudgetLimits 配置的全部预算：总预算在前，类型预算按类型排序
+func configuredBudgetLimits(now time.Time) []budgetLimit {
+	var limits []budgetLimit
+	add := func(period, generationType string, limit int) {
+		if limit > 0 {
+			limits = append(limits, budgetLimit{
+				Period: period,
+				Type:   generationType,
+				Limit:  float64(limit),
+				since:  budgetPeriodStart(period, now),
+			})
+		}
+	}
+	add("day", "", config.BudgetDailyLimit)
+	add("month", "", config.BudgetMonthlyLimit)
+	for _, period := range []string{"day", "month"} {
+		typeLimits := config.BudgetTypeDailyLimits
+		if period == "month" {
+			typeLimits = config.BudgetTypeMonthlyLimits
+		}
+		types := make([]string, 0, len(typeLimits))
+		for t := range typeLimits {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		for _, t := range types {
+			add(period, t, typeLimits[t])
+		}
+	}
+	return limits
+}
+
+// loadBudgetUsage 统计每项预算本期已用和已预留的数量
+// 已用来自消耗台账，以及本期已完成但还没有写入台账的任务（完成状态先于台账写入）；
+// 排队中和处理中的任务不论创建时间都计为预留（批次父任务由子任务计入）
+func loadBudgetUsage(limits []budgetLimit) error {
+	if len(limits) == 0 {
+		return nil
+	}
+	earliest := limits[0].since
+	for _, l := range limits[1:] {
+		if l.since.Before(earliest) {
+			earliest = l.since
+		}
+	}
+
+	var entries []models.CreditLedgerEntry
+	if err := config.DB.Model(&models.CreditLedgerEntry{}).Select("type", "image_size", "created_at").
+		Where("created_at >= ?", earliest).Find(&entries).Error; err != nil {
+		return err
+	}
+	var unrecorded []models.GenerationTask
+	if err := config.DB.Model(&models.GenerationTask{}).Select("type", "image_size", "finished_at").
+		Where("status = ? AND finished_at >= ?", models.TaskStatusCompleted, earliest).
+		Where("batch_id = '' OR parent_task_id <> ''").
+		Where("task_id NOT IN (?)", config.DB.Model(&models.CreditLedgerEntry{}).Select("task_id")).
+		Find(&unrecorded).Error; err != nil {
+		return err
+	}
+	var pending []models.GenerationTask
+	if err := config.DB.Model(&models.GenerationTask{}).Select("type", "image_size").
+		Where("status IN ?", []models.TaskStatus{models.TaskStatusQueued, models.TaskStatusProcessing}).
+		Where("batch_id = '' OR parent_task_id <> ''").Find(&pending).Error; err != nil {
+		return err
+	}
+
+	for i := range limits {
+		l := &limits[i]
+		for _, entry := range entries {
+			if l.matches(entry.Type) && !entry.CreatedAt.Before(l.since) {
+				l.Used += budgetAmount(entry.ImageSize)
+			}
+		}
+		for _, task := range unrecorded {
+			if l.matches(task.Type) && !task.FinishedAt.Before(l.since) {
+				l.Used += budgetAmount(task.ImageSize)
+			}
+		}
+		for _, task := range pending {
+			if l.matches(task.Type) {
+				l.Reserved += budgetAmount(task.ImageSize)
+			}
+		}
+		l.finish()
+	}
+	return nil
+}
+
+// BudgetStatus 预算配置和本期使用情况
+type BudgetStatus struct {
+	Enabled     bool          `json:"enabled"` // 是否配置了任何预算
+	Unit        string        `json:"unit"`    // images | credits
+	WarnPercent int           `json:"warn_percent"`
+	Warning     bool          `json:"warning"` // 任一预算达到提示阈值
+	Limits      []budgetLimit `json:"limits"`
+}
+
+// BuildBudgetStatus 统计各项预算的使用情况
+func BuildBudgetStatus() (*BudgetStatus, error) {
+	limits := configuredBudgetLimits(time.Now())
+	if err := loadBudgetUsage(limits); err != nil {
+		return nil, err
+	}
+	status := &BudgetStatus{
+		Enabled:     len(limits) > 0,
+		Unit:        budgetUnit(),
+		WarnPercent: budgetWarnPercent(),
+		Limits:      limits,
+	}
+	if status.Limits == nil {
+		status.Limits = []budgetLimit{}
+	}
+	for _, l := range limits {
+		if l.Warning {
+			status.Warning = true
+		}
+	}
+	return status, nil
+}
+
+// budgetExceededError 请求的图片超出预算
+type budgetExceededError struct {
+	Limit budgetLimit
+}
+
+func (e *budgetExceededError) Error() string {
+	l := e.Limit
+	unit := budgetUnitLabel()
+	return fmt.Sprintf("超出%s：本期已用 %g %s，排队中 %g %s，本次请求 %g %s，上限 %g %s",
+		l.description(), l.Used, unit, l.Reserved, unit, l.Requested, unit, l.Limit, unit)
+}
+
+// checkBudget 检查创建这些图片的任务后是否超出任一预算，未配置预算时不查询数据库
+func checkBudget(items []budgetItem) error {
+	limits := configuredBudgetLimits(time.Now())
+	if len(limits) == 0 || len(items) == 0 {
+		return nil
+	}
+	if err := loadBudgetUsage(limits); err != nil {
+		return err
+	}
+	for _, l := range limits {
+		for _, item := range items {
+			if l.matches(item.Type) {
+				l.Requested += budgetAmount(item.ImageSize)
+			}
+		}
+		if l.Requested > 0 && l.Used+l.Reserved+l.Requested > l.Limit {
+			return &budgetExceededError{Limit: l}
+		}
+	}
+	return nil
+}
+
+// budgetMu 串行化预算检查和任务写入：检查通过后到任务写入数据库之前不允许其他请求检查预算，
+// 避免并发请求按同一份用量都通过检查后一起超出预算
+var budgetMu sync.Mutex
+
+// noBudgetLock 未配置预算时的释放函数
+func noBudgetLock() {}
+
+// reserveBudget 持有预算锁并检查预算，通过时返回释放锁的函数，调用方写入任务后调用（可重复调用）
+// 锁内只应写入任务记录，保存参考图等文件操作放在加锁之前；未配置预算时不加锁，超出预算或检查失败时不持有锁
+func reserveBudget(items []budgetItem) (func(), error) {
+	if len(configuredBudgetLimits(time.Now())) == 0 {
+		return noBudgetLock, nil
+	}
+	budgetMu.Lock()
+	if err := checkBudget(items); err != nil {
+		budgetMu.Unlock()
+		return nil, err
+	}
+	var once sync.Once
+	return func() { once.Do(budgetMu.Unlock) }, nil
+}
+
+// respondBudgetError 超出预算时返回 402 和超出的预算，其他错误返回 500
+func respondBudgetError(c *gin.Context, err error) {
+	var exceeded *budgetExceededError
+	if errors.As(err, &exceeded) {
+		utils.LogAPI("拒绝生成请求: %v", err)
+		c.JSON(402, gin.H{"error": err.Error(), "budget": exceeded.Limit})
+		return
+	}
+	utils.LogAPI("检查预算失败: %v", err)
+	c.JSON(500, gin.H{"error": "检查预算失败"})
+}
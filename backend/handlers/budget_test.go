@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"sigma/config"
+	"sigma/models"
+)
+
+func TestCheckBudget_RejectsGenerationOverBudget(t *testing.T) {
+	r, cleanup := setupGenerationTypeTest(t)
+	defer cleanup()
+	config.DB.AutoMigrate(&models.CreditLedgerEntry{})
+	r.GET("/config/check", CheckConfigHandler)
+
+	unit, daily, typeDaily, warn := config.BudgetUnit, config.BudgetDailyLimit, config.BudgetTypeDailyLimits, config.BudgetWarnPercent
+	originalToken := config.GetAPIToken()
+	defer func() {
+		config.BudgetUnit, config.BudgetDailyLimit, config.BudgetTypeDailyLimits, config.BudgetWarnPercent = unit, daily, typeDaily, warn
+		config.SetAPIToken(originalToken)
+	}()
+	config.BudgetUnit = config.BudgetUnitCredits
+	config.BudgetDailyLimit = 5
+	config.BudgetTypeDailyLimits = map[string]int{models.GenerationTypeWhiteBackground: 1}
+	config.BudgetWarnPercent = 50
+	config.SetAPIToken("test-api-key")
+
+	// 今天已生成一张 4K（2 额度），昨天的记录不计入每日预算；排队中的 2K 任务预留 1 额度
+	config.DB.Create(&models.CreditLedgerEntry{Type: models.GenerationTypeCreate, ImageSize: "4K", Credits: 2})
+	config.DB.Create(&models.CreditLedgerEntry{Type: models.GenerationTypeCreate, ImageSize: "4K", Credits: 2, CreatedAt: time.Now().AddDate(0, 0, -1)})
+	config.DB.Create(&models.GenerationTask{TaskID: "queued", Status: models.TaskStatusQueued, Type: models.GenerationTypeCreate, ImageSize: "2K", ImageCount: 1})
+
+	w := postGenerate(r, map[string]string{"type": models.GenerationTypeCreate, "imageSize": "4K", "count": "2"}, 0)
+	if w.Code != http.StatusPaymentRequired {
+		t.Fatalf("超出预算应返回 402，实际为 %d: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		Error  string      `json:"error"`
+		Budget budgetLimit `json:"budget"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if response.Budget.Period != "day" || response.Budget.Used != 2 || response.Budget.Reserved != 1 || response.Budget.Requested != 4 || response.Error == "" {
+		t.Errorf("应返回超出的每日预算: %+v", response)
+	}
+	var taskCount int64
+	config.DB.Model(&models.GenerationTask{}).Count(&taskCount)
+	if taskCount != 1 {
+		t.Errorf("超出预算时不应创建任务，实际任务数 %d", taskCount)
+	}
+
+	// 恰好用满总预算时允许；类型预算单独限制
+	if err := checkBudget([]budgetItem{{Type: models.GenerationTypeCreate, ImageSize: "2K"}, {Type: models.GenerationTypeCreate, ImageSize: "2K"}}); err != nil {
+		t.Errorf("未超出预算时不应拒绝: %v", err)
+	}
+	err := checkBudget([]budgetItem{{Type: models.GenerationTypeWhiteBackground, ImageSize: "1K"}, {Type: models.GenerationTypeWhiteBackground, ImageSize: "1K"}})
+	var exceeded *budgetExceededError
+	if !errors.As(err, &exceeded) || exceeded.Limit.Type != models.GenerationTypeWhiteBackground {
+		t.Errorf("应超出白底图的每日预算: %v", err)
+	}
+
+	// 配置检查返回预算使用情况，总预算已用 60% 达到提示阈值
+	config.SetAPIToken("")
+	w = doJSON(r, "GET", "/config/check", "")
+	var checked struct {
+		Budget BudgetStatus `json:"budget"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &checked)
+	budget := checked.Budget
+	if !budget.Enabled || !budget.Warning || budget.Unit != config.BudgetUnitCredits || len(budget.Limits) != 2 {
+		t.Fatalf("预算状态不正确: %+v", budget)
+	}
+	if total := budget.Limits[0]; total.Type != "" || total.Percent != 60 || total.Remaining != 2 || !total.Warning {
+		t.Errorf("总预算使用情况不正确: %+v", total)
+	}
+	if typed := budget.Limits[1]; typed.Type != models.GenerationTypeWhiteBackground || typed.Warning {
+		t.Errorf("白底图预算未使用，不应提示: %+v", typed)
+	}
+}
+
+func TestCheckBudget_CountsUnrecordedAndSerializesRequests(t *testing.T) {
+	r, cleanup := setupGenerationTypeTest(t)
+	defer cleanup()
+	config.DB.AutoMigrate(&models.CreditLedgerEntry{})
+
+	unit, daily := config.BudgetUnit, config.BudgetDailyLimit
+	originalToken := config.GetAPIToken()
+	defer func() {
+		config.BudgetUnit, config.BudgetDailyLimit = unit, daily
+		config.SetAPIToken(originalToken)
+	}()
+	config.SetAPIToken("test-api-key")
+	config.BudgetUnit = config.BudgetUnitImages
+
+	// 未配置预算时不加锁，不会阻塞其他请求
+	config.BudgetDailyLimit = 0
+	for i := 0; i < 2; i++ {
+		if _, err := reserveBudget([]budgetItem{{Type: models.GenerationTypeCreate, ImageSize: "2K"}}); err != nil {
+			t.Fatalf("未配置预算时不应拒绝: %v", err)
+		}
+	}
+	config.BudgetDailyLimit = 3
+
+	// 已完成但还没写入台账的任务计为已用；已有台账的任务不重复计入
+	now := time.Now()
+	config.DB.Create(&models.GenerationTask{TaskID: "recorded", Status: models.TaskStatusCompleted, Type: models.GenerationTypeCreate, ImageSize: "2K", FinishedAt: &now})
+	config.DB.Create(&models.CreditLedgerEntry{TaskID: "recorded", Type: models.GenerationTypeCreate, ImageSize: "2K", Credits: 1})
+	config.DB.Create(&models.GenerationTask{TaskID: "unrecorded", Status: models.TaskStatusCompleted, Type: models.GenerationTypeCreate, ImageSize: "2K", FinishedAt: &now})
+
+	status, err := BuildBudgetStatus()
+	if err != nil || len(status.Limits) != 1 || status.Limits[0].Used != 2 || status.Limits[0].Reserved != 0 {
+		t.Fatalf("已用数量应包含未写入台账的已完成任务: %+v, %v", status, err)
+	}
+
+	// 剩余 1 张预算：第一个请求持有预算锁直到任务写入，之后的请求在锁上等待并按新的用量检查
+	item := []budgetItem{{Type: models.GenerationTypeCreate, ImageSize: "2K"}}
+	release, err := reserveBudget(item)
+	if err != nil {
+		t.Fatalf("剩余预算足够时不应拒绝: %v", err)
+	}
+	result := make(chan error, 1)
+	go func() {
+		release, err := reserveBudget(item)
+		if err == nil {
+			release()
+		}
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		t.Fatalf("持有预算锁时其他请求应等待，实际已返回: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	config.DB.Create(&models.GenerationTask{TaskID: "queued", Status: models.TaskStatusQueued, Type: models.GenerationTypeCreate, ImageSize: "2K", ImageCount: 1})
+	release()
+	release() // 重复释放不影响
+	var exceeded *budgetExceededError
+	if err := <-result; !errors.As(err, &exceeded) {
+		t.Errorf("任务写入后等待的请求应超出预算: %v", err)
+	}
+
+	w := postGenerate(r, map[string]string{"type": models.GenerationTypeCreate, "imageSize": "2K"}, 0)
+	if w.Code != http.StatusPaymentRequired {
+		t.Errorf("预算用完后应返回 402，实际为 %d", w.Code)
+	}
+}
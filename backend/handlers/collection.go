@@ -0,0 +1,419 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"sigma/config"
+	"sigma/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	maxTagLength            = 32 // 单个标签最大字符数
+	maxCollectionNameLength = 64 // 合集名称最大字符数
+)
+
+// loadHistoryTags 批量读取历史记录的标签，每条记录都有对应的（可能为空的）标签列表
+func loadHistoryTags(history []models.GenerationHistory) map[uint][]string {
+	tags := make(map[uint][]string, len(history))
+	if len(history) == 0 {
+		return tags
+	}
+	ids := make([]uint, len(history))
+	for i, h := range history {
+		ids[i] = h.ID
+		tags[h.ID] = []string{}
+	}
+
+	var rows []models.HistoryTag
+	config.DB.Where("history_id IN ?", ids).Order("tag asc").Find(&rows)
+	for _, row := range rows {
+		tags[row.HistoryID] = append(tags[row.HistoryID], row.Tag)
+	}
+	return tags
+}
+
+// normalizeTags 去除标签首尾空白并去重，空标签忽略
+func normalizeTags(raw []string) ([]string, error) {
+	seen := make(map[string]bool, len(raw))
+	tags := make([]string, 0, len(raw))
+	for _, tag := range raw {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, fmt.Errorf("标签 %s 过长，最多 %d 个字符", tag, maxTagLength)
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// applyCurationFilters 应用收藏、标签和合集筛选条件
+// favorite=true|false；tag 可重复或逗号分隔，记录需包含全部标签；collection_id 可重复或逗号分隔，记录属于任一合集即可
+func applyCurationFilters(c *gin.Context, query *gorm.DB) (*gorm.DB, error) {
+	switch c.Query("favorite") {
+	case "":
+	case "true":
+		query = query.Where("favorite = ?", true)
+	case "false":
+		query = query.Where("favorite = ? OR favorite IS NULL", false)
+	default:
+		return nil, fmt.Errorf("favorite 参数只能为 true 或 false")
+	}
+
+	for _, tag := range queryValues(c, "tag") {
+		query = query.Where("id IN (?)", config.DB.Model(&models.HistoryTag{}).Select("history_id").Where("tag = ?", tag))
+	}
+
+	if values := queryValues(c, "collection_id"); len(values) > 0 {
+		ids := make([]uint, len(values))
+		for i, v := range values {
+			id, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("无效的合集 ID: %s", v)
+			}
+			ids[i] = uint(id)
+		}
+		query = query.Where("id IN (?)", config.DB.Model(&models.CollectionItem{}).Select("history_id").Where("collection_id IN ?", ids))
+	}
+	return query, nil
+}
+
+// existingHistoryIDs 过滤出实际存在的历史记录 ID
+func existingHistoryIDs(ids []uint) ([]uint, error) {
+	var existing []uint
+	err := config.DB.Model(&models.GenerationHistory{}).Where("id IN ?", ids).Pluck("id", &existing).Error
+	return existing, err
+}
+
+// SetFavoriteHandler 批量收藏或取消收藏
+// POST /history/favorite {"ids": [1, 2], "favorite": true}
+func SetFavoriteHandler(c *gin.Context) {
+	var req struct {
+		IDs      []uint `json:"ids" binding:"required"`
+		Favorite *bool  `json:"favorite" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "无效的请求参数"})
+		return
+	}
+	if len(req.IDs) == 0 {
+		c.JSON(400, gin.H{"error": "请选择要收藏的记录"})
+		return
+	}
+
+	result := config.DB.Model(&models.GenerationHistory{}).Where("id IN ?", req.IDs).Update("favorite", *req.Favorite)
+	if result.Error != nil {
+		c.JSON(500, gin.H{"error": "更新记录失败"})
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": "更新成功",
+		"updated": result.RowsAffected,
+	})
+}
+
+// UpdateHistoryTagsHandler 批量添加或移除标签
+// POST /history/tags {"ids": [1, 2], "add": ["客户A"], "remove": ["草稿"]}
+func UpdateHistoryTagsHandler(c *gin.Context) {
+	var req struct {
+		IDs    []uint   `json:"ids" binding:"required"`
+		Add    []string `json:"add"`
+		Remove []string `json:"remove"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "无效的请求参数"})
+		return
+	}
+	if len(req.IDs) == 0 {
+		c.JSON(400, gin.H{"error": "请选择要设置标签的记录"})
+		return
+	}
+	add, err := normalizeTags(req.Add)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	remove, _ := normalizeTags(req.Remove)
+	if len(add) == 0 && len(remove) == 0 {
+		c.JSON(400, gin.H{"error": "请指定要添加或移除的标签"})
+		return
+	}
+
+	ids, err := existingHistoryIDs(req.IDs)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "查询记录失败"})
+		return
+	}
+
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if len(remove) > 0 {
+			if err := tx.Where("history_id IN ? AND tag IN ?", ids, remove).Delete(&models.HistoryTag{}).Error; err != nil {
+				return err
+			}
+		}
+		rows := make([]models.HistoryTag, 0, len(ids)*len(add))
+		for _, id := range ids {
+			for _, tag := range add {
+				rows = append(rows, models.HistoryTag{HistoryID: id, Tag: tag})
+			}
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		// 已有的标签保持不变
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "更新标签失败"})
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": "更新成功",
+		"updated": len(ids),
+	})
+}
+
+// tagCount 标签及使用该标签的记录数
+type tagCount struct {
+	Tag   string `json:"tag"`
+	Count int64  `json:"count"`
+}
+
+// ListTagsHandler 获取所有标签及使用次数，按使用次数倒序
+// GET /tags
+func ListTagsHandler(c *gin.Context) {
+	tags := []tagCount{}
+	err := config.DB.Model(&models.HistoryTag{}).
+		Select("tag, COUNT(*) AS count").
+		Group("tag").
+		Order("count desc, tag asc").
+		Scan(&tags).Error
+	if err != nil {
+		c.JSON(500, gin.H{"error": "获取标签失败"})
+		return
+	}
+	c.JSON(200, tags)
+}
+
+// collectionRequest 创建或修改合集的请求体
+type collectionRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+}
+
+// ListCollectionsHandler 获取所有合集及其记录数
+// GET /collections
+func ListCollectionsHandler(c *gin.Context) {
+	var collections []models.Collection
+	if err := config.DB.Order("updated_at desc, id desc").Find(&collections).Error; err != nil {
+		c.JSON(500, gin.H{"error": "获取合集失败"})
+		return
+	}
+
+	var counts []struct {
+		CollectionID uint
+		Count        int64
+	}
+	config.DB.Model(&models.CollectionItem{}).
+		Select("collection_id, COUNT(*) AS count").
+		Group("collection_id").
+		Scan(&counts)
+	countByID := make(map[uint]int64, len(counts))
+	for _, row := range counts {
+		countByID[row.CollectionID] = row.Count
+	}
+	for i := range collections {
+		collections[i].ItemCount = countByID[collections[i].ID]
+	}
+	c.JSON(200, collections)
+}
+
+// CreateCollectionHandler 新建合集
+// POST /collections {"name": "客户A 春季上新", "description": "..."}
+func CreateCollectionHandler(c *gin.Context) {
+	var req collectionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "请求格式错误"})
+		return
+	}
+	if req.Name == nil {
+		c.JSON(400, gin.H{"error": "合集名称不能为空"})
+		return
+	}
+	collection := models.Collection{}
+	if err := applyCollectionRequest(&collection, req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if collectionNameTaken(collection.Name, 0) {
+		c.JSON(409, gin.H{"error": "合集名称已存在"})
+		return
+	}
+
+	if err := config.DB.Create(&collection).Error; err != nil {
+		c.JSON(500, gin.H{"error": "保存合集失败"})
+		return
+	}
+	c.JSON(200, collection)
+}
+
+// UpdateCollectionHandler 修改合集名称或描述
+// PUT /collections/:id
+func UpdateCollectionHandler(c *gin.Context) {
+	collection, ok := loadCollection(c)
+	if !ok {
+		return
+	}
+	var req collectionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "请求格式错误"})
+		return
+	}
+	if err := applyCollectionRequest(collection, req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if collectionNameTaken(collection.Name, collection.ID) {
+		c.JSON(409, gin.H{"error": "合集名称已存在"})
+		return
+	}
+
+	if err := config.DB.Save(collection).Error; err != nil {
+		c.JSON(500, gin.H{"error": "保存合集失败"})
+		return
+	}
+	c.JSON(200, collection)
+}
+
+// DeleteCollectionHandler 删除合集，合集中的历史记录保留
+// DELETE /collections/:id
+func DeleteCollectionHandler(c *gin.Context) {
+	collection, ok := loadCollection(c)
+	if !ok {
+		return
+	}
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("collection_id = ?", collection.ID).Delete(&models.CollectionItem{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(collection).Error
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "删除合集失败"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "删除成功"})
+}
+
+// AddCollectionItemsHandler 批量将历史记录加入合集，已在合集中的记录忽略
+// POST /collections/:id/items {"ids": [1, 2]}
+func AddCollectionItemsHandler(c *gin.Context) {
+	collection, ids, ok := bindCollectionItems(c)
+	if !ok {
+		return
+	}
+	existing, err := existingHistoryIDs(ids)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "查询记录失败"})
+		return
+	}
+
+	var added int64
+	if len(existing) > 0 {
+		items := make([]models.CollectionItem, len(existing))
+		for i, id := range existing {
+			items[i] = models.CollectionItem{CollectionID: collection.ID, HistoryID: id}
+		}
+		result := config.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&items)
+		if result.Error != nil {
+			c.JSON(500, gin.H{"error": "加入合集失败"})
+			return
+		}
+		added = result.RowsAffected
+		config.DB.Model(collection).Update("updated_at", time.Now())
+	}
+	c.JSON(200, gin.H{
+		"message": "加入成功",
+		"added":   added,
+	})
+}
+
+// RemoveCollectionItemsHandler 批量将历史记录移出合集
+// POST /collections/:id/items/remove {"ids": [1, 2]}
+func RemoveCollectionItemsHandler(c *gin.Context) {
+	collection, ids, ok := bindCollectionItems(c)
+	if !ok {
+		return
+	}
+	result := config.DB.Where("collection_id = ? AND history_id IN ?", collection.ID, ids).Delete(&models.CollectionItem{})
+	if result.Error != nil {
+		c.JSON(500, gin.H{"error": "移出合集失败"})
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": "移出成功",
+		"removed": result.RowsAffected,
+	})
+}
+
+// applyCollectionRequest 将请求中提交的字段写入合集
+func applyCollectionRequest(collection *models.Collection, req collectionRequest) error {
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			return fmt.Errorf("合集名称不能为空")
+		}
+		if utf8.RuneCountInString(name) > maxCollectionNameLength {
+			return fmt.Errorf("合集名称过长，最多 %d 个字符", maxCollectionNameLength)
+		}
+		collection.Name = name
+	}
+	if req.Description != nil {
+		collection.Description = strings.TrimSpace(*req.Description)
+	}
+	return nil
+}
+
+// collectionNameTaken 检查合集名称是否已被其他合集使用
+func collectionNameTaken(name string, excludeID uint) bool {
+	var count int64
+	config.DB.Model(&models.Collection{}).Where("name = ? AND id != ?", name, excludeID).Count(&count)
+	return count > 0
+}
+
+// bindCollectionItems 读取合集和请求中的记录 ID，失败时直接返回错误响应
+func bindCollectionItems(c *gin.Context) (*models.Collection, []uint, bool) {
+	collection, ok := loadCollection(c)
+	if !ok {
+		return nil, nil, false
+	}
+	var req struct {
+		IDs []uint `json:"ids" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.IDs) == 0 {
+		c.JSON(400, gin.H{"error": "请选择记录"})
+		return nil, nil, false
+	}
+	return collection, req.IDs, true
+}
+
+// loadCollection 按路径参数读取合集，不存在时直接返回 404
+func loadCollection(c *gin.Context) (*models.Collection, bool) {
+	var collection models.Collection
+	if err := config.DB.First(&collection, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "合集不存在"})
+		return nil, false
+	}
+	return &collection, true
+}
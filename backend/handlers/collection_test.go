@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"sigma/config"
+	"sigma/models"
+)
+
+func setupCollectionTest(t *testing.T) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/history", HistoryHandler)
+	r.GET("/history/query", HistoryQueryHandler)
+	r.POST("/history/favorite", SetFavoriteHandler)
+	r.POST("/history/tags", UpdateHistoryTagsHandler)
+	r.GET("/tags", ListTagsHandler)
+	r.GET("/collections", ListCollectionsHandler)
+	r.POST("/collections", CreateCollectionHandler)
+	r.PUT("/collections/:id", UpdateCollectionHandler)
+	r.DELETE("/collections/:id", DeleteCollectionHandler)
+	r.POST("/collections/:id/items", AddCollectionItemsHandler)
+	r.POST("/collections/:id/items/remove", RemoveCollectionItemsHandler)
+	return r
+}
+
+func getHistory(t *testing.T, r *gin.Engine, path string) []models.GenerationHistoryResponse {
+	req, _ := http.NewRequest("GET", path, nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s 期望状态码 200，实际为 %d: %s", path, w.Code, w.Body.String())
+	}
+	var history []models.GenerationHistoryResponse
+	json.Unmarshal(w.Body.Bytes(), &history)
+	return history
+}
+
+func TestHistoryTagsAndFavorites(t *testing.T) {
+	cleanup := setupHistoryTestDB(t)
+	defer cleanup()
+	r := setupCollectionTest(t)
+
+	for i := 1; i <= 3; i++ {
+		config.DB.Create(&models.GenerationHistory{Prompt: fmt.Sprintf("图片 %d", i), ImageURL: "images/x.png", Type: models.GenerationTypeCreate})
+	}
+
+	if w := doJSON(r, "POST", "/history/tags", `{"ids": [1, 2, 99], "add": ["客户A", " 精选 ", "客户A"]}`); w.Code != http.StatusOK {
+		t.Fatalf("添加标签失败: %d %s", w.Code, w.Body.String())
+	}
+	// 重复添加不产生重复标签，同时移除标签
+	if w := doJSON(r, "POST", "/history/tags", `{"ids": [2, 3], "add": ["客户A"], "remove": ["精选"]}`); w.Code != http.StatusOK {
+		t.Fatalf("更新标签失败: %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(r, "POST", "/history/tags", `{"ids": [1]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("未指定标签应返回 400，实际为 %d", w.Code)
+	}
+	if w := doJSON(r, "POST", "/history/favorite", `{"ids": [3], "favorite": true}`); w.Code != http.StatusOK {
+		t.Fatalf("收藏失败: %d %s", w.Code, w.Body.String())
+	}
+
+	var tags []tagCount
+	req, _ := http.NewRequest("GET", "/tags", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	json.Unmarshal(w.Body.Bytes(), &tags)
+	if len(tags) != 2 || tags[0] != (tagCount{Tag: "客户A", Count: 3}) || tags[1] != (tagCount{Tag: "精选", Count: 1}) {
+		t.Errorf("标签统计不正确: %+v", tags)
+	}
+
+	history := getHistory(t, r, "/history?tag=客户A,精选")
+	if len(history) != 1 || history[0].ID != 1 || len(history[0].Tags) != 2 {
+		t.Errorf("期望只返回同时包含两个标签的记录 1: %+v", history)
+	}
+	history = getHistory(t, r, "/history?favorite=true&tag=客户A")
+	if len(history) != 1 || history[0].ID != 3 || !history[0].Favorite {
+		t.Errorf("期望只返回收藏的记录 3: %+v", history)
+	}
+	if history := getHistory(t, r, "/history"); len(history) != 3 || history[0].Tags == nil {
+		t.Errorf("未打标签的记录也应返回标签列表: %+v", history)
+	}
+}
+
+func TestCollections(t *testing.T) {
+	cleanup := setupHistoryTestDB(t)
+	defer cleanup()
+	r := setupCollectionTest(t)
+
+	for i := 1; i <= 3; i++ {
+		config.DB.Create(&models.GenerationHistory{Prompt: fmt.Sprintf("图片 %d", i), ImageURL: "images/x.png", Type: models.GenerationTypeCreate})
+	}
+
+	w := doJSON(r, "POST", "/collections", `{"name": " 客户A 春季上新 ", "description": "首批"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("创建合集失败: %d %s", w.Code, w.Body.String())
+	}
+	var clientA models.Collection
+	json.Unmarshal(w.Body.Bytes(), &clientA)
+	if clientA.Name != "客户A 春季上新" {
+		t.Errorf("合集名称应去除首尾空白: %q", clientA.Name)
+	}
+	if w := doJSON(r, "POST", "/collections", `{"name": "客户A 春季上新"}`); w.Code != http.StatusConflict {
+		t.Errorf("重复的合集名称应返回 409，实际为 %d", w.Code)
+	}
+	doJSON(r, "POST", "/collections", `{"name": "客户B"}`)
+
+	itemsPath := fmt.Sprintf("/collections/%d/items", clientA.ID)
+	if w := doJSON(r, "POST", itemsPath, `{"ids": [1, 2, 99]}`); w.Code != http.StatusOK {
+		t.Fatalf("加入合集失败: %d %s", w.Code, w.Body.String())
+	}
+	doJSON(r, "POST", itemsPath, `{"ids": [2, 3]}`)
+	doJSON(r, "POST", "/collections/2/items", `{"ids": [3]}`)
+	doJSON(r, "POST", itemsPath+"/remove", `{"ids": [1]}`)
+
+	var collections []models.Collection
+	req, _ := http.NewRequest("GET", "/collections", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	json.Unmarshal(w.Body.Bytes(), &collections)
+	counts := map[string]int64{}
+	for _, col := range collections {
+		counts[col.Name] = col.ItemCount
+	}
+	if len(collections) != 2 || counts["客户A 春季上新"] != 2 || counts["客户B"] != 1 {
+		t.Errorf("合集记录数不正确: %+v", collections)
+	}
+
+	var page historyQueryResponse
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/history/query?collection_id=%d", clientA.ID), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	json.Unmarshal(w.Body.Bytes(), &page)
+	if page.Total != 2 {
+		t.Errorf("期望合集中有 2 条记录，实际为 %d", page.Total)
+	}
+	if history := getHistory(t, r, "/history?collection_id=1,2"); len(history) != 2 {
+		t.Errorf("属于任一合集的记录都应返回，实际为 %d", len(history))
+	}
+
+	// 删除合集只删除关联，历史记录保留
+	if w := doJSON(r, "DELETE", fmt.Sprintf("/collections/%d", clientA.ID), ""); w.Code != http.StatusOK {
+		t.Fatalf("删除合集失败: %d", w.Code)
+	}
+	var itemCount, historyCount int64
+	config.DB.Model(&models.CollectionItem{}).Where("collection_id = ?", clientA.ID).Count(&itemCount)
+	config.DB.Model(&models.GenerationHistory{}).Count(&historyCount)
+	if itemCount != 0 || historyCount != 3 {
+		t.Errorf("删除合集后关联应为 0、记录应为 3，实际为 %d / %d", itemCount, historyCount)
+	}
+	if w := doJSON(r, "POST", itemsPath, `{"ids": [1]}`); w.Code != http.StatusNotFound {
+		t.Errorf("已删除的合集应返回 404，实际为 %d", w.Code)
+	}
+}
@@ -1,59 +1,14 @@
 package handlers
 
 import (
-	"crypto/tls"
-	"encoding/hex"
-	"encoding/json"
 	"fmt"
-	"io"
-	"math"
-	"net/http"
 	"sigma/config"
-	"time"
+	"sigma/providers"
+	"sigma/utils"
 
 	"github.com/gin-gonic/gin"
 )
 
-// 平台配置（加密存储）
-type platformConfig struct {
-	BaseURL string
-	User    string
-	Key     string
-}
-
-// VectorEngine 平台配置
-var vectorEngineConfig = platformConfig{
-	BaseURL: "https://api.vectorengine.ai",
-	User:    decodeHex("313432353338"),
-	Key:     decodeHex("30736f684368584a764a51394579444c5a3738637879334b796f41786956733d"),
-}
-
-// Aiaimi 平台配置
-var aiaimiConfig = platformConfig{
-	BaseURL: "https://aiaimi.cc",
-	User:    decodeHex("33"),                                                       // "3"
-	Key:     decodeHex("77485a584d356f6e63674a6e6d444f7776473842696a58756e42584d"), // "wHZXM5oncgJnmDOwvG8BijXunBXM"
-}
-
-// decodeHex 解码十六进制字符串
-func decodeHex(hexStr string) string {
-	bytes, err := hex.DecodeString(hexStr)
-	if err != nil {
-		return ""
-	}
-	return string(bytes)
-}
-
-// TokenValidationResult 验证结果
-type TokenValidationResult struct {
-	Valid    bool
-	Name     string
-	Remain   float64
-	Used     float64
-	Group    string // 分组信息
-	Platform string // 内部使用，不暴露给前端
-}
-
 // CheckConfigHandler 检查配置 Handler
 func CheckConfigHandler(c *gin.Context) {
 	apiKey := config.GetAPIToken()
@@ -82,7 +37,8 @@ func CheckConfigHandler(c *gin.Context) {
 	var used float64 = 0
 	var tokenName string = ""
 	if hasAPIKey {
-		result := validateApiKeyAllPlatforms(apiKey)
+		result := providers.ValidateAll(apiKey)
+		rememberPricing(result)
 		if result.Valid {
 			remain = result.Remain
 			used = result.Used
@@ -90,6 +46,12 @@ func CheckConfigHandler(c *gin.Context) {
 		}
 	}
 
+	// 预算使用情况，达到提示阈值时 budget.warning 为 true
+	budget, err := BuildBudgetStatus()
+	if err != nil {
+		utils.LogAPI("统计预算使用情况失败: %v", err)
+	}
+
 	c.JSON(200, gin.H{
 		"has_api_key":       hasAPIKey,
 		"masked_key":        maskedKey,
@@ -99,6 +61,7 @@ func CheckConfigHandler(c *gin.Context) {
 		"remain":            remain,
 		"used":              used,
 		"token_name":        tokenName,
+		"budget":            budget,
 	})
 }
 
@@ -119,7 +82,7 @@ func ValidateApiKeyHandler(c *gin.Context) {
 	}
 
 	// 调用验证服务检查 Key 是否有效（自动尝试所有平台）
-	result := validateApiKeyAllPlatforms(req.ApiKey)
+	result := providers.ValidateAll(req.ApiKey)
 	if !result.Valid {
 		c.JSON(400, gin.H{
 			"valid": false,
@@ -136,193 +99,6 @@ func ValidateApiKeyHandler(c *gin.Context) {
 	})
 }
 
-// validateApiKeyAllPlatforms 自动尝试所有平台验证 API Key
-func validateApiKeyAllPlatforms(apiKey string) TokenValidationResult {
-	// 1. 尝试 VectorEngine
-	result := checkVectorEngine(apiKey)
-	if result.Valid {
-		return result
-	}
-
-	// 2. 如果失败，尝试 Aiaimi
-	result = checkAiaimi(apiKey)
-	if result.Valid {
-		return result
-	}
-
-	// 3. 都失败了
-	return TokenValidationResult{Valid: false}
-}
-
-// checkVectorEngine 验证 VectorEngine 平台的 API Key
-func checkVectorEngine(apiKey string) TokenValidationResult {
-	url := fmt.Sprintf("%s/api/token/search?keyword=&token=%s", vectorEngineConfig.BaseURL, apiKey)
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return TokenValidationResult{Valid: false}
-	}
-
-	// 设置验证头
-	req.Header.Set("new-api-user", vectorEngineConfig.User)
-	req.Header.Set("Authorization", vectorEngineConfig.Key)
-
-	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return TokenValidationResult{Valid: false}
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return TokenValidationResult{Valid: false}
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return TokenValidationResult{Valid: false}
-	}
-
-	// 解析响应
-	var result map[string]interface{}
-	if err := json.Unmarshal(body, &result); err != nil {
-		return TokenValidationResult{Valid: false}
-	}
-
-	if result["success"] != true {
-		return TokenValidationResult{Valid: false}
-	}
-
-	dataList, ok := result["data"].([]interface{})
-	if !ok || len(dataList) == 0 {
-		return TokenValidationResult{Valid: false}
-	}
-
-	info := dataList[0].(map[string]interface{})
-
-	// 获取分组信息
-	group := ""
-	if g, ok := info["group"].(string); ok {
-		group = g
-	}
-
-	// 根据分组确定单张成本
-	// 限时特价: 0.099/张
-	// 优质gemini（默认）: 0.165/张
-	costPerImage := 0.165
-	if group == "限时特价" {
-		costPerImage = 0.099
-	}
-
-	// VectorEngine 算法
-	remainQuota := 0.0
-	usedQuota := 0.0
-	if v, ok := info["remain_quota"].(float64); ok {
-		remainQuota = v
-	}
-	if v, ok := info["used_quota"].(float64); ok {
-		usedQuota = v
-	}
-
-	remainSheets := math.Round(remainQuota / 1000000.0 / costPerImage)
-	usedSheets := math.Round(usedQuota / 1000000.0 / costPerImage)
-
-	name := "未命名"
-	if n, ok := info["name"].(string); ok && n != "" {
-		name = n
-	}
-
-	return TokenValidationResult{
-		Valid:    true,
-		Name:     name,
-		Remain:   remainSheets,
-		Used:     usedSheets,
-		Group:    group,
-		Platform: "vectorengine",
-	}
-}
-
-// checkAiaimi 验证 Aiaimi 平台的 API Key
-func checkAiaimi(apiKey string) TokenValidationResult {
-	url := fmt.Sprintf("%s/api/token/search?keyword=&token=%s", aiaimiConfig.BaseURL, apiKey)
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return TokenValidationResult{Valid: false}
-	}
-
-	// 设置验证头
-	req.Header.Set("New-Api-User", aiaimiConfig.User)
-	req.Header.Set("Authorization", aiaimiConfig.Key)
-
-	// Aiaimi 需要跳过 TLS 验证
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}
-	client := &http.Client{
-		Transport: tr,
-		Timeout:   8 * time.Second,
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return TokenValidationResult{Valid: false}
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return TokenValidationResult{Valid: false}
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return TokenValidationResult{Valid: false}
-	}
-
-	// 解析响应
-	var result map[string]interface{}
-	if err := json.Unmarshal(body, &result); err != nil {
-		return TokenValidationResult{Valid: false}
-	}
-
-	if result["success"] != true {
-		return TokenValidationResult{Valid: false}
-	}
-
-	dataList, ok := result["data"].([]interface{})
-	if !ok || len(dataList) == 0 {
-		return TokenValidationResult{Valid: false}
-	}
-
-	info := dataList[0].(map[string]interface{})
-
-	// Aiaimi 算法
-	remainQuota := 0.0
-	usedQuota := 0.0
-	if v, ok := info["remain_quota"].(float64); ok {
-		remainQuota = v
-	}
-	if v, ok := info["used_quota"].(float64); ok {
-		usedQuota = v
-	}
-
-	remainSheets := (remainQuota / 500000.0) / 1.5
-	usedSheets := (usedQuota / 500000.0) / 1.5
-
-	name := "未命名"
-	if n, ok := info["name"].(string); ok && n != "" {
-		name = n
-	}
-
-	return TokenValidationResult{
-		Valid:    true,
-		Name:     name,
-		Remain:   remainSheets,
-		Used:     usedSheets,
-		Platform: "aiaimi",
-	}
-}
-
 // SetApiKeyHandler 设置 API Key Handler
 func SetApiKeyHandler(c *gin.Context) {
 	type Request struct {
@@ -344,7 +120,7 @@ func SetApiKeyHandler(c *gin.Context) {
 	var platform config.PlatformType = config.PlatformVectorEngine
 
 	if !req.SkipValidate {
-		result := validateApiKeyAllPlatforms(req.ApiKey)
+		result := providers.ValidateAll(req.ApiKey)
 		if !result.Valid {
 			c.JSON(400, gin.H{"error": "无效的 API Key"})
 			return
@@ -356,7 +132,7 @@ func SetApiKeyHandler(c *gin.Context) {
 		}
 	} else {
 		// 跳过验证时，仍然需要检测平台
-		result := validateApiKeyAllPlatforms(req.ApiKey)
+		result := providers.ValidateAll(req.ApiKey)
 		if result.Valid {
 			platform = config.PlatformType(result.Platform)
 			if !config.IsProduction {
@@ -402,7 +178,8 @@ func AutoDetectAndSetPlatform(apiKey string) {
 		return
 	}
 
-	result := validateApiKeyAllPlatforms(apiKey)
+	result := providers.ValidateAll(apiKey)
+	rememberPricing(result)
 	if result.Valid && result.Platform != "" {
 		platform := config.PlatformType(result.Platform)
 		if platform != config.GetAPIPlatform() {
@@ -0,0 +1,322 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"sigma/config"
+	"sigma/models"
+	"sigma/providers"
+	"sigma/utils"
+)
+
+// GenerationResult 一次生成调用的结果
+// 单图异步任务与多图 SSE 批次共用同一结果类型
+type GenerationResult struct {
+	Success      bool
+	ImageURL     string        // 成功时保存的图片相对路径（images/xxx）
+	SavedFiles   []string      // 本次调用写入输出目录的文件（相对路径）
+	ErrorMessage string        // 原始错误信息（未过滤）
+	StatusCode   int           // 供应商返回的 HTTP 状态码，请求未发出时为 0
+	IsQuotaError bool          // 是否为余额不足错误
+	Duration     time.Duration // 请求耗时
+	// 重试信息（由 runGenerationWithRetry 填充）
+	Attempts      int
+	AttemptErrors []models.AttemptError
+
+	err error // 原始错误，用于重试分类
+}
+
+// DisplayError 返回可展示给前端的错误信息
+// 余额错误保留原文供前端识别，其他错误过滤敏感信息
+func (r GenerationResult) DisplayError() string {
+	if r.IsQuotaError {
+		return r.ErrorMessage
+	}
+	return config.FilterSensitiveInfo(r.ErrorMessage)
+}
+
+// failedResult 构建失败结果并完成余额错误分类
+func failedResult(err error, statusCode int, duration time.Duration) GenerationResult {
+	return GenerationResult{
+		Success:      false,
+		ErrorMessage: err.Error(),
+		StatusCode:   statusCode,
+		IsQuotaError: isQuotaError(err.Error()),
+		Duration:     duration,
+		err:          err,
+	}
+}
+
+// cancelledResult 构建任务被取消时的结果
+func cancelledResult(statusCode int, duration time.Duration) GenerationResult {
+	result := failedResult(context.Canceled, statusCode, duration)
+	result.ErrorMessage = models.TaskCancelledMessage
+	return result
+}
+
+// isCancelled 结果是否因任务取消而失败
+func (r GenerationResult) isCancelled() bool {
+	return errors.Is(r.err, context.Canceled)
+}
+
+// discardSavedFiles 删除已写入输出目录的文件（任务取消时丢弃部分结果）
+// 内容相同的图片共用一个文件，仍被其他历史记录使用的文件保留
+func discardSavedFiles(files []string) {
+	for _, f := range files {
+		if outputImageInUse(filepath.Base(f), nil, false) {
+			continue
+		}
+		path := filepath.Join(config.OutputDir, filepath.Base(f))
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			utils.LogAPI("删除文件失败: %s, %v", path, err)
+		}
+	}
+}
+
+// runGeneration 执行一次完整的生成流程：构建请求、发送、解析响应并保存图片
+// label 仅用于日志，标识任务或批次内序号；取消 ctx 会中断上游请求并丢弃已保存的图片
+func runGeneration(ctx context.Context, provider providers.Provider, apiKey string, genReq providers.GenerateRequest, label string) GenerationResult {
+	if provider == nil {
+		return failedResult(errors.New("未配置可用的生成平台"), 0, 0)
+	}
+
+	utils.LogAPI("[%s] 构建请求: AspectRatio=%s, ImageSize=%s, RefImages=%d", label, genReq.AspectRatio, genReq.ImageSize, len(genReq.RefImages))
+	req, err := provider.BuildRequest(ctx, apiKey, genReq)
+	if err != nil {
+		utils.LogAPI("[%s] 创建请求失败: %v", label, err)
+		return failedResult(err, 0, 0)
+	}
+
+	utils.LogAPI("[%s] 开始 AI API 请求 (平台: %s, URL: %s)...", label, provider.Platform(), req.URL.String())
+	startTime := time.Now()
+	resp, err := provider.Send(req)
+	if err != nil {
+		duration := time.Since(startTime)
+		if ctx.Err() != nil {
+			utils.LogAPI("[%s] 请求已取消 (耗时: %v)", label, duration)
+			return cancelledResult(0, duration)
+		}
+		utils.LogAPI("[%s] 请求失败: %v (耗时: %v)", label, err, duration)
+		return failedResult(err, 0, duration)
+	}
+
+	images, err := provider.ParseResponse(resp)
+	if err != nil {
+		var apiErr *providers.APIError
+		if errors.As(err, &apiErr) {
+			utils.LogAPI("[%s] API 错误: %s", label, config.FilterSensitiveInfo(apiErr.Message))
+			return failedResult(apiErr, apiErr.StatusCode, resp.Duration)
+		}
+		utils.LogAPI("[%s] %v", label, err)
+		return failedResult(err, resp.StatusCode, resp.Duration)
+	}
+
+	// 提取图片 - 尝试多种方式，只有全部失败才报错
+	var lastError error
+	for _, img := range images {
+		localURL, err := saveProviderImage(ctx, img)
+		if err != nil {
+			lastError = err
+			utils.LogAPI("[%s] 图片保存失败: %v", label, err)
+			continue // 继续尝试其他方式
+		}
+		if ctx.Err() != nil {
+			discardSavedFiles([]string{localURL})
+			utils.LogAPI("[%s] 请求已取消，丢弃图片: %s", label, localURL)
+			return cancelledResult(resp.StatusCode, resp.Duration)
+		}
+		utils.LogAPI("[%s] 图片生成成功: %s (耗时: %v)", label, localURL, resp.Duration)
+		return GenerationResult{
+			Success:    true,
+			ImageURL:   localURL,
+			SavedFiles: []string{localURL},
+			StatusCode: resp.StatusCode,
+			Duration:   resp.Duration,
+		}
+	}
+
+	// 所有方式都失败了
+	utils.LogAPI("[%s] 所有处理方式都失败，最后错误: %v", label, lastError)
+	return failedResult(fmt.Errorf("请求成功但图片处理失败: %w", lastError), resp.StatusCode, resp.Duration)
+}
+
+// batchInfo 多图批次信息
+type batchInfo struct {
+	ID        string
+	Index     int
+	Total     int
+	Variation string // 提示词矩阵组合（JSON）
+}
+
+// recordGeneratedImage 保存成功生成的图片到历史记录
+// 历史记录沿用任务的提示词模板和批次信息
+func recordGeneratedImage(genReq providers.GenerateRequest, generationType string, refImagesJSON []byte, imageURL string, task *models.GenerationTask) models.GenerationHistory {
+	record := models.GenerationHistory{
+		Prompt:         genReq.Prompt,
+		OriginalPrompt: task.OriginalPrompt,
+		TemplateID:     task.TemplateID,
+		ImageURL:       imageURL,
+		FileName:       extractFileName(imageURL),
+		RefImages:      string(refImagesJSON),
+		Type:           generationType,
+		AspectRatio:    genReq.AspectRatio,
+		ImageSize:      genReq.ImageSize,
+	}
+	if batch := batchInfoFor(task); batch != nil {
+		batchID, batchIndex, batchTotal := batch.ID, batch.Index, batch.Total
+		record.BatchID = &batchID
+		record.BatchIndex = &batchIndex
+		record.BatchTotal = &batchTotal
+		record.Variation = batch.Variation
+	}
+	config.DB.Create(&record)
+
+	return record
+}
+
+// isQuotaError 检查错误消息是否是余额不足错误
+func isQuotaError(errorMessage string) bool {
+	quotaKeywords := []string{
+		"额度已用尽",
+		"余额不足",
+		"quota",
+		"insufficient",
+		"RemainQuota",
+		"balance",
+	}
+	lowerMsg := strings.ToLower(errorMessage)
+	for _, keyword := range quotaKeywords {
+		if strings.Contains(lowerMsg, strings.ToLower(keyword)) {
+			return true
+		}
+	}
+	return false
+}
+
+// saveBase64Image 保存 base64 图片到本地
+func saveBase64Image(dataURL string) (string, error) {
+	utils.LogAPI("开始处理 base64 图片")
+
+	// 解析 data URL: data:image/jpeg;base64,/9j/4AAQ...
+	re := regexp.MustCompile(`^data:image/([^;]+);base64,(.+)$`)
+	matches := re.FindStringSubmatch(dataURL)
+	if len(matches) != 3 {
+		return "", fmt.Errorf("无效的 base64 data URL 格式")
+	}
+
+	mimeType := matches[1] // jpeg, png, etc.
+	base64Data := matches[2]
+
+	// 解码 base64
+	imgData, err := base64.StdEncoding.DecodeString(base64Data)
+	if err != nil {
+		return "", fmt.Errorf("解码 base64 失败: %w", err)
+	}
+
+	// 确定文件扩展名
+	ext := "." + mimeType
+	if mimeType == "jpeg" {
+		ext = ".jpg"
+	}
+
+	// 保存到本地（按内容寻址）
+	relativeImageURL, err := storeFile(models.StoredFileKindOutput, imgData, ext)
+	if err != nil {
+		return "", fmt.Errorf("保存图片失败: %w", err)
+	}
+	utils.LogAPI("base64 图片保存成功: %s (%d bytes)", relativeImageURL, len(imgData))
+	return relativeImageURL, nil
+}
+
+// imageDownloadTimeout 下载生成结果图片的超时时间
+const imageDownloadTimeout = 60 * time.Second
+
+// downloadAndSaveImage 下载图片并保存到本地
+func downloadAndSaveImage(ctx context.Context, imageURL string) (string, error) {
+	utils.LogAPI("开始下载图片: %s", imageURL)
+
+	// 创建 HTTP 客户端
+	client := &http.Client{Timeout: imageDownloadTimeout}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", imageURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("下载图片失败: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("下载图片失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("下载图片失败，状态码: %d", resp.StatusCode)
+	}
+
+	// 读取图片数据
+	imgData, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("读取图片数据失败: %w", err)
+	}
+
+	// 确定文件扩展名
+	ext := ".jpg"
+	contentType := resp.Header.Get("Content-Type")
+	if strings.Contains(contentType, "png") {
+		ext = ".png"
+	} else if strings.Contains(contentType, "webp") {
+		ext = ".webp"
+	} else if strings.Contains(contentType, "gif") {
+		ext = ".gif"
+	}
+
+	// 保存到本地（按内容寻址）
+	relativeImageURL, err := storeFile(models.StoredFileKindOutput, imgData, ext)
+	if err != nil {
+		return "", fmt.Errorf("保存图片失败: %w", err)
+	}
+	utils.LogAPI("图片下载成功: %s -> %s", imageURL, relativeImageURL)
+	return relativeImageURL, nil
+}
+
+// saveProviderImage 将供应商返回的图片保存到输出目录，返回相对路径
+func saveProviderImage(ctx context.Context, img providers.Image) (string, error) {
+	// 方式1: inlineData 格式（base64 图片）
+	if img.Base64 != "" {
+		imgData, err := base64.StdEncoding.DecodeString(img.Base64)
+		if err != nil {
+			return "", fmt.Errorf("inlineData base64 解码失败: %w", err)
+		}
+
+		relativeImageURL, err := storeFile(models.StoredFileKindOutput, imgData, ".png")
+		if err != nil {
+			return "", fmt.Errorf("保存 inlineData 图片失败: %w", err)
+		}
+		return relativeImageURL, nil
+	}
+
+	// 方式2: text 中的 base64 data URL
+	if strings.HasPrefix(img.URL, "data:image/") {
+		localURL, err := saveBase64Image(img.URL)
+		if err != nil {
+			return "", fmt.Errorf("text base64 保存失败: %w", err)
+		}
+		return localURL, nil
+	}
+
+	// 方式3: 下载 HTTP/HTTPS 图片并保存到本地
+	localURL, err := downloadAndSaveImage(ctx, img.URL)
+	if err != nil {
+		return "", fmt.Errorf("下载 HTTP 图片失败: %w", err)
+	}
+	return localURL, nil
+}
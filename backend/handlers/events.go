@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"sigma/config"
+	"sigma/events"
+	"sigma/models"
+	"sigma/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// eventHeartbeatInterval SSE 心跳间隔，防止代理或浏览器因空闲断开连接
+const eventHeartbeatInterval = 15 * time.Second
+
+// TaskEventsHandler 任务事件流，推送所有任务的生命周期变化
+// GET /tasks/events
+// 断线重连时浏览器自动携带 Last-Event-ID 头（也可通过 ?last_event_id= 指定），服务端补发之后的事件；
+// 无法补发时先推送一条 resync 事件，客户端应重新拉取 /tasks/processing。
+func TaskEventsHandler(c *gin.Context) {
+	lastID := parseLastEventID(c)
+	backlog, resync, ch, unsubscribe := events.Default().Subscribe(lastID)
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(200)
+
+	if resync {
+		writeTaskEvent(c, events.Event{Type: events.Resync, At: time.Now()})
+	}
+	for _, event := range backlog {
+		writeTaskEvent(c, event)
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, ok := <-ch:
+			if !ok {
+				// 订阅被断开（消费过慢），客户端重连后按 Last-Event-ID 补发
+				return
+			}
+			writeTaskEvent(c, event)
+			c.Writer.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": ping\n\n")
+			c.Writer.Flush()
+		}
+	}
+}
+
+// parseLastEventID 读取客户端已收到的最后一个事件 ID
+func parseLastEventID(c *gin.Context) int64 {
+	value := c.GetHeader("Last-Event-ID")
+	if value == "" {
+		value = c.Query("last_event_id")
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id < 0 {
+		return 0
+	}
+	return id
+}
+
+// writeTaskEvent 按 SSE 格式写出一条事件（resync 事件没有 ID，不影响客户端的 Last-Event-ID）
+func writeTaskEvent(c *gin.Context, event events.Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		utils.LogAPI("序列化任务事件失败: %v", err)
+		return
+	}
+	if event.ID > 0 {
+		fmt.Fprintf(c.Writer, "id: %d\n", event.ID)
+	}
+	fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", event.Type, data)
+}
+
+// publishTaskEvent 发布任务状态事件，附带转换为绝对 URL 的任务快照
+func publishTaskEvent(eventType string, task *models.GenerationTask) {
+	resp := task.ToResponse()
+	resp.ImageURL = utils.ToAbsoluteURL(resp.ImageURL, config.ServerPort)
+	resp.RefImages = utils.ConvertRefImagesJSON(resp.RefImages, config.ServerPort, false)
+	events.Publish(eventType, task.TaskID, resp)
+}
+
+// saveTaskOutcome 保存任务的最终状态，并在完成或失败时发布对应事件
+// 返回 false 表示任务已不在处理中（已被取消），最终状态未写入
+func saveTaskOutcome(task *models.GenerationTask) bool {
+	if !persistTaskOutcome(task) {
+		return false
+	}
+	publishTaskOutcome(task)
+	return true
+}
+
+// persistTaskOutcome 只在任务仍处于处理中时写入最终状态，避免覆盖同时发生的取消
+// 状态未写入时仍记录尝试次数（已产生的上游调用）
+func persistTaskOutcome(task *models.GenerationTask) bool {
+	result := config.DB.Model(&models.GenerationTask{}).
+		Where("task_id = ? AND status = ?", task.TaskID, models.TaskStatusProcessing).
+		Updates(map[string]interface{}{
+			"status":         task.Status,
+			"image_url":      task.ImageURL,
+			"error_msg":      task.ErrorMsg,
+			"finished_at":    task.FinishedAt,
+			"attempts":       task.Attempts,
+			"attempt_errors": task.AttemptErrors,
+		})
+	if result.Error == nil && result.RowsAffected == 1 {
+		return true
+	}
+	config.DB.Model(&models.GenerationTask{}).Where("task_id = ?", task.TaskID).
+		Updates(map[string]interface{}{
+			"attempts":       task.Attempts,
+			"attempt_errors": task.AttemptErrors,
+		})
+	return false
+}
+
+// publishTaskOutcome 发布完成或失败事件
+// 取消事件由 cancelTask 在状态切换时发布，这里不重复发布；批次子任务结束后同步汇总父任务
+func publishTaskOutcome(task *models.GenerationTask) {
+	switch task.Status {
+	case models.TaskStatusCompleted:
+		publishTaskEvent(events.TaskCompleted, task)
+	case models.TaskStatusFailed:
+		publishTaskEvent(events.TaskFailed, task)
+	}
+	if task.ParentTaskID != "" {
+		refreshBatchParent(task.ParentTaskID)
+	}
+}
+
+// publishImageEvent 发布图片已保存事件；批次子任务附带批次内序号和父任务 ID
+func publishImageEvent(task *models.GenerationTask, imageURL string) {
+	data := gin.H{"image_url": utils.ToAbsoluteURL(imageURL, config.ServerPort)}
+	if task.ParentTaskID != "" {
+		data["parent_task_id"] = task.ParentTaskID
+		data["batch_id"] = task.BatchID
+	}
+	if task.BatchIndex != nil {
+		data["index"] = *task.BatchIndex
+	}
+	events.Publish(events.TaskImage, task.TaskID, data)
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"sigma/events"
+)
+
+func TestTaskEventsHandler_ReplaysAfterLastEventID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	first := events.Publish(events.TaskQueued, "task-a", nil)
+	second := events.Publish(events.TaskStarted, "task-a", nil)
+	third := events.Publish(events.TaskCompleted, "task-a", nil)
+
+	r := gin.New()
+	r.GET("/tasks/events", TaskEventsHandler)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "/tasks/events", nil)
+	req.Header.Set("Last-Event-ID", fmt.Sprint(first.ID))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	body := w.Body.String()
+	if strings.Contains(body, fmt.Sprintf("id: %d\n", first.ID)) {
+		t.Error("Last-Event-ID 之前的事件不应补发")
+	}
+	for _, event := range []events.Event{second, third} {
+		if !strings.Contains(body, fmt.Sprintf("id: %d\nevent: %s\n", event.ID, event.Type)) {
+			t.Errorf("缺少补发事件 %d (%s)，响应内容: %s", event.ID, event.Type, body)
+		}
+	}
+}
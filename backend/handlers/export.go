@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"sigma/config"
+	"sigma/models"
+	"sigma/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// exportMaxRecords 单次导出的最大记录数
+const exportMaxRecords = 5000
+
+// ExportRequest 导出筛选条件，各条件同时生效
+type ExportRequest struct {
+	IDs          []uint   `json:"ids"`
+	BatchID      string   `json:"batch_id"`
+	From         string   `json:"from"` // YYYY-MM-DD 或 RFC3339
+	To           string   `json:"to"`
+	Types        []string `json:"types"`
+	Tags         []string `json:"tags"` // 须同时包含所有标签
+	CollectionID *uint    `json:"collection_id"`
+}
+
+// empty 是否未指定任何筛选条件
+func (r ExportRequest) empty() bool {
+	return len(r.IDs) == 0 && r.BatchID == "" && r.From == "" && r.To == "" &&
+		len(r.Types) == 0 && len(r.Tags) == 0 && r.CollectionID == nil
+}
+
+// exportManifestItem 清单中的一条记录
+type exportManifestItem struct {
+	ID          uint      `json:"id"`
+	Prompt      string    `json:"prompt"`
+	Type        string    `json:"type"`
+	AspectRatio string    `json:"aspect_ratio"`
+	ImageSize   string    `json:"image_size"`
+	BatchID     string    `json:"batch_id,omitempty"`
+	BatchIndex  *int      `json:"batch_index,omitempty"`
+	BatchTotal  *int      `json:"batch_total,omitempty"`
+	Tags        []string  `json:"tags"`
+	Image       string    `json:"image"`      // 压缩包内的图片路径，文件缺失时为空
+	RefImages   []string  `json:"ref_images"` // 压缩包内的参考图路径
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// exportQuery 按导出条件构建查询，只导出图片未删除且不在回收站的记录
+func exportQuery(req ExportRequest) (*gorm.DB, error) {
+	query := config.DB.Model(&models.GenerationHistory{}).
+		Where("image_url != ''").
+		Where("image_deleted = ? OR image_deleted IS NULL", false).
+		Where("trashed_at IS NULL")
+
+	if len(req.IDs) > 0 {
+		query = query.Where("id IN ?", req.IDs)
+	}
+	if req.BatchID != "" {
+		query = query.Where("batch_id = ?", req.BatchID)
+	}
+	if req.From != "" {
+		from, err := parseHistoryTime(req.From, false)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("created_at >= ?", from)
+	}
+	if req.To != "" {
+		to, err := parseHistoryTime(req.To, true)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("created_at < ?", to)
+	}
+	if len(req.Types) > 0 {
+		for _, t := range req.Types {
+			if !isKnownGenerationType(t) {
+				return nil, unknownGenerationTypeError(t)
+			}
+		}
+		query = query.Where("type IN ?", req.Types)
+	}
+	for _, tag := range req.Tags {
+		query = query.Where("id IN (?)", config.DB.Model(&models.HistoryTag{}).Select("history_id").Where("tag = ?", strings.TrimSpace(tag)))
+	}
+	if req.CollectionID != nil {
+		query = query.Where("id IN (?)", config.DB.Model(&models.CollectionItem{}).Select("history_id").Where("collection_id = ?", *req.CollectionID))
+	}
+	return query, nil
+}
+
+// ExportHandler 按条件导出历史记录的图片、参考图和清单（manifest.json / manifest.csv），以 zip 流式返回
+// POST /export {"ids": [1, 2], "batch_id": "", "from": "2025-01-01", "to": "2025-01-31", "types": ["create"], "tags": ["客户A"]}
+func ExportHandler(c *gin.Context) {
+	var req ExportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "无效的请求参数"})
+		return
+	}
+	if req.empty() {
+		c.JSON(400, gin.H{"error": "请指定导出范围"})
+		return
+	}
+
+	query, err := exportQuery(req)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	var history []models.GenerationHistory
+	if err := query.Order("created_at asc, id asc").Limit(exportMaxRecords + 1).Find(&history).Error; err != nil {
+		c.JSON(500, gin.H{"error": "获取历史记录失败"})
+		return
+	}
+	if len(history) == 0 {
+		c.JSON(404, gin.H{"error": "没有符合条件的记录"})
+		return
+	}
+	if len(history) > exportMaxRecords {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("单次最多导出 %d 条记录，请缩小导出范围", exportMaxRecords)})
+		return
+	}
+
+	fileName := fmt.Sprintf("export_%s.zip", time.Now().Format("20060102_150405"))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", fileName))
+	c.Header("Content-Type", "application/zip")
+	c.Status(200)
+
+	zw := zip.NewWriter(c.Writer)
+	if err := writeExportArchive(zw, history); err != nil {
+		// 响应已开始发送，只能记录日志并中断压缩包
+		utils.LogAPI("导出失败: %v", err)
+		return
+	}
+	if err := zw.Close(); err != nil {
+		utils.LogAPI("导出失败: %v", err)
+		return
+	}
+	utils.LogAPI("导出完成: %s (%d 条记录)", fileName, len(history))
+}
+
+// writeExportArchive 写入图片、参考图和清单
+// 图片保存为 images/<记录 ID><扩展名>，参考图保存为 ref_images/<文件名>（多条记录共用的参考图只保存一次）；
+// 找不到的文件记录在清单的 missing_files 中
+func writeExportArchive(zw *zip.Writer, history []models.GenerationHistory) error {
+	tags := loadHistoryTags(history)
+	items := make([]exportManifestItem, 0, len(history))
+	missing := []string{}
+	writtenRefs := make(map[string]bool)
+
+	for _, h := range history {
+		item := exportManifestItem{
+			ID:          h.ID,
+			Prompt:      h.Prompt,
+			Type:        h.Type,
+			AspectRatio: h.AspectRatio,
+			ImageSize:   h.ImageSize,
+			BatchIndex:  h.BatchIndex,
+			BatchTotal:  h.BatchTotal,
+			Tags:        tags[h.ID],
+			RefImages:   []string{},
+			CreatedAt:   h.CreatedAt,
+		}
+		if h.BatchID != nil {
+			item.BatchID = *h.BatchID
+		}
+
+		if name := imageFileName(h.ImageURL); name != "" {
+			entry := fmt.Sprintf("images/%d%s", h.ID, filepath.Ext(name))
+			found, err := addExportFile(zw, entry, filepath.Join(config.OutputDir, name))
+			if err != nil {
+				return err
+			}
+			if found {
+				item.Image = entry
+			} else {
+				missing = append(missing, storagePrefix(models.StoredFileKindOutput)+name)
+			}
+		}
+
+		for _, ref := range parseRefImagePaths(h.RefImages) {
+			if !strings.HasPrefix(ref, storagePrefix(models.StoredFileKindUpload)) {
+				continue
+			}
+			entry := "ref_images/" + filepath.Base(ref)
+			if !writtenRefs[entry] {
+				found, err := addExportFile(zw, entry, filepath.Join(config.UploadDir, filepath.Base(ref)))
+				if err != nil {
+					return err
+				}
+				if !found {
+					missing = append(missing, ref)
+					continue
+				}
+				writtenRefs[entry] = true
+			}
+			item.RefImages = append(item.RefImages, entry)
+		}
+		items = append(items, item)
+	}
+
+	manifest, err := json.MarshalIndent(gin.H{
+		"exported_at":   time.Now(),
+		"count":         len(items),
+		"items":         items,
+		"missing_files": missing,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := addExportData(zw, "manifest.json", manifest); err != nil {
+		return err
+	}
+	return addExportData(zw, "manifest.csv", exportManifestCSV(items))
+}
+
+// exportManifestCSV 生成 CSV 格式的清单
+func exportManifestCSV(items []exportManifestItem) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("\ufeff") // BOM，便于 Excel 正确识别 UTF-8
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"id", "prompt", "type", "aspect_ratio", "image_size", "batch_id", "batch_index", "batch_total", "tags", "image", "ref_images", "created_at"})
+	for _, item := range items {
+		batchIndex, batchTotal := "", ""
+		if item.BatchIndex != nil {
+			batchIndex = fmt.Sprint(*item.BatchIndex)
+		}
+		if item.BatchTotal != nil {
+			batchTotal = fmt.Sprint(*item.BatchTotal)
+		}
+		w.Write([]string{
+			fmt.Sprint(item.ID), item.Prompt, item.Type, item.AspectRatio, item.ImageSize,
+			item.BatchID, batchIndex, batchTotal, strings.Join(item.Tags, ";"),
+			item.Image, strings.Join(item.RefImages, ";"), item.CreatedAt.Format(time.RFC3339),
+		})
+	}
+	w.Flush()
+	return buf.Bytes()
+}
+
+// addExportFile 将磁盘文件写入压缩包（图片已压缩，直接存储），文件不存在时返回 false
+func addExportFile(zw *zip.Writer, name, path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer f.Close()
+
+	header := &zip.FileHeader{Name: name, Method: zip.Store}
+	if info, err := f.Stat(); err == nil {
+		header.Modified = info.ModTime()
+	}
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return false, err
+	}
+	_, err = io.Copy(w, f)
+	return err == nil, err
+}
+
+// addExportData 将内容写入压缩包
+func addExportData(zw *zip.Writer, name string, data []byte) error {
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
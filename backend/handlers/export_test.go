@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"sigma/config"
+	"sigma/models"
+)
+
+func setupExportTest(t *testing.T) *gin.Engine {
+	cleanup := setupHistoryTestDB(t)
+	t.Cleanup(cleanup)
+	config.DB.AutoMigrate(&models.GenerationTypeDef{})
+	if err := SeedGenerationTypes(); err != nil {
+		t.Fatalf("写入内置生成类型失败: %v", err)
+	}
+	config.OutputDir = t.TempDir()
+	config.UploadDir = t.TempDir()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/export", ExportHandler)
+	return r
+}
+
+// readExportZip 读取导出的压缩包，返回文件名到内容的映射
+func readExportZip(t *testing.T, body []byte) map[string][]byte {
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("无法读取压缩包: %v", err)
+	}
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = data
+	}
+	return files
+}
+
+func TestExport_ZipWithImagesRefsAndManifest(t *testing.T) {
+	r := setupExportTest(t)
+
+	os.WriteFile(filepath.Join(config.OutputDir, "a.png"), []byte("image-a"), 0644)
+	os.WriteFile(filepath.Join(config.OutputDir, "b.png"), []byte("image-b"), 0644)
+	os.WriteFile(filepath.Join(config.UploadDir, "ref.jpg"), []byte("ref"), 0644)
+
+	batchID := "batch-1"
+	index, total := 0, 2
+	day := time.Date(2025, 3, 1, 10, 0, 0, 0, time.Local)
+	a := models.GenerationHistory{Prompt: "白底, 商品", ImageURL: "images/a.png", RefImages: `["http://localhost:8080/uploads/ref.jpg"]`,
+		Type: models.GenerationTypeWhiteBackground, AspectRatio: "1:1", ImageSize: "2K", BatchID: &batchID, BatchIndex: &index, BatchTotal: &total, CreatedAt: day}
+	b := models.GenerationHistory{Prompt: "b", ImageURL: "images/b.png", RefImages: `["uploads/ref.jpg","uploads/gone.jpg"]`,
+		Type: models.GenerationTypeWhiteBackground, CreatedAt: day.Add(time.Hour)}
+	other := models.GenerationHistory{Prompt: "c", ImageURL: "images/c.png", Type: models.GenerationTypeCreate, CreatedAt: day}
+	config.DB.Create(&a)
+	config.DB.Create(&b)
+	config.DB.Create(&other)
+	config.DB.Create(&models.HistoryTag{HistoryID: a.ID, Tag: "客户A"})
+
+	w := doJSON(r, "POST", "/export", `{"types": ["white_background"], "from": "2025-03-01", "to": "2025-03-01"}`)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("导出失败: %d %s", w.Code, w.Body.String())
+	}
+	files := readExportZip(t, w.Body.Bytes())
+
+	if string(files["images/1.png"]) != "image-a" || string(files["images/2.png"]) != "image-b" {
+		t.Errorf("压缩包中的图片不正确: %v", len(files))
+	}
+	if string(files["ref_images/ref.jpg"]) != "ref" {
+		t.Errorf("压缩包中应包含参考图")
+	}
+	if _, ok := files["images/3.png"]; ok {
+		t.Errorf("不符合条件的记录不应导出")
+	}
+
+	var manifest struct {
+		Count        int                  `json:"count"`
+		Items        []exportManifestItem `json:"items"`
+		MissingFiles []string             `json:"missing_files"`
+	}
+	if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+		t.Fatalf("无法解析清单: %v", err)
+	}
+	if manifest.Count != 2 || manifest.Items[0].BatchID != batchID || *manifest.Items[0].BatchTotal != 2 ||
+		manifest.Items[0].Tags[0] != "客户A" || manifest.Items[0].RefImages[0] != "ref_images/ref.jpg" {
+		t.Errorf("清单内容不正确: %+v", manifest)
+	}
+	if len(manifest.MissingFiles) != 1 || manifest.MissingFiles[0] != "uploads/gone.jpg" {
+		t.Errorf("缺失的文件应记录在清单中: %v", manifest.MissingFiles)
+	}
+
+	csvLines := strings.Split(strings.TrimSpace(string(files["manifest.csv"])), "\n")
+	if len(csvLines) != 3 || !strings.Contains(csvLines[1], `"白底, 商品"`) {
+		t.Errorf("CSV 清单不正确: %q", csvLines)
+	}
+}
+
+func TestExport_Validation(t *testing.T) {
+	r := setupExportTest(t)
+
+	if w := doJSON(r, "POST", "/export", `{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("未指定导出范围应返回 400，实际为 %d", w.Code)
+	}
+	if w := doJSON(r, "POST", "/export", `{"types": ["unknown"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("未知类型应返回 400，实际为 %d", w.Code)
+	}
+	if w := doJSON(r, "POST", "/export", `{"ids": [99]}`); w.Code != http.StatusNotFound {
+		t.Errorf("没有符合条件的记录应返回 404，实际为 %d", w.Code)
+	}
+}
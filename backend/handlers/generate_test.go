@@ -23,7 +23,11 @@ func setupGenerateTestDB(t *testing.T) func() {
 	if err != nil {
 		t.Fatalf("无法创建测试数据库: %v", err)
 	}
-	config.DB.AutoMigrate(&models.GenerationTask{}, &models.GenerationHistory{}, &models.GenerationStats{})
+	// :memory: 数据库每个连接都是独立的库，后台任务并发访问时必须复用同一连接
+	if sqlDB, err := config.DB.DB(); err == nil {
+		sqlDB.SetMaxOpenConns(1)
+	}
+	config.DB.AutoMigrate(&models.GenerationTask{}, &models.GenerationHistory{}, &models.GenerationStats{}, &models.HistoryTag{}, &models.StoredFile{})
 
 	return func() {
 		sqlDB, _ := config.DB.DB()
@@ -33,7 +37,7 @@ func setupGenerateTestDB(t *testing.T) func() {
 
 // **Feature: generation-persistence, Property 1: Task Creation Invariant**
 // *For any* valid generation request, when the generate endpoint is called,
-// a task record SHALL be created with status "processing" before the AI API is invoked.
+// a task record SHALL be created with status "queued" before the AI API is invoked.
 // **Validates: Requirements 1.1**
 func TestProperty_TaskCreationInvariant(t *testing.T) {
 	cleanup := setupGenerateTestDB(t)
@@ -101,8 +105,8 @@ func TestProperty_TaskCreationInvariant(t *testing.T) {
 		config.DB.First(&task)
 
 		// Property assertions:
-		// 1. Task status is "processing"
-		if task.Status != models.TaskStatusProcessing {
+		// 1. Task status is "queued" (single-image tasks wait for the worker pool)
+		if task.Status != models.TaskStatusQueued {
 			return false
 		}
 
@@ -147,6 +151,7 @@ func TestProperty_TaskCreationWithAPIKey(t *testing.T) {
 	originalToken := config.GetAPIToken()
 	config.SetAPIToken("test-api-key")
 	defer config.SetAPIToken(originalToken)
+	config.UploadDir = t.TempDir()
 
 	// Valid generation types
 	validTypes := []string{
@@ -176,6 +181,12 @@ func TestProperty_TaskCreationWithAPIKey(t *testing.T) {
 		writer.WriteField("type", genType)
 		writer.WriteField("aspectRatio", "1:1")
 		writer.WriteField("imageSize", "2K")
+		// 按生成类型要求的参考图槽位上传参考图
+		typeDef, _ := lookupGenerationType(genType)
+		for range typeDef.RefImageSlots {
+			part, _ := writer.CreateFormFile("images", "ref.png")
+			part.Write([]byte("png"))
+		}
 		writer.Close()
 
 		r := gin.New()
@@ -225,9 +236,9 @@ func TestProperty_TaskCreationWithAPIKey(t *testing.T) {
 			return false
 		}
 
-		// 5. Task status is either processing (if API call is pending) or failed (if API call failed)
-		// Since we're using a fake API key, the API call will fail
-		if task.Status != models.TaskStatusProcessing && task.Status != models.TaskStatusFailed {
+		// 5. Task is queued until the worker pool picks it up
+		// (the queue is not started in tests, so no API call is made)
+		if task.Status != models.TaskStatusQueued {
 			return false
 		}
 
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"sigma/config"
+	"sigma/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// generationTypePattern 自定义生成类型标识：小写字母开头，只包含小写字母、数字和下划线
+var generationTypePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{1,31}$`)
+
+// SeedGenerationTypes 写入内置生成类型，已存在的类型（可能被修改过）保持不变
+func SeedGenerationTypes() error {
+	for _, builtin := range models.BuiltinGenerationTypes() {
+		builtin.BuiltIn = true
+		var existing models.GenerationTypeDef
+		err := config.DB.Where("type = ?", builtin.Type).
+			Attrs(builtin).
+			FirstOrCreate(&existing).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookupGenerationType 查找已注册的生成类型
+// 内置类型即使数据库中没有记录（如写入失败）也始终可用
+func lookupGenerationType(generationType string) (*models.GenerationTypeDef, bool) {
+	var def models.GenerationTypeDef
+	if err := config.DB.Where("type = ?", generationType).First(&def).Error; err == nil {
+		return &def, true
+	}
+	for _, builtin := range models.BuiltinGenerationTypes() {
+		if builtin.Type == generationType {
+			builtin.BuiltIn = true
+			return &builtin, true
+		}
+	}
+	return nil, false
+}
+
+// isKnownGenerationType 检查生成类型是否已注册
+func isKnownGenerationType(generationType string) bool {
+	_, ok := lookupGenerationType(generationType)
+	return ok
+}
+
+// unknownGenerationTypeError 未注册生成类型的错误信息
+func unknownGenerationTypeError(generationType string) error {
+	return fmt.Errorf("未知的生成类型: %s", generationType)
+}
+
+// checkRefImageSlots 检查参考图数量是否满足生成类型要求的槽位
+func checkRefImageSlots(def *models.GenerationTypeDef, count int) error {
+	if count >= len(def.RefImageSlots) {
+		return nil
+	}
+	return fmt.Errorf("生成类型 %s 需要 %d 张参考图（%s），实际为 %d 张",
+		def.Type, len(def.RefImageSlots), strings.Join(def.RefImageSlots, "、"), count)
+}
+
+// typeDefaultAspectRatio 请求未指定比例时使用生成类型的默认比例
+func typeDefaultAspectRatio(def *models.GenerationTypeDef, aspectRatio string) string {
+	if aspectRatio == "" {
+		aspectRatio = def.DefaultAspectRatio
+	}
+	return normalizeAspectRatio(aspectRatio)
+}
+
+// typeDefaultImageSize 请求未指定尺寸时使用生成类型的默认尺寸
+func typeDefaultImageSize(def *models.GenerationTypeDef, imageSize string) string {
+	if imageSize == "" {
+		imageSize = def.DefaultImageSize
+	}
+	return normalizeImageSize(imageSize)
+}
+
+// generationTypeRequest 创建或修改生成类型的请求体
+type generationTypeRequest struct {
+	Type               string    `json:"type"`
+	Name               string    `json:"name"`
+	Template           string    `json:"template"` // 提示词模板（创建时可选，保存为该类型的默认模板）
+	RefImageSlots      *[]string `json:"ref_image_slots"`
+	DefaultAspectRatio *string   `json:"default_aspect_ratio"`
+	DefaultImageSize   *string   `json:"default_image_size"`
+}
+
+// ListGenerationTypesHandler 获取所有已注册的生成类型
+// GET /generation-types
+func ListGenerationTypesHandler(c *gin.Context) {
+	var defs []models.GenerationTypeDef
+	if err := config.DB.Order("id asc").Find(&defs).Error; err != nil {
+		c.JSON(500, gin.H{"error": "获取生成类型失败"})
+		return
+	}
+	c.JSON(200, defs)
+}
+
+// GetGenerationTypeHandler 获取单个生成类型
+// GET /generation-types/:type
+func GetGenerationTypeHandler(c *gin.Context) {
+	def, ok := loadGenerationType(c)
+	if !ok {
+		return
+	}
+	c.JSON(200, def)
+}
+
+// CreateGenerationTypeHandler 新增自定义生成类型
+// POST /generation-types
+func CreateGenerationTypeHandler(c *gin.Context) {
+	var req generationTypeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "请求格式错误"})
+		return
+	}
+	if !generationTypePattern.MatchString(req.Type) {
+		c.JSON(400, gin.H{"error": "类型标识只能包含小写字母、数字和下划线，以字母开头，长度 2-32"})
+		return
+	}
+
+	def := models.GenerationTypeDef{
+		Type:          req.Type,
+		Name:          req.Name,
+		RefImageSlots: []string{},
+	}
+	if def.Name == "" {
+		def.Name = req.Type
+	}
+	applyGenerationTypeRequest(&def, req)
+
+	if isKnownGenerationType(def.Type) {
+		c.JSON(409, gin.H{"error": "生成类型已存在"})
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&def).Error; err != nil {
+			return err
+		}
+		if strings.TrimSpace(req.Template) == "" {
+			return nil
+		}
+		return tx.Create(&models.PromptTemplate{
+			TemplateID: def.Type + ".default",
+			Type:       def.Type,
+			Name:       def.Name,
+			Template:   req.Template,
+			IsDefault:  true,
+		}).Error
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "保存生成类型失败"})
+		return
+	}
+	c.JSON(200, def)
+}
+
+// UpdateGenerationTypeHandler 修改生成类型（名称、参考图槽位、默认比例和尺寸）
+// PUT /generation-types/:type
+// 提示词模板通过 /prompt-templates 接口修改
+func UpdateGenerationTypeHandler(c *gin.Context) {
+	def, ok := loadGenerationType(c)
+	if !ok {
+		return
+	}
+
+	var req generationTypeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "请求格式错误"})
+		return
+	}
+	if req.Name != "" {
+		def.Name = req.Name
+	}
+	applyGenerationTypeRequest(def, req)
+
+	if err := config.DB.Save(def).Error; err != nil {
+		c.JSON(500, gin.H{"error": "保存生成类型失败"})
+		return
+	}
+	c.JSON(200, def)
+}
+
+// DeleteGenerationTypeHandler 删除自定义生成类型及其提示词模板
+// DELETE /generation-types/:type
+// 内置类型和已有任务或历史记录的类型不能删除
+func DeleteGenerationTypeHandler(c *gin.Context) {
+	def, ok := loadGenerationType(c)
+	if !ok {
+		return
+	}
+	if def.BuiltIn {
+		c.JSON(409, gin.H{"error": "内置生成类型不能删除"})
+		return
+	}
+
+	var historyCount, taskCount int64
+	config.DB.Model(&models.GenerationHistory{}).Where("type = ?", def.Type).Count(&historyCount)
+	config.DB.Model(&models.GenerationTask{}).Where("type = ?", def.Type).Count(&taskCount)
+	if historyCount > 0 || taskCount > 0 {
+		c.JSON(409, gin.H{"error": "该生成类型已有任务或历史记录，不能删除"})
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("type = ?", def.Type).Delete(&models.PromptTemplate{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(def).Error
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "删除生成类型失败"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "删除成功"})
+}
+
+// applyGenerationTypeRequest 将请求中提交的可选字段写入类型定义
+func applyGenerationTypeRequest(def *models.GenerationTypeDef, req generationTypeRequest) {
+	if req.RefImageSlots != nil {
+		slots := make([]string, 0, len(*req.RefImageSlots))
+		for _, slot := range *req.RefImageSlots {
+			if slot = strings.TrimSpace(slot); slot != "" {
+				slots = append(slots, slot)
+			}
+		}
+		def.RefImageSlots = slots
+	}
+	if req.DefaultAspectRatio != nil {
+		def.DefaultAspectRatio = *req.DefaultAspectRatio
+	}
+	if req.DefaultImageSize != nil {
+		def.DefaultImageSize = *req.DefaultImageSize
+	}
+}
+
+// loadGenerationType 按路径参数读取生成类型，不存在时直接返回 404
+func loadGenerationType(c *gin.Context) (*models.GenerationTypeDef, bool) {
+	var def models.GenerationTypeDef
+	if err := config.DB.Where("type = ?", c.Param("type")).First(&def).Error; err != nil {
+		c.JSON(404, gin.H{"error": "生成类型不存在"})
+		return nil, false
+	}
+	return &def, true
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"sigma/config"
+	"sigma/models"
+)
+
+func setupGenerationTypeTest(t *testing.T) (*gin.Engine, func()) {
+	cleanup := setupGenerateTestDB(t)
+	config.DB.AutoMigrate(&models.GenerationTypeDef{}, &models.PromptTemplate{})
+	if err := SeedGenerationTypes(); err != nil {
+		t.Fatalf("写入内置生成类型失败: %v", err)
+	}
+	if err := SeedPromptTemplates(); err != nil {
+		t.Fatalf("写入内置模板失败: %v", err)
+	}
+	config.UploadDir = t.TempDir()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/generation-types", ListGenerationTypesHandler)
+	r.POST("/generation-types", CreateGenerationTypeHandler)
+	r.PUT("/generation-types/:type", UpdateGenerationTypeHandler)
+	r.DELETE("/generation-types/:type", DeleteGenerationTypeHandler)
+	r.POST("/generate", GenerateHandler)
+	r.GET("/history", HistoryHandler)
+	r.GET("/history/types/:type", TypedHistoryHandler)
+	r.GET("/tasks/processing", GetProcessingTasks)
+	return r, cleanup
+}
+
+// postGenerate 提交生成请求，附带 refCount 张参考图
+func postGenerate(r *gin.Engine, fields map[string]string, refCount int) *httptest.ResponseRecorder {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for k, v := range fields {
+		writer.WriteField(k, v)
+	}
+	for i := 0; i < refCount; i++ {
+		part, _ := writer.CreateFormFile("images", "ref.png")
+		part.Write([]byte("png"))
+	}
+	writer.Close()
+
+	req, _ := http.NewRequest("POST", "/generate", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestCreateGenerationType_UsedByGenerate(t *testing.T) {
+	r, cleanup := setupGenerationTypeTest(t)
+	defer cleanup()
+
+	originalToken := config.GetAPIToken()
+	config.SetAPIToken("test-api-key")
+	defer config.SetAPIToken(originalToken)
+
+	w := doJSON(r, "POST", "/generation-types", `{
+		"type": "poster",
+		"name": "海报",
+		"template": "为图中商品设计一张海报",
+		"ref_image_slots": ["商品图", " "],
+		"default_aspect_ratio": "3:4",
+		"default_image_size": "4K"
+	}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("创建生成类型失败: %d %s", w.Code, w.Body.String())
+	}
+	var created models.GenerationTypeDef
+	json.Unmarshal(w.Body.Bytes(), &created)
+	if len(created.RefImageSlots) != 1 || created.BuiltIn {
+		t.Errorf("生成类型不正确: %+v", created)
+	}
+
+	if w := doJSON(r, "POST", "/generation-types", `{"type": "poster"}`); w.Code != http.StatusConflict {
+		t.Errorf("重复的类型应返回 409，实际为 %d", w.Code)
+	}
+	if w := doJSON(r, "POST", "/generation-types", `{"type": "Bad Type"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("非法的类型标识应返回 400，实际为 %d", w.Code)
+	}
+
+	// 缺少必需的参考图
+	if w := postGenerate(r, map[string]string{"type": "poster"}, 0); w.Code != http.StatusBadRequest {
+		t.Errorf("缺少参考图应返回 400，实际为 %d", w.Code)
+	}
+
+	// 未提交提示词时使用类型的默认模板，比例和尺寸使用类型默认值
+	w = postGenerate(r, map[string]string{"type": "poster"}, 1)
+	if w.Code != http.StatusOK {
+		t.Fatalf("期望状态码 200，实际为 %d: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		TaskID string `json:"task_id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	var task models.GenerationTask
+	config.DB.Where("task_id = ?", response.TaskID).First(&task)
+	if task.Prompt != "为图中商品设计一张海报" || task.TemplateID != "poster.default" {
+		t.Errorf("应使用类型的默认模板: %+v", task)
+	}
+	if task.AspectRatio != "3:4" || task.ImageSize != "4K" {
+		t.Errorf("应使用类型的默认比例和尺寸，实际为 %s / %s", task.AspectRatio, task.ImageSize)
+	}
+
+	// 已有任务的类型不能删除
+	if w := doJSON(r, "DELETE", "/generation-types/poster", ""); w.Code != http.StatusConflict {
+		t.Errorf("已有任务的类型应返回 409，实际为 %d", w.Code)
+	}
+	if w := doJSON(r, "DELETE", "/generation-types/create", ""); w.Code != http.StatusConflict {
+		t.Errorf("内置类型应返回 409，实际为 %d", w.Code)
+	}
+}
+
+func TestGenerationTypes_RejectUnknownTypes(t *testing.T) {
+	r, cleanup := setupGenerationTypeTest(t)
+	defer cleanup()
+
+	originalToken := config.GetAPIToken()
+	config.SetAPIToken("test-api-key")
+	defer config.SetAPIToken(originalToken)
+
+	if w := postGenerate(r, map[string]string{"type": "unknown", "prompt": "x"}, 0); w.Code != http.StatusBadRequest {
+		t.Errorf("生成接口应拒绝未知类型，实际为 %d", w.Code)
+	}
+
+	for _, path := range []string{"/history?type=unknown", "/history/types/unknown", "/tasks/processing?type=unknown"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s 应拒绝未知类型，实际为 %d", path, w.Code)
+		}
+	}
+
+	// 注册后即可使用通用历史接口
+	doJSON(r, "POST", "/generation-types", `{"type": "banner"}`)
+	config.DB.Create(&models.GenerationHistory{Prompt: "banner", ImageURL: "images/banner.png", Type: "banner"})
+
+	req, _ := http.NewRequest("GET", "/history/types/banner", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var history []models.GenerationHistoryResponse
+	json.Unmarshal(w.Body.Bytes(), &history)
+	if w.Code != http.StatusOK || len(history) != 1 {
+		t.Errorf("期望 1 条 banner 历史记录，实际为 %d (%d)", len(history), w.Code)
+	}
+}
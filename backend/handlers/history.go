@@ -1,9 +1,6 @@
 package handlers
 
 import (
-	"log"
-	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -18,6 +15,7 @@ import (
 // convertHistoryToResponse 将历史记录转换为响应格式，同时转换 URL
 func convertHistoryToResponse(history []models.GenerationHistory) []models.GenerationHistoryResponse {
 	response := make([]models.GenerationHistoryResponse, len(history))
+	tags := loadHistoryTags(history)
 	for i, h := range history {
 		// 转换 URL 为当前端口的绝对路径（兼容旧数据和新数据）
 		imageURL := utils.ToAbsoluteURL(h.ImageURL, config.ServerPort)
@@ -37,18 +35,23 @@ func convertHistoryToResponse(history []models.GenerationHistory) []models.Gener
 			ID:             h.ID,
 			Prompt:         h.Prompt,
 			OriginalPrompt: h.OriginalPrompt,
+			TemplateID:     h.TemplateID,
 			ImageURL:       imageURL,
 			FileName:       h.FileName,
 			RefImages:      refImages,
 			Type:           h.Type,
 			ImageDeleted:   h.ImageDeleted,
+			TrashedAt:      h.TrashedAt,
 			AspectRatio:    aspectRatio,
 			ImageSize:      imageSize,
+			Favorite:       h.Favorite,
+			Tags:           tags[h.ID],
 			CreatedAt:      h.CreatedAt,
 			UpdatedAt:      h.UpdatedAt,
 			BatchID:        h.BatchID,
 			BatchIndex:     h.BatchIndex,
 			BatchTotal:     h.BatchTotal,
+			Variation:      h.Variation,
 		}
 	}
 	return response
@@ -78,11 +81,12 @@ func HistoryHandler(c *gin.Context) {
 	var history []models.GenerationHistory
 	// 只过滤有效的图片记录
 	// 注意：不再使用 deleted_at 字段，因为新版本已移除软删除功能
-	// 使用 image_deleted 字段来标记图片是否已被删除
-	// 过滤条件：有图片URL + 图片未被删除
+	// 使用 image_deleted 字段来标记图片是否已被删除，trashed_at 标记图片是否在回收站
+	// 过滤条件：有图片URL + 图片未被删除 + 不在回收站
 	query := config.DB.Model(&models.GenerationHistory{}).
 		Where("image_url != '' AND image_url IS NOT NULL").
-		Where("image_deleted = ? OR image_deleted IS NULL", false)
+		Where("image_deleted = ? OR image_deleted IS NULL", false).
+		Where("trashed_at IS NULL")
 
 	dateStr := c.Query("date")
 	if dateStr != "" {
@@ -94,37 +98,23 @@ func HistoryHandler(c *gin.Context) {
 		}
 	}
 
-	// 支持按类型筛选
+	// 支持按类型筛选（只接受已注册的类型）
 	typeFilter := c.Query("type")
 	if typeFilter != "" {
+		if !isKnownGenerationType(typeFilter) {
+			c.JSON(400, gin.H{"error": unknownGenerationTypeError(typeFilter).Error()})
+			return
+		}
 		query = query.Where("type = ?", typeFilter)
 	}
 
-	page, pageSize := parsePageParams(c)
-	offset := (page - 1) * pageSize
-
-	result := query.Order("created_at desc").
-		Offset(offset).
-		Limit(pageSize).
-		Find(&history)
-
-	if result.Error != nil {
-		c.JSON(500, gin.H{"error": "获取历史记录失败"})
+	// 支持按收藏、标签和合集筛选
+	query, err := applyCurationFilters(c, query)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(200, convertHistoryToResponse(history))
-}
-
-// WhiteBackgroundHistoryHandler 获取白底图历史记录
-// 只返回成功生成的记录，不包含已删除的图片
-func WhiteBackgroundHistoryHandler(c *gin.Context) {
-	var history []models.GenerationHistory
-	query := config.DB.Model(&models.GenerationHistory{}).
-		Where("type = ?", models.GenerationTypeWhiteBackground).
-		Where("image_url != '' AND image_url IS NOT NULL").
-		Where("image_deleted = ? OR image_deleted IS NULL", false)
-
 	page, pageSize := parsePageParams(c)
 	offset := (page - 1) * pageSize
 
@@ -134,99 +124,68 @@ func WhiteBackgroundHistoryHandler(c *gin.Context) {
 		Find(&history)
 
 	if result.Error != nil {
-		c.JSON(500, gin.H{"error": "获取白底图历史记录失败"})
+		c.JSON(500, gin.H{"error": "获取历史记录失败"})
 		return
 	}
 
 	c.JSON(200, convertHistoryToResponse(history))
 }
 
-// ClothingChangeHistoryHandler 获取换装历史记录
+// typedHistoryHandler 返回按生成类型筛选的历史记录处理函数
 // 只返回成功生成的记录，不包含已删除的图片
-func ClothingChangeHistoryHandler(c *gin.Context) {
-	var history []models.GenerationHistory
-	query := config.DB.Model(&models.GenerationHistory{}).
-		Where("type = ?", models.GenerationTypeClothingChange).
-		Where("image_url != '' AND image_url IS NOT NULL").
-		Where("image_deleted = ? OR image_deleted IS NULL", false)
-
-	page, pageSize := parsePageParams(c)
-	offset := (page - 1) * pageSize
-
-	result := query.Order("created_at desc").
-		Offset(offset).
-		Limit(pageSize).
-		Find(&history)
-
-	if result.Error != nil {
-		c.JSON(500, gin.H{"error": "获取换装历史记录失败"})
-		return
-	}
-
-	c.JSON(200, convertHistoryToResponse(history))
-}
+func typedHistoryHandler(generationType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var history []models.GenerationHistory
+		query := config.DB.Model(&models.GenerationHistory{}).
+			Where("type = ?", generationType).
+			Where("image_url != '' AND image_url IS NOT NULL").
+			Where("image_deleted = ? OR image_deleted IS NULL", false).
+			Where("trashed_at IS NULL")
+
+		query, err := applyCurationFilters(c, query)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
 
-// ProductSceneHistoryHandler 获取一键商品图历史记录
-// 只返回成功生成的记录，不包含已删除的图片
-func ProductSceneHistoryHandler(c *gin.Context) {
-	var history []models.GenerationHistory
-	query := config.DB.Model(&models.GenerationHistory{}).
-		Where("type = ?", models.GenerationTypeProductScene).
-		Where("image_url != '' AND image_url IS NOT NULL").
-		Where("image_deleted = ? OR image_deleted IS NULL", false)
+		page, pageSize := parsePageParams(c)
+		offset := (page - 1) * pageSize
 
-	page, pageSize := parsePageParams(c)
-	offset := (page - 1) * pageSize
+		result := query.Order("created_at desc").
+			Offset(offset).
+			Limit(pageSize).
+			Find(&history)
 
-	result := query.Order("created_at desc").
-		Offset(offset).
-		Limit(pageSize).
-		Find(&history)
+		if result.Error != nil {
+			c.JSON(500, gin.H{"error": "获取历史记录失败"})
+			return
+		}
 
-	if result.Error != nil {
-		c.JSON(500, gin.H{"error": "获取商品图历史记录失败"})
-		return
+		c.JSON(200, convertHistoryToResponse(history))
 	}
-
-	c.JSON(200, convertHistoryToResponse(history))
 }
 
-// LightShadowHistoryHandler 获取光影融合历史记录
-// 只返回成功生成的记录，不包含已删除的图片
-func LightShadowHistoryHandler(c *gin.Context) {
-	var history []models.GenerationHistory
-	query := config.DB.Model(&models.GenerationHistory{}).
-		Where("type = ?", models.GenerationTypeLightShadow).
-		Where("image_url != '' AND image_url IS NOT NULL").
-		Where("image_deleted = ? OR image_deleted IS NULL", false)
-
-	page, pageSize := parsePageParams(c)
-	offset := (page - 1) * pageSize
-
-	result := query.Order("created_at desc").
-		Offset(offset).
-		Limit(pageSize).
-		Find(&history)
-
-	if result.Error != nil {
-		c.JSON(500, gin.H{"error": "获取光影融合历史记录失败"})
+// TypedHistoryHandler 获取任意已注册生成类型的历史记录
+// GET /history/types/:type
+func TypedHistoryHandler(c *gin.Context) {
+	generationType := c.Param("type")
+	if !isKnownGenerationType(generationType) {
+		c.JSON(400, gin.H{"error": unknownGenerationTypeError(generationType).Error()})
 		return
 	}
-
-	c.JSON(200, convertHistoryToResponse(history))
+	typedHistoryHandler(generationType)(c)
 }
 
-// deleteImageFile 删除图片文件
-func deleteImageFile(imageURL string) {
-	if imageURL == "" {
-		return
-	}
-
-	if !config.IsProduction {
-		log.Printf("尝试删除图片文件，URL: %s", imageURL)
-	}
+// 内置生成类型的历史记录接口（保留原有路由）
+var (
+	WhiteBackgroundHistoryHandler = typedHistoryHandler(models.GenerationTypeWhiteBackground) // 白底图
+	ClothingChangeHistoryHandler  = typedHistoryHandler(models.GenerationTypeClothingChange)  // 换装
+	ProductSceneHistoryHandler    = typedHistoryHandler(models.GenerationTypeProductScene)    // 一键商品图
+	LightShadowHistoryHandler     = typedHistoryHandler(models.GenerationTypeLightShadow)     // 光影融合
+)
 
-	// 从 URL 中提取文件名
+// imageFileName 从图片 URL 中提取 output 目录中的文件名，无法识别时返回空字符串
+func imageFileName(imageURL string) string {
 	// 数据库中存储的格式可能是:
 	// 1. 相对路径: images/xxx.png
 	// 2. 完整 URL: http://localhost:8080/images/xxx.png
@@ -243,40 +202,11 @@ func deleteImageFile(imageURL string) {
 			fileName = parts[len(parts)-1]
 		}
 	}
-
-	if fileName == "" {
-		if !config.IsProduction {
-			log.Printf("无法从 URL 提取文件名: %s", imageURL)
-		}
-		return
-	}
-
-	// 构建完整文件路径
-	filePath := filepath.Join(config.OutputDir, fileName)
-	if !config.IsProduction {
-		log.Printf("准备删除文件: %s", filePath)
-	}
-
-	// 删除文件
-	if err := os.Remove(filePath); err != nil {
-		if os.IsNotExist(err) {
-			if !config.IsProduction {
-				log.Printf("文件不存在，跳过删除: %s", filePath)
-			}
-		} else {
-			if !config.IsProduction {
-				log.Printf("删除图片文件失败: %s, 错误: %v", filePath, err)
-			}
-		}
-	} else {
-		if !config.IsProduction {
-			log.Printf("成功删除文件: %s", filePath)
-		}
-	}
+	return fileName
 }
 
 // DeleteHistoryHandler 删除历史记录
-// 只删除 output 目录中的图片文件，数据库记录保留并标记为已删除
+// 图片移入回收站，保留期内可恢复，数据库记录保留
 // 不影响生成次数统计
 func DeleteHistoryHandler(c *gin.Context) {
 	idStr := c.Param("id")
@@ -293,11 +223,8 @@ func DeleteHistoryHandler(c *gin.Context) {
 		return
 	}
 
-	// 删除图片文件
-	deleteImageFile(history.ImageURL)
-
-	// 标记记录为已删除（保留数据库记录）
-	if err := config.DB.Model(&history).Update("image_deleted", true).Error; err != nil {
+	// 图片移入回收站（保留数据库记录）
+	if _, err := trashHistories([]models.GenerationHistory{history}); err != nil {
 		c.JSON(500, gin.H{"error": "更新记录失败"})
 		return
 	}
@@ -306,7 +233,7 @@ func DeleteHistoryHandler(c *gin.Context) {
 }
 
 // BatchDeleteHistoryHandler 批量删除历史记录
-// 只删除图片文件，数据库记录保留并标记为已删除
+// 图片移入回收站，数据库记录保留
 func BatchDeleteHistoryHandler(c *gin.Context) {
 	var req struct {
 		IDs []uint `json:"ids" binding:"required"`
@@ -326,26 +253,64 @@ func BatchDeleteHistoryHandler(c *gin.Context) {
 	var histories []models.GenerationHistory
 	config.DB.Where("id IN ?", req.IDs).Find(&histories)
 
-	// 删除图片文件
-	for _, h := range histories {
-		deleteImageFile(h.ImageURL)
+	// 批量移入回收站（保留数据库记录）
+	trashed, err := trashHistories(histories)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "更新记录失败"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"message": "删除成功",
+		"deleted": trashed,
+	})
+}
+
+// BatchHistoryHandler 获取单个批次的历史记录，按批次内序号排序并支持分页
+// GET /history/batch/:batch_id?page=1&page_size=20
+func BatchHistoryHandler(c *gin.Context) {
+	batchID := c.Param("batch_id")
+	if batchID == "" {
+		c.JSON(400, gin.H{"error": "无效的批次 ID"})
+		return
+	}
+
+	query := config.DB.Model(&models.GenerationHistory{}).
+		Where("batch_id = ?", batchID).
+		Where("image_url != '' AND image_url IS NOT NULL").
+		Where("image_deleted = ? OR image_deleted IS NULL", false).
+		Where("trashed_at IS NULL")
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(500, gin.H{"error": "获取批次记录失败"})
+		return
 	}
 
-	// 批量标记为已删除（保留数据库记录）
-	result := config.DB.Model(&models.GenerationHistory{}).Where("id IN ?", req.IDs).Update("image_deleted", true)
+	page, pageSize := parsePageParams(c)
+	offset := (page - 1) * pageSize
+
+	var history []models.GenerationHistory
+	result := query.Order("batch_index asc").
+		Offset(offset).
+		Limit(pageSize).
+		Find(&history)
 	if result.Error != nil {
-		c.JSON(500, gin.H{"error": "更新记录失败"})
+		c.JSON(500, gin.H{"error": "获取批次记录失败"})
 		return
 	}
 
 	c.JSON(200, gin.H{
-		"message": "删除成功",
-		"deleted": result.RowsAffected,
+		"batch_id":  batchID,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+		"items":     convertHistoryToResponse(history),
 	})
 }
 
 // DeleteHistoryByBatchHandler 按批次 ID 删除历史记录
-// 只删除图片文件，数据库记录保留并标记为已删除
+// 图片移入回收站，数据库记录保留
 func DeleteHistoryByBatchHandler(c *gin.Context) {
 	batchID := c.Param("batch_id")
 	if batchID == "" {
@@ -366,26 +331,21 @@ func DeleteHistoryByBatchHandler(c *gin.Context) {
 		return
 	}
 
-	// 删除图片文件
-	for _, h := range histories {
-		deleteImageFile(h.ImageURL)
-	}
-
-	// 批量标记为已删除（保留数据库记录）
-	deleteResult := config.DB.Model(&models.GenerationHistory{}).Where("batch_id = ?", batchID).Update("image_deleted", true)
-	if deleteResult.Error != nil {
+	// 批量移入回收站（保留数据库记录）
+	trashed, err := trashHistories(histories)
+	if err != nil {
 		c.JSON(500, gin.H{"error": "更新批次记录失败"})
 		return
 	}
 
 	c.JSON(200, gin.H{
 		"message": "删除成功",
-		"deleted": deleteResult.RowsAffected,
+		"deleted": trashed,
 	})
 }
 
 // DeleteHistoryByDateHandler 按日期删除历史记录
-// 只删除图片文件，数据库记录保留并标记为已删除
+// 图片移入回收站，数据库记录保留
 func DeleteHistoryByDateHandler(c *gin.Context) {
 	dateStr := c.Param("date")
 
@@ -408,23 +368,15 @@ func DeleteHistoryByDateHandler(c *gin.Context) {
 		return
 	}
 
-	// 删除图片文件
-	for _, h := range histories {
-		deleteImageFile(h.ImageURL)
-	}
-
-	// 批量标记为已删除（保留数据库记录）
-	result := config.DB.Model(&models.GenerationHistory{}).
-		Where("created_at >= ? AND created_at < ?", startOfDay, endOfDay).
-		Update("image_deleted", true)
-
-	if result.Error != nil {
+	// 批量移入回收站（保留数据库记录）
+	trashed, err := trashHistories(histories)
+	if err != nil {
 		c.JSON(500, gin.H{"error": "更新记录失败"})
 		return
 	}
 
 	c.JSON(200, gin.H{
 		"message": "删除成功",
-		"deleted": result.RowsAffected,
+		"deleted": trashed,
 	})
 }
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"sigma/config"
+	"sigma/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultHistoryQueryLimit = 50  // 每次返回的默认条数
+	maxHistoryQueryLimit     = 200 // 每次返回的最大条数
+)
+
+// historyCursor 游标分页位置：上一页最后一条记录的 (created_at, id)
+type historyCursor struct {
+	CreatedAt time.Time
+	ID        uint
+}
+
+// encode 编码为不透明的游标字符串
+func (cur historyCursor) encode() string {
+	raw := fmt.Sprintf("%d,%d", cur.CreatedAt.UnixNano(), cur.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeHistoryCursor 解析游标字符串
+func decodeHistoryCursor(value string) (historyCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return historyCursor{}, fmt.Errorf("无效的游标")
+	}
+	parts := strings.SplitN(string(raw), ",", 2)
+	if len(parts) != 2 {
+		return historyCursor{}, fmt.Errorf("无效的游标")
+	}
+	nanos, err1 := strconv.ParseInt(parts[0], 10, 64)
+	id, err2 := strconv.ParseUint(parts[1], 10, 64)
+	if err1 != nil || err2 != nil {
+		return historyCursor{}, fmt.Errorf("无效的游标")
+	}
+	return historyCursor{CreatedAt: time.Unix(0, nanos), ID: uint(id)}, nil
+}
+
+// HistoryQueryHandler 统一的历史记录查询接口
+// GET /history/query
+// 筛选参数：from / to（日期 YYYY-MM-DD，包含当天，或 RFC3339 时间）、type、aspect_ratio、image_size
+// （均可重复或逗号分隔）、batch_id、q（提示词包含）、deleted（false 默认 | true 已删除或在回收站 | all）、
+// favorite、tag、collection_id（见 applyCurationFilters）；
+// sort 为 desc（默认，最新在前）或 asc；limit 默认 50，最大 200；
+// cursor 为上一页返回的 next_cursor，按 (created_at, id) 定位，不使用 offset 扫描
+func HistoryQueryHandler(c *gin.Context) {
+	query := config.DB.Model(&models.GenerationHistory{}).
+		Where("image_url != '' AND image_url IS NOT NULL")
+
+	query, err := applyHistoryFilters(c, query)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		query = query.Where("prompt LIKE ? ESCAPE '\\'", "%"+escapeLike(q)+"%")
+	}
+
+	// 总数不受游标影响
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		c.JSON(500, gin.H{"error": "获取历史记录失败"})
+		return
+	}
+
+	ascending := c.DefaultQuery("sort", "desc") == "asc"
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultHistoryQueryLimit)))
+	if limit <= 0 {
+		limit = defaultHistoryQueryLimit
+	}
+	if limit > maxHistoryQueryLimit {
+		limit = maxHistoryQueryLimit
+	}
+
+	if value := c.Query("cursor"); value != "" {
+		cursor, err := decodeHistoryCursor(value)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if ascending {
+			query = query.Where("created_at > ? OR (created_at = ? AND id > ?)", cursor.CreatedAt, cursor.CreatedAt, cursor.ID)
+		} else {
+			query = query.Where("created_at < ? OR (created_at = ? AND id < ?)", cursor.CreatedAt, cursor.CreatedAt, cursor.ID)
+		}
+	}
+
+	order := "created_at desc, id desc"
+	if ascending {
+		order = "created_at asc, id asc"
+	}
+
+	// 多取一条判断是否还有下一页
+	var history []models.GenerationHistory
+	if err := query.Order(order).Limit(limit + 1).Find(&history).Error; err != nil {
+		c.JSON(500, gin.H{"error": "获取历史记录失败"})
+		return
+	}
+
+	hasMore := len(history) > limit
+	if hasMore {
+		history = history[:limit]
+	}
+	nextCursor := ""
+	if hasMore {
+		last := history[len(history)-1]
+		nextCursor = historyCursor{CreatedAt: last.CreatedAt, ID: last.ID}.encode()
+	}
+
+	c.JSON(200, gin.H{
+		"items":       convertHistoryToResponse(history),
+		"total":       total,
+		"has_more":    hasMore,
+		"next_cursor": nextCursor,
+	})
+}
+
+// applyHistoryFilters 将查询参数中的筛选条件（q 除外）应用到历史记录查询
+func applyHistoryFilters(c *gin.Context, query *gorm.DB) (*gorm.DB, error) {
+	if value := c.Query("from"); value != "" {
+		from, err := parseHistoryTime(value, false)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("created_at >= ?", from)
+	}
+	if value := c.Query("to"); value != "" {
+		to, err := parseHistoryTime(value, true)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("created_at < ?", to)
+	}
+
+	if types := queryValues(c, "type"); len(types) > 0 {
+		for _, t := range types {
+			if !isKnownGenerationType(t) {
+				return nil, unknownGenerationTypeError(t)
+			}
+		}
+		query = query.Where("type IN ?", types)
+	}
+	if ratios := queryValues(c, "aspect_ratio"); len(ratios) > 0 {
+		query = query.Where("aspect_ratio IN ?", ratios)
+	}
+	if sizes := queryValues(c, "image_size"); len(sizes) > 0 {
+		query = query.Where("image_size IN ?", sizes)
+	}
+	if batchID := c.Query("batch_id"); batchID != "" {
+		query = query.Where("batch_id = ?", batchID)
+	}
+
+	switch c.DefaultQuery("deleted", "false") {
+	case "false":
+		query = query.Where("image_deleted = ? OR image_deleted IS NULL", false).Where("trashed_at IS NULL")
+	case "true":
+		query = query.Where("image_deleted = ? OR trashed_at IS NOT NULL", true)
+	case "all":
+	default:
+		return nil, fmt.Errorf("deleted 参数只能为 false、true 或 all")
+	}
+	return applyCurationFilters(c, query)
+}
+
+// parseHistoryTime 解析日期（YYYY-MM-DD，按本地时区）或 RFC3339 时间
+// 作为结束日期时返回次日零点，使结束日期当天包含在范围内
+func parseHistoryTime(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		if endOfDay {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("无效的时间格式 %s，请使用 YYYY-MM-DD 或 RFC3339", value)
+	}
+	return t.Local(), nil
+}
+
+// queryValues 读取可重复或逗号分隔的查询参数
+func queryValues(c *gin.Context, name string) []string {
+	var values []string
+	for _, raw := range c.QueryArray(name) {
+		for _, v := range strings.Split(raw, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
+
+// escapeLike 转义 LIKE 模式中的通配符
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
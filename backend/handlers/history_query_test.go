@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"sigma/config"
+	"sigma/models"
+)
+
+type historyQueryResponse struct {
+	Items      []models.GenerationHistoryResponse `json:"items"`
+	Total      int64                              `json:"total"`
+	HasMore    bool                               `json:"has_more"`
+	NextCursor string                             `json:"next_cursor"`
+}
+
+func queryHistory(t *testing.T, r *gin.Engine, rawQuery string) historyQueryResponse {
+	req, _ := http.NewRequest("GET", "/history/query?"+rawQuery, nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("查询 %q 期望状态码 200，实际为 %d: %s", rawQuery, w.Code, w.Body.String())
+	}
+	var response historyQueryResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return response
+}
+
+func TestHistoryQueryHandler_CursorPagination(t *testing.T) {
+	cleanup := setupHistoryTestDB(t)
+	defer cleanup()
+
+	// 多条记录共享同一 created_at，游标需要按 id 区分
+	createdAt := time.Now().Add(-time.Hour)
+	for i := 0; i < 7; i++ {
+		config.DB.Create(&models.GenerationHistory{
+			Prompt:    "同一时间",
+			ImageURL:  "images/same.png",
+			Type:      models.GenerationTypeCreate,
+			CreatedAt: createdAt,
+		})
+	}
+	config.DB.Create(&models.GenerationHistory{Prompt: "最新", ImageURL: "images/new.png", Type: models.GenerationTypeCreate})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/history/query", HistoryQueryHandler)
+
+	seen := make(map[uint]bool)
+	var order []uint
+	cursor := ""
+	for page := 0; page < 10; page++ {
+		q := "limit=3"
+		if cursor != "" {
+			q += "&cursor=" + cursor
+		}
+		response := queryHistory(t, r, q)
+		if response.Total != 8 {
+			t.Errorf("total 应为全部记录数 8，实际为 %d", response.Total)
+		}
+		for _, item := range response.Items {
+			if seen[item.ID] {
+				t.Fatalf("记录 %d 重复出现", item.ID)
+			}
+			seen[item.ID] = true
+			order = append(order, item.ID)
+		}
+		if !response.HasMore {
+			break
+		}
+		cursor = response.NextCursor
+	}
+
+	if len(order) != 8 {
+		t.Fatalf("期望遍历 8 条记录，实际为 %d", len(order))
+	}
+	if order[0] != 8 {
+		t.Errorf("第一条应为最新记录，实际为 %d", order[0])
+	}
+	for i := 2; i < len(order); i++ {
+		if order[i] > order[i-1] {
+			t.Errorf("相同时间的记录应按 id 降序: %v", order)
+			break
+		}
+	}
+}
+
+func TestHistoryQueryHandler_Filters(t *testing.T) {
+	cleanup := setupHistoryTestDB(t)
+	defer cleanup()
+
+	batchID := "batch-1"
+	day := time.Date(2025, 3, 10, 12, 0, 0, 0, time.Local)
+	records := []models.GenerationHistory{
+		{Prompt: "海边的猫", ImageURL: "images/1.png", Type: models.GenerationTypeCreate, AspectRatio: "16:9", ImageSize: "4K", CreatedAt: day},
+		{Prompt: "白底水杯", ImageURL: "images/2.png", Type: models.GenerationTypeWhiteBackground, AspectRatio: "1:1", ImageSize: "2K", CreatedAt: day.AddDate(0, 0, 1), BatchID: &batchID},
+		{Prompt: "100%纯棉", ImageURL: "images/3.png", Type: models.GenerationTypeClothingChange, AspectRatio: "1:1", ImageSize: "2K", CreatedAt: day.AddDate(0, 0, 2)},
+		{Prompt: "已删除", ImageURL: "images/4.png", Type: models.GenerationTypeCreate, AspectRatio: "1:1", ImageSize: "2K", CreatedAt: day, ImageDeleted: true},
+	}
+	for i := range records {
+		config.DB.Create(&records[i])
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/history/query", HistoryQueryHandler)
+
+	cases := []struct {
+		query string
+		want  int64
+	}{
+		{"", 3},
+		{"deleted=all", 4},
+		{"deleted=true", 1},
+		{"type=create,white_background", 2},
+		{"type=create&type=clothing_change", 2},
+		{"aspect_ratio=1:1&image_size=2K", 2},
+		{"image_size=4K", 1},
+		{"batch_id=batch-1", 1},
+		{"q=猫", 1},
+		{"q=%25", 1}, // % 按字面匹配
+		{"from=2025-03-11", 2},
+		{"from=2025-03-10&to=2025-03-11", 2},
+		{"to=2025-03-10", 1},
+	}
+	for _, tc := range cases {
+		if got := queryHistory(t, r, tc.query).Total; got != tc.want {
+			t.Errorf("查询 %q 期望 %d 条，实际为 %d", tc.query, tc.want, got)
+		}
+	}
+
+	asc := queryHistory(t, r, "sort=asc")
+	if len(asc.Items) != 3 || asc.Items[0].Prompt != "海边的猫" {
+		t.Errorf("升序第一条应为最早的记录: %+v", asc.Items)
+	}
+
+	for _, bad := range []string{"type=unknown", "deleted=maybe", "from=yesterday", "cursor=@@"} {
+		req, _ := http.NewRequest("GET", "/history/query?"+bad, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("查询 %q 期望状态码 400，实际为 %d", bad, w.Code)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode/utf8"
+
+	"sigma/config"
+	"sigma/models"
+	"sigma/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// historySearchFTS 历史记录全文索引是否可用（SQLite 未编译 FTS5 时使用 LIKE 搜索）
+var historySearchFTS bool
+
+// ftsMinTermLength trigram 分词器要求每个搜索词至少 3 个字符，更短的搜索词使用 LIKE 匹配
+const ftsMinTermLength = 3
+
+// 搜索结果摘要中高亮匹配文本的标记
+const (
+	snippetMarkStart = "<mark>"
+	snippetMarkEnd   = "</mark>"
+)
+
+// 生成摘要时使用的占位标记（Unicode 私有区字符），HTML 转义摘要文本后再替换为 <mark>
+const (
+	snippetPlaceholderStart = "\uE000"
+	snippetPlaceholderEnd   = "\uE001"
+)
+
+// historySearchSchema 全文索引表和同步触发器
+// history_fts 是 generation_histories 的外部内容索引，使用 trigram 分词以支持中文子串搜索
+var historySearchSchema = []string{
+	`CREATE VIRTUAL TABLE IF NOT EXISTS history_fts USING fts5(
+		prompt, original_prompt,
+		content='generation_histories', content_rowid='id', tokenize='trigram'
+	)`,
+	`CREATE TRIGGER IF NOT EXISTS history_fts_ai AFTER INSERT ON generation_histories BEGIN
+		INSERT INTO history_fts(rowid, prompt, original_prompt) VALUES (new.id, new.prompt, new.original_prompt);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS history_fts_ad AFTER DELETE ON generation_histories BEGIN
+		INSERT INTO history_fts(history_fts, rowid, prompt, original_prompt) VALUES ('delete', old.id, old.prompt, old.original_prompt);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS history_fts_au AFTER UPDATE OF prompt, original_prompt ON generation_histories BEGIN
+		INSERT INTO history_fts(history_fts, rowid, prompt, original_prompt) VALUES ('delete', old.id, old.prompt, old.original_prompt);
+		INSERT INTO history_fts(rowid, prompt, original_prompt) VALUES (new.id, new.prompt, new.original_prompt);
+	END`,
+}
+
+// EnsureHistorySearchIndex 创建历史记录全文索引及同步触发器
+// 索引表首次创建时为已有记录重建索引；SQLite 不支持 FTS5 时返回错误，搜索接口退回 LIKE 匹配
+func EnsureHistorySearchIndex() error {
+	historySearchFTS = false
+
+	var existing int64
+	config.DB.Raw("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'history_fts'").Scan(&existing)
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		for _, stmt := range historySearchSchema {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		if existing == 0 {
+			return tx.Exec("INSERT INTO history_fts(history_fts) VALUES ('rebuild')").Error
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("创建全文索引失败: %w", err)
+	}
+	historySearchFTS = true
+	return nil
+}
+
+// historySearchRow 全文搜索结果行
+type historySearchRow struct {
+	models.GenerationHistory
+	Snippet string
+}
+
+// HistorySearchHandler 按提示词全文搜索历史记录
+// GET /history/search?q=运动鞋
+// 结果按相关度排序，snippet 字段为高亮匹配文本的摘要；支持 /history/query 的筛选参数和 page / page_size 分页，
+// 返回 {items, total}，total 为匹配的总条数
+func HistorySearchHandler(c *gin.Context) {
+	terms := strings.Fields(c.Query("q"))
+	if len(terms) == 0 {
+		c.JSON(400, gin.H{"error": "请输入搜索内容"})
+		return
+	}
+
+	filtered, err := applyHistoryFilters(c, config.DB.Model(&models.GenerationHistory{}).
+		Where("image_url != '' AND image_url IS NOT NULL"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, pageSize := parsePageParams(c)
+	offset := (page - 1) * pageSize
+
+	var rows []historySearchRow
+	var total int64
+	if historySearchFTS && ftsSearchable(terms) {
+		total, err = searchHistoryFTS(filtered, terms, pageSize, offset, &rows)
+	} else {
+		total, err = searchHistoryLike(filtered, terms, pageSize, offset, &rows)
+	}
+	if err != nil {
+		utils.LogAPI("搜索历史记录失败: %v", err)
+		c.JSON(500, gin.H{"error": "搜索历史记录失败"})
+		return
+	}
+
+	history := make([]models.GenerationHistory, len(rows))
+	for i, row := range rows {
+		history[i] = row.GenerationHistory
+	}
+	items := convertHistoryToResponse(history)
+	for i := range items {
+		items[i].Snippet = escapeSnippet(rows[i].Snippet)
+	}
+	c.JSON(200, gin.H{
+		"items": items,
+		"total": total,
+	})
+}
+
+// searchHistoryFTS 使用全文索引搜索，按相关度排序，返回匹配的总条数
+func searchHistoryFTS(filtered *gorm.DB, terms []string, limit, offset int, rows *[]historySearchRow) (int64, error) {
+	match := ftsMatchQuery(terms)
+
+	var total int64
+	err := config.DB.Raw(`SELECT count(*) FROM history_fts JOIN (?) AS h ON h.id = history_fts.rowid
+		WHERE history_fts MATCH ?`, filtered, match).Scan(&total).Error
+	if err != nil {
+		return 0, err
+	}
+
+	err = config.DB.Raw(`SELECT h.*, snippet(history_fts, -1, ?, ?, '…', 24) AS snippet
+		FROM history_fts JOIN (?) AS h ON h.id = history_fts.rowid
+		WHERE history_fts MATCH ?
+		ORDER BY bm25(history_fts), h.created_at DESC
+		LIMIT ? OFFSET ?`,
+		snippetPlaceholderStart, snippetPlaceholderEnd, filtered, match, limit, offset).
+		Scan(rows).Error
+	return total, err
+}
+
+// ftsSearchable 所有搜索词都满足 trigram 最小长度时才能使用全文索引
+func ftsSearchable(terms []string) bool {
+	for _, term := range terms {
+		if utf8.RuneCountInString(term) < ftsMinTermLength {
+			return false
+		}
+	}
+	return true
+}
+
+// ftsMatchQuery 将搜索词转换为 FTS5 查询：每个词作为短语匹配，多个词同时满足
+func ftsMatchQuery(terms []string) string {
+	phrases := make([]string, len(terms))
+	for i, term := range terms {
+		phrases[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+	}
+	return strings.Join(phrases, " ")
+}
+
+// searchHistoryLike 使用 LIKE 匹配提示词（无全文索引或搜索词过短时），按时间倒序，返回匹配的总条数
+// LIKE 不区分大小写，摘要高亮同样不区分大小写
+func searchHistoryLike(filtered *gorm.DB, terms []string, limit, offset int, rows *[]historySearchRow) (int64, error) {
+	query := filtered
+	for _, term := range terms {
+		pattern := "%" + escapeLike(term) + "%"
+		query = query.Where("(prompt LIKE ? ESCAPE '\\' OR original_prompt LIKE ? ESCAPE '\\')", pattern, pattern)
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return 0, err
+	}
+
+	var history []models.GenerationHistory
+	if err := query.Order("created_at desc, id desc").Limit(limit).Offset(offset).Find(&history).Error; err != nil {
+		return 0, err
+	}
+	*rows = make([]historySearchRow, len(history))
+	for i, h := range history {
+		text := h.Prompt
+		if indexFold([]rune(text), []rune(terms[0]), 0) < 0 {
+			text = h.OriginalPrompt
+		}
+		(*rows)[i] = historySearchRow{GenerationHistory: h, Snippet: highlightSnippet(text, terms, 24)}
+	}
+	return total, nil
+}
+
+// escapeSnippet HTML 转义摘要中的提示词文本，再把占位标记替换为 <mark>，避免提示词中的标签被前端当作 HTML 渲染
+func escapeSnippet(snippet string) string {
+	return strings.NewReplacer(snippetPlaceholderStart, snippetMarkStart, snippetPlaceholderEnd, snippetMarkEnd).
+		Replace(html.EscapeString(snippet))
+}
+
+// highlightSnippet 截取第一个匹配词附近的文本，并用占位标记标出所有匹配词（由 escapeSnippet 转为 <mark>）
+// 匹配不区分大小写，高亮保留原文的大小写
+func highlightSnippet(text string, terms []string, contextRunes int) string {
+	runes := []rune(text)
+	start, end := 0, len(runes)
+	first := []rune(terms[0])
+	if pos := indexFold(runes, first, 0); pos >= 0 {
+		if pos-contextRunes > 0 {
+			start = pos - contextRunes
+		}
+		if limit := pos + len(first) + contextRunes; limit < end {
+			end = limit
+		}
+	}
+
+	window := runes[start:end]
+	marked := make([]bool, len(window))
+	for _, term := range terms {
+		termRunes := []rune(term)
+		for pos := indexFold(window, termRunes, 0); pos >= 0; pos = indexFold(window, termRunes, pos+len(termRunes)) {
+			for i := pos; i < pos+len(termRunes); i++ {
+				marked[i] = true
+			}
+		}
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	for i, r := range window {
+		if marked[i] && (i == 0 || !marked[i-1]) {
+			b.WriteString(snippetPlaceholderStart)
+		}
+		b.WriteRune(r)
+		if marked[i] && (i == len(window)-1 || !marked[i+1]) {
+			b.WriteString(snippetPlaceholderEnd)
+		}
+	}
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// indexFold 返回 term 在 runes 中从 from 开始第一次出现的位置（按字符计，不区分大小写），未找到时返回 -1
+func indexFold(runes, term []rune, from int) int {
+	if len(term) == 0 {
+		return -1
+	}
+	for i := from; i+len(term) <= len(runes); i++ {
+		if strings.EqualFold(string(runes[i:i+len(term)]), string(term)) {
+			return i
+		}
+	}
+	return -1
+}
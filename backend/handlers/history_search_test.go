@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	glebarez "github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"sigma/config"
+	"sigma/models"
+)
+
+// setupHistorySearchTestDB 使用与生产环境相同的 SQLite 驱动（内置 FTS5）
+func setupHistorySearchTestDB(t *testing.T) func() {
+	var err error
+	config.DB, err = gorm.Open(glebarez.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("无法创建测试数据库: %v", err)
+	}
+	sqlDB, _ := config.DB.DB()
+	sqlDB.SetMaxOpenConns(1)
+	config.DB.AutoMigrate(&models.GenerationHistory{}, &models.GenerationTypeDef{}, &models.HistoryTag{})
+
+	return func() {
+		historySearchFTS = false
+		sqlDB.Close()
+	}
+}
+
+type historySearchResponse struct {
+	Items []models.GenerationHistoryResponse `json:"items"`
+	Total int64                              `json:"total"`
+}
+
+func searchHistoryPage(t *testing.T, r *gin.Engine, q string, query string) historySearchResponse {
+	req, _ := http.NewRequest("GET", "/history/search?q="+url.QueryEscape(q)+query, nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("搜索 %q 期望状态码 200，实际为 %d: %s", q, w.Code, w.Body.String())
+	}
+	var response historySearchResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return response
+}
+
+func searchHistory(t *testing.T, r *gin.Engine, q string) []models.GenerationHistoryResponse {
+	return searchHistoryPage(t, r, q, "").Items
+}
+
+func TestHistorySearchHandler_FTSRankingAndSync(t *testing.T) {
+	cleanup := setupHistorySearchTestDB(t)
+	defer cleanup()
+
+	// 建索引前已有的记录通过重建进入索引
+	config.DB.Create(&models.GenerationHistory{Prompt: "一双白色运动鞋放在木桌上", ImageURL: "images/1.png", Type: models.GenerationTypeCreate})
+	if err := EnsureHistorySearchIndex(); err != nil {
+		t.Fatalf("创建全文索引失败: %v", err)
+	}
+	if err := EnsureHistorySearchIndex(); err != nil {
+		t.Fatalf("重复创建全文索引失败: %v", err)
+	}
+
+	// 建索引后插入的记录由触发器同步
+	config.DB.Create(&models.GenerationHistory{Prompt: "运动鞋特写，运动鞋鞋底纹理清晰，运动鞋", ImageURL: "images/2.png", Type: models.GenerationTypeCreate})
+	config.DB.Create(&models.GenerationHistory{Prompt: "{product} 摆在沙滩上", OriginalPrompt: "{product} 摆在{scene}上", ImageURL: "images/3.png", Type: models.GenerationTypeProductScene})
+	config.DB.Create(&models.GenerationHistory{Prompt: "运动鞋生成失败", Type: models.GenerationTypeCreate})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/history/search", HistorySearchHandler)
+
+	results := searchHistory(t, r, "运动鞋")
+	if len(results) != 2 {
+		t.Fatalf("期望 2 条结果（失败记录不返回），实际为 %d", len(results))
+	}
+	if !strings.HasSuffix(results[0].ImageURL, "images/2.png") {
+		t.Errorf("匹配次数多的记录应排在前面: %+v", results[0])
+	}
+	if !strings.Contains(results[0].Snippet, "<mark>运动鞋</mark>") {
+		t.Errorf("摘要应高亮匹配文本: %q", results[0].Snippet)
+	}
+
+	// 分页时 total 为匹配的总条数
+	if page := searchHistoryPage(t, r, "运动鞋", "&page_size=1"); len(page.Items) != 1 || page.Total != 2 {
+		t.Errorf("期望每页 1 条、共 2 条，实际为 %d 条、共 %d 条", len(page.Items), page.Total)
+	}
+
+	// 原始模板也参与搜索
+	if results := searchHistory(t, r, "{scene}"); len(results) != 1 || results[0].Type != models.GenerationTypeProductScene {
+		t.Errorf("应能按原始模板搜索: %+v", results)
+	}
+
+	// 修改和删除同步到索引
+	config.DB.Model(&models.GenerationHistory{}).Where("id = ?", 1).Update("prompt", "一只红色帆布包")
+	config.DB.Delete(&models.GenerationHistory{}, 2)
+	if results := searchHistory(t, r, "运动鞋"); len(results) != 0 {
+		t.Errorf("修改或删除的记录不应被搜索到: %+v", results)
+	}
+	if results := searchHistory(t, r, "帆布包"); len(results) != 1 {
+		t.Errorf("期望 1 条帆布包记录，实际为 %d", len(results))
+	}
+
+	// 少于 3 个字的搜索词使用 LIKE 匹配
+	if results := searchHistory(t, r, "红色"); len(results) != 1 || results[0].Snippet != "一只<mark>红色</mark>帆布包" {
+		t.Errorf("短搜索词应使用 LIKE 匹配: %+v", results)
+	}
+}
+
+func TestHistorySearchHandler_LikeFallback(t *testing.T) {
+	cleanup := setupHistoryTestDB(t)
+	defer cleanup()
+	historySearchFTS = false
+
+	config.DB.Create(&models.GenerationHistory{Prompt: "白色运动鞋 木桌", ImageURL: "images/1.png", Type: models.GenerationTypeCreate})
+	config.DB.Create(&models.GenerationHistory{Prompt: "运动鞋 50%折扣", ImageURL: "images/2.png", Type: models.GenerationTypeCreate})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/history/search", HistorySearchHandler)
+
+	if results := searchHistory(t, r, "运动鞋"); len(results) != 2 {
+		t.Errorf("期望 2 条结果，实际为 %d", len(results))
+	}
+	// 多个搜索词需要同时匹配，通配符按字面匹配
+	results := searchHistory(t, r, "运动鞋 50%")
+	if len(results) != 1 || results[0].Snippet != "<mark>运动鞋</mark> <mark>50%</mark>折扣" {
+		t.Errorf("期望 1 条高亮结果: %+v", results)
+	}
+
+	// LIKE 不区分大小写，高亮同样不区分大小写并保留原文大小写
+	config.DB.Create(&models.GenerationHistory{Prompt: "Nike Air 跑鞋，NIKE 标志", ImageURL: "images/4.png", Type: models.GenerationTypeCreate})
+	page := searchHistoryPage(t, r, "nike", "&page_size=1")
+	if page.Total != 1 || len(page.Items) != 1 || page.Items[0].Snippet != "<mark>Nike</mark> Air 跑鞋，<mark>NIKE</mark> 标志" {
+		t.Errorf("期望不区分大小写的高亮: %+v", page)
+	}
+
+	// 提示词中的标签转义后返回，只有高亮标记是 HTML
+	config.DB.Create(&models.GenerationHistory{Prompt: `<b onclick="x">帆布包</b>`, ImageURL: "images/3.png", Type: models.GenerationTypeCreate})
+	results = searchHistory(t, r, "帆布包")
+	if len(results) != 1 || results[0].Snippet != "&lt;b onclick=&#34;x&#34;&gt;<mark>帆布包</mark>&lt;/b&gt;" {
+		t.Errorf("摘要应转义提示词中的 HTML: %+v", results)
+	}
+
+	req, _ := http.NewRequest("GET", "/history/search?q=+", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("空搜索内容应返回 400，实际为 %d", w.Code)
+	}
+}
@@ -19,7 +19,7 @@ func setupHistoryTestDB(t *testing.T) func() {
 	if err != nil {
 		t.Fatalf("无法创建测试数据库: %v", err)
 	}
-	config.DB.AutoMigrate(&models.GenerationHistory{}, &models.GenerationStats{})
+	config.DB.AutoMigrate(&models.GenerationHistory{}, &models.GenerationStats{}, &models.HistoryTag{}, &models.Collection{}, &models.CollectionItem{})
 	
 	return func() {
 		sqlDB, _ := config.DB.DB()
@@ -145,3 +145,47 @@ func TestHistoryHandler_TypeFilter(t *testing.T) {
 		t.Errorf("不筛选期望 2 条记录，实际有 %d 条", len(response2))
 	}
 }
+
+func TestBatchHistoryHandler_PaginatesWithinBatch(t *testing.T) {
+	cleanup := setupHistoryTestDB(t)
+	defer cleanup()
+
+	batchID := "batch-large"
+	total := 12
+	for i := total - 1; i >= 0; i-- {
+		index := i
+		config.DB.Create(&models.GenerationHistory{
+			ImageURL:   "images/gen.png",
+			Type:       models.GenerationTypeCreate,
+			BatchID:    &batchID,
+			BatchIndex: &index,
+			BatchTotal: &total,
+		})
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/history/batch/:batch_id", BatchHistoryHandler)
+
+	req, _ := http.NewRequest("GET", "/history/batch/"+batchID+"?page=2&page_size=5", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var response struct {
+		Total int64                              `json:"total"`
+		Items []models.GenerationHistoryResponse `json:"items"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+
+	if response.Total != int64(total) {
+		t.Errorf("期望总数 %d，实际为 %d", total, response.Total)
+	}
+	if len(response.Items) != 5 {
+		t.Fatalf("期望第 2 页 5 条记录，实际为 %d", len(response.Items))
+	}
+	for i, item := range response.Items {
+		if item.BatchIndex == nil || *item.BatchIndex != 5+i {
+			t.Errorf("第 %d 条记录的批次序号不正确: %v", i, item.BatchIndex)
+		}
+	}
+}
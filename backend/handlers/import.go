@@ -0,0 +1,657 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"sigma/config"
+	"sigma/events"
+	"sigma/models"
+	"sigma/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 导入文件的大小上限，防止超大清单或压缩炸弹耗尽内存
+var (
+	maxImportManifestSize int64 = 10 << 20 // 清单文件 10MB
+	maxImportRefImageSize int64 = 20 << 20 // 压缩包中的单张参考图 20MB
+)
+
+// importRow 清单中的一行
+type importRow struct {
+	Row         int
+	SKU         string
+	ProductName string
+	Scene       string
+	Type        string
+	Prompt      string
+	AspectRatio string
+	ImageSize   string
+	RefImages   []string // 压缩包内的参考图文件名
+}
+
+// CreateImportJobHandler 创建批量导入任务
+// POST /import/jobs
+// 表单字段：manifest（CSV 或 JSON 清单，必填）、archive（参考图 zip，可选）、
+// type / aspectRatio / imageSize（清单中对应列为空时的默认值）
+// 每个有效行创建一个排队中的生成任务；无效行跳过并在响应中列出
+func CreateImportJobHandler(c *gin.Context) {
+	manifestHeader, err := c.FormFile("manifest")
+	if err != nil {
+		c.JSON(400, gin.H{"error": "请上传清单文件"})
+		return
+	}
+	manifestData, err := readFormFile(manifestHeader, maxImportManifestSize)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "读取清单文件失败: " + err.Error()})
+		return
+	}
+	rows, err := parseImportManifest(manifestHeader.Filename, manifestData)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if len(rows) == 0 {
+		c.JSON(400, gin.H{"error": "清单中没有数据行"})
+		return
+	}
+
+	archive := map[string]*zip.File{}
+	if archiveHeader, err := c.FormFile("archive"); err == nil {
+		var closeArchive func() error
+		archive, closeArchive, err = openImportArchive(archiveHeader)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		defer closeArchive()
+	}
+
+	defaults := importRow{
+		Type:        c.PostForm("type"),
+		AspectRatio: c.PostForm("aspectRatio"),
+		ImageSize:   c.PostForm("imageSize"),
+	}
+
+	jobID := uuid.New().String()
+	var valid []importTask
+	skipped := []models.ImportRowError{}
+
+	for _, row := range rows {
+		task, refNames, err := buildImportTask(row, defaults, archive)
+		if err != nil {
+			skipped = append(skipped, models.ImportRowError{Row: row.Row, SKU: row.SKU, Error: err.Error()})
+			continue
+		}
+		task.ImportJobID = jobID
+		valid = append(valid, importTask{row: row, task: task, refNames: refNames})
+	}
+
+	if len(valid) == 0 {
+		c.JSON(400, gin.H{"error": "清单中没有可导入的行", "skipped": skipped})
+		return
+	}
+	budgetItems := make([]budgetItem, len(valid))
+	for i, v := range valid {
+		budgetItems[i] = budgetItem{Type: v.task.Type, ImageSize: v.task.ImageSize}
+	}
+	if err := checkBudget(budgetItems); err != nil {
+		respondBudgetError(c, err)
+		return
+	}
+
+	// 校验和预算检查通过后再解压参考图，被拒绝的导入不在上传目录留下文件
+	extracted := make(map[string]string) // 压缩包内文件名 -> 已保存的相对路径，同一参考图只解压一次
+	var tasks []models.GenerationTask
+	var items []models.ImportJobItem
+	for _, v := range valid {
+		if err := attachImportRefImages(&v.task, v.refNames, archive, extracted); err != nil {
+			skipped = append(skipped, models.ImportRowError{Row: v.row.Row, SKU: v.row.SKU, Error: err.Error()})
+			continue
+		}
+		tasks = append(tasks, v.task)
+		items = append(items, models.ImportJobItem{
+			JobID:       jobID,
+			Row:         v.row.Row,
+			SKU:         v.row.SKU,
+			ProductName: v.row.ProductName,
+			Scene:       v.row.Scene,
+			TaskID:      v.task.TaskID,
+		})
+	}
+	sort.Slice(skipped, func(i, j int) bool { return skipped[i].Row < skipped[j].Row })
+
+	if len(tasks) == 0 {
+		discardImportRefImages(extracted)
+		c.JSON(400, gin.H{"error": "清单中没有可导入的行", "skipped": skipped})
+		return
+	}
+
+	skippedJSON, _ := json.Marshal(skipped)
+	job := models.ImportJob{
+		JobID:        jobID,
+		ManifestName: manifestHeader.Filename,
+		Total:        len(tasks),
+		Skipped:      string(skippedJSON),
+	}
+	// 解压参考图后在预算锁内按实际导入的行再次检查并写入任务
+	budgetItems = budgetItems[:0]
+	for _, task := range tasks {
+		budgetItems = append(budgetItems, budgetItem{Type: task.Type, ImageSize: task.ImageSize})
+	}
+	releaseBudget, err := reserveBudget(budgetItems)
+	if err != nil {
+		discardImportRefImages(extracted)
+		respondBudgetError(c, err)
+		return
+	}
+	defer releaseBudget()
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&job).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&tasks).Error; err != nil {
+			return err
+		}
+		return tx.Create(&items).Error
+	})
+	releaseBudget()
+	if err != nil {
+		discardImportRefImages(extracted)
+		utils.LogAPI("创建导入任务失败: %v", err)
+		c.JSON(500, gin.H{"error": "创建导入任务失败"})
+		return
+	}
+
+	for i := range tasks {
+		publishTaskEvent(events.TaskQueued, &tasks[i])
+	}
+	getTaskQueue().Notify()
+	utils.LogAPI("导入任务 %s: 清单=%s, 创建 %d 个任务, 跳过 %d 行", jobID, manifestHeader.Filename, len(tasks), len(skipped))
+
+	c.JSON(200, gin.H{
+		"job_id":  jobID,
+		"total":   len(tasks),
+		"skipped": skipped,
+	})
+}
+
+// GetImportJobHandler 获取导入任务进度
+// GET /import/jobs/:id
+func GetImportJobHandler(c *gin.Context) {
+	job, ok := loadImportJob(c)
+	if !ok {
+		return
+	}
+
+	progress, err := importJobProgress(job)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "获取导入进度失败"})
+		return
+	}
+	c.JSON(200, progress)
+}
+
+// ImportJobManifestHandler 下载导入结果清单，记录每个 SKU 对应的输出文件
+// GET /import/jobs/:id/manifest?format=json|csv
+func ImportJobManifestHandler(c *gin.Context) {
+	job, ok := loadImportJob(c)
+	if !ok {
+		return
+	}
+
+	var items []models.ImportJobItem
+	if err := config.DB.Where("job_id = ?", job.JobID).Order("row asc").Find(&items).Error; err != nil {
+		c.JSON(500, gin.H{"error": "获取导入结果失败"})
+		return
+	}
+	taskIDs := make([]string, len(items))
+	for i, item := range items {
+		taskIDs[i] = item.TaskID
+	}
+	var tasks []models.GenerationTask
+	if err := config.DB.Where("task_id IN ?", taskIDs).Find(&tasks).Error; err != nil {
+		c.JSON(500, gin.H{"error": "获取导入结果失败"})
+		return
+	}
+	tasksByID := make(map[string]*models.GenerationTask, len(tasks))
+	for i := range tasks {
+		tasksByID[tasks[i].TaskID] = &tasks[i]
+	}
+
+	type manifestEntry struct {
+		Row         int      `json:"row"`
+		SKU         string   `json:"sku"`
+		ProductName string   `json:"product_name"`
+		Scene       string   `json:"scene"`
+		Type        string   `json:"type"`
+		TaskID      string   `json:"task_id"`
+		Status      string   `json:"status"`
+		OutputFiles []string `json:"output_files"`
+		Error       string   `json:"error,omitempty"`
+	}
+	entries := make([]manifestEntry, 0, len(items))
+	for _, item := range items {
+		entry := manifestEntry{
+			Row:         item.Row,
+			SKU:         item.SKU,
+			ProductName: item.ProductName,
+			Scene:       item.Scene,
+			TaskID:      item.TaskID,
+			OutputFiles: []string{},
+		}
+		if task, ok := tasksByID[item.TaskID]; ok {
+			entry.Type = task.Type
+			entry.Status = string(task.Status)
+			entry.Error = task.ErrorMsg
+			if task.Status == models.TaskStatusCompleted && task.ImageURL != "" {
+				entry.OutputFiles = append(entry.OutputFiles, utils.ToAbsoluteURL(task.ImageURL, config.ServerPort))
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	skipped := []models.ImportRowError{}
+	if job.Skipped != "" {
+		json.Unmarshal([]byte(job.Skipped), &skipped)
+	}
+
+	if c.DefaultQuery("format", "json") == "csv" {
+		var buf bytes.Buffer
+		buf.WriteString("\ufeff") // BOM，便于 Excel 正确识别 UTF-8
+		w := csv.NewWriter(&buf)
+		w.Write([]string{"row", "sku", "product_name", "scene", "type", "task_id", "status", "output_files", "error"})
+		for _, e := range entries {
+			w.Write([]string{fmt.Sprint(e.Row), e.SKU, e.ProductName, e.Scene, e.Type, e.TaskID, e.Status, strings.Join(e.OutputFiles, ";"), e.Error})
+		}
+		for _, s := range skipped {
+			w.Write([]string{fmt.Sprint(s.Row), s.SKU, "", "", "", "", "skipped", "", s.Error})
+		}
+		w.Flush()
+
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=import_%s.csv", job.JobID))
+		c.Data(200, "text/csv; charset=utf-8", buf.Bytes())
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=import_%s.json", job.JobID))
+	c.JSON(200, gin.H{
+		"job_id":  job.JobID,
+		"items":   entries,
+		"skipped": skipped,
+	})
+}
+
+// loadImportJob 按路径参数读取导入任务，不存在时直接返回 404
+func loadImportJob(c *gin.Context) (*models.ImportJob, bool) {
+	var job models.ImportJob
+	if err := config.DB.Where("job_id = ?", c.Param("id")).First(&job).Error; err != nil {
+		c.JSON(404, gin.H{"error": "导入任务不存在"})
+		return nil, false
+	}
+	return &job, true
+}
+
+// importJobProgress 按任务状态汇总导入进度
+func importJobProgress(job *models.ImportJob) (models.ImportJobProgress, error) {
+	progress := models.ImportJobProgress{
+		JobID:     job.JobID,
+		Total:     job.Total,
+		Skipped:   []models.ImportRowError{},
+		CreatedAt: job.CreatedAt,
+	}
+	if job.Skipped != "" {
+		json.Unmarshal([]byte(job.Skipped), &progress.Skipped)
+	}
+
+	var counts []struct {
+		Status models.TaskStatus
+		Count  int
+	}
+	err := config.DB.Model(&models.GenerationTask{}).
+		Select("status, count(*) as count").
+		Where("import_job_id = ?", job.JobID).
+		Group("status").
+		Scan(&counts).Error
+	if err != nil {
+		return progress, err
+	}
+	for _, row := range counts {
+		switch row.Status {
+		case models.TaskStatusQueued:
+			progress.Queued = row.Count
+		case models.TaskStatusProcessing:
+			progress.Processing = row.Count
+		case models.TaskStatusCompleted:
+			progress.Completed = row.Count
+		case models.TaskStatusFailed:
+			progress.Failed = row.Count
+		case models.TaskStatusCancelled:
+			progress.Cancelled = row.Count
+		}
+	}
+
+	progress.Status = "completed"
+	if progress.Queued > 0 || progress.Processing > 0 {
+		progress.Status = "processing"
+	}
+	return progress, nil
+}
+
+// buildImportTask 校验清单行并构建排队中的生成任务，返回需要从压缩包解压的参考图文件名
+// 参考图只检查是否在压缩包中，由 attachImportRefImages 在预算检查通过后解压
+func buildImportTask(row, defaults importRow, archive map[string]*zip.File) (models.GenerationTask, []string, error) {
+	if row.SKU == "" {
+		return models.GenerationTask{}, nil, fmt.Errorf("缺少 SKU")
+	}
+
+	generationType := row.Type
+	if generationType == "" {
+		generationType = defaults.Type
+	}
+	if generationType == "" {
+		return models.GenerationTask{}, nil, fmt.Errorf("缺少生成类型")
+	}
+	typeDef, ok := lookupGenerationType(generationType)
+	if !ok {
+		return models.GenerationTask{}, nil, unknownGenerationTypeError(generationType)
+	}
+	if err := checkRefImageSlots(typeDef, len(row.RefImages)); err != nil {
+		return models.GenerationTask{}, nil, err
+	}
+
+	// 未提供 prompt 时使用该类型的默认提示词模板
+	template, templateID := row.Prompt, ""
+	if template == "" {
+		tpl, err := resolvePromptTemplate("", generationType)
+		if err != nil || tpl == nil {
+			return models.GenerationTask{}, nil, fmt.Errorf("生成类型 %s 没有默认提示词，请在清单中提供 prompt", generationType)
+		}
+		template, templateID = tpl.Template, tpl.TemplateID
+	}
+	prompt, err := utils.RenderPrompt(template, map[string]string{
+		"sku":     row.SKU,
+		"product": row.ProductName,
+		"scene":   row.Scene,
+	})
+	if err != nil {
+		return models.GenerationTask{}, nil, err
+	}
+
+	var refNames []string
+	for _, name := range row.RefImages {
+		name = path.Base(strings.ReplaceAll(name, "\\", "/")) // 压缩包按文件名索引，清单中可以带目录
+		if _, exists := archive[name]; !exists {
+			return models.GenerationTask{}, nil, fmt.Errorf("参考图 %s 不在压缩包中", name)
+		}
+		refNames = append(refNames, name)
+	}
+
+	aspectRatio := row.AspectRatio
+	if aspectRatio == "" {
+		aspectRatio = defaults.AspectRatio
+	}
+	imageSize := row.ImageSize
+	if imageSize == "" {
+		imageSize = defaults.ImageSize
+	}
+
+	return models.GenerationTask{
+		TaskID:         uuid.New().String(),
+		Status:         models.TaskStatusQueued,
+		Type:           generationType,
+		Prompt:         prompt,
+		StartedAt:      time.Now(),
+		ImageCount:     1,
+		AspectRatio:    typeDefaultAspectRatio(typeDef, aspectRatio),
+		ImageSize:      typeDefaultImageSize(typeDef, imageSize),
+		TemplateID:     templateID,
+		OriginalPrompt: template,
+	}, refNames, nil
+}
+
+// importTask 通过校验、等待解压参考图的导入行
+type importTask struct {
+	row      importRow
+	task     models.GenerationTask
+	refNames []string
+}
+
+// attachImportRefImages 从压缩包解压任务的参考图到上传目录并写入任务
+func attachImportRefImages(task *models.GenerationTask, refNames []string, archive map[string]*zip.File, extracted map[string]string) error {
+	var refImages []string
+	for _, name := range refNames {
+		saved, ok := extracted[name]
+		if !ok {
+			var err error
+			saved, err = extractImportRefImage(archive[name])
+			if err != nil {
+				return fmt.Errorf("解压参考图 %s 失败: %v", name, err)
+			}
+			extracted[name] = saved
+		}
+		refImages = append(refImages, saved)
+	}
+	refImagesJSON, _ := json.Marshal(refImages)
+	task.RefImages = string(refImagesJSON)
+	return nil
+}
+
+// discardImportRefImages 导入失败时删除本次解压、且没有被其他任务或历史记录引用的参考图
+func discardImportRefImages(extracted map[string]string) {
+	for _, saved := range extracted {
+		pattern := "%" + escapeLike(saved) + "%"
+		var refs int64
+		config.DB.Model(&models.GenerationTask{}).Where("ref_images LIKE ? ESCAPE '\\'", pattern).Count(&refs)
+		if refs == 0 {
+			config.DB.Model(&models.GenerationHistory{}).Where("ref_images LIKE ? ESCAPE '\\'", pattern).Count(&refs)
+		}
+		if refs > 0 {
+			continue
+		}
+		filePath := filepath.Join(config.UploadDir, filepath.Base(saved))
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			utils.LogAPI("删除参考图失败: %s, %v", filePath, err)
+		}
+		config.DB.Where("path = ?", saved).Delete(&models.StoredFile{})
+	}
+}
+
+// parseImportManifest 按文件扩展名解析 CSV 或 JSON 清单
+func parseImportManifest(fileName string, data []byte) ([]importRow, error) {
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".csv":
+		return parseImportCSV(data)
+	case ".json":
+		return parseImportJSON(data)
+	}
+	return nil, fmt.Errorf("不支持的清单格式，请上传 .csv 或 .json 文件")
+}
+
+// parseImportCSV 解析带表头的 CSV 清单
+// 列名：sku, product_name, scene, ref_images, type, prompt, aspect_ratio, image_size（sku 必填，其余可选）
+func parseImportCSV(data []byte) ([]importRow, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("CSV 解析失败: %v", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["sku"]; !ok {
+		return nil, fmt.Errorf("CSV 缺少 sku 列")
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var rows []importRow
+	for i, record := range records[1:] {
+		row := importRow{
+			Row:         i + 1,
+			SKU:         field(record, "sku"),
+			ProductName: field(record, "product_name"),
+			Scene:       field(record, "scene"),
+			Type:        field(record, "type"),
+			Prompt:      field(record, "prompt"),
+			AspectRatio: field(record, "aspect_ratio"),
+			ImageSize:   field(record, "image_size"),
+			RefImages:   splitRefImageNames(field(record, "ref_images")),
+		}
+		if row.SKU == "" && row.ProductName == "" && len(row.RefImages) == 0 {
+			continue // 跳过空行
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// parseImportJSON 解析 JSON 数组清单，字段与 CSV 列名相同；ref_images 可以是数组或分隔符字符串
+func parseImportJSON(data []byte) ([]importRow, error) {
+	var records []struct {
+		SKU         string          `json:"sku"`
+		ProductName string          `json:"product_name"`
+		Scene       string          `json:"scene"`
+		Type        string          `json:"type"`
+		Prompt      string          `json:"prompt"`
+		AspectRatio string          `json:"aspect_ratio"`
+		ImageSize   string          `json:"image_size"`
+		RefImages   json.RawMessage `json:"ref_images"`
+	}
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("JSON 解析失败，清单应为对象数组: %v", err)
+	}
+
+	rows := make([]importRow, len(records))
+	for i, r := range records {
+		var refImages []string
+		if len(r.RefImages) > 0 {
+			var list []string
+			var joined string
+			if err := json.Unmarshal(r.RefImages, &list); err == nil {
+				for _, name := range list {
+					if name = strings.TrimSpace(name); name != "" {
+						refImages = append(refImages, name)
+					}
+				}
+			} else if err := json.Unmarshal(r.RefImages, &joined); err == nil {
+				refImages = splitRefImageNames(joined)
+			}
+		}
+		rows[i] = importRow{
+			Row:         i + 1,
+			SKU:         strings.TrimSpace(r.SKU),
+			ProductName: strings.TrimSpace(r.ProductName),
+			Scene:       strings.TrimSpace(r.Scene),
+			Type:        strings.TrimSpace(r.Type),
+			Prompt:      r.Prompt,
+			AspectRatio: strings.TrimSpace(r.AspectRatio),
+			ImageSize:   strings.TrimSpace(r.ImageSize),
+			RefImages:   refImages,
+		}
+	}
+	return rows, nil
+}
+
+// splitRefImageNames 拆分以 ; 或 | 分隔的参考图文件名
+func splitRefImageNames(value string) []string {
+	var names []string
+	for _, name := range strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == '|' }) {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// openImportArchive 打开参考图压缩包，按文件名（不含目录）索引其中的文件
+// 压缩包直接从上传文件读取，不整体载入内存；返回的 close 需在使用完文件后调用
+func openImportArchive(header *multipart.FileHeader) (map[string]*zip.File, func() error, error) {
+	f, err := header.Open()
+	if err != nil {
+		return nil, nil, fmt.Errorf("读取压缩包失败")
+	}
+	reader, err := zip.NewReader(f, header.Size)
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("压缩包格式错误，请上传 zip 文件")
+	}
+
+	files := make(map[string]*zip.File)
+	for _, f := range reader.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		// 只使用文件名部分，同时避免压缩包中的路径穿越
+		files[path.Base(f.Name)] = f
+	}
+	return files, f.Close, nil
+}
+
+// extractImportRefImage 将压缩包中的参考图保存到上传目录，返回相对路径
+// 超过 maxImportRefImageSize 的文件拒绝解压（按实际读取的字节数判断，不信任压缩包中记录的大小）
+func extractImportRefImage(f *zip.File) (string, error) {
+	if f.UncompressedSize64 > uint64(maxImportRefImageSize) {
+		return "", fmt.Errorf("文件超过 %d MB", maxImportRefImageSize>>20)
+	}
+	src, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	data, err := readLimited(src, maxImportRefImageSize)
+	if err != nil {
+		return "", err
+	}
+
+	return storeFile(models.StoredFileKindUpload, data, path.Ext(f.Name))
+}
+
+// readFormFile 读取上传文件的全部内容，超过 limit 字节时返回错误
+func readFormFile(header *multipart.FileHeader, limit int64) ([]byte, error) {
+	f, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readLimited(f, limit)
+}
+
+// readLimited 最多读取 limit 字节，内容更长时返回错误
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("文件超过 %d MB", limit>>20)
+	}
+	return data, nil
+}
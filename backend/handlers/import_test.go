@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"sigma/config"
+	"sigma/models"
+)
+
+// newImportRequest 构造包含清单和参考图压缩包的导入请求
+func newImportRequest(t *testing.T, manifestName, manifest string, archive map[string]string) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	part, _ := writer.CreateFormFile("manifest", manifestName)
+	part.Write([]byte(manifest))
+
+	if archive != nil {
+		var zipBuf bytes.Buffer
+		zw := zip.NewWriter(&zipBuf)
+		for name, content := range archive {
+			f, _ := zw.Create(name)
+			f.Write([]byte(content))
+		}
+		zw.Close()
+		part, _ = writer.CreateFormFile("archive", "images.zip")
+		part.Write(zipBuf.Bytes())
+	}
+	writer.Close()
+
+	req, err := http.NewRequest("POST", "/import/jobs", &body)
+	if err != nil {
+		t.Fatalf("创建请求失败: %v", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func setupImportTest(t *testing.T) (*gin.Engine, func()) {
+	cleanup := setupTaskTestDB(t)
+	config.DB.AutoMigrate(&models.ImportJob{}, &models.ImportJobItem{}, &models.PromptTemplate{}, &models.StoredFile{})
+	if err := SeedPromptTemplates(); err != nil {
+		t.Fatalf("写入内置模板失败: %v", err)
+	}
+	config.UploadDir = t.TempDir()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/import/jobs", CreateImportJobHandler)
+	r.GET("/import/jobs/:id", GetImportJobHandler)
+	r.GET("/import/jobs/:id/manifest", ImportJobManifestHandler)
+	return r, cleanup
+}
+
+func TestCreateImportJobHandler_CSVManifest(t *testing.T) {
+	r, cleanup := setupImportTest(t)
+	defer cleanup()
+
+	manifest := "SKU,product_name,scene,ref_images,type\n" +
+		"A001,保温杯,办公桌,photos/a001.jpg,product_scene\n" +
+		"A002,马克杯,,a002.jpg;a001.jpg,white_background\n" +
+		"A003,台灯,,missing.jpg,white_background\n" +
+		"A004,键盘,,,create\n"
+	req := newImportRequest(t, "catalog.csv", manifest, map[string]string{
+		"photos/a001.jpg": "jpg-a001",
+		"a002.jpg":        "jpg-a002",
+	})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("期望状态码 200，实际为 %d: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		JobID   string                  `json:"job_id"`
+		Total   int                     `json:"total"`
+		Skipped []models.ImportRowError `json:"skipped"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if response.Total != 2 {
+		t.Errorf("期望创建 2 个任务，实际为 %d", response.Total)
+	}
+	if len(response.Skipped) != 2 || response.Skipped[0].SKU != "A003" || response.Skipped[1].SKU != "A004" {
+		t.Errorf("跳过的行不正确: %+v", response.Skipped)
+	}
+
+	var tasks []models.GenerationTask
+	config.DB.Where("import_job_id = ?", response.JobID).Order("id asc").Find(&tasks)
+	if len(tasks) != 2 {
+		t.Fatalf("期望 2 个导入任务，实际为 %d", len(tasks))
+	}
+	if tasks[0].Status != models.TaskStatusQueued || tasks[0].Type != models.GenerationTypeProductScene {
+		t.Errorf("第一个任务不正确: %+v", tasks[0])
+	}
+	if !strings.Contains(tasks[0].Prompt, "保温杯") || !strings.Contains(tasks[0].Prompt, "办公桌") {
+		t.Errorf("提示词应填入商品名称和场景: %s", tasks[0].Prompt)
+	}
+	if tasks[0].TemplateID != "product_scene.default" {
+		t.Errorf("应记录使用的默认模板，实际为 %q", tasks[0].TemplateID)
+	}
+
+	// 同一参考图只解压一次，两个任务引用同一文件
+	var refs0, refs1 []string
+	json.Unmarshal([]byte(tasks[0].RefImages), &refs0)
+	json.Unmarshal([]byte(tasks[1].RefImages), &refs1)
+	if len(refs0) != 1 || len(refs1) != 2 || refs1[1] != refs0[0] {
+		t.Errorf("参考图不正确: %v / %v", refs0, refs1)
+	}
+	data, err := os.ReadFile(filepath.Join(config.UploadDir, filepath.Base(refs0[0])))
+	if err != nil || string(data) != "jpg-a001" {
+		t.Errorf("参考图应解压到上传目录: %v", err)
+	}
+}
+
+func TestImportJobProgressAndManifest(t *testing.T) {
+	r, cleanup := setupImportTest(t)
+	defer cleanup()
+
+	manifest := `[
+		{"sku": "B001", "product_name": "背包", "scene": "山间小路", "type": "product_scene", "ref_images": ["b001.jpg"]},
+		{"sku": "B002", "type": "create", "prompt": "{sku} 的海报"}
+	]`
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, newImportRequest(t, "catalog.json", manifest, map[string]string{"b001.jpg": "jpg-b001"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("期望状态码 200，实际为 %d: %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		JobID string `json:"job_id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+
+	var tasks []models.GenerationTask
+	config.DB.Where("import_job_id = ?", created.JobID).Order("id asc").Find(&tasks)
+	if len(tasks) != 2 || tasks[1].Prompt != "B002 的海报" {
+		t.Fatalf("导入任务不正确: %+v", tasks)
+	}
+	tasks[0].Status = models.TaskStatusCompleted
+	tasks[0].ImageURL = "output/b001.png"
+	config.DB.Save(&tasks[0])
+
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/import/jobs/"+created.JobID, nil)
+	r.ServeHTTP(w, req)
+	var progress models.ImportJobProgress
+	json.Unmarshal(w.Body.Bytes(), &progress)
+	if progress.Status != "processing" || progress.Completed != 1 || progress.Queued != 1 {
+		t.Errorf("导入进度不正确: %+v", progress)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/import/jobs/"+created.JobID+"/manifest?format=csv", nil)
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Header().Get("Content-Disposition"), "attachment") {
+		t.Fatalf("结果清单应以附件形式下载: %d %v", w.Code, w.Header())
+	}
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[1], "B001") || !strings.Contains(lines[1], "output/b001.png") {
+		t.Errorf("结果清单不正确: %q", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/import/jobs/not-exist", nil)
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("不存在的导入任务应返回 404，实际为 %d", w.Code)
+	}
+}
+
+func TestCreateImportJobHandler_RefImagesExtractedAfterChecks(t *testing.T) {
+	r, cleanup := setupImportTest(t)
+	defer cleanup()
+	config.DB.AutoMigrate(&models.CreditLedgerEntry{})
+
+	daily, maxSize := config.BudgetDailyLimit, maxImportRefImageSize
+	defer func() { config.BudgetDailyLimit, maxImportRefImageSize = daily, maxSize }()
+
+	manifest := "SKU,product_name,ref_images,type\n" +
+		"C001,水杯,c001.jpg,white_background\n" +
+		"C002,水壶,c002.jpg,white_background\n"
+	archive := map[string]string{"c001.jpg": "jpg-c001", "c002.jpg": strings.Repeat("x", 64)}
+
+	// 超出预算时拒绝导入，不解压任何参考图
+	config.BudgetDailyLimit = 1
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, newImportRequest(t, "catalog.csv", manifest, archive))
+	if w.Code != http.StatusPaymentRequired {
+		t.Fatalf("超出预算应返回 402，实际为 %d: %s", w.Code, w.Body.String())
+	}
+	if entries, _ := os.ReadDir(config.UploadDir); len(entries) != 0 {
+		t.Errorf("被拒绝的导入不应在上传目录留下文件，实际有 %d 个", len(entries))
+	}
+
+	// 超过大小上限的参考图跳过对应行
+	config.BudgetDailyLimit = 0
+	maxImportRefImageSize = 32
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, newImportRequest(t, "catalog.csv", manifest, archive))
+	var response struct {
+		Total   int                     `json:"total"`
+		Skipped []models.ImportRowError `json:"skipped"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if w.Code != http.StatusOK || response.Total != 1 || len(response.Skipped) != 1 || response.Skipped[0].SKU != "C002" {
+		t.Fatalf("超大参考图所在行应跳过: %d %s", w.Code, w.Body.String())
+	}
+	if entries, _ := os.ReadDir(config.UploadDir); len(entries) != 1 {
+		t.Errorf("只应解压未超限的参考图，实际有 %d 个文件", len(entries))
+	}
+}
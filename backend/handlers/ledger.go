@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"sigma/config"
+	"sigma/models"
+	"sigma/providers"
+	"sigma/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// sizeCreditMultiplier 图片尺寸对应的消耗系数：4K 图片计为 2 张，其他计为 1 张
+func sizeCreditMultiplier(imageSize string) float64 {
+	if imageSize == "4K" {
+		return 2
+	}
+	return 1
+}
+
+// knownPricing 最近一次查询余额得到的平台分组和单价
+type knownPricing struct {
+	Group   string
+	Pricing providers.Pricing
+}
+
+var (
+	pricingMu   sync.RWMutex
+	lastPricing = make(map[string]knownPricing) // 平台 -> 计价
+)
+
+// rememberPricing 记录余额查询结果中的计价，余额查询失败时台账沿用最近一次的单价
+func rememberPricing(result providers.TokenValidationResult) {
+	if !result.Valid || result.Platform == "" {
+		return
+	}
+	pricingMu.Lock()
+	lastPricing[result.Platform] = knownPricing{Group: result.Group, Pricing: result.Pricing}
+	pricingMu.Unlock()
+}
+
+// pricingFor 平台最近一次的计价，还没有成功查询过余额时 ok 为 false
+func pricingFor(platform string) (knownPricing, bool) {
+	pricingMu.RLock()
+	defer pricingMu.RUnlock()
+	pricing, ok := lastPricing[platform]
+	return pricing, ok
+}
+
+// resolvePricing 台账使用的计价：计价未知时查询一次余额（不论是否开启余额快照），查询失败时 ok 为 false
+func resolvePricing(provider providers.Provider, apiKey string) (knownPricing, bool) {
+	platform := string(provider.Platform())
+	if pricing, ok := pricingFor(platform); ok || apiKey == "" {
+		return pricing, ok
+	}
+	rememberPricing(provider.CheckBalance(apiKey))
+	return pricingFor(platform)
+}
+
+// balanceSnapshot 查询当前剩余张数（未取整），未开启余额快照或查询失败时返回 nil
+func balanceSnapshot(provider providers.Provider, apiKey string) *float64 {
+	if !config.LedgerBalanceSnapshots || provider == nil || apiKey == "" {
+		return nil
+	}
+	result := provider.CheckBalance(apiKey)
+	if !result.Valid {
+		return nil
+	}
+	rememberPricing(result)
+	remain := result.Remain
+	if images := result.Pricing.Images(result.RemainQuota); images > 0 {
+		remain = images
+	}
+	return &remain
+}
+
+// recordLedgerEntry 为成功生成的图片写入消耗台账，并查询生成后的余额
+func recordLedgerEntry(provider providers.Provider, apiKey string, task *models.GenerationTask, history models.GenerationHistory, before *float64) {
+	if provider == nil {
+		return
+	}
+	platform := string(provider.Platform())
+	after := balanceSnapshot(provider, apiKey)
+	pricing, priced := resolvePricing(provider, apiKey)
+
+	multiplier := sizeCreditMultiplier(history.ImageSize)
+	entry := models.CreditLedgerEntry{
+		TaskID:         task.TaskID,
+		HistoryID:      history.ID,
+		Type:           history.Type,
+		Platform:       platform,
+		PriceGroup:     pricing.Group,
+		ImageSize:      history.ImageSize,
+		SizeMultiplier: multiplier,
+		Attempts:       task.Attempts,
+		Credits:        multiplier,
+		BalanceBefore:  before,
+		BalanceAfter:   after,
+	}
+	// 计价未知时单价和费用留空，不记为 0 元
+	if priced {
+		unitPrice := pricing.Pricing.UnitPrice
+		cost := multiplier * unitPrice
+		entry.UnitPrice, entry.Cost = &unitPrice, &cost
+	}
+	if err := config.DB.Create(&entry).Error; err != nil {
+		utils.LogAPI("任务 %s 写入消耗台账失败: %v", task.TaskID, err)
+	}
+}
+
+// ledgerBucket 一个分组（或全部）的消耗
+type ledgerBucket struct {
+	Key     string  `json:"key,omitempty"`
+	Images  int64   `json:"images"`
+	Credits float64 `json:"credits"` // 估算消耗的张数
+	Cost    float64 `json:"cost"`    // 估算费用（元），不含计价未知的记录
+	// 计价未知（单价为空）的记录数
+	UnpricedImages int64 `json:"unpriced_images"`
+	// 同时有生成前后余额快照的记录：余额变化与估算消耗对比
+	SnapshotImages  int64   `json:"snapshot_images"`
+	SnapshotCredits float64 `json:"snapshot_credits"` // 这些记录的估算消耗
+	BalanceDelta    float64 `json:"balance_delta"`    // 这些记录的生成前后余额差之和
+}
+
+// add 计入一条台账记录
+func (b *ledgerBucket) add(entry *models.CreditLedgerEntry) {
+	b.Images++
+	b.Credits += entry.Credits
+	if entry.Cost != nil {
+		b.Cost += *entry.Cost
+	} else {
+		b.UnpricedImages++
+	}
+	if entry.BalanceBefore != nil && entry.BalanceAfter != nil {
+		b.SnapshotImages++
+		b.SnapshotCredits += entry.Credits
+		b.BalanceDelta += *entry.BalanceBefore - *entry.BalanceAfter
+	}
+}
+
+// finish 保留 4 位小数
+func (b *ledgerBucket) finish() {
+	round := func(v float64) float64 { return math.Round(v*10000) / 10000 }
+	b.Credits = round(b.Credits)
+	b.Cost = round(b.Cost)
+	b.SnapshotCredits = round(b.SnapshotCredits)
+	b.BalanceDelta = round(b.BalanceDelta)
+}
+
+// LedgerSummary 消耗汇总
+type LedgerSummary struct {
+	From    *time.Time     `json:"from"`
+	To      *time.Time     `json:"to"`
+	GroupBy string         `json:"group_by"`
+	Totals  ledgerBucket   `json:"totals"`
+	Groups  []ledgerBucket `json:"groups"`
+}
+
+// BuildLedgerSummary 按日期、月份、生成类型或图片尺寸汇总消耗台账，时间范围按记录时间 [from, to) 筛选
+func BuildLedgerSummary(from, to *time.Time, groupBy string) (*LedgerSummary, error) {
+	groupKey, ok := statsGroupKeys[groupBy]
+	if !ok {
+		return nil, fmt.Errorf("不支持的分组方式: %s", groupBy)
+	}
+
+	var entries []models.CreditLedgerEntry
+	query := ledgerQuery(from, to)
+	if err := query.Find(&entries).Error; err != nil {
+		return nil, err
+	}
+
+	summary := &LedgerSummary{From: from, To: to, GroupBy: groupBy}
+	groups := make(map[string]*ledgerBucket)
+	for i := range entries {
+		entry := &entries[i]
+		summary.Totals.add(entry)
+		key := groupKey(entry.CreatedAt, entry.Type, entry.ImageSize)
+		if groups[key] == nil {
+			groups[key] = &ledgerBucket{Key: key}
+		}
+		groups[key].add(entry)
+	}
+
+	summary.Totals.finish()
+	summary.Groups = make([]ledgerBucket, 0, len(groups))
+	for _, b := range groups {
+		b.finish()
+		summary.Groups = append(summary.Groups, *b)
+	}
+	// 日期按时间顺序，其他维度按费用从高到低
+	sort.Slice(summary.Groups, func(i, j int) bool {
+		a, b := summary.Groups[i], summary.Groups[j]
+		if groupBy != "day" && groupBy != "month" && a.Credits != b.Credits {
+			return a.Credits > b.Credits
+		}
+		return a.Key < b.Key
+	})
+	return summary, nil
+}
+
+// ledgerQuery 按记录时间筛选台账
+func ledgerQuery(from, to *time.Time) *gorm.DB {
+	query := config.DB.Model(&models.CreditLedgerEntry{})
+	if from != nil {
+		query = query.Where("created_at >= ?", *from)
+	}
+	if to != nil {
+		query = query.Where("created_at < ?", *to)
+	}
+	return query
+}
+
+// LedgerSummaryHandler 消耗汇总：按日期、月份、生成类型或图片尺寸分组的张数和估算费用
+// GET /ledger/summary?from=2025-01-01&to=2025-01-31&group_by=day
+func LedgerSummaryHandler(c *gin.Context) {
+	from, to, err := parseStatsRange(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	groupBy, err := parseStatsGroupBy(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	summary, err := BuildLedgerSummary(from, to, groupBy)
+	if err != nil {
+		utils.LogAPI("生成消耗汇总失败: %v", err)
+		c.JSON(500, gin.H{"error": "生成消耗汇总失败"})
+		return
+	}
+	c.JSON(200, summary)
+}
+
+// LedgerHandler 消耗台账明细，按时间倒序分页
+// GET /ledger?from=2025-01-01&to=2025-01-31&type=create&page=1&page_size=100
+func LedgerHandler(c *gin.Context) {
+	from, to, err := parseStatsRange(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	query := ledgerQuery(from, to)
+	if generationType := c.Query("type"); generationType != "" {
+		if !isKnownGenerationType(generationType) {
+			c.JSON(400, gin.H{"error": unknownGenerationTypeError(generationType).Error()})
+			return
+		}
+		query = query.Where("type = ?", generationType)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(500, gin.H{"error": "获取消耗台账失败"})
+		return
+	}
+	page, pageSize := parsePageParams(c)
+	var entries []models.CreditLedgerEntry
+	if err := query.Order("created_at DESC, id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&entries).Error; err != nil {
+		c.JSON(500, gin.H{"error": "获取消耗台账失败"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+		"items":     entries,
+	})
+}
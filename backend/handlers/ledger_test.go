@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"sigma/config"
+	"sigma/models"
+	"sigma/providers"
+)
+
+// balanceProvider 按顺序返回预设剩余额度的测试供应商（限时特价分组，0.099 元/张）
+type balanceProvider struct {
+	fakeProvider
+	quotas  []float64
+	checks  int
+	invalid bool // 余额查询失败
+}
+
+func (p *balanceProvider) CheckBalance(apiKey string) providers.TokenValidationResult {
+	p.checks++
+	if p.invalid {
+		return providers.TokenValidationResult{Valid: false}
+	}
+	quota := p.quotas[0]
+	if len(p.quotas) > 1 {
+		p.quotas = p.quotas[1:]
+	}
+	return providers.TokenValidationResult{
+		Valid:       true,
+		Platform:    "fake",
+		Group:       "限时特价",
+		RemainQuota: quota,
+		Pricing:     providers.Pricing{QuotaPerUnit: 1000000, UnitPrice: 0.099},
+	}
+}
+
+func setupLedgerTest(t *testing.T) *gin.Engine {
+	var err error
+	config.DB, err = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("无法创建测试数据库: %v", err)
+	}
+	config.DB.AutoMigrate(&models.CreditLedgerEntry{})
+	snapshots := config.LedgerBalanceSnapshots
+	t.Cleanup(func() {
+		config.LedgerBalanceSnapshots = snapshots
+		sqlDB, _ := config.DB.DB()
+		sqlDB.Close()
+	})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/ledger", LedgerHandler)
+	r.GET("/ledger/summary", LedgerSummaryHandler)
+	return r
+}
+
+func approxEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestRecordLedgerEntry_SizeMultiplierAndSnapshots(t *testing.T) {
+	r := setupLedgerTest(t)
+	// 生成前 10 张，生成 4K 图片后 8 张
+	provider := &balanceProvider{quotas: []float64{990000, 792000}}
+
+	config.LedgerBalanceSnapshots = true
+	task := &models.GenerationTask{TaskID: "t1", Attempts: 2}
+	before := balanceSnapshot(provider, "key")
+	recordLedgerEntry(provider, "key", task, models.GenerationHistory{ID: 1, Type: models.GenerationTypeCreate, ImageSize: "4K"}, before)
+
+	var entry models.CreditLedgerEntry
+	config.DB.First(&entry)
+	if entry.Credits != 2 || entry.SizeMultiplier != 2 || entry.Attempts != 2 || entry.PriceGroup != "限时特价" {
+		t.Errorf("台账记录不正确: %+v", entry)
+	}
+	if entry.UnitPrice == nil || entry.Cost == nil || !approxEqual(*entry.UnitPrice, 0.099) || !approxEqual(*entry.Cost, 0.198) {
+		t.Errorf("费用应按分组单价估算: %v %v", entry.UnitPrice, entry.Cost)
+	}
+	if entry.BalanceBefore == nil || entry.BalanceAfter == nil || !approxEqual(*entry.BalanceBefore-*entry.BalanceAfter, 2) {
+		t.Errorf("应记录生成前后的余额: %v %v", entry.BalanceBefore, entry.BalanceAfter)
+	}
+
+	// 关闭余额快照后沿用最近一次查询到的单价
+	config.LedgerBalanceSnapshots = false
+	recordLedgerEntry(provider, "key", &models.GenerationTask{TaskID: "t2"},
+		models.GenerationHistory{ID: 2, Type: models.GenerationTypeWhiteBackground, ImageSize: "2K"}, balanceSnapshot(provider, "key"))
+
+	w := doJSON(r, "GET", "/ledger/summary?group_by=size", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("期望状态码 200，实际为 %d: %s", w.Code, w.Body.String())
+	}
+	var summary LedgerSummary
+	json.Unmarshal(w.Body.Bytes(), &summary)
+	totals := summary.Totals
+	if totals.Images != 2 || totals.Credits != 3 || !approxEqual(totals.Cost, 0.297) {
+		t.Errorf("汇总不正确: %+v", totals)
+	}
+	if totals.SnapshotImages != 1 || totals.SnapshotCredits != 2 || totals.BalanceDelta != 2 {
+		t.Errorf("只有同时有前后快照的记录计入余额对比: %+v", totals)
+	}
+	if len(summary.Groups) != 2 || summary.Groups[0].Key != "4K" {
+		t.Errorf("按尺寸分组应按消耗降序: %+v", summary.Groups)
+	}
+
+	w = doJSON(r, "GET", "/ledger?page_size=1", "")
+	var page struct {
+		Total int64                      `json:"total"`
+		Items []models.CreditLedgerEntry `json:"items"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &page)
+	if page.Total != 2 || len(page.Items) != 1 || page.Items[0].TaskID != "t2" {
+		t.Errorf("台账明细应按时间倒序分页: %+v", page)
+	}
+}
+
+func TestRecordLedgerEntry_UnknownPricing(t *testing.T) {
+	r := setupLedgerTest(t)
+	forgetPricing := func() {
+		pricingMu.Lock()
+		delete(lastPricing, "fake")
+		pricingMu.Unlock()
+	}
+	forgetPricing()
+	t.Cleanup(forgetPricing)
+	config.LedgerBalanceSnapshots = false
+	history := models.GenerationHistory{ID: 1, Type: models.GenerationTypeCreate, ImageSize: "2K"}
+
+	// 余额查询失败时计价未知，单价和费用为空
+	failing := &balanceProvider{invalid: true}
+	recordLedgerEntry(failing, "key", &models.GenerationTask{TaskID: "t1"}, history, nil)
+	var entry models.CreditLedgerEntry
+	config.DB.Where("task_id = ?", "t1").First(&entry)
+	if entry.UnitPrice != nil || entry.Cost != nil || entry.Credits != 1 {
+		t.Errorf("计价未知时单价和费用应为空: %+v", entry)
+	}
+
+	// 未开启余额快照时查询一次余额确定计价，之后沿用
+	provider := &balanceProvider{quotas: []float64{990000}}
+	recordLedgerEntry(provider, "key", &models.GenerationTask{TaskID: "t2"}, history, nil)
+	recordLedgerEntry(provider, "key", &models.GenerationTask{TaskID: "t3"}, history, nil)
+	if provider.checks != 1 {
+		t.Errorf("计价已知后不应再查询余额，实际查询 %d 次", provider.checks)
+	}
+	var priced models.CreditLedgerEntry
+	config.DB.Where("task_id = ?", "t3").First(&priced)
+	if priced.UnitPrice == nil || !approxEqual(*priced.UnitPrice, 0.099) || priced.PriceGroup != "限时特价" || priced.BalanceAfter != nil {
+		t.Errorf("应按查询到的分组计价且不记录余额快照: %+v", priced)
+	}
+
+	w := doJSON(r, "GET", "/ledger/summary?group_by=type", "")
+	var summary LedgerSummary
+	json.Unmarshal(w.Body.Bytes(), &summary)
+	if totals := summary.Totals; totals.Images != 3 || totals.UnpricedImages != 1 || !approxEqual(totals.Cost, 0.198) {
+		t.Errorf("费用汇总不应包含计价未知的记录: %+v", totals)
+	}
+}
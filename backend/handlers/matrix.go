@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"sigma/config"
+	"sigma/models"
+	"sigma/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// MatrixGenerateHandler 提示词矩阵批量生成
+// POST /generate/matrix
+// 表单字段：
+//   - prompt: 提示词模板，占位符格式为 {name}
+//   - variables: JSON 对象，占位符名称 → 取值列表，如 {"scene": ["海滩", "雪山"]}
+//   - aspectRatio / imageSize: 可重复提交多个取值，参与组合
+//   - type / images / stream: 同 POST /generate
+//
+// 所有组合展开为同一批次（共享一个 BatchID）的子任务，每条历史记录保存对应的组合
+func MatrixGenerateHandler(c *gin.Context) {
+	if config.GetAPIToken() == "" {
+		c.JSON(401, gin.H{"error": "请先配置 API Key"})
+		return
+	}
+
+	template := c.PostForm("prompt")
+	if template == "" {
+		c.JSON(400, gin.H{"error": "提示词模板不能为空"})
+		return
+	}
+
+	variables := map[string][]string{}
+	if raw := c.PostForm("variables"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &variables); err != nil {
+			c.JSON(400, gin.H{"error": "variables 格式错误，应为 JSON 对象"})
+			return
+		}
+	}
+
+	generationType := c.PostForm("type")
+	if generationType == "" {
+		generationType = models.GenerationTypeCreate
+	}
+	typeDef, ok := lookupGenerationType(generationType)
+	if !ok {
+		c.JSON(400, gin.H{"error": unknownGenerationTypeError(generationType).Error()})
+		return
+	}
+	if err := checkRefImageSlots(typeDef, uploadedRefImageCount(c)); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 未提交比例或尺寸时使用生成类型的默认值
+	aspectRatios := c.PostFormArray("aspectRatio")
+	for i, ratio := range aspectRatios {
+		aspectRatios[i] = normalizeAspectRatio(ratio)
+	}
+	if len(aspectRatios) == 0 {
+		aspectRatios = []string{typeDefaultAspectRatio(typeDef, "")}
+	}
+	imageSizes := c.PostFormArray("imageSize")
+	for i, size := range imageSizes {
+		imageSizes[i] = normalizeImageSize(size)
+	}
+	if len(imageSizes) == 0 {
+		imageSizes = []string{typeDefaultImageSize(typeDef, "")}
+	}
+
+	items, err := expandPromptMatrix(template, variables, aspectRatios, imageSizes, maxImageCount())
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	budgetItems := make([]budgetItem, len(items))
+	for i, item := range items {
+		budgetItems[i] = budgetItem{Type: generationType, ImageSize: item.ImageSize}
+	}
+	if err := checkBudget(budgetItems); err != nil {
+		respondBudgetError(c, err)
+		return
+	}
+
+	savedRefImages, err := saveUploadedRefImages(c)
+	if err != nil {
+		utils.LogAPI("保存参考图失败: %v", err)
+		c.JSON(500, gin.H{"error": "保存参考图失败"})
+		return
+	}
+	refImagesJSON, _ := json.Marshal(savedRefImages)
+
+	parent := models.GenerationTask{
+		TaskID:         uuid.New().String(),
+		Type:           generationType,
+		Prompt:         template,
+		OriginalPrompt: template,
+		RefImages:      string(refImagesJSON),
+		StartedAt:      time.Now(),
+		AspectRatio:    items[0].AspectRatio,
+		ImageSize:      items[0].ImageSize,
+	}
+	// 保存参考图后在预算锁内再次检查并写入任务
+	releaseBudget, err := reserveBudget(budgetItems)
+	if err != nil {
+		respondBudgetError(c, err)
+		return
+	}
+	defer releaseBudget()
+	children, err := createBatchTasks(&parent, items)
+	releaseBudget()
+	if err != nil {
+		utils.LogAPI("创建矩阵批次失败: %v", err)
+		c.JSON(500, gin.H{"error": "创建任务失败"})
+		return
+	}
+	utils.LogAPI("提示词矩阵: 模板=%q, 组合数=%d, batch_id=%s", template, len(items), parent.BatchID)
+
+	generateMultipleImages(c, &parent, children, savedRefImages)
+}
+
+// expandPromptMatrix 将模板、占位符取值、比例和尺寸展开为所有组合
+// 组合顺序：占位符按名称排序后依次展开，最后是比例和尺寸（尺寸变化最快）
+func expandPromptMatrix(template string, variables map[string][]string, aspectRatios, imageSizes []string, limit int) ([]batchItem, error) {
+	if len(aspectRatios) == 0 {
+		aspectRatios = []string{normalizeAspectRatio("")}
+	}
+	if len(imageSizes) == 0 {
+		imageSizes = []string{normalizeImageSize("")}
+	}
+
+	placeholders := utils.TemplatePlaceholders(template)
+	used := make(map[string]bool, len(placeholders))
+	for _, name := range placeholders {
+		used[name] = true
+		if len(variables[name]) == 0 {
+			return nil, fmt.Errorf("占位符 {%s} 缺少取值", name)
+		}
+	}
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		if !used[name] {
+			return nil, fmt.Errorf("变量 %s 未在模板中使用", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	total := len(aspectRatios) * len(imageSizes)
+	for _, name := range names {
+		total *= len(variables[name])
+		if total > limit {
+			break
+		}
+	}
+	if total > limit {
+		return nil, fmt.Errorf("组合数量超过上限 %d", limit)
+	}
+
+	// 按"里程表"方式枚举组合：最后一位变化最快
+	dims := make([][]string, 0, len(names)+2)
+	for _, name := range names {
+		dims = append(dims, variables[name])
+	}
+	dims = append(dims, aspectRatios, imageSizes)
+	counters := make([]int, len(dims))
+
+	items := make([]batchItem, 0, total)
+	for n := 0; n < total; n++ {
+		values := make(map[string]string, len(names))
+		for i, name := range names {
+			values[name] = dims[i][counters[i]]
+		}
+		prompt, err := utils.RenderPrompt(template, values)
+		if err != nil {
+			return nil, err
+		}
+		variation := models.PromptVariation{
+			Template:    template,
+			Values:      values,
+			AspectRatio: dims[len(names)][counters[len(names)]],
+			ImageSize:   dims[len(names)+1][counters[len(names)+1]],
+		}
+		variationJSON, _ := json.Marshal(variation)
+		items = append(items, batchItem{
+			Prompt:      prompt,
+			AspectRatio: variation.AspectRatio,
+			ImageSize:   variation.ImageSize,
+			Variation:   string(variationJSON),
+		})
+
+		for i := len(counters) - 1; i >= 0; i-- {
+			counters[i]++
+			if counters[i] < len(dims[i]) {
+				break
+			}
+			counters[i] = 0
+		}
+	}
+	return items, nil
+}
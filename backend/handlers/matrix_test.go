@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"sigma/config"
+	"sigma/models"
+)
+
+func TestExpandPromptMatrix_CartesianProduct(t *testing.T) {
+	items, err := expandPromptMatrix("{product} 在 {scene}",
+		map[string][]string{"scene": {"海滩", "雪山"}, "product": {"杯子"}},
+		[]string{"1:1", "16:9"}, nil, 50)
+	if err != nil {
+		t.Fatalf("展开失败: %v", err)
+	}
+	if len(items) != 4 {
+		t.Fatalf("期望 4 个组合，实际为 %d", len(items))
+	}
+
+	if items[0].Prompt != "杯子 在 海滩" || items[0].AspectRatio != "1:1" || items[0].ImageSize != "2K" {
+		t.Errorf("第 1 个组合不正确: %+v", items[0])
+	}
+	if items[1].Prompt != "杯子 在 海滩" || items[1].AspectRatio != "16:9" {
+		t.Errorf("比例应比占位符变化更快: %+v", items[1])
+	}
+	if items[3].Prompt != "杯子 在 雪山" || items[3].AspectRatio != "16:9" {
+		t.Errorf("最后一个组合不正确: %+v", items[3])
+	}
+
+	var variation models.PromptVariation
+	if err := json.Unmarshal([]byte(items[3].Variation), &variation); err != nil {
+		t.Fatalf("组合信息无法解析: %v", err)
+	}
+	if variation.Values["scene"] != "雪山" || variation.AspectRatio != "16:9" || variation.Template != "{product} 在 {scene}" {
+		t.Errorf("组合信息不正确: %+v", variation)
+	}
+}
+
+func TestExpandPromptMatrix_Validation(t *testing.T) {
+	cases := []struct {
+		name      string
+		template  string
+		variables map[string][]string
+		limit     int
+	}{
+		{"缺少取值", "{scene}", map[string][]string{}, 50},
+		{"空取值列表", "{scene}", map[string][]string{"scene": {}}, 50},
+		{"未使用的变量", "固定提示词", map[string][]string{"scene": {"海滩"}}, 50},
+		{"超过上限", "{scene}", map[string][]string{"scene": {"a", "b", "c"}}, 2},
+	}
+	for _, tc := range cases {
+		if _, err := expandPromptMatrix(tc.template, tc.variables, nil, nil, tc.limit); err == nil {
+			t.Errorf("%s: 期望返回错误", tc.name)
+		}
+	}
+}
+
+func TestMatrixGenerateHandler_CreatesSingleBatch(t *testing.T) {
+	cleanup := setupGenerateTestDB(t)
+	defer cleanup()
+
+	gin.SetMode(gin.TestMode)
+	config.UploadDir = t.TempDir()
+	originalToken := config.GetAPIToken()
+	config.SetAPIToken("test-api-key")
+	defer config.SetAPIToken(originalToken)
+
+	r := gin.New()
+	r.POST("/generate/matrix", MatrixGenerateHandler)
+
+	form := url.Values{}
+	form.Set("prompt", "{product} 在 {scene}")
+	form.Set("variables", `{"product":["杯子"],"scene":["海滩","雪山","森林"]}`)
+	form.Add("imageSize", "2K")
+	form.Add("imageSize", "4K")
+	req, _ := http.NewRequest("POST", "/generate/matrix", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("期望状态码 200，实际为 %d: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		TaskID  string `json:"task_id"`
+		BatchID string `json:"batch_id"`
+		Count   int    `json:"count"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if response.Count != 6 {
+		t.Errorf("期望 6 个组合，实际为 %d", response.Count)
+	}
+
+	var children []models.GenerationTask
+	config.DB.Where("parent_task_id = ?", response.TaskID).Find(&children)
+	if len(children) != 6 {
+		t.Fatalf("期望 6 个子任务，实际为 %d", len(children))
+	}
+	for _, child := range children {
+		if child.BatchID != response.BatchID || child.Variation == "" {
+			t.Errorf("子任务应共享 batch_id 并记录组合: %+v", child)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"sigma/config"
+	"sigma/models"
+	"sigma/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SeedPromptTemplates 写入内置提示词模板，已存在的模板（可能被用户修改过）保持不变
+func SeedPromptTemplates() error {
+	for _, builtin := range models.BuiltinPromptTemplates() {
+		builtin.BuiltIn = true
+		var existing models.PromptTemplate
+		err := config.DB.Where("template_id = ?", builtin.TemplateID).
+			Attrs(builtin).
+			FirstOrCreate(&existing).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// promptTemplateRequest 创建或修改模板的请求体
+type promptTemplateRequest struct {
+	TemplateID string `json:"template_id"`
+	Type       string `json:"type"`
+	Name       string `json:"name"`
+	Template   string `json:"template"`
+	IsDefault  *bool  `json:"is_default"`
+}
+
+// ListPromptTemplatesHandler 获取提示词模板列表
+// GET /prompt-templates?type=product_scene
+func ListPromptTemplatesHandler(c *gin.Context) {
+	query := config.DB.Model(&models.PromptTemplate{})
+	if generationType := c.Query("type"); generationType != "" {
+		query = query.Where("type = ?", generationType)
+	}
+
+	var templates []models.PromptTemplate
+	if err := query.Order("type asc, id asc").Find(&templates).Error; err != nil {
+		c.JSON(500, gin.H{"error": "获取提示词模板失败"})
+		return
+	}
+	for i := range templates {
+		templates[i].Placeholders = utils.TemplatePlaceholders(templates[i].Template)
+	}
+	c.JSON(200, templates)
+}
+
+// GetPromptTemplateHandler 获取单个提示词模板
+// GET /prompt-templates/:id
+func GetPromptTemplateHandler(c *gin.Context) {
+	tpl, ok := loadPromptTemplate(c)
+	if !ok {
+		return
+	}
+	c.JSON(200, tpl)
+}
+
+// CreatePromptTemplateHandler 新建提示词模板
+// POST /prompt-templates
+func CreatePromptTemplateHandler(c *gin.Context) {
+	var req promptTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "请求格式错误"})
+		return
+	}
+	if !isKnownGenerationType(req.Type) {
+		c.JSON(400, gin.H{"error": unknownGenerationTypeError(req.Type).Error()})
+		return
+	}
+	if strings.TrimSpace(req.Template) == "" {
+		c.JSON(400, gin.H{"error": "模板内容不能为空"})
+		return
+	}
+	if req.TemplateID == "" {
+		req.TemplateID = fmt.Sprintf("%s.%s", req.Type, uuid.New().String()[:8])
+	}
+
+	tpl := models.PromptTemplate{
+		TemplateID: req.TemplateID,
+		Type:       req.Type,
+		Name:       req.Name,
+		Template:   req.Template,
+		IsDefault:  req.IsDefault != nil && *req.IsDefault,
+	}
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		tx.Model(&models.PromptTemplate{}).Where("template_id = ?", tpl.TemplateID).Count(&count)
+		if count > 0 {
+			return errPromptTemplateExists
+		}
+		if tpl.IsDefault {
+			if err := clearDefaultTemplate(tx, tpl.Type); err != nil {
+				return err
+			}
+		}
+		return tx.Create(&tpl).Error
+	})
+	if errors.Is(err, errPromptTemplateExists) {
+		c.JSON(409, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "保存提示词模板失败"})
+		return
+	}
+
+	tpl.Placeholders = utils.TemplatePlaceholders(tpl.Template)
+	c.JSON(200, tpl)
+}
+
+// UpdatePromptTemplateHandler 修改提示词模板（名称、内容、是否默认）
+// PUT /prompt-templates/:id
+func UpdatePromptTemplateHandler(c *gin.Context) {
+	tpl, ok := loadPromptTemplate(c)
+	if !ok {
+		return
+	}
+
+	var req promptTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "请求格式错误"})
+		return
+	}
+	if req.Name != "" {
+		tpl.Name = req.Name
+	}
+	if req.Template != "" {
+		if strings.TrimSpace(req.Template) == "" {
+			c.JSON(400, gin.H{"error": "模板内容不能为空"})
+			return
+		}
+		tpl.Template = req.Template
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if req.IsDefault != nil {
+			if *req.IsDefault && !tpl.IsDefault {
+				if err := clearDefaultTemplate(tx, tpl.Type); err != nil {
+					return err
+				}
+			}
+			tpl.IsDefault = *req.IsDefault
+		}
+		if err := tx.Save(tpl).Error; err != nil {
+			return err
+		}
+		return ensureDefaultTemplate(tx, tpl.Type)
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "保存提示词模板失败"})
+		return
+	}
+
+	config.DB.First(tpl, tpl.ID)
+	tpl.Placeholders = utils.TemplatePlaceholders(tpl.Template)
+	c.JSON(200, tpl)
+}
+
+// DeletePromptTemplateHandler 删除自定义提示词模板（内置模板不能删除）
+// DELETE /prompt-templates/:id
+func DeletePromptTemplateHandler(c *gin.Context) {
+	tpl, ok := loadPromptTemplate(c)
+	if !ok {
+		return
+	}
+	if tpl.BuiltIn {
+		c.JSON(409, gin.H{"error": "内置模板不能删除，可以修改或恢复默认"})
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(tpl).Error; err != nil {
+			return err
+		}
+		return ensureDefaultTemplate(tx, tpl.Type)
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "删除提示词模板失败"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "删除成功"})
+}
+
+// ResetPromptTemplateHandler 将内置模板恢复为默认内容
+// POST /prompt-templates/:id/reset
+func ResetPromptTemplateHandler(c *gin.Context) {
+	tpl, ok := loadPromptTemplate(c)
+	if !ok {
+		return
+	}
+
+	for _, builtin := range models.BuiltinPromptTemplates() {
+		if builtin.TemplateID != tpl.TemplateID {
+			continue
+		}
+		tpl.Name = builtin.Name
+		tpl.Template = builtin.Template
+		if err := config.DB.Save(tpl).Error; err != nil {
+			c.JSON(500, gin.H{"error": "保存提示词模板失败"})
+			return
+		}
+		tpl.Placeholders = utils.TemplatePlaceholders(tpl.Template)
+		c.JSON(200, tpl)
+		return
+	}
+	c.JSON(409, gin.H{"error": "只有内置模板可以恢复默认"})
+}
+
+var errPromptTemplateExists = errors.New("模板 ID 已存在")
+
+// loadPromptTemplate 按路径参数读取模板，不存在时直接返回 404
+func loadPromptTemplate(c *gin.Context) (*models.PromptTemplate, bool) {
+	var tpl models.PromptTemplate
+	if err := config.DB.Where("template_id = ?", c.Param("id")).First(&tpl).Error; err != nil {
+		c.JSON(404, gin.H{"error": "提示词模板不存在"})
+		return nil, false
+	}
+	tpl.Placeholders = utils.TemplatePlaceholders(tpl.Template)
+	return &tpl, true
+}
+
+// clearDefaultTemplate 取消某个生成类型的默认模板
+func clearDefaultTemplate(tx *gorm.DB, generationType string) error {
+	return tx.Model(&models.PromptTemplate{}).
+		Where("type = ? AND is_default = ?", generationType, true).
+		Update("is_default", false).Error
+}
+
+// ensureDefaultTemplate 生成类型没有默认模板时，将最早的内置模板设为默认
+func ensureDefaultTemplate(tx *gorm.DB, generationType string) error {
+	var count int64
+	tx.Model(&models.PromptTemplate{}).Where("type = ? AND is_default = ?", generationType, true).Count(&count)
+	if count > 0 {
+		return nil
+	}
+	var builtin models.PromptTemplate
+	if err := tx.Where("type = ? AND built_in = ?", generationType, true).Order("id asc").First(&builtin).Error; err != nil {
+		return nil
+	}
+	return tx.Model(&builtin).Update("is_default", true).Error
+}
+
+// resolvePromptTemplate 查找模板：指定 templateID 时按 ID 查找（类型必须一致），否则使用该类型的默认模板
+// 该类型没有默认模板时返回 nil
+func resolvePromptTemplate(templateID, generationType string) (*models.PromptTemplate, error) {
+	var tpl models.PromptTemplate
+	if templateID != "" {
+		if err := config.DB.Where("template_id = ?", templateID).First(&tpl).Error; err != nil {
+			return nil, fmt.Errorf("提示词模板 %s 不存在", templateID)
+		}
+		if tpl.Type != generationType {
+			return nil, fmt.Errorf("提示词模板 %s 不适用于生成类型 %s", templateID, generationType)
+		}
+		return &tpl, nil
+	}
+	if err := config.DB.Where("type = ? AND is_default = ?", generationType, true).First(&tpl).Error; err != nil {
+		return nil, nil
+	}
+	return &tpl, nil
+}
+
+// renderedPrompt 由服务端模板渲染得到的提示词
+type renderedPrompt struct {
+	Prompt     string
+	TemplateID string
+	Template   string
+}
+
+// resolveRequestPrompt 根据生成请求中的结构化字段渲染提示词
+// 表单字段：template_id（可选，默认使用该类型的默认模板）、productName → {product}、
+// scene → {scene}、variables（JSON 对象，其余占位符取值）、prompt → {prompt}。
+// 未指定 template_id 且没有任何结构化字段时沿用客户端提交的 prompt，返回 nil；
+// 连 prompt 也没有提交时使用该类型的默认模板（自定义生成类型只需上传参考图）
+func resolveRequestPrompt(c *gin.Context, generationType string) (*renderedPrompt, error) {
+	values := map[string]string{}
+	if raw := c.PostForm("variables"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &values); err != nil {
+			return nil, fmt.Errorf("variables 格式错误，应为 JSON 对象")
+		}
+	}
+	if product := strings.TrimSpace(c.PostForm("productName")); product != "" {
+		values["product"] = product
+	}
+	if scene := strings.TrimSpace(c.PostForm("scene")); scene != "" {
+		values["scene"] = scene
+	}
+
+	templateID := c.PostForm("template_id")
+	structured := templateID != "" || len(values) > 0
+	if prompt := c.PostForm("prompt"); prompt != "" {
+		if !structured {
+			return nil, nil
+		}
+		values["prompt"] = prompt
+	}
+
+	tpl, err := resolvePromptTemplate(templateID, generationType)
+	if err != nil {
+		return nil, err
+	}
+	if tpl == nil {
+		if !structured {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("生成类型 %s 没有默认提示词模板", generationType)
+	}
+	prompt, err := utils.RenderPrompt(tpl.Template, values)
+	if err != nil {
+		return nil, err
+	}
+	return &renderedPrompt{Prompt: prompt, TemplateID: tpl.TemplateID, Template: tpl.Template}, nil
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"sigma/config"
+	"sigma/models"
+)
+
+func setupPromptTemplateTest(t *testing.T) (*gin.Engine, func()) {
+	cleanup := setupGenerateTestDB(t)
+	config.DB.AutoMigrate(&models.PromptTemplate{})
+	if err := SeedPromptTemplates(); err != nil {
+		t.Fatalf("写入内置模板失败: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/prompt-templates", ListPromptTemplatesHandler)
+	r.POST("/prompt-templates", CreatePromptTemplateHandler)
+	r.PUT("/prompt-templates/:id", UpdatePromptTemplateHandler)
+	r.DELETE("/prompt-templates/:id", DeletePromptTemplateHandler)
+	r.POST("/prompt-templates/:id/reset", ResetPromptTemplateHandler)
+	r.POST("/generate", GenerateHandler)
+	return r, cleanup
+}
+
+func doJSON(r *gin.Engine, method, path, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestSeedPromptTemplates_KeepsEditedTemplates(t *testing.T) {
+	r, cleanup := setupPromptTemplateTest(t)
+	defer cleanup()
+
+	w := doJSON(r, "PUT", "/prompt-templates/light_shadow.default", `{"template": "增强{product}的光影"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("修改模板失败: %d %s", w.Code, w.Body.String())
+	}
+	if err := SeedPromptTemplates(); err != nil {
+		t.Fatalf("重复写入内置模板失败: %v", err)
+	}
+
+	var templates []models.PromptTemplate
+	config.DB.Find(&templates)
+	if len(templates) != len(models.BuiltinPromptTemplates()) {
+		t.Errorf("重复写入不应产生新模板，实际数量 %d", len(templates))
+	}
+	tpl, _ := resolvePromptTemplate("", models.GenerationTypeLightShadow)
+	if tpl == nil || tpl.Template != "增强{product}的光影" {
+		t.Errorf("重复写入不应覆盖已修改的模板: %+v", tpl)
+	}
+
+	w = doJSON(r, "POST", "/prompt-templates/light_shadow.default/reset", "")
+	tpl, _ = resolvePromptTemplate("", models.GenerationTypeLightShadow)
+	if w.Code != http.StatusOK || !strings.Contains(tpl.Template, "光影真实性") {
+		t.Errorf("恢复默认失败: %d %+v", w.Code, tpl)
+	}
+}
+
+func TestPromptTemplateHandlers_DefaultSwitching(t *testing.T) {
+	r, cleanup := setupPromptTemplateTest(t)
+	defer cleanup()
+
+	w := doJSON(r, "POST", "/prompt-templates", `{"template_id": "product_scene.studio", "type": "product_scene", "name": "棚拍", "template": "{product} 放在{scene}的摄影棚", "is_default": true}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("创建模板失败: %d %s", w.Code, w.Body.String())
+	}
+	var created models.PromptTemplate
+	json.Unmarshal(w.Body.Bytes(), &created)
+	if len(created.Placeholders) != 2 {
+		t.Errorf("应返回模板占位符，实际为 %v", created.Placeholders)
+	}
+
+	tpl, _ := resolvePromptTemplate("", models.GenerationTypeProductScene)
+	if tpl == nil || tpl.TemplateID != "product_scene.studio" {
+		t.Fatalf("新模板应成为默认模板: %+v", tpl)
+	}
+
+	if w := doJSON(r, "POST", "/prompt-templates", `{"template_id": "product_scene.studio", "type": "product_scene", "template": "x"}`); w.Code != http.StatusConflict {
+		t.Errorf("重复的模板 ID 应返回 409，实际为 %d", w.Code)
+	}
+	if w := doJSON(r, "POST", "/prompt-templates", `{"type": "unknown", "template": "x"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("未知生成类型应返回 400，实际为 %d", w.Code)
+	}
+	if w := doJSON(r, "DELETE", "/prompt-templates/product_scene.default", ""); w.Code != http.StatusConflict {
+		t.Errorf("内置模板不能删除，期望 409，实际为 %d", w.Code)
+	}
+
+	// 删除默认的自定义模板后，内置模板重新成为默认
+	if w := doJSON(r, "DELETE", "/prompt-templates/product_scene.studio", ""); w.Code != http.StatusOK {
+		t.Fatalf("删除模板失败: %d", w.Code)
+	}
+	tpl, _ = resolvePromptTemplate("", models.GenerationTypeProductScene)
+	if tpl == nil || tpl.TemplateID != "product_scene.default" {
+		t.Errorf("内置模板应恢复为默认: %+v", tpl)
+	}
+}
+
+func TestGenerateHandler_RendersServerTemplate(t *testing.T) {
+	r, cleanup := setupPromptTemplateTest(t)
+	defer cleanup()
+
+	originalToken := config.GetAPIToken()
+	config.SetAPIToken("test-api-key")
+	defer config.SetAPIToken(originalToken)
+	config.UploadDir = t.TempDir()
+
+	post := func(fields map[string]string) *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		for k, v := range fields {
+			writer.WriteField(k, v)
+		}
+		part, _ := writer.CreateFormFile("images", "product.png")
+		part.Write([]byte("png"))
+		writer.Close()
+		req, _ := http.NewRequest("POST", "/generate", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post(map[string]string{"type": models.GenerationTypeProductScene, "productName": "保温杯", "scene": "露营桌"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("期望状态码 200，实际为 %d: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		TaskID string `json:"task_id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+
+	var task models.GenerationTask
+	config.DB.Where("task_id = ?", response.TaskID).First(&task)
+	if task.TemplateID != "product_scene.default" || !strings.Contains(task.OriginalPrompt, "{product}") {
+		t.Errorf("任务应记录模板 ID 和模板原文: %+v", task)
+	}
+	if !strings.Contains(task.Prompt, "保温杯") || !strings.Contains(task.Prompt, "露营桌") || strings.Contains(task.Prompt, "{") {
+		t.Errorf("提示词应由模板渲染: %s", task.Prompt)
+	}
+
+	// 缺少占位符取值时返回 400
+	w = post(map[string]string{"type": models.GenerationTypeProductScene, "productName": "保温杯"})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("缺少场景时期望状态码 400，实际为 %d", w.Code)
+	}
+	// 模板与生成类型不一致时返回 400
+	w = post(map[string]string{"type": models.GenerationTypeLightShadow, "template_id": "product_scene.default"})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("模板类型不一致时期望状态码 400，实际为 %d", w.Code)
+	}
+}
@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"sigma/config"
+	"sigma/events"
+	"sigma/models"
+	"sigma/providers"
+	"sigma/utils"
+)
+
+// queuePollInterval 没有唤醒信号时轮询数据库的间隔
+const queuePollInterval = 3 * time.Second
+
+// queueFetchLimit 每轮调度最多读取的排队任务数
+const queueFetchLimit = 100
+
+// TaskQueue 基于 GenerationTask 表的持久化任务队列
+// 排队任务按创建顺序（FIFO）调度，同时受全局工作池大小与按类型并发上限约束
+type TaskQueue struct {
+	mu            sync.Mutex
+	size          int
+	typeLimits    map[string]int
+	running       int
+	runningByType map[string]int
+	// cancels 运行中任务的取消函数，按 task_id 索引
+	cancels map[string]context.CancelCauseFunc
+
+	wake      chan struct{}
+	startOnce sync.Once
+}
+
+var (
+	taskQueue     *TaskQueue
+	taskQueueOnce sync.Once
+)
+
+// NewTaskQueue 创建任务队列
+func NewTaskQueue(size int, typeLimits map[string]int) *TaskQueue {
+	if size < 1 {
+		size = 4
+	}
+	return &TaskQueue{
+		size:          size,
+		typeLimits:    typeLimits,
+		runningByType: make(map[string]int),
+		cancels:       make(map[string]context.CancelCauseFunc),
+		wake:          make(chan struct{}, 1),
+	}
+}
+
+// getTaskQueue 获取全局任务队列（按配置懒加载）
+func getTaskQueue() *TaskQueue {
+	taskQueueOnce.Do(func() {
+		taskQueue = NewTaskQueue(config.WorkerPoolSize, config.TypeConcurrencyLimits)
+	})
+	return taskQueue
+}
+
+// StartTaskQueue 启动全局任务队列的调度循环
+func StartTaskQueue() {
+	getTaskQueue().Start()
+}
+
+// Start 启动调度循环（重复调用无副作用）
+func (q *TaskQueue) Start() {
+	q.startOnce.Do(func() {
+		go q.dispatchLoop()
+	})
+}
+
+// Notify 唤醒调度循环（非阻塞）
+func (q *TaskQueue) Notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// canRunLocked 检查是否还有空闲槽位（调用方需持有锁）
+func (q *TaskQueue) canRunLocked(taskType string) bool {
+	if q.running >= q.size {
+		return false
+	}
+	if limit, ok := q.typeLimits[taskType]; ok && q.runningByType[taskType] >= limit {
+		return false
+	}
+	return true
+}
+
+// tryAcquire 尝试占用一个槽位
+func (q *TaskQueue) tryAcquire(taskType string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.canRunLocked(taskType) {
+		return false
+	}
+	q.running++
+	q.runningByType[taskType]++
+	return true
+}
+
+// release 释放槽位并唤醒调度循环
+func (q *TaskQueue) release(taskType string) {
+	q.mu.Lock()
+	q.running--
+	q.runningByType[taskType]--
+	q.mu.Unlock()
+	q.Notify()
+}
+
+// track 为任务创建可取消的 context，返回的 done 在任务结束时调用
+func (q *TaskQueue) track(taskID string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	q.mu.Lock()
+	q.cancels[taskID] = cancel
+	q.mu.Unlock()
+	return ctx, func() {
+		q.mu.Lock()
+		delete(q.cancels, taskID)
+		q.mu.Unlock()
+		cancel(nil)
+	}
+}
+
+// cancel 取消运行中的任务，中断其上游请求；任务不在本进程中运行时返回 false
+func (q *TaskQueue) cancel(taskID string) bool {
+	return q.cancelWithCause(taskID, nil)
+}
+
+// expire 因超时中断运行中的任务，任务随后按失败处理而不是取消
+func (q *TaskQueue) expire(taskID string) bool {
+	return q.cancelWithCause(taskID, errTaskTimedOut)
+}
+
+// cancelWithCause 以指定原因取消运行中的任务
+func (q *TaskQueue) cancelWithCause(taskID string, cause error) bool {
+	q.mu.Lock()
+	cancel, ok := q.cancels[taskID]
+	q.mu.Unlock()
+	if ok {
+		cancel(cause)
+	}
+	return ok
+}
+
+// full 工作池是否已满
+func (q *TaskQueue) full() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.running >= q.size
+}
+
+// dispatchLoop 调度循环：有唤醒信号或到达轮询间隔时调度一次
+func (q *TaskQueue) dispatchLoop() {
+	for {
+		q.dispatch()
+		select {
+		case <-q.wake:
+		case <-time.After(queuePollInterval):
+		}
+	}
+}
+
+// dispatch 按 FIFO 顺序领取排队任务并交给工作池执行
+// 某个类型达到并发上限时跳过该类型，继续调度后面的其他类型任务
+func (q *TaskQueue) dispatch() {
+	if config.DB == nil || q.full() {
+		return
+	}
+
+	var queued []models.GenerationTask
+	if err := config.DB.Where("status = ?", models.TaskStatusQueued).
+		Order("id asc").
+		Limit(queueFetchLimit).
+		Find(&queued).Error; err != nil {
+		utils.LogAPI("读取排队任务失败: %v", err)
+		return
+	}
+
+	for i := range queued {
+		task := queued[i]
+		if q.full() {
+			return
+		}
+		if !q.tryAcquire(task.Type) {
+			continue
+		}
+		// 先登记取消函数再领取，保证处于 processing 的任务总能被取消
+		ctx, done := q.track(task.TaskID)
+		if !claimQueuedTask(&task) {
+			done()
+			q.release(task.Type)
+			continue
+		}
+		go func() {
+			defer q.release(task.Type)
+			defer done()
+			processQueuedTask(ctx, &task)
+		}()
+	}
+}
+
+// claimQueuedTask 原子地将任务从 queued 切换为 processing，防止重复领取
+func claimQueuedTask(task *models.GenerationTask) bool {
+	if !task.StartTask() {
+		return false
+	}
+	result := config.DB.Model(&models.GenerationTask{}).
+		Where("task_id = ? AND status = ?", task.TaskID, models.TaskStatusQueued).
+		Updates(map[string]interface{}{
+			"status":     task.Status,
+			"started_at": task.StartedAt,
+		})
+	if result.Error != nil || result.RowsAffected != 1 {
+		return false
+	}
+	publishTaskEvent(events.TaskStarted, task)
+	return true
+}
+
+// processQueuedTask 执行一个已领取的队列任务
+func processQueuedTask(ctx context.Context, task *models.GenerationTask) {
+	currentToken := config.GetAPIToken()
+	if currentToken == "" {
+		task.FailTask("请先配置 API Key")
+		saveTaskOutcome(task)
+		return
+	}
+
+	refImages, err := loadRefImages(task.RefImages)
+	if err != nil {
+		task.FailTask(err.Error())
+		saveTaskOutcome(task)
+		utils.LogAPI("任务 %s 加载参考图失败: %v", task.TaskID, err)
+		return
+	}
+
+	genReq := providers.GenerateRequest{
+		Prompt:      task.Prompt,
+		RefImages:   refImages,
+		AspectRatio: task.AspectRatio,
+		ImageSize:   task.ImageSize,
+	}
+	processAIGeneration(ctx, currentToken, genReq, task.Type, []byte(task.RefImages), task.TaskID, task)
+}
+
+// loadRefImages 从上传目录读取任务引用的参考图
+func loadRefImages(refImagesJSON string) ([]providers.RefImage, error) {
+	var paths []string
+	if refImagesJSON != "" {
+		if err := json.Unmarshal([]byte(refImagesJSON), &paths); err != nil {
+			return nil, fmt.Errorf("参考图数据无效: %w", err)
+		}
+	}
+
+	refImages := make([]providers.RefImage, 0, len(paths))
+	for _, p := range paths {
+		fileName := filepath.Base(utils.ToRelativePath(p))
+		data, err := os.ReadFile(filepath.Join(config.UploadDir, fileName))
+		if err != nil {
+			return nil, fmt.Errorf("参考图丢失: %s", fileName)
+		}
+		refImages = append(refImages, providers.RefImage{MimeType: refImageMimeType(fileName), Data: data})
+	}
+	return refImages, nil
+}
+
+// refImageMimeType 根据文件名推断参考图 MIME 类型
+func refImageMimeType(fileName string) string {
+	if strings.HasSuffix(strings.ToLower(fileName), ".png") {
+		return "image/png"
+	}
+	return "image/jpeg"
+}
+
+// RequeueInterruptedTasks 服务启动时恢复上次运行中断的任务
+// 单图任务和批次子任务重新入队继续执行，批次父任务根据子任务状态重新汇总；
+// 旧版本遗留的多图任务（没有子任务，依赖已断开的 SSE 连接）直接标记失败
+func RequeueInterruptedTasks() (requeued int64, failed int64, err error) {
+	result := config.DB.Model(&models.GenerationTask{}).
+		Where("status = ? AND image_count <= 1", models.TaskStatusProcessing).
+		Update("status", models.TaskStatusQueued)
+	if result.Error != nil {
+		return 0, 0, result.Error
+	}
+	requeued = result.RowsAffected
+
+	result = config.DB.Model(&models.GenerationTask{}).
+		Where("status = ? AND image_count > 1 AND batch_id = ''", models.TaskStatusProcessing).
+		Updates(map[string]interface{}{
+			"status":      models.TaskStatusFailed,
+			"error_msg":   "服务重启，任务中断",
+			"finished_at": time.Now(),
+		})
+	if result.Error != nil {
+		return requeued, 0, result.Error
+	}
+	failed = result.RowsAffected
+
+	var parents []models.GenerationTask
+	if err := config.DB.Where("status = ? AND batch_id <> '' AND parent_task_id = ''", models.TaskStatusProcessing).
+		Find(&parents).Error; err != nil {
+		return requeued, failed, err
+	}
+	for _, parent := range parents {
+		refreshBatchParent(parent.TaskID)
+	}
+	return requeued, failed, nil
+}
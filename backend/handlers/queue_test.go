@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"sigma/config"
+	"sigma/models"
+)
+
+func TestTaskQueue_RespectsLimits(t *testing.T) {
+	q := NewTaskQueue(3, map[string]int{models.GenerationTypeCreate: 1})
+
+	if !q.tryAcquire(models.GenerationTypeCreate) {
+		t.Fatal("第一个 create 任务应能获得槽位")
+	}
+	if q.tryAcquire(models.GenerationTypeCreate) {
+		t.Error("create 类型已达上限，不应再获得槽位")
+	}
+	if !q.tryAcquire(models.GenerationTypeWhiteBackground) || !q.tryAcquire(models.GenerationTypeWhiteBackground) {
+		t.Error("未限制的类型应能使用剩余槽位")
+	}
+	if q.tryAcquire(models.GenerationTypeLightShadow) {
+		t.Error("工作池已满，不应再获得槽位")
+	}
+
+	q.release(models.GenerationTypeCreate)
+	if !q.tryAcquire(models.GenerationTypeCreate) {
+		t.Error("释放后应能重新获得槽位")
+	}
+}
+
+func TestClaimQueuedTask_IsExclusive(t *testing.T) {
+	cleanup := setupTaskTestDB(t)
+	defer cleanup()
+
+	task := models.GenerationTask{
+		TaskID:    uuid.New().String(),
+		Status:    models.TaskStatusQueued,
+		Type:      models.GenerationTypeCreate,
+		StartedAt: time.Now(),
+	}
+	config.DB.Create(&task)
+
+	first, second := task, task
+	if !claimQueuedTask(&first) {
+		t.Fatal("第一次领取应成功")
+	}
+	if claimQueuedTask(&second) {
+		t.Error("同一任务不应被重复领取")
+	}
+
+	var stored models.GenerationTask
+	config.DB.Where("task_id = ?", task.TaskID).First(&stored)
+	if stored.Status != models.TaskStatusProcessing {
+		t.Errorf("领取后状态应为 processing，实际为 %s", stored.Status)
+	}
+}
+
+func TestSaveTaskOutcome_DoesNotOverwriteCancel(t *testing.T) {
+	cleanup := setupTaskTestDB(t)
+	defer cleanup()
+
+	task := models.GenerationTask{TaskID: uuid.New().String(), Status: models.TaskStatusQueued, Type: models.GenerationTypeCreate, StartedAt: time.Now()}
+	config.DB.Create(&task)
+	if !claimQueuedTask(&task) {
+		t.Fatal("领取任务失败")
+	}
+
+	// 工作协程完成生成前，任务被取消
+	cancelled := task
+	if !cancelTask(&cancelled) {
+		t.Fatal("取消任务失败")
+	}
+	task.RecordAttempts(2, nil)
+	task.CompleteTask("images/done.png")
+	if saveTaskOutcome(&task) {
+		t.Error("已取消的任务不应再写入完成状态")
+	}
+
+	var stored models.GenerationTask
+	config.DB.Where("task_id = ?", task.TaskID).First(&stored)
+	if stored.Status != models.TaskStatusCancelled || stored.ImageURL != "" {
+		t.Errorf("取消状态被覆盖: %+v", stored)
+	}
+	if stored.Attempts != 2 {
+		t.Errorf("仍应记录尝试次数，实际为 %d", stored.Attempts)
+	}
+}
+
+func TestRequeueInterruptedTasks(t *testing.T) {
+	cleanup := setupTaskTestDB(t)
+	defer cleanup()
+
+	single := models.GenerationTask{TaskID: uuid.New().String(), Status: models.TaskStatusProcessing, Type: models.GenerationTypeCreate, ImageCount: 1, StartedAt: time.Now()}
+	batch := models.GenerationTask{TaskID: uuid.New().String(), Status: models.TaskStatusProcessing, Type: models.GenerationTypeCreate, ImageCount: 4, StartedAt: time.Now()}
+	config.DB.Create(&single)
+	config.DB.Create(&batch)
+
+	requeued, failed, err := RequeueInterruptedTasks()
+	if err != nil {
+		t.Fatalf("恢复任务失败: %v", err)
+	}
+	if requeued != 1 || failed != 1 {
+		t.Errorf("期望重新入队 1 个、失败 1 个，实际为 %d / %d", requeued, failed)
+	}
+
+	var stored models.GenerationTask
+	config.DB.Where("task_id = ?", single.TaskID).First(&stored)
+	if stored.Status != models.TaskStatusQueued {
+		t.Errorf("单图任务应重新入队，实际状态为 %s", stored.Status)
+	}
+	var storedBatch models.GenerationTask
+	config.DB.Where("task_id = ?", batch.TaskID).First(&storedBatch)
+	if storedBatch.Status != models.TaskStatusFailed {
+		t.Errorf("批量任务应标记失败，实际状态为 %s", storedBatch.Status)
+	}
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"time"
+
+	"sigma/config"
+	"sigma/models"
+	"sigma/providers"
+	"sigma/utils"
+)
+
+// RetryPolicy AI 调用重试策略
+type RetryPolicy struct {
+	MaxAttempts int           // 最大尝试次数（含首次）
+	BaseDelay   time.Duration // 首次重试前的基础等待时间
+	MaxDelay    time.Duration // 单次等待上限
+}
+
+// currentRetryPolicy 从配置读取重试策略
+func currentRetryPolicy() RetryPolicy {
+	policy := RetryPolicy{
+		MaxAttempts: config.RetryMaxAttempts,
+		BaseDelay:   config.RetryBaseDelay,
+		MaxDelay:    config.RetryMaxDelay,
+	}
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	return policy
+}
+
+// backoff 计算第 attempt 次失败后的等待时间（指数退避 + 随机抖动）
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	if p.BaseDelay <= 0 {
+		return 0
+	}
+	delay := p.BaseDelay << uint(attempt-1)
+	if p.MaxDelay > 0 && (delay > p.MaxDelay || delay <= 0) {
+		delay = p.MaxDelay
+	}
+	// 一半固定 + 一半随机，避免并发请求同时重试
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// maxDuration 返回按该策略执行一次生成的最长耗时：每次尝试都耗尽 perAttempt，且每次退避都取上限
+func (p RetryPolicy) maxDuration(perAttempt time.Duration) time.Duration {
+	total := time.Duration(p.MaxAttempts) * perAttempt
+	for attempt := 1; attempt < p.MaxAttempts; attempt++ {
+		delay := p.BaseDelay << uint(attempt-1)
+		if p.MaxDelay > 0 && (delay > p.MaxDelay || delay <= 0) {
+			delay = p.MaxDelay
+		}
+		if delay > 0 {
+			total += delay
+		}
+	}
+	return total
+}
+
+// isRetryable 判断失败结果是否值得重试
+// 余额不足和任务取消永不重试；429、5xx、超时和模型未返回内容视为临时错误
+func isRetryable(result GenerationResult) bool {
+	if result.Success || result.isCancelled() || result.IsQuotaError || isQuotaError(result.ErrorMessage) {
+		return false
+	}
+	if result.StatusCode == 429 || result.StatusCode >= 500 {
+		return true
+	}
+	if result.err == nil {
+		return false
+	}
+	if errors.Is(result.err, providers.ErrEmptyResponse) || errors.Is(result.err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(result.err, &netErr) && netErr.Timeout()
+}
+
+// runGenerationWithRetry 按重试策略执行生成，返回最后一次结果
+// 结果中的 Attempts / AttemptErrors 记录了每次尝试的情况；ctx 取消后不再重试
+func runGenerationWithRetry(ctx context.Context, provider providers.Provider, apiKey string, genReq providers.GenerateRequest, label string, policy RetryPolicy, index *int) GenerationResult {
+	var attemptErrors []models.AttemptError
+	var result GenerationResult
+	attempts := 0
+
+	for attempts < policy.MaxAttempts {
+		attempts++
+		result = runGeneration(ctx, provider, apiKey, genReq, label)
+		if result.Success || result.isCancelled() {
+			break
+		}
+
+		attemptErrors = append(attemptErrors, models.AttemptError{
+			Attempt:    attempts,
+			Index:      index,
+			Error:      result.DisplayError(),
+			StatusCode: result.StatusCode,
+			At:         time.Now(),
+		})
+
+		if attempts == policy.MaxAttempts || !isRetryable(result) {
+			break
+		}
+
+		delay := policy.backoff(attempts)
+		utils.LogAPI("[%s] 第 %d 次尝试失败（%s），%v 后重试", label, attempts, result.ErrorMessage, delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			utils.LogAPI("[%s] 任务已取消，停止重试", label)
+			result = cancelledResult(0, 0)
+		}
+		if result.isCancelled() {
+			break
+		}
+	}
+
+	result.Attempts = attempts
+	result.AttemptErrors = attemptErrors
+	return result
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"sigma/config"
+	"sigma/models"
+	"sigma/providers"
+)
+
+// fakeProvider 按顺序返回预设响应的测试供应商
+type fakeProvider struct {
+	responses []*providers.Response
+	calls     int
+}
+
+func (f *fakeProvider) Platform() config.PlatformType { return "fake" }
+
+func (f *fakeProvider) BuildRequest(ctx context.Context, apiKey string, req providers.GenerateRequest) (*http.Request, error) {
+	return http.NewRequestWithContext(ctx, "POST", "http://fake.local/generate", nil)
+}
+
+func (f *fakeProvider) Send(req *http.Request) (*providers.Response, error) {
+	resp := f.responses[f.calls]
+	if f.calls < len(f.responses)-1 {
+		f.calls++
+	}
+	return resp, nil
+}
+
+func (f *fakeProvider) ParseResponse(resp *providers.Response) ([]providers.Image, error) {
+	if resp.StatusCode != 200 {
+		return nil, &providers.APIError{StatusCode: resp.StatusCode, Message: string(resp.Body)}
+	}
+	if len(resp.Body) == 0 {
+		return nil, providers.ErrEmptyResponse
+	}
+	return []providers.Image{{MimeType: "image/png", Base64: string(resp.Body)}}, nil
+}
+
+func (f *fakeProvider) CheckBalance(apiKey string) providers.TokenValidationResult {
+	return providers.TokenValidationResult{}
+}
+
+func TestRunGenerationWithRetry_RetriesTransientErrors(t *testing.T) {
+	config.OutputDir = t.TempDir()
+	provider := &fakeProvider{responses: []*providers.Response{
+		{StatusCode: 503, Body: []byte("upstream busy")},
+		{StatusCode: 200},
+		{StatusCode: 200, Body: []byte("aGVsbG8=")},
+	}}
+	policy := RetryPolicy{MaxAttempts: 3}
+
+	result := runGenerationWithRetry(context.Background(), provider, "key", providers.GenerateRequest{}, "test", policy, nil)
+	if !result.Success {
+		t.Fatalf("期望重试后成功，实际错误: %s", result.ErrorMessage)
+	}
+	if result.Attempts != 3 {
+		t.Errorf("期望尝试 3 次，实际为 %d", result.Attempts)
+	}
+	if len(result.AttemptErrors) != 2 || result.AttemptErrors[0].StatusCode != 503 {
+		t.Errorf("失败记录不正确: %+v", result.AttemptErrors)
+	}
+	if len(result.SavedFiles) != 1 {
+		t.Errorf("期望保存 1 个文件，实际为 %d", len(result.SavedFiles))
+	}
+}
+
+func TestRunGenerationWithRetry_NeverRetriesQuotaErrors(t *testing.T) {
+	provider := &fakeProvider{responses: []*providers.Response{
+		{StatusCode: 429, Body: []byte("余额不足")},
+		{StatusCode: 200, Body: []byte("aGVsbG8=")},
+	}}
+	policy := RetryPolicy{MaxAttempts: 5}
+
+	result := runGenerationWithRetry(context.Background(), provider, "key", providers.GenerateRequest{}, "test", policy, nil)
+	if result.Success || !result.IsQuotaError {
+		t.Fatalf("余额错误应直接失败，实际结果: %+v", result)
+	}
+	if result.Attempts != 1 {
+		t.Errorf("余额错误不应重试，实际尝试 %d 次", result.Attempts)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	cases := []struct {
+		name   string
+		result GenerationResult
+		want   bool
+	}{
+		{"429", failedResult(errors.New("too many requests"), 429, 0), true},
+		{"502", failedResult(errors.New("bad gateway"), 502, 0), true},
+		{"400", failedResult(errors.New("bad request"), 400, 0), false},
+		{"quota 429", failedResult(errors.New("quota exceeded"), 429, 0), false},
+		{"empty response", failedResult(providers.ErrEmptyResponse, 200, 0), true},
+		{"timeout", failedResult(context.DeadlineExceeded, 0, 0), true},
+		{"no image", failedResult(providers.ErrNoImage, 200, 0), false},
+	}
+	for _, tc := range cases {
+		if got := isRetryable(tc.result); got != tc.want {
+			t.Errorf("%s: 期望 %v，实际为 %v", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestRunGenerationWithRetry_StopsWhenCancelled(t *testing.T) {
+	provider := &fakeProvider{responses: []*providers.Response{
+		{StatusCode: 503, Body: []byte("upstream busy")},
+	}}
+	policy := RetryPolicy{MaxAttempts: 5, BaseDelay: time.Hour}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	result := runGenerationWithRetry(ctx, provider, "key", providers.GenerateRequest{}, "test", policy, nil)
+	if !result.isCancelled() {
+		t.Fatalf("取消后应返回取消结果，实际结果: %+v", result)
+	}
+	if result.Attempts != 1 {
+		t.Errorf("取消后不应继续重试，实际尝试 %d 次", result.Attempts)
+	}
+	if result.DisplayError() != models.TaskCancelledMessage {
+		t.Errorf("取消错误信息不正确: %s", result.DisplayError())
+	}
+}
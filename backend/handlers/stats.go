@@ -4,42 +4,40 @@ import (
 	"github.com/gin-gonic/gin"
 	"sigma/config"
 	"sigma/models"
+	"sigma/utils"
 )
 
-// GetGenerationCountHandler 获取生成计数
-func GetGenerationCountHandler(c *gin.Context) {
-	var stats models.GenerationStats
-	
-	// 尝试获取统计记录，如果不存在则创建
-	result := config.DB.First(&stats)
-	if result.Error != nil {
-		// 记录不存在，创建新记录
-		stats = models.GenerationStats{TotalCount: 0}
-		config.DB.Create(&stats)
+// CountGeneratedImages 累计生成的图片数，由历史记录计算（4K 图片计为 2 张）
+// 删除的记录保留在数据库中，仍计入累计数；再加上旧版本计数器迁移时留下的基准（见 models.GenerationStats）
+func CountGeneratedImages() (int64, error) {
+	var total int64
+	err := config.DB.Model(&models.GenerationHistory{}).
+		Select("COALESCE(SUM(CASE WHEN image_size = '4K' THEN 2 ELSE 1 END), 0)").
+		Scan(&total).Error
+	if err != nil {
+		return 0, err
 	}
-	
-	c.JSON(200, models.GenerationStatsResponse{
-		TotalCount: stats.TotalCount,
-	})
+
+	var baseline []models.GenerationStats
+	if err := config.DB.Order("id").Limit(1).Find(&baseline).Error; err != nil {
+		return 0, err
+	}
+	if len(baseline) > 0 {
+		total += int64(baseline[0].TotalCount)
+	}
+	return total, nil
 }
 
-// IncrementGenerationCountHandler 增加生成计数
-func IncrementGenerationCountHandler(c *gin.Context) {
-	var stats models.GenerationStats
-	
-	// 尝试获取统计记录
-	result := config.DB.First(&stats)
-	if result.Error != nil {
-		// 记录不存在，创建新记录并设置计数为1
-		stats = models.GenerationStats{TotalCount: 1}
-		config.DB.Create(&stats)
-	} else {
-		// 记录存在，增加计数
-		stats.TotalCount++
-		config.DB.Save(&stats)
+// GetGenerationCountHandler 获取生成计数
+func GetGenerationCountHandler(c *gin.Context) {
+	total, err := CountGeneratedImages()
+	if err != nil {
+		utils.LogAPI("统计生成计数失败: %v", err)
+		c.JSON(500, gin.H{"error": "获取生成计数失败"})
+		return
 	}
-	
+
 	c.JSON(200, models.GenerationStatsResponse{
-		TotalCount: stats.TotalCount,
+		TotalCount: int(total),
 	})
 }
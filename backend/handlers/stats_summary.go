@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"sigma/config"
+	"sigma/models"
+	"sigma/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// statsGroupKeys 统计分组维度 -> 分组键
+// 按日期和月份分组时使用本地时区
+var statsGroupKeys = map[string]func(createdAt time.Time, generationType, imageSize string) string{
+	"day":   func(createdAt time.Time, _, _ string) string { return createdAt.Local().Format("2006-01-02") },
+	"month": func(createdAt time.Time, _, _ string) string { return createdAt.Local().Format("2006-01") },
+	"type":  func(_ time.Time, generationType, _ string) string { return generationType },
+	"size": func(_ time.Time, _, imageSize string) string {
+		if imageSize == "" {
+			return "unknown"
+		}
+		return imageSize
+	},
+}
+
+// statsBucket 一个分组（或全部）的生成统计
+type statsBucket struct {
+	Key          string  `json:"key,omitempty"`
+	Images       int64   `json:"images"` // 成功生成的图片数（历史记录数）
+	Tasks        int64   `json:"tasks"`
+	Completed    int64   `json:"completed"`
+	Failed       int64   `json:"failed"`
+	Cancelled    int64   `json:"cancelled"`
+	Pending      int64   `json:"pending"`        // 排队中或处理中
+	SuccessRate  float64 `json:"success_rate"`   // completed / (completed + failed)，取消的任务不计入
+	AvgLatencyMs int64   `json:"avg_latency_ms"` // 完成的任务从开始处理到完成的平均耗时
+
+	latencyTotal time.Duration
+	latencyCount int64
+}
+
+// addTask 计入一个任务
+func (b *statsBucket) addTask(task *models.GenerationTask) {
+	b.Tasks++
+	switch task.Status {
+	case models.TaskStatusCompleted:
+		b.Completed++
+		if task.FinishedAt != nil && !task.StartedAt.IsZero() && task.FinishedAt.After(task.StartedAt) {
+			b.latencyTotal += task.FinishedAt.Sub(task.StartedAt)
+			b.latencyCount++
+		}
+	case models.TaskStatusFailed:
+		b.Failed++
+	case models.TaskStatusCancelled:
+		b.Cancelled++
+	default:
+		b.Pending++
+	}
+}
+
+// finish 计算成功率和平均耗时
+func (b *statsBucket) finish() {
+	if finished := b.Completed + b.Failed; finished > 0 {
+		b.SuccessRate = float64(b.Completed) / float64(finished)
+	}
+	if b.latencyCount > 0 {
+		b.AvgLatencyMs = (b.latencyTotal / time.Duration(b.latencyCount)).Milliseconds()
+	}
+}
+
+// StatsSummary 生成统计汇总
+type StatsSummary struct {
+	From    *time.Time    `json:"from"`
+	To      *time.Time    `json:"to"`
+	GroupBy string        `json:"group_by"`
+	Totals  statsBucket   `json:"totals"`
+	Groups  []statsBucket `json:"groups"`
+}
+
+// BuildStatsSummary 根据历史记录和任务统计生成情况
+// 图片数来自历史记录（只有成功生成的图片写入历史记录），任务状态和耗时来自任务；
+// 批次父任务由子任务汇总，不重复计入；时间范围按创建时间 [from, to) 筛选，为空表示不限
+func BuildStatsSummary(from, to *time.Time, groupBy string) (*StatsSummary, error) {
+	groupKey, ok := statsGroupKeys[groupBy]
+	if !ok {
+		return nil, fmt.Errorf("不支持的分组方式: %s", groupBy)
+	}
+
+	var histories []models.GenerationHistory
+	historyQuery := config.DB.Model(&models.GenerationHistory{}).Select("type", "image_size", "created_at")
+	var tasks []models.GenerationTask
+	taskQuery := config.DB.Model(&models.GenerationTask{}).
+		Select("type", "status", "image_size", "created_at", "started_at", "finished_at").
+		Where("batch_id = '' OR parent_task_id <> ''")
+	if from != nil {
+		historyQuery = historyQuery.Where("created_at >= ?", *from)
+		taskQuery = taskQuery.Where("created_at >= ?", *from)
+	}
+	if to != nil {
+		historyQuery = historyQuery.Where("created_at < ?", *to)
+		taskQuery = taskQuery.Where("created_at < ?", *to)
+	}
+	if err := historyQuery.Find(&histories).Error; err != nil {
+		return nil, err
+	}
+	if err := taskQuery.Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+
+	summary := &StatsSummary{From: from, To: to, GroupBy: groupBy}
+	groups := make(map[string]*statsBucket)
+	group := func(key string) *statsBucket {
+		if groups[key] == nil {
+			groups[key] = &statsBucket{Key: key}
+		}
+		return groups[key]
+	}
+	for _, h := range histories {
+		summary.Totals.Images++
+		group(groupKey(h.CreatedAt, h.Type, h.ImageSize)).Images++
+	}
+	for i := range tasks {
+		task := &tasks[i]
+		summary.Totals.addTask(task)
+		group(groupKey(task.CreatedAt, task.Type, task.ImageSize)).addTask(task)
+	}
+
+	summary.Totals.finish()
+	summary.Groups = make([]statsBucket, 0, len(groups))
+	for _, b := range groups {
+		b.finish()
+		summary.Groups = append(summary.Groups, *b)
+	}
+	// 日期按时间顺序，其他维度按图片数从多到少
+	sort.Slice(summary.Groups, func(i, j int) bool {
+		a, b := summary.Groups[i], summary.Groups[j]
+		if groupBy != "day" && groupBy != "month" && a.Images != b.Images {
+			return a.Images > b.Images
+		}
+		return a.Key < b.Key
+	})
+	return summary, nil
+}
+
+// parseStatsRange 解析统计时间范围 from / to（YYYY-MM-DD 或 RFC3339），为空表示不限
+func parseStatsRange(c *gin.Context) (from, to *time.Time, err error) {
+	if value := c.Query("from"); value != "" {
+		t, err := parseHistoryTime(value, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		from = &t
+	}
+	if value := c.Query("to"); value != "" {
+		t, err := parseHistoryTime(value, true)
+		if err != nil {
+			return nil, nil, err
+		}
+		to = &t
+	}
+	if from != nil && to != nil && !to.After(*from) {
+		return nil, nil, errors.New("结束时间必须晚于开始时间")
+	}
+	return from, to, nil
+}
+
+// parseStatsGroupBy 解析统计分组方式，默认按日期
+func parseStatsGroupBy(c *gin.Context) (string, error) {
+	groupBy := c.DefaultQuery("group_by", "day")
+	if _, ok := statsGroupKeys[groupBy]; !ok {
+		return "", errors.New("group_by 必须是 day、month、type 或 size")
+	}
+	return groupBy, nil
+}
+
+// StatsSummaryHandler 生成统计：按日期、月份、生成类型或图片尺寸分组的图片数、任务成功率和平均耗时
+// GET /stats/summary?from=2025-01-01&to=2025-01-31&group_by=day
+func StatsSummaryHandler(c *gin.Context) {
+	from, to, err := parseStatsRange(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	groupBy, err := parseStatsGroupBy(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	summary, err := BuildStatsSummary(from, to, groupBy)
+	if err != nil {
+		utils.LogAPI("生成统计失败: %v", err)
+		c.JSON(500, gin.H{"error": "生成统计失败"})
+		return
+	}
+	c.JSON(200, summary)
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"sigma/config"
+	"sigma/models"
+)
+
+// setupStatsSummaryTest 准备两天的记录：
+// 第一天 create 2K 完成 2 个（耗时 10s、20s）、失败 1 个；第二天 white_background 4K 完成 1 个、取消 1 个，
+// 以及一个批次父任务（不计入统计）
+func setupStatsSummaryTest(t *testing.T) *gin.Engine {
+	var err error
+	config.DB, err = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("无法创建测试数据库: %v", err)
+	}
+	config.DB.AutoMigrate(&models.GenerationHistory{}, &models.GenerationTask{})
+	t.Cleanup(func() {
+		sqlDB, _ := config.DB.DB()
+		sqlDB.Close()
+	})
+
+	day1 := time.Date(2025, 1, 1, 10, 0, 0, 0, time.Local)
+	day2 := day1.AddDate(0, 0, 1)
+	addTask := func(id, generationType, size string, status models.TaskStatus, createdAt time.Time, latency time.Duration) {
+		task := models.GenerationTask{TaskID: id, Type: generationType, ImageSize: size, Status: status, StartedAt: createdAt}
+		task.CreatedAt = createdAt
+		if status != models.TaskStatusQueued {
+			finishedAt := createdAt.Add(latency)
+			task.FinishedAt = &finishedAt
+		}
+		config.DB.Create(&task)
+		if status == models.TaskStatusCompleted {
+			history := models.GenerationHistory{Type: generationType, ImageSize: size, ImageURL: "images/" + id + ".png"}
+			history.CreatedAt = createdAt
+			config.DB.Create(&history)
+		}
+	}
+	addTask("a", models.GenerationTypeCreate, "2K", models.TaskStatusCompleted, day1, 10*time.Second)
+	addTask("b", models.GenerationTypeCreate, "2K", models.TaskStatusCompleted, day1, 20*time.Second)
+	addTask("c", models.GenerationTypeCreate, "2K", models.TaskStatusFailed, day1, time.Second)
+	addTask("d", models.GenerationTypeWhiteBackground, "4K", models.TaskStatusCompleted, day2, 30*time.Second)
+	addTask("e", models.GenerationTypeWhiteBackground, "4K", models.TaskStatusCancelled, day2, time.Second)
+	config.DB.Create(&models.GenerationTask{TaskID: "parent", Type: models.GenerationTypeCreate, Status: models.TaskStatusCompleted,
+		BatchID: "batch", StartedAt: day1})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/stats/summary", StatsSummaryHandler)
+	return r
+}
+
+func TestStatsSummary_GroupByDay(t *testing.T) {
+	r := setupStatsSummaryTest(t)
+
+	w := doJSON(r, "GET", "/stats/summary?from=2025-01-01&to=2025-01-02", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("期望状态码 200，实际为 %d: %s", w.Code, w.Body.String())
+	}
+	var summary StatsSummary
+	json.Unmarshal(w.Body.Bytes(), &summary)
+
+	totals := summary.Totals
+	if totals.Images != 3 || totals.Tasks != 5 || totals.Completed != 3 || totals.Failed != 1 || totals.Cancelled != 1 {
+		t.Errorf("汇总不正确（批次父任务不应计入）: %+v", totals)
+	}
+	if totals.SuccessRate != 0.75 || totals.AvgLatencyMs != 20000 {
+		t.Errorf("成功率或平均耗时不正确: %v %d", totals.SuccessRate, totals.AvgLatencyMs)
+	}
+	if len(summary.Groups) != 2 || summary.Groups[0].Key != "2025-01-01" || summary.Groups[1].Key != "2025-01-02" {
+		t.Fatalf("应按日期升序分组: %+v", summary.Groups)
+	}
+	if day1 := summary.Groups[0]; day1.Images != 2 || day1.Failed != 1 || day1.AvgLatencyMs != 15000 {
+		t.Errorf("第一天的统计不正确: %+v", day1)
+	}
+
+	// 时间范围只包含第二天
+	w = doJSON(r, "GET", "/stats/summary?from=2025-01-02&group_by=size", "")
+	json.Unmarshal(w.Body.Bytes(), &summary)
+	if len(summary.Groups) != 1 || summary.Groups[0].Key != "4K" || summary.Groups[0].Images != 1 || summary.Groups[0].SuccessRate != 1 {
+		t.Errorf("按尺寸分组不正确: %+v", summary.Groups)
+	}
+}
+
+func TestStatsSummary_InvalidParams(t *testing.T) {
+	r := setupStatsSummaryTest(t)
+
+	for _, query := range []string{"group_by=prompt", "from=yesterday", "from=2025-01-02&to=2025-01-01"} {
+		if w := doJSON(r, "GET", "/stats/summary?"+query, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("%s 期望状态码 400，实际为 %d", query, w.Code)
+		}
+	}
+}
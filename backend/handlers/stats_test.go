@@ -51,52 +51,28 @@ func TestGetGenerationCountHandler_Empty(t *testing.T) {
 	}
 }
 
-func TestIncrementGenerationCountHandler(t *testing.T) {
+func TestGetGenerationCountHandler_CountsHistory(t *testing.T) {
 	cleanup := setupTestDB(t)
 	defer cleanup()
-	
+
 	gin.SetMode(gin.TestMode)
 	r := gin.New()
-	r.POST("/stats/increment-count", IncrementGenerationCountHandler)
 	r.GET("/stats/generation-count", GetGenerationCountHandler)
-	
-	// 第一次增加
-	req1, _ := http.NewRequest("POST", "/stats/increment-count", nil)
-	w1 := httptest.NewRecorder()
-	r.ServeHTTP(w1, req1)
-	
-	if w1.Code != http.StatusOK {
-		t.Errorf("期望状态码 200，实际为 %d", w1.Code)
-	}
-	
-	var response1 models.GenerationStatsResponse
-	json.Unmarshal(w1.Body.Bytes(), &response1)
-	
-	if response1.TotalCount != 1 {
-		t.Errorf("第一次增加后期望计数为 1，实际为 %d", response1.TotalCount)
-	}
-	
-	// 第二次增加
-	req2, _ := http.NewRequest("POST", "/stats/increment-count", nil)
-	w2 := httptest.NewRecorder()
-	r.ServeHTTP(w2, req2)
-	
-	var response2 models.GenerationStatsResponse
-	json.Unmarshal(w2.Body.Bytes(), &response2)
-	
-	if response2.TotalCount != 2 {
-		t.Errorf("第二次增加后期望计数为 2，实际为 %d", response2.TotalCount)
-	}
-	
-	// 验证 GET 接口返回正确计数
-	req3, _ := http.NewRequest("GET", "/stats/generation-count", nil)
-	w3 := httptest.NewRecorder()
-	r.ServeHTTP(w3, req3)
-	
-	var response3 models.GenerationStatsResponse
-	json.Unmarshal(w3.Body.Bytes(), &response3)
-	
-	if response3.TotalCount != 2 {
-		t.Errorf("GET 接口期望计数为 2，实际为 %d", response3.TotalCount)
+
+	// 计数由历史记录计算：4K 图片计为 2 张，图片已删除的记录仍计入
+	config.DB.Create(&models.GenerationHistory{Prompt: "a", ImageURL: "images/a.png", ImageSize: "2K"})
+	config.DB.Create(&models.GenerationHistory{Prompt: "b", ImageURL: "images/b.png", ImageSize: "4K"})
+	config.DB.Create(&models.GenerationHistory{Prompt: "c", ImageURL: "images/c.png", ImageDeleted: true})
+	// 旧版本计数器迁移后保存的基准计入累计数
+	config.DB.Create(&models.GenerationStats{TotalCount: 100})
+
+	req, _ := http.NewRequest("GET", "/stats/generation-count", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var response models.GenerationStatsResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if w.Code != http.StatusOK || response.TotalCount != 104 {
+		t.Errorf("期望计数为 104，实际为 %d（状态码 %d）", response.TotalCount, w.Code)
 	}
 }
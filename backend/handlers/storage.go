@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"sigma/config"
+	"sigma/models"
+	"sigma/utils"
+)
+
+// 内容寻址文件的扩展名（按内容识别，文件名中的扩展名只作参考）
+var storedFileExts = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/webp": ".webp",
+	"image/gif":  ".gif",
+}
+
+// storageDir 文件类别对应的存储目录
+func storageDir(kind string) string {
+	if kind == models.StoredFileKindOutput {
+		return config.OutputDir
+	}
+	return config.UploadDir
+}
+
+// storagePrefix 文件类别对应的相对路径前缀（与静态文件路由一致）
+func storagePrefix(kind string) string {
+	if kind == models.StoredFileKindOutput {
+		return "images/"
+	}
+	return "uploads/"
+}
+
+// storedFileExt 确定保存的扩展名：优先按内容识别，无法识别时使用 ext（如 ".png"）
+func storedFileExt(data []byte, ext string) string {
+	contentType := http.DetectContentType(data)
+	if detected, ok := storedFileExts[contentType]; ok {
+		return detected
+	}
+	ext = strings.ToLower(ext)
+	if ext == ".jpeg" {
+		ext = ".jpg"
+	}
+	if ext == "" || len(ext) > 8 || strings.ContainsAny(ext, `/\`) {
+		return ".bin"
+	}
+	return ext
+}
+
+// storeFile 按内容 SHA-256 保存文件并返回相对路径（uploads/<hash>.png 或 images/<hash>.png）
+// 同一类别中已有相同内容时直接复用，不再写入新文件
+func storeFile(kind string, data []byte, ext string) (string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	// 已有记录时沿用记录中的路径（即使扩展名不同）
+	var existing models.StoredFile
+	if err := config.DB.Where("kind = ? AND hash = ?", kind, hash).First(&existing).Error; err == nil {
+		if err := writeFileOnce(filepath.Join(storageDir(kind), filepath.Base(existing.Path)), data); err != nil {
+			return "", err
+		}
+		return existing.Path, nil
+	}
+
+	fileName := hash + storedFileExt(data, ext)
+	if err := writeFileOnce(filepath.Join(storageDir(kind), fileName), data); err != nil {
+		return "", err
+	}
+
+	record := models.StoredFile{
+		Kind: kind,
+		Hash: hash,
+		Path: storagePrefix(kind) + fileName,
+		Size: int64(len(data)),
+	}
+	// 文件已写入，记录写入失败不影响本次使用，下次保存相同内容时会补全
+	if err := config.DB.Where("kind = ? AND hash = ?", kind, hash).Attrs(record).FirstOrCreate(&record).Error; err != nil {
+		utils.LogAPI("保存文件记录失败: %s, 错误: %v", record.Path, err)
+	}
+	return record.Path, nil
+}
+
+// writeFileOnce 写入内容寻址文件；文件已存在时内容必然相同，只更新修改时间
+// （孤立文件清理按修改时间保留较新的文件，避免刚被复用的文件在写入记录前被删除）
+// 先写入临时文件再重命名，避免并发写入或中断时留下不完整的文件
+func writeFileOnce(path string, data []byte) error {
+	if _, err := os.Stat(path); err == nil {
+		now := time.Now()
+		os.Chtimes(path, now, now)
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		// 并发写入同一内容时，另一方可能已完成重命名
+		if _, statErr := os.Stat(path); statErr == nil {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// storageRefPath 将引用中的 URL 或路径统一为相对路径（uploads/xxx 或 images/xxx）
+func storageRefPath(ref string) string {
+	return strings.TrimPrefix(utils.ToRelativePath(ref), "/")
+}
+
+// parseRefImagePaths 解析 RefImages JSON 中的参考图路径
+func parseRefImagePaths(refImagesJSON string) []string {
+	if refImagesJSON == "" {
+		return nil
+	}
+	var refs []string
+	if err := json.Unmarshal([]byte(refImagesJSON), &refs); err != nil {
+		return nil
+	}
+	paths := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		if ref != "" {
+			paths = append(paths, storageRefPath(ref))
+		}
+	}
+	return paths
+}
+
+// outputImageInUse 检查生成图片是否仍被其他历史记录使用（内容相同的图片共用一个文件）
+// 只统计图片未清除的记录；trashed 为 true 时只统计回收站中的记录，否则只统计不在回收站的记录
+func outputImageInUse(fileName string, excludeIDs []uint, trashed bool) bool {
+	query := config.DB.Model(&models.GenerationHistory{}).
+		Where("image_url = ? OR image_url LIKE ? ESCAPE '\\'", "images/"+fileName, "%/images/"+escapeLike(fileName)).
+		Where("image_deleted = ? OR image_deleted IS NULL", false)
+	if trashed {
+		query = query.Where("trashed_at IS NOT NULL")
+	} else {
+		query = query.Where("trashed_at IS NULL")
+	}
+	if len(excludeIDs) > 0 {
+		query = query.Where("id NOT IN ?", excludeIDs)
+	}
+	var count int64
+	query.Count(&count)
+	return count > 0
+}
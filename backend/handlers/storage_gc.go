@@ -0,0 +1,515 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"sigma/config"
+	"sigma/models"
+	"sigma/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// storageReportItemLimit 报告中列出的孤立文件和失效引用的最大条数（计数不受限制）
+const storageReportItemLimit = 100
+
+// defaultOrphanMinAge 孤立文件的最短保留时间
+// 上传和生成的文件先写入磁盘再写入记录，较新的文件可能属于进行中的任务
+const defaultOrphanMinAge = 24 * time.Hour
+
+// storageFile 输出目录或上传目录中的文件
+type storageFile struct {
+	Kind       string    `json:"kind"`
+	Path       string    `json:"path"` // 相对路径：images/xxx 或 uploads/xxx
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// danglingRef 指向不存在文件的记录字段
+type danglingRef struct {
+	Record string `json:"record"` // history | task
+	ID     uint   `json:"id"`
+	Field  string `json:"field"` // image_url | ref_images
+	Path   string `json:"path"`
+}
+
+// storageUsage 文件数和字节数
+type storageUsage struct {
+	Files int64 `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+func (u *storageUsage) add(size int64) {
+	u.Files++
+	u.Bytes += size
+}
+
+// storageKindUsage 某一类文件的占用情况
+type storageKindUsage struct {
+	storageUsage
+	Referenced storageUsage `json:"referenced"`
+	Orphaned   storageUsage `json:"orphaned"`
+}
+
+// storageTypeUsage 按生成类型统计的占用
+type storageTypeUsage struct {
+	Type string `json:"type"`
+	storageUsage
+}
+
+// storageMonthUsage 按文件修改月份统计的占用
+type storageMonthUsage struct {
+	Month string `json:"month"` // 2006-01
+	storageUsage
+	Orphaned storageUsage `json:"orphaned"`
+}
+
+// storageScan 磁盘文件与数据库记录交叉比对的结果
+type storageScan struct {
+	files       []storageFile
+	refTypes    map[string]string // 被引用的路径 -> 第一个引用它的记录的生成类型
+	refCounts   map[string]int    // 被引用的路径 -> 引用它的任务和历史记录数
+	orphans     []storageFile
+	dangling    []danglingRef
+	staleStored []uint // 文件已不存在的 StoredFile 记录
+}
+
+// scanStorageDir 列出目录中的文件（不含子目录）
+func scanStorageDir(kind string) ([]storageFile, error) {
+	entries, err := os.ReadDir(storageDir(kind))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	files := make([]storageFile, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, storageFile{
+			Kind:       kind,
+			Path:       storagePrefix(kind) + entry.Name(),
+			Size:       info.Size(),
+			ModifiedAt: info.ModTime(),
+		})
+	}
+	return files, nil
+}
+
+// isStoragePath 路径是否位于输出目录或上传目录（其他路径不做存在性检查）
+func isStoragePath(path string) bool {
+	return filepath.Dir(path) == "images" || filepath.Dir(path) == "uploads"
+}
+
+// scanStorage 将输出目录、上传目录中的文件与任务和历史记录中的引用交叉比对
+// 孤立文件：没有任何任务或历史记录引用的文件
+// 失效引用：历史记录的图片（未清除）或任务、历史记录的参考图指向不存在的文件
+func scanStorage() (*storageScan, error) {
+	scan := &storageScan{refTypes: make(map[string]string), refCounts: make(map[string]int)}
+	for _, kind := range []string{models.StoredFileKindOutput, models.StoredFileKindUpload} {
+		files, err := scanStorageDir(kind)
+		if err != nil {
+			return nil, err
+		}
+		scan.files = append(scan.files, files...)
+	}
+	exists := make(map[string]bool, len(scan.files))
+	for _, f := range scan.files {
+		exists[f.Path] = true
+	}
+
+	// 同一条记录多次引用同一文件时只计一次
+	recordRefs := make(map[string]bool)
+	addRef := func(path, generationType string) {
+		if _, ok := scan.refTypes[path]; !ok {
+			scan.refTypes[path] = generationType
+		}
+		if !recordRefs[path] {
+			recordRefs[path] = true
+			scan.refCounts[path]++
+		}
+	}
+	checkRefImages := func(record string, id uint, refImages, generationType string) {
+		for _, path := range parseRefImagePaths(refImages) {
+			addRef(path, generationType)
+			if isStoragePath(path) && !exists[path] {
+				scan.dangling = append(scan.dangling, danglingRef{Record: record, ID: id, Field: "ref_images", Path: path})
+			}
+		}
+	}
+
+	var tasks []models.GenerationTask
+	err := config.DB.Select("id", "type", "ref_images", "image_url").
+		FindInBatches(&tasks, 1000, func(tx *gorm.DB, batch int) error {
+			for _, t := range tasks {
+				clear(recordRefs)
+				checkRefImages("task", t.ID, t.RefImages, t.Type)
+				if t.ImageURL != "" {
+					addRef(storageRefPath(t.ImageURL), t.Type)
+				}
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var histories []models.GenerationHistory
+	err = config.DB.Select("id", "type", "ref_images", "image_url", "image_deleted", "trashed_at").
+		FindInBatches(&histories, 1000, func(tx *gorm.DB, batch int) error {
+			for _, h := range histories {
+				clear(recordRefs)
+				checkRefImages("history", h.ID, h.RefImages, h.Type)
+				fileName := imageFileName(h.ImageURL)
+				if fileName == "" || h.ImageDeleted {
+					continue
+				}
+				path := "images/" + fileName
+				addRef(path, h.Type)
+				if exists[path] {
+					continue
+				}
+				// 回收站中的记录，图片在回收站目录中
+				if h.TrashedAt != nil {
+					if _, err := os.Stat(filepath.Join(config.TrashDir, fileName)); err == nil {
+						continue
+					}
+				}
+				scan.dangling = append(scan.dangling, danglingRef{Record: "history", ID: h.ID, Field: "image_url", Path: path})
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range scan.files {
+		if _, ok := scan.refTypes[f.Path]; !ok {
+			scan.orphans = append(scan.orphans, f)
+		}
+	}
+
+	var stored []models.StoredFile
+	if err := config.DB.Select("id", "path").Find(&stored).Error; err != nil {
+		return nil, err
+	}
+	for _, s := range stored {
+		if !exists[s.Path] {
+			scan.staleStored = append(scan.staleStored, s.ID)
+		}
+	}
+	return scan, nil
+}
+
+// StorageReport 存储占用报告
+type StorageReport struct {
+	Total      storageUsage                 `json:"total"`
+	ByKind     map[string]*storageKindUsage `json:"by_kind"`
+	ByType     []storageTypeUsage           `json:"by_type"`
+	ByMonth    []storageMonthUsage          `json:"by_month"`
+	References []storageFileRefs            `json:"references"` // 被引用的文件，按引用数从高到低
+	Orphans    storageOrphans               `json:"orphans"`
+	Dangling   storageDangling              `json:"dangling"`
+}
+
+// storageFileRefs 文件及引用它的任务和历史记录数（生成报告时根据 RefImages 和图片地址统计）
+type storageFileRefs struct {
+	storageFile
+	RefCount int `json:"ref_count"`
+}
+
+// storageOrphans 孤立文件（没有任何记录引用的文件）
+type storageOrphans struct {
+	storageUsage
+	Items []storageFile `json:"items"`
+}
+
+// storageDangling 失效引用（记录指向的文件不存在）
+type storageDangling struct {
+	Count       int           `json:"count"`
+	Items       []danglingRef `json:"items"`
+	StoredFiles int           `json:"stored_files"` // 文件已不存在的 StoredFile 记录数
+}
+
+// BuildStorageReport 生成存储占用报告：按文件类别、生成类型和月份统计，列出孤立文件和失效引用
+func BuildStorageReport() (*StorageReport, error) {
+	scan, err := scanStorage()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &StorageReport{
+		ByKind: map[string]*storageKindUsage{
+			models.StoredFileKindOutput: {},
+			models.StoredFileKindUpload: {},
+		},
+	}
+	typeUsage := make(map[string]*storageTypeUsage)
+	monthUsage := make(map[string]*storageMonthUsage)
+	for _, f := range scan.files {
+		report.Total.add(f.Size)
+		kind := report.ByKind[f.Kind]
+		kind.add(f.Size)
+
+		month := f.ModifiedAt.Format("2006-01")
+		if monthUsage[month] == nil {
+			monthUsage[month] = &storageMonthUsage{Month: month}
+		}
+		monthUsage[month].add(f.Size)
+
+		generationType, referenced := scan.refTypes[f.Path]
+		if !referenced {
+			kind.Orphaned.add(f.Size)
+			monthUsage[month].Orphaned.add(f.Size)
+			continue
+		}
+		kind.Referenced.add(f.Size)
+		report.References = append(report.References, storageFileRefs{storageFile: f, RefCount: scan.refCounts[f.Path]})
+		if typeUsage[generationType] == nil {
+			typeUsage[generationType] = &storageTypeUsage{Type: generationType}
+		}
+		typeUsage[generationType].add(f.Size)
+	}
+
+	report.ByType = make([]storageTypeUsage, 0, len(typeUsage))
+	for _, u := range typeUsage {
+		report.ByType = append(report.ByType, *u)
+	}
+	sort.Slice(report.ByType, func(i, j int) bool { return report.ByType[i].Bytes > report.ByType[j].Bytes })
+
+	report.ByMonth = make([]storageMonthUsage, 0, len(monthUsage))
+	for _, u := range monthUsage {
+		report.ByMonth = append(report.ByMonth, *u)
+	}
+	sort.Slice(report.ByMonth, func(i, j int) bool { return report.ByMonth[i].Month > report.ByMonth[j].Month })
+
+	sort.SliceStable(report.References, func(i, j int) bool {
+		a, b := report.References[i], report.References[j]
+		if a.RefCount != b.RefCount {
+			return a.RefCount > b.RefCount
+		}
+		return a.Path < b.Path
+	})
+	report.References = limitItems(report.References, storageReportItemLimit)
+
+	for _, f := range scan.orphans {
+		report.Orphans.add(f.Size)
+	}
+	report.Orphans.Items = limitItems(scan.orphans, storageReportItemLimit)
+	report.Dangling = storageDangling{
+		Count:       len(scan.dangling),
+		Items:       limitItems(scan.dangling, storageReportItemLimit),
+		StoredFiles: len(scan.staleStored),
+	}
+	return report, nil
+}
+
+// StorageReportHandler 存储占用报告
+// GET /storage/report
+func StorageReportHandler(c *gin.Context) {
+	report, err := BuildStorageReport()
+	if err != nil {
+		utils.LogAPI("生成存储报告失败: %v", err)
+		c.JSON(500, gin.H{"error": "生成存储报告失败"})
+		return
+	}
+	c.JSON(200, report)
+}
+
+// limitItems 截取前 limit 项，空列表返回空数组而不是 null
+func limitItems[T any](items []T, limit int) []T {
+	if len(items) > limit {
+		return items[:limit]
+	}
+	if items == nil {
+		return []T{}
+	}
+	return items
+}
+
+// StorageGCRequest 存储清理请求
+type StorageGCRequest struct {
+	RemoveOrphans  bool `json:"remove_orphans"`  // 删除孤立文件
+	RepairDangling bool `json:"repair_dangling"` // 修复失效引用
+	DryRun         bool `json:"dry_run"`         // 只统计将要执行的操作，不修改文件和数据库
+	MinAgeHours    *int `json:"min_age_hours"`   // 孤立文件的最短保留时间（小时），默认 24
+}
+
+// StorageGCResult 存储清理结果
+type StorageGCResult struct {
+	DryRun             bool  `json:"dry_run"`
+	RemovedFiles       int64 `json:"removed_files"`
+	ReclaimedBytes     int64 `json:"reclaimed_bytes"`
+	SkippedRecent      int64 `json:"skipped_recent"` // 未到最短保留时间而保留的孤立文件
+	RepairedHistories  int64 `json:"repaired_histories"`
+	RepairedTasks      int64 `json:"repaired_tasks"`
+	RemovedStoredFiles int64 `json:"removed_stored_files"` // 删除的文件已不存在的 StoredFile 记录
+}
+
+// RunStorageGC 删除孤立文件、修复失效引用
+// 历史记录的图片不存在时标记为图片已删除；参考图不存在时从 RefImages 中移除；
+// 文件已不存在的 StoredFile 记录删除
+func RunStorageGC(req StorageGCRequest) (*StorageGCResult, error) {
+	minAge := defaultOrphanMinAge
+	if req.MinAgeHours != nil && *req.MinAgeHours >= 0 {
+		minAge = time.Duration(*req.MinAgeHours) * time.Hour
+	}
+
+	scan, err := scanStorage()
+	if err != nil {
+		return nil, err
+	}
+	result := &StorageGCResult{DryRun: req.DryRun}
+
+	if req.RemoveOrphans {
+		cutoff := time.Now().Add(-minAge)
+		var removedPaths []string
+		for _, f := range scan.orphans {
+			if f.ModifiedAt.After(cutoff) {
+				result.SkippedRecent++
+				continue
+			}
+			if !req.DryRun {
+				path := filepath.Join(storageDir(f.Kind), filepath.Base(f.Path))
+				if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+					utils.LogAPI("删除孤立文件失败: %s, 错误: %v", path, err)
+					continue
+				}
+				removedPaths = append(removedPaths, f.Path)
+			}
+			result.RemovedFiles++
+			result.ReclaimedBytes += f.Size
+		}
+		if len(removedPaths) > 0 {
+			if err := config.DB.Where("path IN ?", removedPaths).Delete(&models.StoredFile{}).Error; err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if req.RepairDangling {
+		if err := repairDanglingRefs(scan, req.DryRun, result); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// repairDanglingRefs 修复失效引用
+func repairDanglingRefs(scan *storageScan, dryRun bool, result *StorageGCResult) error {
+	missingImages := make(map[uint]bool)
+	missingRefs := map[string]map[uint]map[string]bool{"history": {}, "task": {}}
+	for _, d := range scan.dangling {
+		if d.Field == "image_url" {
+			missingImages[d.ID] = true
+			continue
+		}
+		if missingRefs[d.Record][d.ID] == nil {
+			missingRefs[d.Record][d.ID] = make(map[string]bool)
+		}
+		missingRefs[d.Record][d.ID][d.Path] = true
+	}
+
+	repairedHistories := make(map[uint]bool)
+	for id := range missingImages {
+		repairedHistories[id] = true
+	}
+	for id := range missingRefs["history"] {
+		repairedHistories[id] = true
+	}
+	result.RepairedHistories = int64(len(repairedHistories))
+	result.RepairedTasks = int64(len(missingRefs["task"]))
+	result.RemovedStoredFiles = int64(len(scan.staleStored))
+	if dryRun {
+		return nil
+	}
+
+	if len(missingImages) > 0 {
+		ids := make([]uint, 0, len(missingImages))
+		for id := range missingImages {
+			ids = append(ids, id)
+		}
+		if err := config.DB.Model(&models.GenerationHistory{}).Where("id IN ?", ids).Update("image_deleted", true).Error; err != nil {
+			return err
+		}
+	}
+	for id, missing := range missingRefs["history"] {
+		var h models.GenerationHistory
+		if err := config.DB.Select("id", "ref_images").First(&h, id).Error; err != nil {
+			continue
+		}
+		if err := config.DB.Model(&h).Update("ref_images", removeRefImages(h.RefImages, missing)).Error; err != nil {
+			return err
+		}
+	}
+	for id, missing := range missingRefs["task"] {
+		var t models.GenerationTask
+		if err := config.DB.Select("id", "ref_images").First(&t, id).Error; err != nil {
+			continue
+		}
+		if err := config.DB.Model(&t).Update("ref_images", removeRefImages(t.RefImages, missing)).Error; err != nil {
+			return err
+		}
+	}
+	if len(scan.staleStored) > 0 {
+		if err := config.DB.Delete(&models.StoredFile{}, scan.staleStored).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeRefImages 从 RefImages JSON 中移除指定路径的参考图
+func removeRefImages(refImagesJSON string, missing map[string]bool) string {
+	var refs []string
+	if err := json.Unmarshal([]byte(refImagesJSON), &refs); err != nil {
+		return refImagesJSON
+	}
+	kept := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		if !missing[storageRefPath(ref)] {
+			kept = append(kept, ref)
+		}
+	}
+	data, _ := json.Marshal(kept)
+	return string(data)
+}
+
+// StorageGCHandler 清理孤立文件、修复失效引用
+// POST /storage/gc {"remove_orphans": true, "repair_dangling": true, "dry_run": false, "min_age_hours": 24}
+func StorageGCHandler(c *gin.Context) {
+	var req StorageGCRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "请求参数错误"})
+		return
+	}
+	if !req.RemoveOrphans && !req.RepairDangling {
+		c.JSON(400, gin.H{"error": "请选择要执行的操作（remove_orphans 或 repair_dangling）"})
+		return
+	}
+
+	result, err := RunStorageGC(req)
+	if err != nil {
+		utils.LogAPI("存储清理失败: %v", err)
+		c.JSON(500, gin.H{"error": "存储清理失败"})
+		return
+	}
+	if !req.DryRun {
+		utils.LogAPI("存储清理完成: 删除 %d 个孤立文件 (%d bytes)，修复 %d 条历史记录、%d 个任务",
+			result.RemovedFiles, result.ReclaimedBytes, result.RepairedHistories, result.RepairedTasks)
+	}
+	c.JSON(200, result)
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"sigma/config"
+	"sigma/models"
+)
+
+type storageReportResponse struct {
+	Total      storageUsage                `json:"total"`
+	ByKind     map[string]storageKindUsage `json:"by_kind"`
+	ByType     []storageTypeUsage          `json:"by_type"`
+	References []storageFileRefs           `json:"references"`
+	Orphans    struct {
+		Files int64         `json:"files"`
+		Items []storageFile `json:"items"`
+	} `json:"orphans"`
+	Dangling struct {
+		Count       int           `json:"count"`
+		Items       []danglingRef `json:"items"`
+		StoredFiles int           `json:"stored_files"`
+	} `json:"dangling"`
+}
+
+// setupStorageGCTest 准备输出目录和上传目录：
+// images/used.png、uploads/ref.png 被引用，images/orphan.png 为较早的孤立文件，uploads/fresh.png 为刚写入的孤立文件；
+// 历史记录 2 的图片和任务的第二张参考图不存在
+func setupStorageGCTest(t *testing.T) *gin.Engine {
+	var err error
+	config.DB, err = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("无法创建测试数据库: %v", err)
+	}
+	config.DB.AutoMigrate(&models.GenerationHistory{}, &models.GenerationTask{}, &models.StoredFile{}, &models.HistoryTag{})
+	t.Cleanup(func() {
+		sqlDB, _ := config.DB.DB()
+		sqlDB.Close()
+	})
+	config.OutputDir = t.TempDir()
+	config.UploadDir = t.TempDir()
+	config.TrashDir = t.TempDir()
+
+	old := time.Now().Add(-48 * time.Hour)
+	files := map[string]string{
+		filepath.Join(config.OutputDir, "used.png"):   "used",
+		filepath.Join(config.OutputDir, "orphan.png"): "orphan!",
+		filepath.Join(config.UploadDir, "ref.png"):    "ref",
+		filepath.Join(config.UploadDir, "fresh.png"):  "fresh",
+	}
+	for path, content := range files {
+		os.WriteFile(path, []byte(content), 0644)
+		if filepath.Base(path) != "fresh.png" {
+			os.Chtimes(path, old, old)
+		}
+	}
+
+	config.DB.Create(&models.GenerationHistory{Prompt: "a", ImageURL: "images/used.png", RefImages: `["http://localhost:8080/uploads/ref.png"]`, Type: models.GenerationTypeWhiteBackground})
+	config.DB.Create(&models.GenerationHistory{Prompt: "b", ImageURL: "images/missing.png", Type: models.GenerationTypeCreate})
+	config.DB.Create(&models.GenerationTask{TaskID: "t1", Type: models.GenerationTypeWhiteBackground, RefImages: `["uploads/ref.png","uploads/gone.png"]`, StartedAt: time.Now()})
+	config.DB.Create(&models.StoredFile{Kind: models.StoredFileKindUpload, Hash: "gone", Path: "uploads/gone.png"})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/storage/report", StorageReportHandler)
+	r.POST("/storage/gc", StorageGCHandler)
+	return r
+}
+
+func getStorageReport(t *testing.T, r *gin.Engine) storageReportResponse {
+	w := doJSON(r, "GET", "/storage/report", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("获取存储报告期望状态码 200，实际为 %d: %s", w.Code, w.Body.String())
+	}
+	var report storageReportResponse
+	json.Unmarshal(w.Body.Bytes(), &report)
+	return report
+}
+
+func TestStorageReport_OrphansAndDangling(t *testing.T) {
+	r := setupStorageGCTest(t)
+
+	report := getStorageReport(t, r)
+	if report.Total.Files != 4 || report.Total.Bytes != int64(len("used")+len("orphan!")+len("ref")+len("fresh")) {
+		t.Errorf("总占用不正确: %+v", report.Total)
+	}
+	if output := report.ByKind[models.StoredFileKindOutput]; output.Referenced.Files != 1 || output.Orphaned.Bytes != int64(len("orphan!")) {
+		t.Errorf("输出目录统计不正确: %+v", output)
+	}
+	if report.Orphans.Files != 2 {
+		t.Errorf("期望 2 个孤立文件，实际为 %+v", report.Orphans)
+	}
+	if len(report.ByType) != 1 || report.ByType[0].Type != models.GenerationTypeWhiteBackground || report.ByType[0].Files != 2 {
+		t.Errorf("按类型统计不正确: %+v", report.ByType)
+	}
+	if report.Dangling.Count != 2 || report.Dangling.StoredFiles != 1 {
+		t.Errorf("失效引用不正确: %+v", report.Dangling)
+	}
+	// 参考图被历史记录和任务各引用一次，输出图片被一条历史记录引用
+	refs := report.References
+	if len(refs) != 2 || refs[0].Path != "uploads/ref.png" || refs[0].RefCount != 2 || refs[1].Path != "images/used.png" || refs[1].RefCount != 1 {
+		t.Errorf("文件引用数不正确: %+v", refs)
+	}
+}
+
+func TestStorageGC_DryRunThenReclaimAndRepair(t *testing.T) {
+	r := setupStorageGCTest(t)
+
+	body := `{"remove_orphans": true, "repair_dangling": true, "dry_run": true}`
+	w := doJSON(r, "POST", "/storage/gc", body)
+	var result StorageGCResult
+	json.Unmarshal(w.Body.Bytes(), &result)
+	if w.Code != http.StatusOK || result.RemovedFiles != 1 || result.SkippedRecent != 1 || result.RepairedHistories != 1 || result.RepairedTasks != 1 {
+		t.Fatalf("预览结果不正确: %d %s", w.Code, w.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(config.OutputDir, "orphan.png")); err != nil {
+		t.Fatalf("预览不应删除文件: %v", err)
+	}
+
+	w = doJSON(r, "POST", "/storage/gc", `{"remove_orphans": true, "repair_dangling": true}`)
+	json.Unmarshal(w.Body.Bytes(), &result)
+	if result.RemovedFiles != 1 || result.ReclaimedBytes != int64(len("orphan!")) || result.RemovedStoredFiles != 1 {
+		t.Fatalf("清理结果不正确: %s", w.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(config.OutputDir, "orphan.png")); !os.IsNotExist(err) {
+		t.Errorf("较早的孤立文件应已删除")
+	}
+	if _, err := os.Stat(filepath.Join(config.UploadDir, "fresh.png")); err != nil {
+		t.Errorf("较新的孤立文件应保留: %v", err)
+	}
+
+	var history models.GenerationHistory
+	config.DB.Where("image_url = ?", "images/missing.png").First(&history)
+	if !history.ImageDeleted {
+		t.Errorf("图片不存在的历史记录应标记为图片已删除")
+	}
+	var task models.GenerationTask
+	config.DB.Where("task_id = ?", "t1").First(&task)
+	if task.RefImages != `["uploads/ref.png"]` {
+		t.Errorf("任务中不存在的参考图应已移除: %s", task.RefImages)
+	}
+
+	report := getStorageReport(t, r)
+	if report.Dangling.Count != 0 || report.Dangling.StoredFiles != 0 || report.Orphans.Files != 1 {
+		t.Errorf("清理后报告不正确: %+v %+v", report.Dangling, report.Orphans)
+	}
+
+	if w := doJSON(r, "POST", "/storage/gc", `{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("未选择操作应返回 400，实际为 %d", w.Code)
+	}
+}
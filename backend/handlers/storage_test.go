@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sigma/config"
+	"sigma/models"
+)
+
+// pngHeader 最小的 PNG 文件头，用于按内容识别扩展名
+var pngHeader = []byte("\x89PNG\r\n\x1a\n")
+
+func TestStoreFile_DeduplicatesByContent(t *testing.T) {
+	cleanup := setupGenerateTestDB(t)
+	defer cleanup()
+	config.UploadDir = t.TempDir()
+
+	data := append(append([]byte{}, pngHeader...), []byte("product photo")...)
+	first, err := storeFile(models.StoredFileKindUpload, data, ".JPEG")
+	if err != nil {
+		t.Fatalf("保存文件失败: %v", err)
+	}
+	if filepath.Ext(first) != ".png" || filepath.Dir(first) != "uploads" {
+		t.Errorf("应按内容识别为 PNG 并保存到 uploads: %s", first)
+	}
+
+	// 相同内容（即使文件名不同）复用已保存的文件
+	second, err := storeFile(models.StoredFileKindUpload, data, ".png")
+	if err != nil || second != first {
+		t.Errorf("相同内容应复用 %s，实际为 %s (%v)", first, second, err)
+	}
+	other, _ := storeFile(models.StoredFileKindUpload, []byte("another photo"), ".jpg")
+	if other == first || filepath.Ext(other) != ".jpg" {
+		t.Errorf("不同内容应保存为新文件: %s", other)
+	}
+
+	entries, _ := os.ReadDir(config.UploadDir)
+	var count int64
+	config.DB.Model(&models.StoredFile{}).Count(&count)
+	if len(entries) != 2 || count != 2 {
+		t.Errorf("期望 2 个文件和 2 条记录，实际为 %d / %d", len(entries), count)
+	}
+
+	// 文件被手动删除后，再次上传相同内容时重新写入
+	os.Remove(filepath.Join(config.UploadDir, filepath.Base(first)))
+	if again, err := storeFile(models.StoredFileKindUpload, data, ".png"); err != nil || again != first {
+		t.Fatalf("重新写入失败: %s (%v)", again, err)
+	}
+	if _, err := os.Stat(filepath.Join(config.UploadDir, filepath.Base(first))); err != nil {
+		t.Errorf("文件应已重新写入: %v", err)
+	}
+}
+
+func TestGenerate_ReusesUploadedRefImages(t *testing.T) {
+	r, cleanup := setupGenerationTypeTest(t)
+	defer cleanup()
+
+	originalToken := config.GetAPIToken()
+	config.SetAPIToken("test-api-key")
+	defer config.SetAPIToken(originalToken)
+
+	var refs [][]string
+	for i := 0; i < 2; i++ {
+		w := postGenerate(r, map[string]string{"type": models.GenerationTypeWhiteBackground}, 1)
+		if w.Code != http.StatusOK {
+			t.Fatalf("期望状态码 200，实际为 %d: %s", w.Code, w.Body.String())
+		}
+		var response struct {
+			TaskID string `json:"task_id"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		var task models.GenerationTask
+		config.DB.Where("task_id = ?", response.TaskID).First(&task)
+		refs = append(refs, parseRefImagePaths(task.RefImages))
+	}
+	if len(refs[0]) != 1 || refs[0][0] != refs[1][0] {
+		t.Fatalf("相同的参考图应复用同一文件: %v", refs)
+	}
+	if entries, _ := os.ReadDir(config.UploadDir); len(entries) != 1 {
+		t.Errorf("上传目录中应只有 1 个文件，实际为 %d", len(entries))
+	}
+
+	var stored int64
+	config.DB.Model(&models.StoredFile{}).Where("path = ?", refs[0][0]).Count(&stored)
+	if stored != 1 {
+		t.Errorf("相同内容只应有 1 条文件记录，实际为 %d", stored)
+	}
+}
+
+func TestTrash_KeepsSharedOutputFile(t *testing.T) {
+	cleanup := setupHistoryTestDB(t)
+	defer cleanup()
+	r := setupTrashTest(t)
+
+	// 两条记录的图片内容相同，共用一个文件
+	os.WriteFile(filepath.Join(config.OutputDir, "shared.png"), []byte("png"), 0644)
+	a := models.GenerationHistory{Prompt: "a", ImageURL: "images/shared.png", Type: models.GenerationTypeCreate}
+	b := models.GenerationHistory{Prompt: "b", ImageURL: "images/shared.png", Type: models.GenerationTypeCreate}
+	config.DB.Create(&a)
+	config.DB.Create(&b)
+
+	doJSON(r, "DELETE", fmt.Sprintf("/history/%d", a.ID), "")
+	if _, err := os.Stat(filepath.Join(config.OutputDir, "shared.png")); err != nil {
+		t.Fatalf("仍被使用的文件应留在输出目录: %v", err)
+	}
+
+	doJSON(r, "DELETE", fmt.Sprintf("/history/%d", b.ID), "")
+	if _, err := os.Stat(filepath.Join(config.TrashDir, "shared.png")); err != nil {
+		t.Fatalf("最后一条记录删除后文件应移入回收站: %v", err)
+	}
+
+	// 恢复其中一条后，另一条仍可恢复
+	doJSON(r, "POST", "/trash/restore", fmt.Sprintf(`{"ids": [%d]}`, a.ID))
+	w := doJSON(r, "POST", "/trash/restore", fmt.Sprintf(`{"ids": [%d]}`, b.ID))
+	var restore struct {
+		Restored []uint `json:"restored"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &restore)
+	if len(restore.Restored) != 1 {
+		t.Errorf("共用文件的记录应都能恢复: %s", w.Body.String())
+	}
+}
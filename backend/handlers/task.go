@@ -1,27 +1,49 @@
 package handlers
 
 import (
+	"errors"
 	"time"
 
 	"sigma/config"
+	"sigma/events"
 	"sigma/models"
+	"sigma/providers"
 	"sigma/utils"
 
 	"github.com/gin-gonic/gin"
 )
 
-// TaskTimeoutDuration 任务超时时间（20分钟）
-// 给 AI API 足够的处理时间（15分钟）+ 5分钟缓冲
-const TaskTimeoutDuration = 20 * time.Minute
+// taskTimeoutGrace 任务超时判定在最长执行时间之外额外留出的缓冲
+const taskTimeoutGrace = 5 * time.Minute
 
-// GetProcessingTasks 获取正在处理的任务
+// taskTimeoutMessage 超时任务的错误信息
+const taskTimeoutMessage = "任务超时"
+
+// errTaskTimedOut 超时清理中断任务时使用的取消原因
+var errTaskTimedOut = errors.New(taskTimeoutMessage)
+
+// TaskTimeoutDuration 返回任务的超时时间
+// 按当前重试策略计算：每次尝试的上游请求与图片下载超时 × 最大尝试次数 + 各次退避上限 + 缓冲，
+// 保证仍在正常重试中的任务不会被判定为超时
+func TaskTimeoutDuration() time.Duration {
+	perAttempt := providers.RequestTimeout + imageDownloadTimeout
+	return currentRetryPolicy().maxDuration(perAttempt) + taskTimeoutGrace
+}
+
+// GetProcessingTasks 获取正在处理的任务（包含排队中的任务）
+// 多图批次只返回父任务，逐张进度通过 GET /tasks/:id 获取；批量导入任务通过 GET /import/jobs/:id 查看
 // GET /tasks/processing?type=create
 func GetProcessingTasks(c *gin.Context) {
 	taskType := c.Query("type")
+	if taskType != "" && !isKnownGenerationType(taskType) {
+		c.JSON(400, gin.H{"error": unknownGenerationTypeError(taskType).Error()})
+		return
+	}
 
 	var tasks []models.GenerationTask
 	query := config.DB.Model(&models.GenerationTask{}).
-		Where("status = ?", models.TaskStatusProcessing)
+		Where("status IN ?", []models.TaskStatus{models.TaskStatusQueued, models.TaskStatusProcessing}).
+		Where("parent_task_id = '' AND import_job_id = ''")
 
 	// 按类型筛选
 	if taskType != "" {
@@ -49,6 +71,7 @@ func GetProcessingTasks(c *gin.Context) {
 }
 
 // GetTaskStatus 获取单个任务状态
+// 多图批次的父任务额外返回 images 字段，包含每张图片的进度（按 page / page_size 分页，总数为 image_count）
 // GET /tasks/:id
 func GetTaskStatus(c *gin.Context) {
 	taskID := c.Param("id")
@@ -68,21 +91,139 @@ func GetTaskStatus(c *gin.Context) {
 	resp := task.ToResponse()
 	resp.ImageURL = utils.ToAbsoluteURL(resp.ImageURL, config.ServerPort)
 	resp.RefImages = utils.ConvertRefImagesJSON(resp.RefImages, config.ServerPort, false)
+	if task.IsBatchParent() {
+		page, pageSize := parsePageParams(c)
+		children, err := loadBatchChildrenPage(task.TaskID, page, pageSize)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "获取批次进度失败"})
+			return
+		}
+		resp.Images = batchProgress(children)
+	}
 
 	c.JSON(200, resp)
 }
 
-// CleanupStaleTasks 清理超时的任务
-// 将处理超过5分钟的任务标记为失败
-func CleanupStaleTasks() (int64, error) {
-	timeoutThreshold := time.Now().Add(-TaskTimeoutDuration)
+// CancelTask 取消排队中或处理中的任务
+// POST /tasks/:id/cancel
+func CancelTask(c *gin.Context) {
+	taskID := c.Param("id")
+	if taskID == "" {
+		c.JSON(400, gin.H{"error": "任务ID不能为空"})
+		return
+	}
+
+	var task models.GenerationTask
+	if result := config.DB.Where("task_id = ?", taskID).First(&task); result.Error != nil {
+		c.JSON(404, gin.H{"error": "任务不存在"})
+		return
+	}
+
+	if !cancelTask(&task) {
+		c.JSON(409, gin.H{"error": "任务已结束，无法取消"})
+		return
+	}
+
+	c.JSON(200, task.ToResponse())
+}
+
+// CancelAllTasks 取消所有排队中或处理中的任务，可按类型筛选
+// POST /tasks/cancel-all?type=create
+func CancelAllTasks(c *gin.Context) {
+	taskType := c.Query("type")
+	if taskType != "" && !isKnownGenerationType(taskType) {
+		c.JSON(400, gin.H{"error": unknownGenerationTypeError(taskType).Error()})
+		return
+	}
+
+	var tasks []models.GenerationTask
+	query := config.DB.Where("status IN ?", []models.TaskStatus{models.TaskStatusQueued, models.TaskStatusProcessing})
+	if taskType != "" {
+		query = query.Where("type = ?", taskType)
+	}
+	if result := query.Find(&tasks); result.Error != nil {
+		c.JSON(500, gin.H{"error": "获取任务失败"})
+		return
+	}
+
+	cancelled := 0
+	for i := range tasks {
+		if cancelTask(&tasks[i]) {
+			cancelled++
+		}
+	}
+
+	utils.LogAPI("批量取消任务: type=%s, cancelled=%d", taskType, cancelled)
+	c.JSON(200, gin.H{"cancelled": cancelled})
+}
+
+// cancelTask 取消任务：先中断运行中的上游请求，再原子地更新任务状态
+// 取消批次父任务会同时取消其未结束的子任务；任务已结束（或在取消过程中刚好结束）时返回 false
+func cancelTask(task *models.GenerationTask) bool {
+	if !task.CancelTask() {
+		return false
+	}
+
+	getTaskQueue().cancel(task.TaskID)
 
 	result := config.DB.Model(&models.GenerationTask{}).
-		Where("status = ? AND started_at < ?", models.TaskStatusProcessing, timeoutThreshold).
+		Where("task_id = ? AND status IN ?", task.TaskID, []models.TaskStatus{models.TaskStatusQueued, models.TaskStatusProcessing}).
 		Updates(map[string]interface{}{
-			"status":    models.TaskStatusFailed,
-			"error_msg": "任务超时",
+			"status":      task.Status,
+			"error_msg":   task.ErrorMsg,
+			"finished_at": task.FinishedAt,
 		})
+	if result.Error != nil || result.RowsAffected != 1 {
+		return false
+	}
+	publishTaskEvent(events.TaskCancelled, task)
+
+	if task.IsBatchParent() {
+		cancelBatchChildren(task.TaskID)
+	} else if task.ParentTaskID != "" {
+		refreshBatchParent(task.ParentTaskID)
+	}
+	return true
+}
+
+// CleanupStaleTasks 清理超时的任务
+// 将处理超过 TaskTimeoutDuration() 的任务标记为失败，并发布失败事件；本进程中仍在运行的任务先中断其上下文，停止上游请求和后续重试
+// 排队中的任务尚未开始执行，不会被清理；批次父任务的状态由子任务汇总，也不在此清理
+func CleanupStaleTasks() (int64, error) {
+	timeoutThreshold := time.Now().Add(-TaskTimeoutDuration())
+
+	var stale []models.GenerationTask
+	if err := config.DB.Where("status = ? AND started_at < ?", models.TaskStatusProcessing, timeoutThreshold).
+		Where("batch_id = '' OR parent_task_id <> ''").
+		Find(&stale).Error; err != nil {
+		return 0, err
+	}
+
+	var cleaned int64
+	for i := range stale {
+		task := &stale[i]
+		getTaskQueue().expire(task.TaskID)
+		if !task.FailTask(taskTimeoutMessage) {
+			continue
+		}
+		result := config.DB.Model(&models.GenerationTask{}).
+			Where("id = ? AND status = ?", task.ID, models.TaskStatusProcessing).
+			Updates(map[string]interface{}{
+				"status":      task.Status,
+				"error_msg":   task.ErrorMsg,
+				"finished_at": task.FinishedAt,
+			})
+		if result.Error != nil {
+			return cleaned, result.Error
+		}
+		if result.RowsAffected == 1 {
+			cleaned++
+			publishTaskEvent(events.TaskFailed, task)
+			if task.ParentTaskID != "" {
+				refreshBatchParent(task.ParentTaskID)
+			}
+		}
+	}
 
-	return result.RowsAffected, result.Error
+	return cleaned, nil
 }
@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -14,6 +16,7 @@ import (
 	"gorm.io/gorm"
 	"sigma/config"
 	"sigma/models"
+	"sigma/providers"
 )
 
 func setupTaskTestDB(t *testing.T) func() {
@@ -22,6 +25,10 @@ func setupTaskTestDB(t *testing.T) func() {
 	if err != nil {
 		t.Fatalf("无法创建测试数据库: %v", err)
 	}
+	// :memory: 数据库每个连接都是独立的库，事务与后台任务必须复用同一连接
+	if sqlDB, err := config.DB.DB(); err == nil {
+		sqlDB.SetMaxOpenConns(1)
+	}
 	config.DB.AutoMigrate(&models.GenerationTask{})
 
 	return func() {
@@ -224,26 +231,40 @@ func TestGetProcessingTasks_Empty(t *testing.T) {
 
 
 // **Feature: generation-persistence, Property 6: Task Timeout Cleanup**
-// *For any* task that has been in "processing" status for more than 5 minutes,
+// *For any* task that has been in "processing" status for longer than TaskTimeoutDuration,
 // the task SHALL be marked as "failed" with a timeout error message.
+// Queued tasks have not started yet and SHALL NOT be swept.
 // **Validates: Requirements 3.1, 3.2**
 func TestProperty_TaskTimeoutCleanup(t *testing.T) {
 	cleanup := setupTaskTestDB(t)
 	defer cleanup()
 
 	// Property: For any set of processing tasks with varying start times,
-	// CleanupStaleTasks marks exactly those tasks older than 5 minutes as failed
-	property := func(numRecent, numStale uint8) bool {
+	// CleanupStaleTasks marks exactly those tasks older than TaskTimeoutDuration as failed
+	property := func(numRecent, numStale, numQueued uint8) bool {
 		// Limit to reasonable numbers
 		numRecent = numRecent % 10
 		numStale = numStale % 10
+		numQueued = numQueued % 10
 
 		// Clean up previous test data
 		config.DB.Exec("DELETE FROM generation_tasks")
 
 		now := time.Now()
-		staleTime := now.Add(-6 * time.Minute) // 6 minutes ago (stale)
-		recentTime := now.Add(-2 * time.Minute) // 2 minutes ago (recent)
+		staleTime := now.Add(-TaskTimeoutDuration() - time.Minute) // past the timeout (stale)
+		recentTime := now.Add(-2 * time.Minute)                  // 2 minutes ago (recent)
+
+		// Create old queued tasks (should NOT be cleaned up)
+		for i := uint8(0); i < numQueued; i++ {
+			task := models.GenerationTask{
+				TaskID:    uuid.New().String(),
+				Status:    models.TaskStatusQueued,
+				Type:      models.GenerationTypeCreate,
+				Prompt:    "queued task",
+				StartedAt: staleTime,
+			}
+			config.DB.Create(&task)
+		}
 
 		// Create recent processing tasks (should NOT be cleaned up)
 		for i := uint8(0); i < numRecent; i++ {
@@ -302,6 +323,13 @@ func TestProperty_TaskTimeoutCleanup(t *testing.T) {
 			}
 		}
 
+		// 4. Queued tasks are untouched
+		var queuedCount int64
+		config.DB.Model(&models.GenerationTask{}).Where("prompt = ? AND status = ?", "queued task", models.TaskStatusQueued).Count(&queuedCount)
+		if queuedCount != int64(numQueued) {
+			return false
+		}
+
 		return true
 	}
 
@@ -367,3 +395,135 @@ func TestCleanupStaleTasks_IgnoresNonProcessingTasks(t *testing.T) {
 		t.Errorf("已失败任务错误信息不应改变")
 	}
 }
+
+func TestCleanupStaleTasks_AbortsRunningTask(t *testing.T) {
+	cleanup := setupTaskTestDB(t)
+	defer cleanup()
+
+	taskID := uuid.New().String()
+	config.DB.Create(&models.GenerationTask{
+		TaskID:    taskID,
+		Status:    models.TaskStatusProcessing,
+		Type:      models.GenerationTypeCreate,
+		StartedAt: time.Now().Add(-TaskTimeoutDuration() - time.Minute),
+	})
+	ctx, done := getTaskQueue().track(taskID)
+	defer done()
+
+	if _, err := CleanupStaleTasks(); err != nil {
+		t.Fatalf("CleanupStaleTasks failed: %v", err)
+	}
+
+	// 运行中的任务被中断，取消原因标明是超时，工作协程据此按失败处理
+	if !errors.Is(context.Cause(ctx), errTaskTimedOut) {
+		t.Errorf("超时任务的 context 应以超时原因取消，实际为 %v", context.Cause(ctx))
+	}
+
+	var stored models.GenerationTask
+	config.DB.Where("task_id = ?", taskID).First(&stored)
+	if stored.Status != models.TaskStatusFailed || stored.ErrorMsg != taskTimeoutMessage {
+		t.Errorf("期望任务因超时失败，实际为 %s（%s）", stored.Status, stored.ErrorMsg)
+	}
+}
+
+func TestTaskTimeoutDuration_FollowsRetryPolicy(t *testing.T) {
+	oldAttempts, oldBase, oldMax := config.RetryMaxAttempts, config.RetryBaseDelay, config.RetryMaxDelay
+	defer func() {
+		config.RetryMaxAttempts, config.RetryBaseDelay, config.RetryMaxDelay = oldAttempts, oldBase, oldMax
+	}()
+
+	perAttempt := providers.RequestTimeout + imageDownloadTimeout
+
+	config.RetryMaxAttempts, config.RetryBaseDelay, config.RetryMaxDelay = 1, 2*time.Second, 30*time.Second
+	if got, want := TaskTimeoutDuration(), perAttempt+taskTimeoutGrace; got != want {
+		t.Errorf("单次尝试期望超时 %v，实际为 %v", want, got)
+	}
+
+	// 4 次尝试，退避 2s、4s，第三次封顶为 5s
+	config.RetryMaxAttempts, config.RetryBaseDelay, config.RetryMaxDelay = 4, 2*time.Second, 5*time.Second
+	if got, want := TaskTimeoutDuration(), 4*perAttempt+11*time.Second+taskTimeoutGrace; got != want {
+		t.Errorf("多次尝试期望超时 %v，实际为 %v", want, got)
+	}
+}
+
+func TestCancelTask_AbortsRunningTask(t *testing.T) {
+	cleanup := setupTaskTestDB(t)
+	defer cleanup()
+
+	gin.SetMode(gin.TestMode)
+
+	taskID := uuid.New().String()
+	config.DB.Create(&models.GenerationTask{
+		TaskID:    taskID,
+		Status:    models.TaskStatusProcessing,
+		Type:      models.GenerationTypeCreate,
+		StartedAt: time.Now(),
+	})
+	ctx, done := getTaskQueue().track(taskID)
+	defer done()
+
+	r := gin.New()
+	r.POST("/tasks/:id/cancel", CancelTask)
+
+	req, _ := http.NewRequest("POST", "/tasks/"+taskID+"/cancel", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("期望状态码 200，实际为 %d", w.Code)
+	}
+	if ctx.Err() == nil {
+		t.Error("取消后运行中任务的 context 应被取消")
+	}
+
+	var stored models.GenerationTask
+	config.DB.Where("task_id = ?", taskID).First(&stored)
+	if stored.Status != models.TaskStatusCancelled {
+		t.Errorf("期望状态为 cancelled，实际为 %s", stored.Status)
+	}
+
+	// 已取消的任务不能再次取消
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusConflict {
+		t.Errorf("重复取消期望状态码 409，实际为 %d", w.Code)
+	}
+}
+
+func TestCancelAllTasks_FiltersByType(t *testing.T) {
+	cleanup := setupTaskTestDB(t)
+	defer cleanup()
+
+	gin.SetMode(gin.TestMode)
+
+	tasks := []models.GenerationTask{
+		{TaskID: uuid.New().String(), Status: models.TaskStatusQueued, Type: models.GenerationTypeCreate, StartedAt: time.Now()},
+		{TaskID: uuid.New().String(), Status: models.TaskStatusProcessing, Type: models.GenerationTypeCreate, StartedAt: time.Now()},
+		{TaskID: uuid.New().String(), Status: models.TaskStatusCompleted, Type: models.GenerationTypeCreate, StartedAt: time.Now()},
+		{TaskID: uuid.New().String(), Status: models.TaskStatusQueued, Type: models.GenerationTypeWhiteBackground, StartedAt: time.Now()},
+	}
+	for i := range tasks {
+		config.DB.Create(&tasks[i])
+	}
+
+	r := gin.New()
+	r.POST("/tasks/cancel-all", CancelAllTasks)
+
+	req, _ := http.NewRequest("POST", "/tasks/cancel-all?type="+models.GenerationTypeCreate, nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var response struct {
+		Cancelled int `json:"cancelled"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if response.Cancelled != 2 {
+		t.Errorf("期望取消 2 个任务，实际为 %d", response.Cancelled)
+	}
+
+	var stored models.GenerationTask
+	config.DB.Where("task_id = ?", tasks[3].TaskID).First(&stored)
+	if stored.Status != models.TaskStatusQueued {
+		t.Errorf("其他类型的任务不应被取消，实际状态为 %s", stored.Status)
+	}
+}
@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"sigma/config"
+	"sigma/models"
+	"sigma/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// trashItem 回收站中的记录，附带回收站内图片的预览地址和计划清除时间
+type trashItem struct {
+	models.GenerationHistoryResponse
+	PreviewURL string    `json:"preview_url"`
+	PurgeAt    time.Time `json:"purge_at"`
+}
+
+// trashPurgeTime 记录计划被清除的时间
+func trashPurgeTime(trashedAt time.Time) time.Time {
+	return trashedAt.AddDate(0, 0, config.TrashRetentionDays)
+}
+
+// moveFile 移动文件，跨文件系统时改为复制后删除
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil || errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		in.Close()
+		return err
+	}
+	_, err = io.Copy(out, in)
+	in.Close()
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// trashHistories 将记录的图片移入回收站并记录移入时间，返回移入的记录数
+// 已在回收站或图片已清除的记录忽略；图片文件不存在时记录仍移入回收站；
+// 内容相同的图片共用一个文件，仍被其他记录使用时文件留在输出目录
+func trashHistories(histories []models.GenerationHistory) (int64, error) {
+	if err := os.MkdirAll(config.TrashDir, 0755); err != nil {
+		return 0, err
+	}
+
+	var candidates []uint
+	for _, h := range histories {
+		candidates = append(candidates, h.ID)
+	}
+
+	var ids []uint
+	moved := make(map[string]string) // 回收站路径 -> 原路径，用于数据库更新失败时撤销
+	for _, h := range histories {
+		if h.ImageDeleted || h.TrashedAt != nil {
+			continue
+		}
+		if fileName := imageFileName(h.ImageURL); fileName != "" && !outputImageInUse(fileName, candidates, false) {
+			src := filepath.Join(config.OutputDir, fileName)
+			dst := filepath.Join(config.TrashDir, fileName)
+			if err := moveFile(src, dst); err == nil {
+				moved[dst] = src
+			} else if !errors.Is(err, os.ErrNotExist) {
+				utils.LogAPI("移入回收站失败: %s, 错误: %v", src, err)
+				continue
+			}
+		}
+		ids = append(ids, h.ID)
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	result := config.DB.Model(&models.GenerationHistory{}).
+		Where("id IN ? AND trashed_at IS NULL", ids).
+		Update("trashed_at", time.Now())
+	if result.Error != nil {
+		for dst, src := range moved {
+			moveFile(dst, src)
+		}
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
+
+// TrashHandler 获取回收站中的记录，最近删除的在前
+// GET /trash?page=1&page_size=100
+func TrashHandler(c *gin.Context) {
+	query := config.DB.Model(&models.GenerationHistory{}).
+		Where("trashed_at IS NOT NULL").
+		Where("image_deleted = ? OR image_deleted IS NULL", false)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(500, gin.H{"error": "获取回收站失败"})
+		return
+	}
+
+	page, pageSize := parsePageParams(c)
+	var history []models.GenerationHistory
+	if err := query.Order("trashed_at desc, id desc").Offset((page - 1) * pageSize).Limit(pageSize).Find(&history).Error; err != nil {
+		c.JSON(500, gin.H{"error": "获取回收站失败"})
+		return
+	}
+
+	responses := convertHistoryToResponse(history)
+	items := make([]trashItem, len(history))
+	for i, h := range history {
+		items[i] = trashItem{
+			GenerationHistoryResponse: responses[i],
+			PurgeAt:                   trashPurgeTime(*h.TrashedAt),
+		}
+		if fileName := imageFileName(h.ImageURL); fileName != "" {
+			items[i].PreviewURL = utils.ToAbsoluteURL("trash/files/"+fileName, config.ServerPort)
+		}
+	}
+
+	c.JSON(200, gin.H{
+		"items":          items,
+		"total":          total,
+		"retention_days": config.TrashRetentionDays,
+	})
+}
+
+// RestoreTrashHandler 从回收站恢复记录，图片移回输出目录
+// POST /trash/restore {"ids": [1, 2]}
+// 回收站中找不到图片文件的记录不恢复，在 failed 中返回
+func RestoreTrashHandler(c *gin.Context) {
+	var req struct {
+		IDs []uint `json:"ids" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.IDs) == 0 {
+		c.JSON(400, gin.H{"error": "请选择要恢复的记录"})
+		return
+	}
+
+	var histories []models.GenerationHistory
+	config.DB.Where("id IN ? AND trashed_at IS NOT NULL", req.IDs).
+		Where("image_deleted = ? OR image_deleted IS NULL", false).
+		Find(&histories)
+
+	restoredIDs := []uint{}
+	failedIDs := []uint{}
+	for _, h := range histories {
+		fileName := imageFileName(h.ImageURL)
+		if fileName == "" {
+			failedIDs = append(failedIDs, h.ID)
+			continue
+		}
+		dst := filepath.Join(config.OutputDir, fileName)
+		err := moveFile(filepath.Join(config.TrashDir, fileName), dst)
+		if errors.Is(err, os.ErrNotExist) {
+			// 回收站中没有文件，但输出目录中仍有同名文件时直接恢复
+			if _, statErr := os.Stat(dst); statErr == nil {
+				err = nil
+			}
+		}
+		if err != nil {
+			utils.LogAPI("从回收站恢复失败: 记录 %d, 错误: %v", h.ID, err)
+			failedIDs = append(failedIDs, h.ID)
+			continue
+		}
+		restoredIDs = append(restoredIDs, h.ID)
+	}
+
+	if len(restoredIDs) > 0 {
+		if err := config.DB.Model(&models.GenerationHistory{}).Where("id IN ?", restoredIDs).Update("trashed_at", nil).Error; err != nil {
+			c.JSON(500, gin.H{"error": "更新记录失败"})
+			return
+		}
+	}
+
+	c.JSON(200, gin.H{
+		"message":  "恢复完成",
+		"restored": restoredIDs,
+		"failed":   failedIDs,
+	})
+}
+
+// PurgeExpiredTrash 永久删除超过保留期的回收站图片，记录标记为图片已删除，返回清除的记录数
+func PurgeExpiredTrash() (int64, error) {
+	cutoff := time.Now().AddDate(0, 0, -config.TrashRetentionDays)
+
+	var histories []models.GenerationHistory
+	err := config.DB.Where("trashed_at IS NOT NULL AND trashed_at <= ?", cutoff).
+		Where("image_deleted = ? OR image_deleted IS NULL", false).
+		Find(&histories).Error
+	if err != nil {
+		return 0, err
+	}
+
+	candidates := make([]uint, len(histories))
+	for i, h := range histories {
+		candidates[i] = h.ID
+	}
+
+	ids := make([]uint, 0, len(histories))
+	for _, h := range histories {
+		// 回收站中其他记录仍使用同一文件时保留
+		if fileName := imageFileName(h.ImageURL); fileName != "" && !outputImageInUse(fileName, candidates, true) {
+			path := filepath.Join(config.TrashDir, fileName)
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				utils.LogAPI("清除回收站图片失败: %s, 错误: %v", path, err)
+				continue
+			}
+		}
+		ids = append(ids, h.ID)
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	result := config.DB.Model(&models.GenerationHistory{}).Where("id IN ?", ids).Update("image_deleted", true)
+	return result.RowsAffected, result.Error
+}
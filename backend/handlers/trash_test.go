@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"sigma/config"
+	"sigma/models"
+)
+
+type trashResponse struct {
+	Items []struct {
+		ID         uint       `json:"id"`
+		PreviewURL string     `json:"preview_url"`
+		TrashedAt  *time.Time `json:"trashed_at"`
+		PurgeAt    time.Time  `json:"purge_at"`
+	} `json:"items"`
+	Total         int64 `json:"total"`
+	RetentionDays int   `json:"retention_days"`
+}
+
+func setupTrashTest(t *testing.T) *gin.Engine {
+	config.OutputDir = t.TempDir()
+	config.TrashDir = filepath.Join(t.TempDir(), "trash")
+	originalRetention := config.TrashRetentionDays
+	config.TrashRetentionDays = 30
+	t.Cleanup(func() { config.TrashRetentionDays = originalRetention })
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/history", HistoryHandler)
+	r.DELETE("/history/:id", DeleteHistoryHandler)
+	r.DELETE("/history/date/:date", DeleteHistoryByDateHandler)
+	r.GET("/trash", TrashHandler)
+	r.POST("/trash/restore", RestoreTrashHandler)
+	return r
+}
+
+// createImageHistory 创建带图片文件的历史记录
+func createImageHistory(t *testing.T, name string, createdAt time.Time) models.GenerationHistory {
+	if err := os.WriteFile(filepath.Join(config.OutputDir, name), []byte("png"), 0644); err != nil {
+		t.Fatalf("写入图片失败: %v", err)
+	}
+	h := models.GenerationHistory{Prompt: name, ImageURL: "images/" + name, FileName: name, Type: models.GenerationTypeCreate, CreatedAt: createdAt}
+	config.DB.Create(&h)
+	return h
+}
+
+func getTrash(t *testing.T, r *gin.Engine) trashResponse {
+	req, _ := http.NewRequest("GET", "/trash", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("获取回收站期望状态码 200，实际为 %d: %s", w.Code, w.Body.String())
+	}
+	var response trashResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return response
+}
+
+func TestTrash_DeleteRestoreAndPurge(t *testing.T) {
+	cleanup := setupHistoryTestDB(t)
+	defer cleanup()
+	r := setupTrashTest(t)
+
+	day := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	first := createImageHistory(t, "a.png", day)
+	second := createImageHistory(t, "b.png", day.Add(time.Hour))
+	kept := createImageHistory(t, "c.png", day.AddDate(0, 0, 1))
+
+	// 按日期删除：图片移入回收站，记录不再出现在历史中
+	if w := doJSON(r, "DELETE", "/history/date/2025-03-01", ""); w.Code != http.StatusOK {
+		t.Fatalf("按日期删除失败: %d %s", w.Code, w.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(config.OutputDir, "a.png")); !os.IsNotExist(err) {
+		t.Errorf("图片应已移出输出目录")
+	}
+	if _, err := os.Stat(filepath.Join(config.TrashDir, "a.png")); err != nil {
+		t.Errorf("图片应在回收站中: %v", err)
+	}
+	if history := getHistory(t, r, "/history"); len(history) != 1 || history[0].ID != kept.ID {
+		t.Errorf("历史中只应剩下未删除的记录: %+v", history)
+	}
+
+	trash := getTrash(t, r)
+	if trash.Total != 2 || trash.RetentionDays != 30 || trash.Items[0].PreviewURL == "" {
+		t.Fatalf("回收站内容不正确: %+v", trash)
+	}
+	if trash.Items[0].TrashedAt == nil || time.Since(*trash.Items[0].TrashedAt) > time.Minute {
+		t.Errorf("回收站记录应返回移入回收站的时间: %v", trash.Items[0].TrashedAt)
+	}
+	if purgeIn := time.Until(trash.Items[0].PurgeAt); purgeIn < 29*24*time.Hour {
+		t.Errorf("计划清除时间应在 30 天后，实际为 %v 后", purgeIn)
+	}
+
+	// 重复删除回收站中的记录不产生变化
+	if w := doJSON(r, "DELETE", fmt.Sprintf("/history/%d", first.ID), ""); w.Code != http.StatusOK {
+		t.Errorf("删除回收站中的记录应成功，实际为 %d", w.Code)
+	}
+
+	// 恢复：图片移回输出目录，记录重新出现在历史中
+	w := doJSON(r, "POST", "/trash/restore", fmt.Sprintf(`{"ids": [%d, %d]}`, first.ID, kept.ID))
+	var restore struct {
+		Restored []uint `json:"restored"`
+		Failed   []uint `json:"failed"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &restore)
+	if w.Code != http.StatusOK || len(restore.Restored) != 1 || restore.Restored[0] != first.ID {
+		t.Fatalf("恢复结果不正确: %d %s", w.Code, w.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(config.OutputDir, "a.png")); err != nil {
+		t.Errorf("恢复后图片应回到输出目录: %v", err)
+	}
+	if history := getHistory(t, r, "/history"); len(history) != 2 {
+		t.Errorf("恢复后历史中应有 2 条记录，实际为 %d", len(history))
+	}
+
+	// 未到保留期不清除
+	if purged, err := PurgeExpiredTrash(); err != nil || purged != 0 {
+		t.Errorf("未到保留期不应清除，实际清除 %d (%v)", purged, err)
+	}
+
+	// 超过保留期后清除图片文件并标记为已删除
+	config.TrashRetentionDays = 0
+	purged, err := PurgeExpiredTrash()
+	if err != nil || purged != 1 {
+		t.Fatalf("期望清除 1 条记录，实际为 %d (%v)", purged, err)
+	}
+	if _, err := os.Stat(filepath.Join(config.TrashDir, "b.png")); !os.IsNotExist(err) {
+		t.Errorf("清除后回收站中不应再有图片")
+	}
+	var purgedRecord models.GenerationHistory
+	config.DB.First(&purgedRecord, second.ID)
+	if !purgedRecord.ImageDeleted {
+		t.Errorf("清除后记录应标记为图片已删除")
+	}
+	if trash := getTrash(t, r); trash.Total != 0 {
+		t.Errorf("清除后回收站应为空，实际为 %d", trash.Total)
+	}
+}
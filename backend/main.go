@@ -17,7 +17,7 @@ import (
 
 	"sigma/config"
 	"sigma/handlers"
-	"sigma/models"
+	"sigma/migrations"
 	"sigma/utils"
 )
 
@@ -67,6 +67,11 @@ func main() {
 	}
 	log.Printf("✓ 上传目录: %s", config.UploadDir)
 
+	if err := os.MkdirAll(config.TrashDir, 0755); err != nil {
+		log.Fatalf("无法创建回收站目录 %s: %v", config.TrashDir, err)
+	}
+	log.Printf("✓ 回收站目录: %s", config.TrashDir)
+
 	// 验证数据库路径的父目录存在
 	dbDir := filepath.Dir(config.DBPath)
 	if dbDir != "" && dbDir != "." {
@@ -83,12 +88,40 @@ func main() {
 	if err != nil {
 		log.Fatal("无法连接数据库:", err)
 	}
-	config.DB.AutoMigrate(&models.GenerationHistory{}, &models.GenerationStats{}, &models.GenerationTask{}, &config.AppConfig{})
 
-	// 自动迁移：恢复被软删除的记录
+	// 数据库迁移：按版本号执行尚未执行的迁移（MIGRATE_DRY_RUN 时执行后回滚并退出）
 	log.Println("检查数据库迁移...")
-	if err := autoMigrateDatabase(); err != nil {
-		log.Printf("警告: 数据库迁移失败: %v", err)
+	if config.MigrateDryRun {
+		pending, err := migrations.Run(config.DB, true)
+		if err != nil {
+			log.Fatalf("数据库迁移试运行失败: %v", err)
+		}
+		fmt.Printf("待执行的数据库迁移: %d 个\n", len(pending))
+		for _, m := range pending {
+			fmt.Printf("  %03d %s\n", m.Version, m.Name)
+		}
+		return
+	}
+	applied, err := migrations.Run(config.DB, false)
+	if err != nil {
+		log.Fatalf("数据库迁移失败: %v", err)
+	}
+	for _, m := range applied {
+		log.Printf("  ✓ 已执行迁移 %03d %s", m.Version, m.Name)
+	}
+	log.Printf("✓ 数据库版本: %d", migrations.Latest())
+
+	// 提示词全文索引（不可用时搜索接口使用 LIKE 匹配）
+	if err := handlers.EnsureHistorySearchIndex(); err != nil {
+		log.Printf("警告: %v，提示词搜索将使用 LIKE 匹配", err)
+	}
+
+	// 写入内置生成类型和提示词模板（不覆盖已修改的记录）
+	if err := handlers.SeedGenerationTypes(); err != nil {
+		log.Printf("警告: 写入内置生成类型失败: %v", err)
+	}
+	if err := handlers.SeedPromptTemplates(); err != nil {
+		log.Printf("警告: 写入内置提示词模板失败: %v", err)
 	}
 
 	// 数据库初始化后，重新加载配置（从数据库加载）
@@ -103,6 +136,14 @@ func main() {
 		handlers.AutoDetectAndSetPlatform(config.GetAPIToken())
 	}
 
+	// 恢复上次运行时中断的任务（单图任务重新入队）
+	requeued, interrupted, requeueErr := handlers.RequeueInterruptedTasks()
+	if requeueErr != nil {
+		log.Printf("警告: 恢复中断任务失败: %v", requeueErr)
+	} else if requeued > 0 || interrupted > 0 {
+		log.Printf("✓ 已重新入队 %d 个中断任务，%d 个批量任务标记为失败", requeued, interrupted)
+	}
+
 	// 启动时清理超时的任务
 	cleanedCount, cleanErr := handlers.CleanupStaleTasks()
 	if cleanErr != nil {
@@ -111,6 +152,22 @@ func main() {
 		log.Printf("✓ 已清理 %d 个超时任务", cleanedCount)
 	}
 
+	// 清除超过保留期的回收站图片（启动时一次，之后每小时一次）
+	if purged, err := handlers.PurgeExpiredTrash(); err != nil {
+		log.Printf("警告: 清理回收站失败: %v", err)
+	} else if purged > 0 {
+		log.Printf("✓ 已清除 %d 张超过保留期的回收站图片", purged)
+	}
+	go func() {
+		ticker := time.NewTicker(1 * time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			if count, err := handlers.PurgeExpiredTrash(); err == nil && count > 0 {
+				log.Printf("定时清理: 已清除 %d 张回收站图片", count)
+			}
+		}
+	}()
+
 	// 启动定时清理任务（每分钟检查一次超时任务）
 	go func() {
 		ticker := time.NewTicker(1 * time.Minute)
@@ -122,6 +179,9 @@ func main() {
 		}
 	}()
 
+	// 启动任务队列工作池
+	handlers.StartTaskQueue()
+
 	// 创建 Gin 路由
 	r := gin.Default()
 
@@ -145,6 +205,7 @@ func main() {
 	// 静态文件服务
 	r.Static("/images", config.OutputDir)
 	r.Static("/uploads", config.UploadDir)
+	r.Static("/trash/files", config.TrashDir)
 
 	// API 路由
 	r.GET("/config/check", handlers.CheckConfigHandler)
@@ -152,11 +213,16 @@ func main() {
 	r.POST("/config/apikey/validate", handlers.ValidateApiKeyHandler)
 	r.POST("/config/disclaimer", handlers.SetDisclaimerHandler)
 	r.POST("/generate", handlers.GenerateHandler)
+	r.POST("/generate/matrix", handlers.MatrixGenerateHandler)
 	r.GET("/history", handlers.HistoryHandler)
+	r.GET("/history/query", handlers.HistoryQueryHandler)
+	r.GET("/history/search", handlers.HistorySearchHandler)
 
 	// 统计接口
 	r.GET("/stats/generation-count", handlers.GetGenerationCountHandler)
-	r.POST("/stats/increment-count", handlers.IncrementGenerationCountHandler)
+	r.GET("/stats/summary", handlers.StatsSummaryHandler)
+	r.GET("/ledger", handlers.LedgerHandler)
+	r.GET("/ledger/summary", handlers.LedgerSummaryHandler)
 
 	// 白底图历史接口
 	r.GET("/history/white-background", handlers.WhiteBackgroundHistoryHandler)
@@ -170,16 +236,69 @@ func main() {
 	// 光影融合历史接口
 	r.GET("/history/light-shadow", handlers.LightShadowHistoryHandler)
 
+	// 任意已注册生成类型的历史接口
+	r.GET("/history/types/:type", handlers.TypedHistoryHandler)
+
 	// 历史记录删除接口
 	r.DELETE("/history/:id", handlers.DeleteHistoryHandler)
 	r.POST("/history/batch-delete", handlers.BatchDeleteHistoryHandler)
+	r.GET("/history/batch/:batch_id", handlers.BatchHistoryHandler)
 	r.DELETE("/history/batch/:batch_id", handlers.DeleteHistoryByBatchHandler)
 	r.DELETE("/history/date/:date", handlers.DeleteHistoryByDateHandler)
 
+	// 回收站接口
+	r.GET("/trash", handlers.TrashHandler)
+	r.POST("/trash/restore", handlers.RestoreTrashHandler)
+
+	// 存储占用报告和孤立文件清理
+	r.GET("/storage/report", handlers.StorageReportHandler)
+	r.POST("/storage/gc", handlers.StorageGCHandler)
+
+	// 导出历史记录和图片
+	r.POST("/export", handlers.ExportHandler)
+
+	// 备份与恢复
+	r.POST("/backup", handlers.BackupHandler)
+	r.POST("/backup/restore", handlers.RestoreBackupHandler)
+
+	// 收藏、标签和合集接口
+	r.POST("/history/favorite", handlers.SetFavoriteHandler)
+	r.POST("/history/tags", handlers.UpdateHistoryTagsHandler)
+	r.GET("/tags", handlers.ListTagsHandler)
+	r.GET("/collections", handlers.ListCollectionsHandler)
+	r.POST("/collections", handlers.CreateCollectionHandler)
+	r.PUT("/collections/:id", handlers.UpdateCollectionHandler)
+	r.DELETE("/collections/:id", handlers.DeleteCollectionHandler)
+	r.POST("/collections/:id/items", handlers.AddCollectionItemsHandler)
+	r.POST("/collections/:id/items/remove", handlers.RemoveCollectionItemsHandler)
+
 	// 任务管理接口
 	r.GET("/tasks/processing", handlers.GetProcessingTasks)
+	r.GET("/tasks/events", handlers.TaskEventsHandler)
+	r.POST("/tasks/cancel-all", handlers.CancelAllTasks)
+	r.POST("/tasks/:id/cancel", handlers.CancelTask)
 	r.GET("/tasks/:id", handlers.GetTaskStatus)
 
+	// 生成类型
+	r.GET("/generation-types", handlers.ListGenerationTypesHandler)
+	r.POST("/generation-types", handlers.CreateGenerationTypeHandler)
+	r.GET("/generation-types/:type", handlers.GetGenerationTypeHandler)
+	r.PUT("/generation-types/:type", handlers.UpdateGenerationTypeHandler)
+	r.DELETE("/generation-types/:type", handlers.DeleteGenerationTypeHandler)
+
+	// 提示词模板
+	r.GET("/prompt-templates", handlers.ListPromptTemplatesHandler)
+	r.POST("/prompt-templates", handlers.CreatePromptTemplateHandler)
+	r.GET("/prompt-templates/:id", handlers.GetPromptTemplateHandler)
+	r.PUT("/prompt-templates/:id", handlers.UpdatePromptTemplateHandler)
+	r.DELETE("/prompt-templates/:id", handlers.DeletePromptTemplateHandler)
+	r.POST("/prompt-templates/:id/reset", handlers.ResetPromptTemplateHandler)
+
+	// 批量导入
+	r.POST("/import/jobs", handlers.CreateImportJobHandler)
+	r.GET("/import/jobs/:id", handlers.GetImportJobHandler)
+	r.GET("/import/jobs/:id/manifest", handlers.ImportJobManifestHandler)
+
 	// 确定实际使用的端口
 	var actualPort int
 	defaultPort, _ := strconv.Atoi(config.ServerPort)
@@ -236,58 +355,3 @@ func setupCleanupHandler() {
 		os.Exit(0)
 	}()
 }
-
-// autoMigrateDatabase 自动迁移数据库
-// 1. 添加 image_deleted 列（如果不存在）
-// 2. 恢复所有被软删除的记录（仅对旧数据库有效）
-func autoMigrateDatabase() error {
-	// 1. 检查 image_deleted 列是否存在
-	var columnExists bool
-	err := config.DB.Raw("SELECT COUNT(*) FROM pragma_table_info('generation_histories') WHERE name='image_deleted'").Scan(&columnExists)
-	if err != nil {
-		return fmt.Errorf("检查 image_deleted 列失败: %w", err)
-	}
-
-	// 2. 如果列不存在，添加它
-	if !columnExists {
-		log.Println("  添加 image_deleted 列...")
-		if err := config.DB.Exec("ALTER TABLE generation_histories ADD COLUMN image_deleted BOOLEAN DEFAULT 0").Error; err != nil {
-			return fmt.Errorf("添加 image_deleted 列失败: %w", err)
-		}
-		log.Println("  ✓ 已添加 image_deleted 列")
-	}
-
-	// 3. 检查 deleted_at 列是否存在（仅对旧数据库有效）
-	var deletedAtExists bool
-	config.DB.Raw("SELECT COUNT(*) FROM pragma_table_info('generation_histories') WHERE name='deleted_at'").Scan(&deletedAtExists)
-
-	// 如果 deleted_at 列不存在，说明是新数据库，不需要迁移
-	if !deletedAtExists {
-		log.Println("  ✓ 数据库已是最新版本（无需迁移）")
-		return nil
-	}
-
-	// 4. 检查是否有被软删除的记录
-	var deletedCount int64
-	config.DB.Raw("SELECT COUNT(*) FROM generation_histories WHERE deleted_at IS NOT NULL").Scan(&deletedCount)
-
-	if deletedCount > 0 {
-		log.Printf("  发现 %d 条被软删除的记录，正在恢复...", deletedCount)
-
-		// 5. 将被软删除的记录标记为 image_deleted = true
-		if err := config.DB.Exec("UPDATE generation_histories SET image_deleted = 1 WHERE deleted_at IS NOT NULL").Error; err != nil {
-			return fmt.Errorf("标记已删除记录失败: %w", err)
-		}
-
-		// 6. 恢复记录（将 deleted_at 设置为 NULL）
-		if err := config.DB.Exec("UPDATE generation_histories SET deleted_at = NULL WHERE deleted_at IS NOT NULL").Error; err != nil {
-			return fmt.Errorf("恢复记录失败: %w", err)
-		}
-
-		log.Printf("  ✓ 成功恢复 %d 条记录", deletedCount)
-	} else {
-		log.Println("  ✓ 数据库已是最新版本")
-	}
-
-	return nil
-}
@@ -0,0 +1,253 @@
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"sigma/config"
+	"sigma/utils"
+
+	"gorm.io/gorm"
+)
+
+// Migration 一个编号的升级迁移
+// 已发布的迁移不能修改或重新编号；数据库结构或数据需要变化时追加新的迁移
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+}
+
+// SchemaMigration 已执行的迁移记录（schema_migrations 表）
+type SchemaMigration struct {
+	Version   int       `json:"version" gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `json:"name"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+// TableName 指定表名
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// errDryRun 试运行结束后回滚事务
+var errDryRun = errors.New("dry run")
+
+// All 全部迁移，按版本号递增
+var All = []Migration{
+	{Version: 1, Name: "initial_schema", Up: migrateInitialSchema},
+	{Version: 2, Name: "restore_soft_deleted_history", Up: migrateRestoreSoftDeleted},
+	{Version: 3, Name: "relative_image_urls", Up: migrateRelativeImageURLs},
+	{Version: 4, Name: "task_finished_at", Up: migrateTaskFinishedAt},
+	{Version: 5, Name: "credit_ledger", Up: migrateCreditLedger},
+	{Version: 6, Name: "drop_stored_file_ref_count", Up: migrateDropStoredFileRefCount},
+	{Version: 7, Name: "ledger_unknown_pricing", Up: migrateLedgerUnknownPricing},
+	{Version: 8, Name: "generation_count_baseline", Up: migrateGenerationCountBaseline},
+}
+
+// Latest 最新的迁移版本（当前程序的数据库结构版本）
+func Latest() int {
+	return All[len(All)-1].Version
+}
+
+// Applied 返回已执行的迁移记录，按版本号递增
+func Applied(db *gorm.DB) ([]SchemaMigration, error) {
+	if !db.Migrator().HasTable(&SchemaMigration{}) {
+		return nil, nil
+	}
+	var applied []SchemaMigration
+	if err := db.Order("version asc").Find(&applied).Error; err != nil {
+		return nil, fmt.Errorf("读取迁移记录失败: %w", err)
+	}
+	return applied, nil
+}
+
+// Pending 返回尚未执行的迁移
+func Pending(db *gorm.DB) ([]Migration, error) {
+	applied, err := Applied(db)
+	if err != nil {
+		return nil, err
+	}
+	done := make(map[int]bool, len(applied))
+	for _, m := range applied {
+		done[m.Version] = true
+	}
+
+	var pending []Migration
+	for _, m := range All {
+		if !done[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Run 按版本号依次执行尚未执行的迁移，返回执行的迁移
+// 每个迁移与其 schema_migrations 记录在同一个事务中提交，失败时停止并保留之前已提交的迁移；
+// dryRun 为 true 时在一个事务中执行全部待执行的迁移后回滚，用于检查迁移能否成功，不修改数据库
+func Run(db *gorm.DB, dryRun bool) ([]Migration, error) {
+	pending, err := Pending(db)
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) == 0 {
+		return nil, nil
+	}
+
+	if dryRun {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&SchemaMigration{}); err != nil {
+				return err
+			}
+			for _, m := range pending {
+				if err := apply(tx, m); err != nil {
+					return err
+				}
+			}
+			return errDryRun
+		})
+		if !errors.Is(err, errDryRun) {
+			return nil, err
+		}
+		return pending, nil
+	}
+
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, fmt.Errorf("创建 schema_migrations 表失败: %w", err)
+	}
+	for i, m := range pending {
+		if err := db.Transaction(func(tx *gorm.DB) error { return apply(tx, m) }); err != nil {
+			return pending[:i], err
+		}
+	}
+	return pending, nil
+}
+
+// apply 在事务中执行一个迁移并写入迁移记录
+func apply(tx *gorm.DB, m Migration) error {
+	if err := m.Up(tx); err != nil {
+		return fmt.Errorf("迁移 %d (%s) 失败: %w", m.Version, m.Name, err)
+	}
+	record := SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}
+	if err := tx.Create(&record).Error; err != nil {
+		return fmt.Errorf("记录迁移 %d (%s) 失败: %w", m.Version, m.Name, err)
+	}
+	return nil
+}
+
+// migrateInitialSchema 创建（或补全）全部数据表
+// 旧版本数据库在这里补齐缺少的列，如 image_deleted；表结构固定为版本 1 时的结构（见 schema.go）
+func migrateInitialSchema(tx *gorm.DB) error {
+	return tx.AutoMigrate(
+		&historyV1{},
+		&statsV1{},
+		&taskV1{},
+		&importJobV1{},
+		&importJobItemV1{},
+		&promptTemplateV1{},
+		&generationTypeV1{},
+		&historyTagV1{},
+		&collectionV1{},
+		&collectionItemV1{},
+		&storedFileV1{},
+		&appConfigV1{},
+	)
+}
+
+// migrateRestoreSoftDeleted 恢复旧版本软删除的历史记录
+// 旧版本使用 gorm 软删除（deleted_at），删除的记录改为保留并标记为图片已删除
+func migrateRestoreSoftDeleted(tx *gorm.DB) error {
+	if !tx.Migrator().HasColumn("generation_histories", "deleted_at") {
+		return nil
+	}
+	if err := tx.Exec("UPDATE generation_histories SET image_deleted = 1 WHERE deleted_at IS NOT NULL").Error; err != nil {
+		return fmt.Errorf("标记已删除记录失败: %w", err)
+	}
+	if err := tx.Exec("UPDATE generation_histories SET deleted_at = NULL WHERE deleted_at IS NOT NULL").Error; err != nil {
+		return fmt.Errorf("恢复记录失败: %w", err)
+	}
+	return nil
+}
+
+// migrateRelativeImageURLs 将历史记录中的完整图片 URL 转换为相对路径
+// 旧版本保存的 URL 带有当时的端口（如 http://localhost:8080/images/xxx.png），端口变化后图片无法显示
+func migrateRelativeImageURLs(tx *gorm.DB) error {
+	_, err := FixImageURLs(tx, false)
+	return err
+}
+
+// FixImageURLs 将历史记录中的完整图片 URL（image_url 和 ref_images）转换为相对路径，返回需要修改的记录数
+// dryRun 为 true 时只统计不修改
+func FixImageURLs(db *gorm.DB, dryRun bool) (int64, error) {
+	type urlRow struct {
+		ID        uint
+		ImageURL  string
+		RefImages string
+	}
+	var rows []urlRow
+	err := db.Table("generation_histories").Select("id", "image_url", "ref_images").
+		Where("image_url LIKE 'http://%' OR image_url LIKE 'https://%' OR ref_images LIKE '%http://%' OR ref_images LIKE '%https://%'").
+		Find(&rows).Error
+	if err != nil {
+		return 0, err
+	}
+
+	var fixed int64
+	for _, row := range rows {
+		imageURL := utils.ToRelativePath(row.ImageURL)
+		refImages := utils.ConvertRefImagesJSON(row.RefImages, config.ServerPort, true)
+		if imageURL == row.ImageURL && refImages == row.RefImages {
+			continue
+		}
+		fixed++
+		if dryRun {
+			continue
+		}
+		err := db.Table("generation_histories").Where("id = ?", row.ID).
+			UpdateColumns(map[string]interface{}{"image_url": imageURL, "ref_images": refImages}).Error
+		if err != nil {
+			return fixed - 1, err
+		}
+	}
+	return fixed, nil
+}
+
+// migrateTaskFinishedAt 为任务增加结束时间（finished_at）
+// 已结束的旧任务没有记录结束时间，以最后更新时间代替
+func migrateTaskFinishedAt(tx *gorm.DB) error {
+	if !tx.Migrator().HasColumn("generation_tasks", "finished_at") {
+		if err := tx.Exec("ALTER TABLE generation_tasks ADD COLUMN finished_at datetime").Error; err != nil {
+			return err
+		}
+	}
+	return tx.Exec("UPDATE generation_tasks SET finished_at = updated_at WHERE finished_at IS NULL AND status IN ('completed', 'failed', 'cancelled')").Error
+}
+
+// migrateCreditLedger 创建消耗台账表
+func migrateCreditLedger(tx *gorm.DB) error {
+	return tx.AutoMigrate(&creditLedgerEntryV5{})
+}
+
+// migrateDropStoredFileRefCount 删除内容寻址文件的引用数列
+// 引用数只在启动等时机重新统计，平时不随上传和删除更新，改为需要时根据任务和历史记录计算
+func migrateDropStoredFileRefCount(tx *gorm.DB) error {
+	if !tx.Migrator().HasColumn("stored_files", "ref_count") {
+		return nil
+	}
+	return tx.Exec("ALTER TABLE stored_files DROP COLUMN ref_count").Error
+}
+
+// migrateLedgerUnknownPricing 计价未知的台账记录此前以单价 0 保存，改为单价和费用为空
+func migrateLedgerUnknownPricing(tx *gorm.DB) error {
+	return tx.Exec("UPDATE credit_ledger_entries SET unit_price = NULL, cost = NULL WHERE unit_price = 0").Error
+}
+
+// migrateGenerationCountBaseline 将旧版本的生成计数器转换为基准偏移
+// 生成计数改为由历史记录计算（4K 计为 2 张），旧计数器中超出历史记录的部分（如早期版本未保存历史的生成）
+// 保存为基准，累计数 = 基准 + 历史记录计数，升级前后的累计数保持一致
+func migrateGenerationCountBaseline(tx *gorm.DB) error {
+	return tx.Exec(`UPDATE generation_stats SET total_count = MAX(total_count - (
+		SELECT COALESCE(SUM(CASE WHEN image_size = '4K' THEN 2 ELSE 1 END), 0) FROM generation_histories
+	), 0) WHERE id = (SELECT MIN(id) FROM generation_stats WHERE deleted_at IS NULL)`).Error
+}
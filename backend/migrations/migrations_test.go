@@ -0,0 +1,254 @@
+package migrations
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"sigma/config"
+	"sigma/models"
+)
+
+func openTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("无法创建测试数据库: %v", err)
+	}
+	// 内存数据库每个连接各自独立
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+// createLegacyDatabase 创建旧版本（软删除、保存完整 URL）的历史记录表
+func createLegacyDatabase(t *testing.T, db *gorm.DB) {
+	stmts := []string{
+		`CREATE TABLE generation_histories (id integer PRIMARY KEY AUTOINCREMENT, created_at datetime, updated_at datetime, deleted_at datetime, prompt text, image_url text, file_name text, ref_images text, type text DEFAULT 'create')`,
+		`INSERT INTO generation_histories (prompt, image_url, ref_images) VALUES ('a', 'http://localhost:8080/images/a.png', '["http://localhost:8080/uploads/ref.png"]')`,
+		`INSERT INTO generation_histories (prompt, image_url, ref_images, deleted_at) VALUES ('b', 'images/b.png', '', '2024-01-01 00:00:00')`,
+	}
+	for _, stmt := range stmts {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatalf("创建旧版本数据库失败: %v", err)
+		}
+	}
+}
+
+func TestRun_FreshDatabase(t *testing.T) {
+	db := openTestDB(t)
+
+	applied, err := Run(db, false)
+	if err != nil {
+		t.Fatalf("迁移失败: %v", err)
+	}
+	if len(applied) != len(All) {
+		t.Errorf("期望执行 %d 个迁移，实际为 %d", len(All), len(applied))
+	}
+	for _, table := range []string{"generation_histories", "generation_tasks", "stored_files", "credit_ledger_entries", "app_configs", "schema_migrations"} {
+		if !db.Migrator().HasTable(table) {
+			t.Errorf("缺少表 %s", table)
+		}
+	}
+
+	// 再次执行不做任何操作
+	applied, err = Run(db, false)
+	if err != nil || len(applied) != 0 {
+		t.Errorf("已是最新版本时不应执行迁移: %d (%v)", len(applied), err)
+	}
+	records, _ := Applied(db)
+	if len(records) != len(All) || records[len(records)-1].Version != Latest() {
+		t.Errorf("迁移记录不正确: %+v", records)
+	}
+}
+
+// 迁移得到的表结构（列名、类型、非空和默认值）与当前模型一致：模型增删或修改字段时必须追加对应的迁移
+func TestRun_SchemaMatchesModels(t *testing.T) {
+	migrated := openTestDB(t)
+	if _, err := Run(migrated, false); err != nil {
+		t.Fatalf("迁移失败: %v", err)
+	}
+	expected := openTestDB(t)
+	tables := []interface{}{
+		&models.GenerationHistory{}, &models.GenerationStats{}, &models.GenerationTask{}, &models.ImportJob{}, &models.ImportJobItem{},
+		&models.PromptTemplate{}, &models.GenerationTypeDef{}, &models.HistoryTag{}, &models.Collection{}, &models.CollectionItem{},
+		&models.StoredFile{}, &models.CreditLedgerEntry{}, &config.AppConfig{},
+	}
+	if err := expected.AutoMigrate(tables...); err != nil {
+		t.Fatalf("创建模型表失败: %v", err)
+	}
+
+	columns := func(db *gorm.DB, table string) string {
+		var names []string
+		db.Raw("SELECT name || ' ' || type || ' ' || \"notnull\" || ' ' || coalesce(dflt_value, '') FROM pragma_table_info(?)", table).Scan(&names)
+		sort.Strings(names)
+		return strings.Join(names, ",")
+	}
+	for _, model := range tables {
+		stmt := &gorm.Statement{DB: expected}
+		stmt.Parse(model)
+		table := stmt.Schema.Table
+		if got, want := columns(migrated, table), columns(expected, table); got != want {
+			t.Errorf("表 %s 的列与模型不一致:\n迁移: %s\n模型: %s", table, got, want)
+		}
+	}
+}
+
+func TestRun_LegacyDatabase(t *testing.T) {
+	db := openTestDB(t)
+	createLegacyDatabase(t, db)
+
+	if _, err := Run(db, false); err != nil {
+		t.Fatalf("迁移失败: %v", err)
+	}
+
+	type row struct {
+		Prompt       string
+		ImageURL     string
+		RefImages    string
+		ImageDeleted bool
+		DeletedAt    *string
+	}
+	var rows []row
+	db.Raw("SELECT prompt, image_url, ref_images, image_deleted, deleted_at FROM generation_histories ORDER BY id").Scan(&rows)
+	if len(rows) != 2 {
+		t.Fatalf("期望 2 条记录，实际为 %d", len(rows))
+	}
+	if rows[0].ImageURL != "images/a.png" || rows[0].RefImages != `["uploads/ref.png"]` {
+		t.Errorf("完整 URL 应转换为相对路径: %+v", rows[0])
+	}
+	if !rows[1].ImageDeleted || rows[1].DeletedAt != nil {
+		t.Errorf("软删除的记录应恢复并标记为图片已删除: %+v", rows[1])
+	}
+}
+
+func TestRun_DryRunDoesNotModify(t *testing.T) {
+	db := openTestDB(t)
+	createLegacyDatabase(t, db)
+
+	pending, err := Run(db, true)
+	if err != nil {
+		t.Fatalf("试运行失败: %v", err)
+	}
+	if len(pending) != len(All) {
+		t.Errorf("试运行应返回全部待执行的迁移，实际为 %d", len(pending))
+	}
+	if db.Migrator().HasTable("schema_migrations") || db.Migrator().HasColumn("generation_histories", "image_deleted") {
+		t.Errorf("试运行不应修改数据库结构")
+	}
+	var imageURL string
+	db.Raw("SELECT image_url FROM generation_histories WHERE prompt = 'a'").Scan(&imageURL)
+	if imageURL != "http://localhost:8080/images/a.png" {
+		t.Errorf("试运行不应修改数据: %s", imageURL)
+	}
+	if remaining, _ := Pending(db); len(remaining) != len(All) {
+		t.Errorf("试运行后迁移仍应待执行，实际为 %d", len(remaining))
+	}
+}
+
+func TestRun_DropsStoredFileRefCount(t *testing.T) {
+	db := openTestDB(t)
+	stmts := []string{
+		`CREATE TABLE stored_files (id integer PRIMARY KEY AUTOINCREMENT, created_at datetime, updated_at datetime, kind text NOT NULL, hash text NOT NULL, path text NOT NULL, size integer, ref_count integer)`,
+		`INSERT INTO stored_files (kind, hash, path, size, ref_count) VALUES ('upload', 'abc', 'uploads/abc.png', 3, 2)`,
+	}
+	for _, stmt := range stmts {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatalf("创建旧版本数据库失败: %v", err)
+		}
+	}
+
+	if _, err := Run(db, false); err != nil {
+		t.Fatalf("迁移失败: %v", err)
+	}
+	if db.Migrator().HasColumn("stored_files", "ref_count") {
+		t.Error("应删除 ref_count 列")
+	}
+	var path string
+	db.Raw("SELECT path FROM stored_files WHERE hash = 'abc'").Scan(&path)
+	if path != "uploads/abc.png" {
+		t.Errorf("删除列后应保留文件记录: %q", path)
+	}
+}
+
+func TestRun_ClearsUnknownLedgerPricing(t *testing.T) {
+	db := openTestDB(t)
+	stmts := []string{
+		`CREATE TABLE credit_ledger_entries (id integer PRIMARY KEY AUTOINCREMENT, created_at datetime, task_id text, price_group text, credits real, unit_price real, cost real)`,
+		`INSERT INTO credit_ledger_entries (task_id, price_group, credits, unit_price, cost) VALUES ('unknown', '', 1, 0, 0), ('priced', 'default', 2, 0.165, 0.33)`,
+	}
+	for _, stmt := range stmts {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatalf("创建旧版本数据库失败: %v", err)
+		}
+	}
+
+	if _, err := Run(db, false); err != nil {
+		t.Fatalf("迁移失败: %v", err)
+	}
+	var unknown, priced struct {
+		UnitPrice *float64
+		Cost      *float64
+	}
+	db.Raw("SELECT unit_price, cost FROM credit_ledger_entries WHERE task_id = 'unknown'").Scan(&unknown)
+	if unknown.UnitPrice != nil || unknown.Cost != nil {
+		t.Errorf("计价未知的记录单价和费用应为空: %v %v", unknown.UnitPrice, unknown.Cost)
+	}
+	db.Raw("SELECT unit_price, cost FROM credit_ledger_entries WHERE task_id = 'priced'").Scan(&priced)
+	if priced.Cost == nil || *priced.Cost != 0.33 {
+		t.Errorf("已知计价的记录不应修改: %v", priced.Cost)
+	}
+}
+
+func TestRun_GenerationCountBaseline(t *testing.T) {
+	db := openTestDB(t)
+	stmts := []string{
+		`CREATE TABLE generation_histories (id integer PRIMARY KEY AUTOINCREMENT, created_at datetime, updated_at datetime, prompt text, image_url text, image_size text)`,
+		`INSERT INTO generation_histories (prompt, image_url, image_size) VALUES ('a', 'images/a.png', '2K'), ('b', 'images/b.png', '4K')`,
+		`CREATE TABLE generation_stats (id integer PRIMARY KEY AUTOINCREMENT, created_at datetime, updated_at datetime, deleted_at datetime, total_count integer DEFAULT 0)`,
+		`INSERT INTO generation_stats (total_count) VALUES (10)`,
+	}
+	for _, stmt := range stmts {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatalf("创建旧版本数据库失败: %v", err)
+		}
+	}
+
+	if _, err := Run(db, false); err != nil {
+		t.Fatalf("迁移失败: %v", err)
+	}
+	// 历史记录计为 3 张（4K 计为 2 张），旧计数器中其余 7 张保存为基准
+	var baseline int
+	db.Raw("SELECT total_count FROM generation_stats").Scan(&baseline)
+	if baseline != 7 {
+		t.Errorf("期望基准为 7，实际为 %d", baseline)
+	}
+}
+
+func TestFixImageURLs(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := Run(db, false); err != nil {
+		t.Fatalf("迁移失败: %v", err)
+	}
+	db.Exec(`INSERT INTO generation_histories (prompt, image_url, ref_images) VALUES ('a', 'http://localhost:9000/images/a.png', ''), ('b', 'images/b.png', '')`)
+
+	fixed, err := FixImageURLs(db, true)
+	if err != nil || fixed != 1 {
+		t.Fatalf("试运行应统计 1 条记录，实际为 %d (%v)", fixed, err)
+	}
+	var imageURL string
+	db.Raw("SELECT image_url FROM generation_histories WHERE prompt = 'a'").Scan(&imageURL)
+	if imageURL != "http://localhost:9000/images/a.png" {
+		t.Errorf("试运行不应修改数据: %s", imageURL)
+	}
+
+	if fixed, err = FixImageURLs(db, false); err != nil || fixed != 1 {
+		t.Fatalf("应修复 1 条记录，实际为 %d (%v)", fixed, err)
+	}
+	db.Raw("SELECT image_url FROM generation_histories WHERE prompt = 'a'").Scan(&imageURL)
+	if imageURL != "images/a.png" {
+		t.Errorf("完整 URL 应转换为相对路径: %s", imageURL)
+	}
+}
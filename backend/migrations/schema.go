@@ -0,0 +1,192 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 迁移使用的表结构快照
+// 已发布的迁移按当时的表结构建表，不引用 models 中的模型：模型后续的修改不会改变旧迁移创建的表，
+// 结构变化通过追加新的迁移完成
+
+// 版本 1（initial_schema）的表结构
+
+type historyV1 struct {
+	ID             uint `gorm:"primarykey"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	Prompt         string
+	OriginalPrompt string
+	TemplateID     string
+	ImageURL       string
+	FileName       string
+	RefImages      string
+	Type           string `gorm:"default:create"`
+	ErrorMsg       string
+	ImageDeleted   bool       `gorm:"default:false"`
+	AspectRatio    string     `gorm:"default:1:1"`
+	ImageSize      string     `gorm:"default:2K"`
+	Favorite       bool       `gorm:"default:false;index"`
+	TrashedAt      *time.Time `gorm:"index"`
+	BatchID        *string    `gorm:"index"`
+	BatchIndex     *int
+	BatchTotal     *int
+	Variation      string
+}
+
+func (historyV1) TableName() string { return "generation_histories" }
+
+type statsV1 struct {
+	gorm.Model
+	TotalCount int `gorm:"default:0"`
+}
+
+func (statsV1) TableName() string { return "generation_stats" }
+
+type taskV1 struct {
+	gorm.Model
+	TaskID         string `gorm:"uniqueIndex;not null"`
+	Status         string `gorm:"default:processing;not null"`
+	Type           string `gorm:"not null"`
+	Prompt         string
+	RefImages      string
+	ImageURL       string
+	ErrorMsg       string
+	StartedAt      time.Time `gorm:"not null"`
+	ImageCount     int       `gorm:"default:1"`
+	BatchID        string    `gorm:"index;default:''"`
+	ParentTaskID   string    `gorm:"index;default:''"`
+	BatchIndex     *int
+	Variation      string
+	ImportJobID    string `gorm:"index;default:''"`
+	TemplateID     string
+	OriginalPrompt string
+	AspectRatio    string
+	ImageSize      string
+	Attempts       int `gorm:"default:0"`
+	AttemptErrors  string
+}
+
+func (taskV1) TableName() string { return "generation_tasks" }
+
+type importJobV1 struct {
+	gorm.Model
+	JobID        string `gorm:"uniqueIndex;not null"`
+	ManifestName string
+	Total        int
+	Skipped      string
+}
+
+func (importJobV1) TableName() string { return "import_jobs" }
+
+type importJobItemV1 struct {
+	ID          uint   `gorm:"primarykey"`
+	JobID       string `gorm:"index;not null"`
+	Row         int
+	SKU         string `gorm:"index"`
+	ProductName string
+	Scene       string
+	TaskID      string `gorm:"index"`
+}
+
+func (importJobItemV1) TableName() string { return "import_job_items" }
+
+type promptTemplateV1 struct {
+	ID         uint `gorm:"primarykey"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	TemplateID string `gorm:"uniqueIndex;not null"`
+	Type       string `gorm:"index;not null"`
+	Name       string
+	Template   string `gorm:"not null"`
+	IsDefault  bool   `gorm:"default:false"`
+	BuiltIn    bool   `gorm:"default:false"`
+}
+
+func (promptTemplateV1) TableName() string { return "prompt_templates" }
+
+type generationTypeV1 struct {
+	ID                 uint `gorm:"primarykey"`
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+	Type               string `gorm:"uniqueIndex;not null"`
+	Name               string
+	RefImageSlots      []string `gorm:"serializer:json"`
+	DefaultAspectRatio string
+	DefaultImageSize   string
+	BuiltIn            bool `gorm:"default:false"`
+}
+
+func (generationTypeV1) TableName() string { return "generation_types" }
+
+type historyTagV1 struct {
+	ID        uint   `gorm:"primarykey"`
+	HistoryID uint   `gorm:"uniqueIndex:idx_history_tag"`
+	Tag       string `gorm:"uniqueIndex:idx_history_tag;index"`
+}
+
+func (historyTagV1) TableName() string { return "history_tags" }
+
+type collectionV1 struct {
+	ID          uint `gorm:"primarykey"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Name        string `gorm:"uniqueIndex"`
+	Description string
+}
+
+func (collectionV1) TableName() string { return "collections" }
+
+type collectionItemV1 struct {
+	ID           uint `gorm:"primarykey"`
+	CreatedAt    time.Time
+	CollectionID uint `gorm:"uniqueIndex:idx_collection_item"`
+	HistoryID    uint `gorm:"uniqueIndex:idx_collection_item;index"`
+}
+
+func (collectionItemV1) TableName() string { return "collection_items" }
+
+type storedFileV1 struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Kind      string `gorm:"uniqueIndex:idx_stored_file_hash;not null"`
+	Hash      string `gorm:"uniqueIndex:idx_stored_file_hash;not null"`
+	Path      string `gorm:"uniqueIndex;not null"`
+	Size      int64
+	RefCount  int64
+}
+
+func (storedFileV1) TableName() string { return "stored_files" }
+
+type appConfigV1 struct {
+	ID             uint   `gorm:"primaryKey"`
+	ConfigKey      string `gorm:"uniqueIndex;size:100"`
+	ConfigValue    string `gorm:"size:500"`
+	EncryptedValue string `gorm:"size:1000"`
+}
+
+func (appConfigV1) TableName() string { return "app_configs" }
+
+// 版本 5（credit_ledger）的表结构
+
+type creditLedgerEntryV5 struct {
+	ID             uint      `gorm:"primarykey"`
+	CreatedAt      time.Time `gorm:"index"`
+	TaskID         string    `gorm:"index"`
+	HistoryID      uint      `gorm:"index"`
+	Type           string    `gorm:"index"`
+	Platform       string
+	PriceGroup     string
+	ImageSize      string
+	SizeMultiplier float64
+	Attempts       int
+	Credits        float64
+	UnitPrice      float64
+	Cost           float64
+	BalanceBefore  *float64
+	BalanceAfter   *float64
+}
+
+func (creditLedgerEntryV5) TableName() string { return "credit_ledger_entries" }
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+)
+
+// HistoryTag 历史记录的自由标签（一条记录可有多个标签）
+type HistoryTag struct {
+	ID        uint   `json:"-" gorm:"primarykey"`
+	HistoryID uint   `json:"history_id" gorm:"uniqueIndex:idx_history_tag"`
+	Tag       string `json:"tag" gorm:"uniqueIndex:idx_history_tag;index"`
+}
+
+// Collection 命名合集，用于按客户或项目整理交付图片
+type Collection struct {
+	ID          uint      `json:"id" gorm:"primarykey"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Name        string    `json:"name" gorm:"uniqueIndex"`
+	Description string    `json:"description"`
+	ItemCount   int64     `json:"item_count" gorm:"-"` // 合集中的记录数（查询时计算）
+}
+
+// CollectionItem 合集与历史记录的多对多关联
+type CollectionItem struct {
+	ID           uint      `json:"-" gorm:"primarykey"`
+	CreatedAt    time.Time `json:"created_at"`
+	CollectionID uint      `json:"collection_id" gorm:"uniqueIndex:idx_collection_item"`
+	HistoryID    uint      `json:"history_id" gorm:"uniqueIndex:idx_collection_item;index"`
+}
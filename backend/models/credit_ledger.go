@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+)
+
+// CreditLedgerEntry 消耗台账：每张成功生成的图片一条记录
+// Credits 以张为单位（2K 图片为 1 张），与余额查询的剩余张数一致；费用按记录时平台分组的单价估算，用于与供应商账单对账
+type CreditLedgerEntry struct {
+	ID             uint      `json:"id" gorm:"primarykey"`
+	CreatedAt      time.Time `json:"created_at" gorm:"index"`
+	TaskID         string    `json:"task_id" gorm:"index"`
+	HistoryID      uint      `json:"history_id" gorm:"index"`
+	Type           string    `json:"type" gorm:"index"`
+	Platform       string    `json:"platform"`
+	PriceGroup     string    `json:"group"`           // 平台分组（决定单价）
+	ImageSize      string    `json:"image_size"`      // 1K | 2K | 4K
+	SizeMultiplier float64   `json:"size_multiplier"` // 尺寸系数：4K 为 2，其他为 1
+	Attempts       int       `json:"attempts"`        // 调用 AI API 的次数（含失败重试）
+	Credits        float64   `json:"credits"`         // 估算消耗的张数
+	UnitPrice      *float64  `json:"unit_price"`      // 每张 2K 图片的价格（元），计价未知时为空
+	Cost           *float64  `json:"cost"`            // 估算费用（元） = Credits × UnitPrice，计价未知时为空
+	// 生成前后查询的剩余张数（查询失败或未开启时为空）
+	// 工作池并发执行时差值包含同时进行的其他任务的消耗，仅供对账参考
+	BalanceBefore *float64 `json:"balance_before"`
+	BalanceAfter  *float64 `json:"balance_after"`
+}
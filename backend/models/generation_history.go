@@ -4,7 +4,7 @@ import (
 	"time"
 )
 
-// GenerationType 生成类型常量
+// GenerationType 内置生成类型常量，自定义类型见 GenerationTypeDef
 const (
 	GenerationTypeCreate          = "create"           // 创作空间生成
 	GenerationTypeWhiteBackground = "white_background" // 白底图生成
@@ -14,48 +14,67 @@ const (
 )
 
 // GenerationHistory 数据库模型
-// 不使用软删除，删除操作只删除图片文件，数据库记录永久保留
+// 不使用软删除，删除操作将图片移入回收站，数据库记录永久保留
 type GenerationHistory struct {
-	ID             uint      `json:"id" gorm:"primarykey"`
-	CreatedAt      time.Time `json:"created_at"`
-	UpdatedAt      time.Time `json:"updated_at"`
-	Prompt         string    `json:"prompt"`
-	OriginalPrompt string    `json:"original_prompt"`
-	ImageURL       string    `json:"image_url"`
-	FileName       string    `json:"file_name"`
-	RefImages      string    `json:"ref_images"`
-	Type           string    `json:"type" gorm:"default:create"`         // 生成类型: create | white_background
-	ErrorMsg       string    `json:"error_msg,omitempty"`                // 错误信息（失败时保存）
-	ImageDeleted   bool      `json:"image_deleted" gorm:"default:false"` // 图片是否已被删除
-	AspectRatio    string    `json:"aspect_ratio" gorm:"default:1:1"`    // 图片比例
-	ImageSize      string    `json:"image_size" gorm:"default:2K"`       // 图片尺寸
+	ID             uint       `json:"id" gorm:"primarykey"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	Prompt         string     `json:"prompt"`
+	OriginalPrompt string     `json:"original_prompt"` // 渲染前的提示词模板（使用服务端模板时）
+	TemplateID     string     `json:"template_id"`     // 使用的提示词模板 ID，直接提交提示词时为空
+	ImageURL       string     `json:"image_url"`
+	FileName       string     `json:"file_name"`
+	RefImages      string     `json:"ref_images"`
+	Type           string     `json:"type" gorm:"default:create"`          // 生成类型: create | white_background
+	ErrorMsg       string     `json:"error_msg,omitempty"`                 // 错误信息（失败时保存）
+	ImageDeleted   bool       `json:"image_deleted" gorm:"default:false"`  // 图片是否已被删除
+	AspectRatio    string     `json:"aspect_ratio" gorm:"default:1:1"`     // 图片比例
+	ImageSize      string     `json:"image_size" gorm:"default:2K"`        // 图片尺寸
+	Favorite       bool       `json:"favorite" gorm:"default:false;index"` // 是否收藏
+	TrashedAt      *time.Time `json:"trashed_at,omitempty" gorm:"index"`   // 移入回收站的时间，为空表示不在回收站
 	// 多图生成批次字段（可空，用于安全迁移）
 	BatchID    *string `json:"batch_id,omitempty" gorm:"index"` // 批次 ID，关联同一次生成的多张图片
-	BatchIndex *int    `json:"batch_index,omitempty"`           // 批次内序号 (0-3)
-	BatchTotal *int    `json:"batch_total,omitempty"`           // 批次总数 (1-4)
+	BatchIndex *int    `json:"batch_index,omitempty"`           // 批次内序号 (0 到 BatchTotal-1)
+	BatchTotal *int    `json:"batch_total,omitempty"`           // 批次总数 (1-config.MaxImageCount)
+	// 提示词矩阵：该图片对应的组合（JSON 格式的 PromptVariation），普通生成为空
+	Variation string `json:"variation,omitempty"`
+}
+
+// PromptVariation 提示词矩阵中的一个组合
+type PromptVariation struct {
+	Template    string            `json:"template"`         // 提示词模板
+	Values      map[string]string `json:"values,omitempty"` // 模板占位符取值
+	AspectRatio string            `json:"aspect_ratio"`
+	ImageSize   string            `json:"image_size"`
 }
 
 // Note: 不再使用 gorm.Model，移除了 DeletedAt 字段
-// 删除操作只删除 output 目录中的图片文件，数据库记录永久保留
-// 使用 ImageDeleted 字段标记图片是否已被删除
+// 删除操作将图片移入回收站（TrashedAt），保留期后清除图片文件并标记 ImageDeleted，数据库记录永久保留
 
 // GenerationHistoryResponse 响应结构体
 type GenerationHistoryResponse struct {
-	ID             uint      `json:"id"`
-	Prompt         string    `json:"prompt"`
-	OriginalPrompt string    `json:"original_prompt"`
-	ImageURL       string    `json:"image_url"`
-	FileName       string    `json:"file_name"`
-	RefImages      string    `json:"ref_images"`
-	Type           string    `json:"type"`
-	ErrorMsg       string    `json:"error_msg,omitempty"`
-	ImageDeleted   bool      `json:"image_deleted"` // 图片是否已被删除
-	AspectRatio    string    `json:"aspect_ratio"`  // 图片比例
-	ImageSize      string    `json:"image_size"`    // 图片尺寸
-	CreatedAt      time.Time `json:"created_at"`
-	UpdatedAt      time.Time `json:"updated_at"`
+	ID             uint       `json:"id"`
+	Prompt         string     `json:"prompt"`
+	OriginalPrompt string     `json:"original_prompt"`
+	TemplateID     string     `json:"template_id,omitempty"`
+	ImageURL       string     `json:"image_url"`
+	FileName       string     `json:"file_name"`
+	RefImages      string     `json:"ref_images"`
+	Type           string     `json:"type"`
+	ErrorMsg       string     `json:"error_msg,omitempty"`
+	ImageDeleted   bool       `json:"image_deleted"`        // 图片是否已被删除
+	AspectRatio    string     `json:"aspect_ratio"`         // 图片比例
+	ImageSize      string     `json:"image_size"`           // 图片尺寸
+	Favorite       bool       `json:"favorite"`             // 是否收藏
+	Tags           []string   `json:"tags"`                 // 标签
+	TrashedAt      *time.Time `json:"trashed_at,omitempty"` // 移入回收站的时间
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
 	// 多图生成批次字段
 	BatchID    *string `json:"batch_id,omitempty"`
 	BatchIndex *int    `json:"batch_index,omitempty"`
 	BatchTotal *int    `json:"batch_total,omitempty"`
+	Variation  string  `json:"variation,omitempty"`
+	// 全文搜索结果中高亮匹配文本的摘要（仅 /history/search 返回）
+	Snippet string `json:"snippet,omitempty"`
 }
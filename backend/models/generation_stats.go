@@ -4,13 +4,15 @@ import (
 	"gorm.io/gorm"
 )
 
-// GenerationStats 生成统计模型
+// GenerationStats 旧版本的生成计数器（单例记录）
+// 生成计数已改为由历史记录计算；升级时旧计数器中超出历史记录的部分保存在 TotalCount 中作为基准，
+// 计入累计数后不再更新
 type GenerationStats struct {
 	gorm.Model
-	TotalCount int `json:"total_count" gorm:"default:0"`
+	TotalCount int `json:"total_count" gorm:"default:0"` // 累计数基准
 }
 
-// GenerationStatsResponse 响应结构体
+// GenerationStatsResponse 生成计数响应结构体
 type GenerationStatsResponse struct {
 	TotalCount int `json:"total_count"`
 }
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"gorm.io/gorm"
@@ -11,75 +12,183 @@ type TaskStatus string
 
 // 任务状态常量
 const (
+	TaskStatusQueued     TaskStatus = "queued" // 已入队，等待工作池调度
 	TaskStatusProcessing TaskStatus = "processing"
 	TaskStatusCompleted  TaskStatus = "completed"
 	TaskStatusFailed     TaskStatus = "failed"
+	TaskStatusCancelled  TaskStatus = "cancelled" // 用户主动取消
 )
 
+// TaskCancelledMessage 任务取消时记录的错误信息
+const TaskCancelledMessage = "任务已取消"
+
 // GenerationTask 生成任务数据库模型
 type GenerationTask struct {
 	gorm.Model
 	TaskID     string     `json:"task_id" gorm:"uniqueIndex;not null"`
 	Status     TaskStatus `json:"status" gorm:"default:processing;not null"`
-	Type       string     `json:"type" gorm:"not null"`       // create, white_background, clothing_change
+	Type       string     `json:"type" gorm:"not null"` // create, white_background, clothing_change
 	Prompt     string     `json:"prompt"`
-	RefImages  string     `json:"ref_images"`                 // JSON array of ref image URLs
-	ImageURL   string     `json:"image_url"`                  // 生成的图片 URL
-	ErrorMsg   string     `json:"error_msg"`                  // 错误信息
+	RefImages  string     `json:"ref_images"` // JSON array of ref image URLs
+	ImageURL   string     `json:"image_url"`  // 生成的图片 URL
+	ErrorMsg   string     `json:"error_msg"`  // 错误信息
 	StartedAt  time.Time  `json:"started_at" gorm:"not null"`
-	ImageCount int        `json:"image_count" gorm:"default:1"` // 请求生成的图片数量 (1-4)
+	FinishedAt *time.Time `json:"finished_at"`                  // 完成、失败或取消的时间，用于统计耗时
+	ImageCount int        `json:"image_count" gorm:"default:1"` // 请求生成的图片数量 (1-config.MaxImageCount)
+	// 多图批次：父任务记录 BatchID，每张图片对应一个子任务（ParentTaskID + BatchIndex）
+	BatchID      string `json:"batch_id" gorm:"index;default:''"`
+	ParentTaskID string `json:"parent_task_id" gorm:"index;default:''"`
+	BatchIndex   *int   `json:"batch_index"`
+	Variation    string `json:"variation"` // 提示词矩阵组合（JSON 格式的 PromptVariation）
+	// 批量导入任务 ID（由 /import/jobs 创建的任务）
+	ImportJobID string `json:"import_job_id" gorm:"index;default:''"`
+	// 服务端提示词模板：Prompt 为渲染结果，OriginalPrompt 为模板原文
+	TemplateID     string `json:"template_id"`
+	OriginalPrompt string `json:"original_prompt"`
+	// 生成参数（队列任务在服务重启后据此恢复执行）
+	AspectRatio string `json:"aspect_ratio"`
+	ImageSize   string `json:"image_size"`
+	// 重试记录
+	Attempts      int    `json:"attempts" gorm:"default:0"` // 累计调用 AI API 的次数
+	AttemptErrors string `json:"attempt_errors"`            // JSON array of AttemptError
+}
+
+// AttemptError 单次失败尝试的记录
+type AttemptError struct {
+	Attempt    int       `json:"attempt"`
+	Index      *int      `json:"index,omitempty"` // 多图批次内序号
+	Error      string    `json:"error"`
+	StatusCode int       `json:"status_code,omitempty"`
+	At         time.Time `json:"at"`
 }
 
 // TaskResponse API 响应结构体
 type TaskResponse struct {
-	ID         uint       `json:"id"`
-	TaskID     string     `json:"task_id"`
-	Status     TaskStatus `json:"status"`
-	Type       string     `json:"type"`
-	Prompt     string     `json:"prompt"`
-	RefImages  string     `json:"ref_images"`
-	ImageURL   string     `json:"image_url"`
-	ErrorMsg   string     `json:"error_msg"`
-	StartedAt  time.Time  `json:"started_at"`
-	CreatedAt  time.Time  `json:"created_at"`
-	UpdatedAt  time.Time  `json:"updated_at"`
-	ImageCount int        `json:"image_count"`
+	ID            uint       `json:"id"`
+	TaskID        string     `json:"task_id"`
+	Status        TaskStatus `json:"status"`
+	Type          string     `json:"type"`
+	Prompt        string     `json:"prompt"`
+	RefImages     string     `json:"ref_images"`
+	ImageURL      string     `json:"image_url"`
+	ErrorMsg      string     `json:"error_msg"`
+	StartedAt     time.Time  `json:"started_at"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	ImageCount    int        `json:"image_count"`
+	AspectRatio   string     `json:"aspect_ratio"`
+	ImageSize     string     `json:"image_size"`
+	Attempts      int        `json:"attempts"`
+	AttemptErrors string     `json:"attempt_errors"`
+	BatchID       string     `json:"batch_id,omitempty"`
+	ParentTaskID  string     `json:"parent_task_id,omitempty"`
+	BatchIndex    *int       `json:"batch_index,omitempty"`
+	Variation     string     `json:"variation,omitempty"`
+	ImportJobID   string     `json:"import_job_id,omitempty"`
+	TemplateID    string     `json:"template_id,omitempty"`
+	// 批次父任务的逐张进度（仅 GET /tasks/:id 返回）
+	Images []BatchImageProgress `json:"images,omitempty"`
+}
+
+// BatchImageProgress 批次内单张图片的进度
+type BatchImageProgress struct {
+	Index     int        `json:"index"`
+	TaskID    string     `json:"task_id"`
+	Status    TaskStatus `json:"status"`
+	ImageURL  string     `json:"image_url,omitempty"`
+	ErrorMsg  string     `json:"error_msg,omitempty"`
+	Variation string     `json:"variation,omitempty"`
 }
 
 // ToResponse 将 GenerationTask 转换为 TaskResponse
 func (t *GenerationTask) ToResponse() TaskResponse {
 	return TaskResponse{
-		ID:         t.ID,
-		TaskID:     t.TaskID,
-		Status:     t.Status,
-		Type:       t.Type,
-		Prompt:     t.Prompt,
-		RefImages:  t.RefImages,
-		ImageURL:   t.ImageURL,
-		ErrorMsg:   t.ErrorMsg,
-		StartedAt:  t.StartedAt,
-		CreatedAt:  t.CreatedAt,
-		UpdatedAt:  t.UpdatedAt,
-		ImageCount: t.ImageCount,
+		ID:            t.ID,
+		TaskID:        t.TaskID,
+		Status:        t.Status,
+		Type:          t.Type,
+		Prompt:        t.Prompt,
+		RefImages:     t.RefImages,
+		ImageURL:      t.ImageURL,
+		ErrorMsg:      t.ErrorMsg,
+		StartedAt:     t.StartedAt,
+		FinishedAt:    t.FinishedAt,
+		CreatedAt:     t.CreatedAt,
+		UpdatedAt:     t.UpdatedAt,
+		ImageCount:    t.ImageCount,
+		AspectRatio:   t.AspectRatio,
+		ImageSize:     t.ImageSize,
+		Attempts:      t.Attempts,
+		AttemptErrors: t.AttemptErrors,
+		BatchID:       t.BatchID,
+		ParentTaskID:  t.ParentTaskID,
+		BatchIndex:    t.BatchIndex,
+		Variation:     t.Variation,
+		ImportJobID:   t.ImportJobID,
+		TemplateID:    t.TemplateID,
+	}
+}
+
+// IsBatchParent 是否为多图批次的父任务（父任务本身不执行生成，由子任务汇总状态）
+func (t *GenerationTask) IsBatchParent() bool {
+	return t.BatchID != "" && t.ParentTaskID == ""
+}
+
+// RecordAttempts 累加尝试次数并追加失败记录
+func (t *GenerationTask) RecordAttempts(attempts int, errs []AttemptError) {
+	t.Attempts += attempts
+	if len(errs) == 0 {
+		return
+	}
+	var existing []AttemptError
+	if t.AttemptErrors != "" {
+		json.Unmarshal([]byte(t.AttemptErrors), &existing)
+	}
+	data, err := json.Marshal(append(existing, errs...))
+	if err == nil {
+		t.AttemptErrors = string(data)
 	}
 }
 
 // IsValidStatus 检查状态是否有效
 func IsValidStatus(status TaskStatus) bool {
-	return status == TaskStatusProcessing ||
+	return status == TaskStatusQueued ||
+		status == TaskStatusProcessing ||
 		status == TaskStatusCompleted ||
-		status == TaskStatusFailed
+		status == TaskStatusFailed ||
+		status == TaskStatusCancelled
+}
+
+// IsFinished 任务是否已结束（完成、失败或取消）
+func (t *GenerationTask) IsFinished() bool {
+	return t.Status == TaskStatusCompleted ||
+		t.Status == TaskStatusFailed ||
+		t.Status == TaskStatusCancelled
 }
 
 // CanTransitionTo 检查状态转换是否有效
-// 只允许从 processing 转换到 completed 或 failed
+// queued 可以开始处理、直接失败或被取消；processing 可以转换到 completed、failed 或 cancelled
 func (t *GenerationTask) CanTransitionTo(newStatus TaskStatus) bool {
-	if t.Status == TaskStatusProcessing {
-		return newStatus == TaskStatusCompleted || newStatus == TaskStatusFailed
+	switch t.Status {
+	case TaskStatusQueued:
+		return newStatus == TaskStatusProcessing || newStatus == TaskStatusFailed || newStatus == TaskStatusCancelled
+	case TaskStatusProcessing:
+		return newStatus == TaskStatusCompleted || newStatus == TaskStatusFailed || newStatus == TaskStatusCancelled
 	}
 	return false
 }
 
+// StartTask 将排队中的任务标记为处理中
+func (t *GenerationTask) StartTask() bool {
+	if !t.CanTransitionTo(TaskStatusProcessing) {
+		return false
+	}
+	t.Status = TaskStatusProcessing
+	t.StartedAt = time.Now()
+	return true
+}
+
 // CompleteTask 将任务标记为完成
 func (t *GenerationTask) CompleteTask(imageURL string) bool {
 	if !t.CanTransitionTo(TaskStatusCompleted) {
@@ -90,6 +199,7 @@ func (t *GenerationTask) CompleteTask(imageURL string) bool {
 	}
 	t.Status = TaskStatusCompleted
 	t.ImageURL = imageURL
+	t.finish()
 	return true
 }
 
@@ -103,5 +213,23 @@ func (t *GenerationTask) FailTask(errorMsg string) bool {
 	}
 	t.Status = TaskStatusFailed
 	t.ErrorMsg = errorMsg
+	t.finish()
 	return true
 }
+
+// CancelTask 将任务标记为已取消
+func (t *GenerationTask) CancelTask() bool {
+	if !t.CanTransitionTo(TaskStatusCancelled) {
+		return false
+	}
+	t.Status = TaskStatusCancelled
+	t.ErrorMsg = TaskCancelledMessage
+	t.finish()
+	return true
+}
+
+// finish 记录任务结束时间
+func (t *GenerationTask) finish() {
+	now := time.Now()
+	t.FinishedAt = &now
+}
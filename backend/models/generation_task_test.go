@@ -33,10 +33,12 @@ func TestProperty_TaskCompletionStateTransition(t *testing.T) {
 		// 1. CompleteTask should succeed for processing tasks with non-empty imageURL
 		// 2. Status should be "completed"
 		// 3. ImageURL should be the provided value (non-empty)
+		// 4. FinishedAt should be recorded
 		return success &&
 			task.Status == TaskStatusCompleted &&
 			task.ImageURL == imageURL &&
-			task.ImageURL != ""
+			task.ImageURL != "" &&
+			task.FinishedAt != nil
 	}
 
 	if err := quick.Check(property, &quick.Config{MaxCount: 100}); err != nil {
@@ -146,6 +148,30 @@ func TestProperty_FailedTaskCannotTransition(t *testing.T) {
 	}
 }
 
+// Test that queued and processing tasks can be cancelled, and cancelled tasks are final
+func TestCancelTask(t *testing.T) {
+	for _, status := range []TaskStatus{TaskStatusQueued, TaskStatusProcessing} {
+		task := &GenerationTask{TaskID: "test-task-id", Status: status}
+		if !task.CancelTask() {
+			t.Fatalf("%s 任务应可以取消", status)
+		}
+		if task.Status != TaskStatusCancelled || task.ErrorMsg != TaskCancelledMessage {
+			t.Errorf("取消后状态不正确: %s / %s", task.Status, task.ErrorMsg)
+		}
+		if !task.IsFinished() {
+			t.Error("已取消的任务应视为已结束")
+		}
+		if task.CanTransitionTo(TaskStatusProcessing) || task.CanTransitionTo(TaskStatusCompleted) || task.CanTransitionTo(TaskStatusFailed) {
+			t.Error("已取消的任务不应再转换状态")
+		}
+	}
+
+	completed := &GenerationTask{TaskID: "test-task-id", Status: TaskStatusCompleted}
+	if completed.CancelTask() {
+		t.Error("已完成的任务不应可以取消")
+	}
+}
+
 // Test that empty values are rejected
 func TestProperty_EmptyValuesRejected(t *testing.T) {
 	// Test that CompleteTask rejects empty imageURL
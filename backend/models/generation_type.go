@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+)
+
+// GenerationTypeDef 生成类型定义
+// 内置的五种类型启动时写入数据库，团队可以通过接口新增自定义类型；
+// 生成、历史、任务等接口只接受已注册的类型
+type GenerationTypeDef struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Type      string    `json:"type" gorm:"uniqueIndex;not null"` // 类型标识，即请求和记录中的 type 字段
+	Name      string    `json:"name"`                             // 显示名称
+	// 必需的参考图槽位（按上传顺序），生成时至少需要同样数量的参考图
+	RefImageSlots      []string `json:"ref_image_slots" gorm:"serializer:json"`
+	DefaultAspectRatio string   `json:"default_aspect_ratio"` // 请求未指定比例时使用
+	DefaultImageSize   string   `json:"default_image_size"`   // 请求未指定尺寸时使用
+	BuiltIn            bool     `json:"built_in" gorm:"default:false"`
+}
+
+// TableName 指定表名
+func (GenerationTypeDef) TableName() string {
+	return "generation_types"
+}
+
+// BuiltinGenerationTypes 内置生成类型，启动时写入数据库（已存在的不覆盖）
+func BuiltinGenerationTypes() []GenerationTypeDef {
+	return []GenerationTypeDef{
+		{Type: GenerationTypeCreate, Name: "创作空间", RefImageSlots: []string{}},
+		{Type: GenerationTypeWhiteBackground, Name: "白底图", RefImageSlots: []string{"产品图"}},
+		{Type: GenerationTypeClothingChange, Name: "换装", RefImageSlots: []string{"模特图", "服装图"}},
+		{Type: GenerationTypeProductScene, Name: "一键商品图", RefImageSlots: []string{"产品图"}},
+		{Type: GenerationTypeLightShadow, Name: "光影融合", RefImageSlots: []string{"产品图"}},
+	}
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ImportJob 批量导入任务（CSV / JSON 清单 + 参考图 zip）
+// 清单中每一行对应一个排队中的 GenerationTask，进度由这些任务的状态汇总
+type ImportJob struct {
+	gorm.Model
+	JobID        string `json:"job_id" gorm:"uniqueIndex;not null"`
+	ManifestName string `json:"manifest_name"` // 上传的清单文件名
+	Total        int    `json:"total"`         // 成功创建的任务数
+	Skipped      string `json:"skipped"`       // JSON array of ImportRowError，未能导入的行
+}
+
+// ImportJobItem 清单中的一行，关联 SKU 与生成任务
+type ImportJobItem struct {
+	ID          uint   `json:"id" gorm:"primarykey"`
+	JobID       string `json:"job_id" gorm:"index;not null"`
+	Row         int    `json:"row"` // 清单中的行号（从 1 开始，不含表头）
+	SKU         string `json:"sku" gorm:"index"`
+	ProductName string `json:"product_name"`
+	Scene       string `json:"scene"`
+	TaskID      string `json:"task_id" gorm:"index"`
+}
+
+// ImportRowError 清单中无法导入的行
+type ImportRowError struct {
+	Row   int    `json:"row"`
+	SKU   string `json:"sku,omitempty"`
+	Error string `json:"error"`
+}
+
+// ImportJobProgress 导入任务进度
+type ImportJobProgress struct {
+	JobID      string           `json:"job_id"`
+	Status     string           `json:"status"` // processing | completed
+	Total      int              `json:"total"`
+	Queued     int              `json:"queued"`
+	Processing int              `json:"processing"`
+	Completed  int              `json:"completed"`
+	Failed     int              `json:"failed"`
+	Cancelled  int              `json:"cancelled"`
+	Skipped    []ImportRowError `json:"skipped"`
+	CreatedAt  time.Time        `json:"created_at"`
+}
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"time"
+)
+
+// PromptTemplate 提示词模板
+// 按生成类型分组，每个类型至多一个默认模板；占位符格式为 {name}
+// 内置模板可以修改或恢复默认，但不能删除
+type PromptTemplate struct {
+	ID         uint      `json:"id" gorm:"primarykey"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	TemplateID string    `json:"template_id" gorm:"uniqueIndex;not null"` // 模板标识，如 product_scene.default
+	Type       string    `json:"type" gorm:"index;not null"`              // 生成类型
+	Name       string    `json:"name"`
+	Template   string    `json:"template" gorm:"not null"`
+	IsDefault  bool      `json:"is_default" gorm:"default:false"` // 请求未指定模板时使用该类型的默认模板
+	BuiltIn    bool      `json:"built_in" gorm:"default:false"`
+	// 模板中的占位符（不入库，由接口返回）
+	Placeholders []string `json:"placeholders" gorm:"-"`
+}
+
+// BuiltinPromptTemplates 内置提示词模板，启动时写入数据库（已存在的不覆盖）
+// 占位符：{product} 商品名称、{scene} 使用场景
+func BuiltinPromptTemplates() []PromptTemplate {
+	return []PromptTemplate{
+		{
+			TemplateID: "white_background.default",
+			Type:       GenerationTypeWhiteBackground,
+			Name:       "白底图",
+			Template:   "不要修改图中的产品和位置，生成产品的白底图",
+			IsDefault:  true,
+		},
+		{
+			TemplateID: "white_background.remove_shadow",
+			Type:       GenerationTypeWhiteBackground,
+			Name:       "白底图（去除光影）",
+			Template:   "不要修改图中的产品和位置，生成产品的白底图，去掉产品表面所有光影反射",
+		},
+		{
+			TemplateID: "clothing_change.default",
+			Type:       GenerationTypeClothingChange,
+			Name:       "换装",
+			Template:   "请你不要修改图一模特的姿势保持模特不变，将图一角色的衣服替换成图二的，需要符合图二衣服的上身逻辑",
+			IsDefault:  true,
+		},
+		{
+			TemplateID: "product_scene.default",
+			Type:       GenerationTypeProductScene,
+			Name:       "商品场景图",
+			Template:   "请你给图中{product}，生成在{scene}的使用场景图，需要符合透视逻辑和使用方法，保证产品{product}的一致性，不要发生产品细节偏移和变化",
+			IsDefault:  true,
+		},
+		{
+			TemplateID: "light_shadow.default",
+			Type:       GenerationTypeLightShadow,
+			Name:       "光影融合",
+			Template:   "不要改变画面中其余内容，增加{product}的光影真实性和场景保持一致",
+			IsDefault:  true,
+		},
+	}
+}
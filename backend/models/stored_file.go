@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+)
+
+// 内容寻址存储的文件类别
+const (
+	StoredFileKindUpload = "upload" // 上传的参考图（UploadDir）
+	StoredFileKindOutput = "output" // 生成的图片（OutputDir）
+)
+
+// StoredFile 按内容 SHA-256 存储的文件
+// 相同内容只保存一份，文件名为 <sha256><扩展名>；引用数不在记录中保存，需要时由任务和历史记录中的引用统计
+type StoredFile struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Kind      string    `json:"kind" gorm:"uniqueIndex:idx_stored_file_hash;not null"`
+	Hash      string    `json:"hash" gorm:"uniqueIndex:idx_stored_file_hash;not null"`
+	Path      string    `json:"path" gorm:"uniqueIndex;not null"` // 相对路径：uploads/xxx.png 或 images/xxx.png
+	Size      int64     `json:"size"`
+}
@@ -0,0 +1,64 @@
+package providers
+
+import (
+	"crypto/tls"
+	"net/http"
+	"time"
+
+	"sigma/config"
+)
+
+// Aiaimi Aiaimi 平台
+type Aiaimi struct {
+	geminiProvider
+	baseURL string
+	user    string
+	key     string
+}
+
+// NewAiaimi 创建 Aiaimi 供应商
+func NewAiaimi() *Aiaimi {
+	return &Aiaimi{
+		geminiProvider: newGeminiProvider(func() string { return config.AiaimiServiceURL }),
+		baseURL:        "https://aiaimi.cc",
+		user:           decodeHex("33"),                                                       // "3"
+		key:            decodeHex("77485a584d356f6e63674a6e6d444f7776473842696a58756e42584d"), // "wHZXM5oncgJnmDOwvG8BijXunBXM"
+	}
+}
+
+// Platform 平台标识
+func (a *Aiaimi) Platform() config.PlatformType {
+	return config.PlatformAiaimi
+}
+
+// CheckBalance 验证 Aiaimi 平台的 API Key
+func (a *Aiaimi) CheckBalance(apiKey string) TokenValidationResult {
+	// Aiaimi 需要跳过 TLS 验证
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+		Timeout: 8 * time.Second,
+	}
+	info, ok := tokenSearch(client, a.baseURL, "New-Api-User", a.user, a.key, apiKey)
+	if !ok {
+		return TokenValidationResult{Valid: false}
+	}
+
+	// Aiaimi 算法（1 元 = 500000 额度，1.5 元/张）
+	pricing := Pricing{QuotaPerUnit: 500000, UnitPrice: 1.5}
+	remainQuota, usedQuota, name := quotaFields(info)
+	remainSheets := pricing.Images(remainQuota)
+	usedSheets := pricing.Images(usedQuota)
+
+	return TokenValidationResult{
+		Valid:    true,
+		Name:     name,
+		Remain:   remainSheets,
+		Used:     usedSheets,
+		Platform: string(config.PlatformAiaimi),
+
+		RemainQuota: remainQuota,
+		Pricing:     pricing,
+	}
+}
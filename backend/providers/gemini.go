@@ -0,0 +1,231 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"sigma/types"
+	"sigma/utils"
+)
+
+// geminiProvider Gemini generateContent 兼容协议的通用实现
+// VectorEngine 与 Aiaimi 都使用该协议，只是地址和余额查询方式不同
+type geminiProvider struct {
+	// endpoint 返回生成接口地址（运行时读取，便于环境变量覆盖）
+	endpoint func() string
+	client   *http.Client
+}
+
+// RequestTimeout 单次生成请求的超时时间（15 分钟），给 AI API 足够的处理时间
+const RequestTimeout = 900 * time.Second
+
+func newGeminiProvider(endpoint func() string) geminiProvider {
+	return geminiProvider{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: RequestTimeout},
+	}
+}
+
+// BuildRequest 构建 Gemini 风格的 AIRequest，使用 Bearer Token 鉴权
+func (g geminiProvider) BuildRequest(ctx context.Context, apiKey string, req GenerateRequest) (*http.Request, error) {
+	parts := []types.Part{{Text: req.Prompt}}
+	for _, ref := range req.RefImages {
+		parts = append(parts, types.Part{InlineData: &types.InlineData{
+			MimeType: ref.MimeType,
+			Data:     base64.StdEncoding.EncodeToString(ref.Data),
+		}})
+	}
+
+	payloadObj := types.AIRequest{
+		Contents: []types.Content{{Role: "user", Parts: parts}},
+		GenerationConfig: types.GenerationConfig{
+			ResponseModalities: []string{"TEXT", "IMAGE"},
+			ImageConfig: &types.ImageConfig{
+				AspectRatio: req.AspectRatio,
+				ImageSize:   req.ImageSize,
+			},
+		},
+	}
+	payloadBytes, err := json.Marshal(payloadObj)
+	if err != nil {
+		return nil, fmt.Errorf("序列化请求失败: %w", err)
+	}
+
+	apiURL := g.endpoint()
+	utils.LogAPIRequest("POST", apiURL, payloadObj)
+	utils.LogJSON("Generate Request", payloadObj)
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+apiKey)
+	return httpReq, nil
+}
+
+// Send 发送请求并读取完整响应体
+func (g geminiProvider) Send(req *http.Request) (*Response, error) {
+	requestStartTime := time.Now()
+	resp, err := g.client.Do(req)
+	duration := time.Since(requestStartTime)
+	if err != nil {
+		utils.LogAPIResponse(0, duration, nil, err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取响应失败: %w", err)
+	}
+
+	var respMap map[string]interface{}
+	if err := json.Unmarshal(body, &respMap); err == nil {
+		utils.LogAPIResponse(resp.StatusCode, duration, respMap, nil)
+		utils.LogJSON("Generate Response", respMap)
+		utils.LogResponseStructureWithStatus("Response Structure", respMap, resp.StatusCode)
+	} else {
+		utils.LogAPI("响应解析失败，原始响应: Status=%d, Body=%s", resp.StatusCode, string(body))
+	}
+
+	return &Response{StatusCode: resp.StatusCode, Body: body, Duration: duration}, nil
+}
+
+// ParseResponse 解析 candidates[0] 中的图片
+// 支持 inlineData 以及 text 中的 markdown 图片（http(s) 或 data URL）
+func (g geminiProvider) ParseResponse(resp *Response) ([]Image, error) {
+	if resp.StatusCode != 200 {
+		var apiError struct {
+			Error struct {
+				Message string `json:"message"`
+				Type    string `json:"type"`
+			} `json:"error"`
+		}
+		errorMessage := fmt.Sprintf("API 请求失败，状态码: %d", resp.StatusCode)
+		if err := json.Unmarshal(resp.Body, &apiError); err == nil && apiError.Error.Message != "" {
+			errorMessage = apiError.Error.Message
+		}
+		return nil, &APIError{StatusCode: resp.StatusCode, Message: errorMessage}
+	}
+
+	var aiResp types.AIResponse
+	if err := json.Unmarshal(resp.Body, &aiResp); err != nil {
+		return nil, fmt.Errorf("解析响应失败: %w", err)
+	}
+	if len(aiResp.Candidates) == 0 {
+		return nil, ErrEmptyResponse
+	}
+
+	var images []Image
+	for _, part := range aiResp.Candidates[0].Content.Parts {
+		// 方式1: inlineData 格式（base64 图片）
+		if part.InlineData != nil && part.InlineData.Data != "" {
+			images = append(images, Image{MimeType: part.InlineData.MimeType, Base64: part.InlineData.Data})
+			continue
+		}
+		// 方式2: text 字段中的 markdown 图片 URL
+		if part.Text != "" {
+			if imageURL := extractImageURLFromMarkdown(part.Text); imageURL != "" {
+				images = append(images, Image{URL: imageURL})
+			}
+		}
+	}
+	if len(images) == 0 {
+		return nil, ErrNoImage
+	}
+	return images, nil
+}
+
+var markdownImageRe = regexp.MustCompile(`!\[.*?\]\(((?:https?://[^\s\)]+|data:image/[^;]+;base64,[^\s\)]+))\)`)
+
+// extractImageURLFromMarkdown 从 markdown 文本中提取图片 URL
+// 支持格式:
+// 1. ![image](https://...) - HTTP/HTTPS URL
+// 2. ![image](data:image/jpeg;base64,...) - Base64 data URL
+func extractImageURLFromMarkdown(text string) string {
+	matches := markdownImageRe.FindStringSubmatch(text)
+	if len(matches) > 1 {
+		return matches[1]
+	}
+	return ""
+}
+
+// tokenSearch 查询 new-api 风格的 /api/token/search 接口，返回第一条 token 信息
+func tokenSearch(client *http.Client, baseURL, userHeader, user, key, apiKey string) (map[string]interface{}, bool) {
+	url := fmt.Sprintf("%s/api/token/search?keyword=&token=%s", baseURL, apiKey)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, false
+	}
+
+	// 设置验证头
+	req.Header.Set(userHeader, user)
+	req.Header.Set("Authorization", key)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, false
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false
+	}
+
+	// 解析响应
+	var result map[string]interface{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, false
+	}
+
+	if result["success"] != true {
+		return nil, false
+	}
+
+	dataList, ok := result["data"].([]interface{})
+	if !ok || len(dataList) == 0 {
+		return nil, false
+	}
+
+	info, ok := dataList[0].(map[string]interface{})
+	return info, ok
+}
+
+// quotaFields 读取 remain_quota / used_quota / name 字段
+func quotaFields(info map[string]interface{}) (remainQuota, usedQuota float64, name string) {
+	if v, ok := info["remain_quota"].(float64); ok {
+		remainQuota = v
+	}
+	if v, ok := info["used_quota"].(float64); ok {
+		usedQuota = v
+	}
+	name = "未命名"
+	if n, ok := info["name"].(string); ok && n != "" {
+		name = n
+	}
+	return remainQuota, usedQuota, name
+}
+
+// decodeHex 解码十六进制字符串
+func decodeHex(hexStr string) string {
+	b, err := hex.DecodeString(hexStr)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
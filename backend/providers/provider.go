@@ -0,0 +1,169 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"sigma/config"
+)
+
+// ErrEmptyResponse 模型未返回任何候选内容
+var ErrEmptyResponse = errors.New("模型未返回内容")
+
+// ErrNoImage 请求成功但响应中没有图片
+var ErrNoImage = errors.New("请求成功但未返回图片，请修改提示词后重试")
+
+// RefImage 参考图（原始字节）
+type RefImage struct {
+	MimeType string
+	Data     []byte
+}
+
+// GenerateRequest 与供应商无关的生成参数
+type GenerateRequest struct {
+	Prompt      string
+	RefImages   []RefImage
+	AspectRatio string
+	ImageSize   string
+}
+
+// Response 供应商原始响应
+type Response struct {
+	StatusCode int
+	Body       []byte
+	Duration   time.Duration
+}
+
+// Image 供应商返回的单张图片
+// Base64 与 URL 二选一：URL 可能是 http(s) 地址，也可能是 data URL
+type Image struct {
+	MimeType string
+	Base64   string
+	URL      string
+}
+
+// APIError 供应商返回的非 200 错误
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// TokenValidationResult 验证结果
+type TokenValidationResult struct {
+	Valid    bool
+	Name     string
+	Remain   float64 // 剩余张数（按 2K 图片计）
+	Used     float64
+	Group    string // 分组信息
+	Platform string // 内部使用，不暴露给前端
+	// 计价信息（用于记录消耗）
+	RemainQuota float64 // 剩余额度（供应商原始单位）
+	Pricing     Pricing
+}
+
+// Pricing 平台计价
+type Pricing struct {
+	QuotaPerUnit float64 // 每 1 元对应的额度（供应商原始单位）
+	UnitPrice    float64 // 每张 2K 图片的价格（元）
+}
+
+// Images 额度可生成的 2K 图片张数
+func (p Pricing) Images(quota float64) float64 {
+	if p.QuotaPerUnit <= 0 || p.UnitPrice <= 0 {
+		return 0
+	}
+	return quota / p.QuotaPerUnit / p.UnitPrice
+}
+
+// Provider 图片生成供应商
+// 新增供应商只需实现该接口并调用 Register，无需改动 handler 代码
+type Provider interface {
+	// Platform 平台标识，与 config.PlatformType 对应
+	Platform() config.PlatformType
+	// BuildRequest 根据生成参数构建 HTTP 请求
+	// 请求绑定 ctx，取消 ctx 会中断正在进行的上游调用
+	BuildRequest(ctx context.Context, apiKey string, req GenerateRequest) (*http.Request, error)
+	// Send 发送请求并读取完整响应
+	Send(req *http.Request) (*Response, error)
+	// ParseResponse 将响应解析为图片列表，非 200 响应返回 *APIError
+	ParseResponse(resp *Response) ([]Image, error)
+	// CheckBalance 验证 API Key 并查询余额
+	CheckBalance(apiKey string) TokenValidationResult
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   []Provider
+)
+
+// Register 注册供应商，验证 API Key 时按注册顺序依次尝试
+func Register(p Provider) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for i, existing := range registry {
+		if existing.Platform() == p.Platform() {
+			registry[i] = p
+			return
+		}
+	}
+	registry = append(registry, p)
+}
+
+// All 返回所有已注册的供应商（按注册顺序）
+func All() []Provider {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	list := make([]Provider, len(registry))
+	copy(list, registry)
+	return list
+}
+
+// Get 按平台标识获取供应商
+func Get(platform config.PlatformType) (Provider, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	for _, p := range registry {
+		if p.Platform() == platform {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("未注册的平台: %s", platform)
+}
+
+// Current 返回当前 API Key 所属平台的供应商
+// 平台未知时回退到第一个注册的供应商（VectorEngine）
+func Current() Provider {
+	if p, err := Get(config.GetAPIPlatform()); err == nil {
+		return p
+	}
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	if len(registry) == 0 {
+		return nil
+	}
+	return registry[0]
+}
+
+// ValidateAll 依次尝试所有供应商验证 API Key
+func ValidateAll(apiKey string) TokenValidationResult {
+	for _, p := range All() {
+		if result := p.CheckBalance(apiKey); result.Valid {
+			return result
+		}
+	}
+	return TokenValidationResult{Valid: false}
+}
+
+func init() {
+	// 注册顺序即验证顺序：先 VectorEngine，再 Aiaimi
+	Register(NewVectorEngine())
+	Register(NewAiaimi())
+}
@@ -0,0 +1,77 @@
+package providers
+
+import (
+	"errors"
+	"testing"
+
+	"sigma/config"
+)
+
+func TestRegistry_BuiltinOrder(t *testing.T) {
+	list := All()
+	if len(list) < 2 {
+		t.Fatalf("期望至少注册 2 个供应商，实际为 %d", len(list))
+	}
+	if list[0].Platform() != config.PlatformVectorEngine {
+		t.Errorf("第一个供应商应为 vectorengine，实际为 %s", list[0].Platform())
+	}
+	if list[1].Platform() != config.PlatformAiaimi {
+		t.Errorf("第二个供应商应为 aiaimi，实际为 %s", list[1].Platform())
+	}
+	if _, err := Get(config.PlatformUnknown); err == nil {
+		t.Error("未注册的平台应返回错误")
+	}
+}
+
+func TestGeminiParseResponse_InlineAndMarkdown(t *testing.T) {
+	g := newGeminiProvider(func() string { return "" })
+	body := `{"candidates":[{"content":{"parts":[
+		{"text":"here ![image](https://example.com/a.png)"},
+		{"inlineData":{"mimeType":"image/png","data":"aGVsbG8="}}
+	]}}]}`
+
+	images, err := g.ParseResponse(&Response{StatusCode: 200, Body: []byte(body)})
+	if err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+	if len(images) != 2 {
+		t.Fatalf("期望 2 张图片，实际为 %d", len(images))
+	}
+	if images[0].URL != "https://example.com/a.png" {
+		t.Errorf("markdown URL 解析错误: %s", images[0].URL)
+	}
+	if images[1].Base64 != "aGVsbG8=" || images[1].MimeType != "image/png" {
+		t.Errorf("inlineData 解析错误: %+v", images[1])
+	}
+}
+
+func TestGeminiParseResponse_Errors(t *testing.T) {
+	g := newGeminiProvider(func() string { return "" })
+
+	_, err := g.ParseResponse(&Response{StatusCode: 429, Body: []byte(`{"error":{"message":"余额不足"}}`)})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 429 || apiErr.Message != "余额不足" {
+		t.Errorf("非 200 响应应返回 APIError，实际为 %v", err)
+	}
+
+	if _, err := g.ParseResponse(&Response{StatusCode: 200, Body: []byte(`{"candidates":[]}`)}); !errors.Is(err, ErrEmptyResponse) {
+		t.Errorf("空 candidates 应返回 ErrEmptyResponse，实际为 %v", err)
+	}
+
+	body := `{"candidates":[{"content":{"parts":[{"text":"no image"}]}}]}`
+	if _, err := g.ParseResponse(&Response{StatusCode: 200, Body: []byte(body)}); !errors.Is(err, ErrNoImage) {
+		t.Errorf("无图片应返回 ErrNoImage，实际为 %v", err)
+	}
+}
+
+func TestPricing_Images(t *testing.T) {
+	if images := vectorEnginePricing("限时特价").Images(990000); images < 9.99 || images > 10.01 {
+		t.Errorf("限时特价分组 990000 额度应为 10 张，实际为 %v", images)
+	}
+	if images := vectorEnginePricing("").Images(165000); images < 0.99 || images > 1.01 {
+		t.Errorf("默认分组 165000 额度应为 1 张，实际为 %v", images)
+	}
+	if images := (Pricing{}).Images(1000); images != 0 {
+		t.Errorf("计价未知时应返回 0，实际为 %v", images)
+	}
+}
@@ -0,0 +1,76 @@
+package providers
+
+import (
+	"math"
+	"net/http"
+	"time"
+
+	"sigma/config"
+)
+
+// VectorEngine VectorEngine 平台（默认平台）
+type VectorEngine struct {
+	geminiProvider
+	baseURL string
+	user    string
+	key     string
+}
+
+// NewVectorEngine 创建 VectorEngine 供应商
+func NewVectorEngine() *VectorEngine {
+	return &VectorEngine{
+		geminiProvider: newGeminiProvider(func() string { return config.AIServiceURL }),
+		baseURL:        "https://api.vectorengine.ai",
+		user:           decodeHex("313432353338"),
+		key:            decodeHex("30736f684368584a764a51394579444c5a3738637879334b796f41786956733d"),
+	}
+}
+
+// Platform 平台标识
+func (v *VectorEngine) Platform() config.PlatformType {
+	return config.PlatformVectorEngine
+}
+
+// CheckBalance 验证 VectorEngine 平台的 API Key
+func (v *VectorEngine) CheckBalance(apiKey string) TokenValidationResult {
+	client := &http.Client{Timeout: 5 * time.Second}
+	info, ok := tokenSearch(client, v.baseURL, "new-api-user", v.user, v.key, apiKey)
+	if !ok {
+		return TokenValidationResult{Valid: false}
+	}
+
+	// 获取分组信息
+	group := ""
+	if g, ok := info["group"].(string); ok {
+		group = g
+	}
+
+	// VectorEngine 算法
+	pricing := vectorEnginePricing(group)
+	remainQuota, usedQuota, name := quotaFields(info)
+	remainSheets := math.Round(pricing.Images(remainQuota))
+	usedSheets := math.Round(pricing.Images(usedQuota))
+
+	return TokenValidationResult{
+		Valid:    true,
+		Name:     name,
+		Remain:   remainSheets,
+		Used:     usedSheets,
+		Group:    group,
+		Platform: string(config.PlatformVectorEngine),
+
+		RemainQuota: remainQuota,
+		Pricing:     pricing,
+	}
+}
+
+// vectorEnginePricing 根据分组确定单张成本（1 元 = 1000000 额度）
+// 限时特价: 0.099/张
+// 优质gemini（默认）: 0.165/张
+func vectorEnginePricing(group string) Pricing {
+	pricing := Pricing{QuotaPerUnit: 1000000, UnitPrice: 0.165}
+	if group == "限时特价" {
+		pricing.UnitPrice = 0.099
+	}
+	return pricing
+}
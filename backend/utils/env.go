@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -16,6 +17,19 @@ func GetEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
+// GetEnvIntOrDefault 获取整数环境变量，未设置或格式错误时返回默认值
+func GetEnvIntOrDefault(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return defaultValue
+	}
+	return parsed
+}
+
 // GetBaseURL 获取基础 URL（用于生成图片和参考图的访问路径）
 // 优先级：BASE_URL 环境变量 > ACTUAL_PORT > config.ActualPort > 传入的 port 参数
 func GetBaseURL(port string) string {
@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// placeholderRe 提示词模板占位符，格式为 {name}，name 由字母、数字、下划线组成
+var placeholderRe = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// TemplatePlaceholders 返回模板中出现的占位符名称（去重，按首次出现顺序）
+func TemplatePlaceholders(template string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, match := range placeholderRe.FindAllStringSubmatch(template, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			names = append(names, match[1])
+		}
+	}
+	return names
+}
+
+// RenderPrompt 用 values 替换模板中的占位符
+// 模板中的占位符缺少取值时返回错误；未在模板中出现的取值会被忽略
+func RenderPrompt(template string, values map[string]string) (string, error) {
+	var missing []string
+	rendered := placeholderRe.ReplaceAllStringFunc(template, func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+		value, ok := values[name]
+		if !ok {
+			missing = append(missing, name)
+			return placeholder
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return "", fmt.Errorf("缺少占位符取值: %s", strings.Join(missing, ", "))
+	}
+	return rendered, nil
+}
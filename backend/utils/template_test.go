@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTemplatePlaceholders(t *testing.T) {
+	got := TemplatePlaceholders("{product} 放在 {scene} 中，{product} 居中，{ 不是占位符 }")
+	want := []string{"product", "scene"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("期望 %v，实际为 %v", want, got)
+	}
+}
+
+func TestRenderPrompt(t *testing.T) {
+	got, err := RenderPrompt("{product} on {scene}", map[string]string{"product": "杯子", "scene": "海滩", "unused": "x"})
+	if err != nil {
+		t.Fatalf("渲染失败: %v", err)
+	}
+	if got != "杯子 on 海滩" {
+		t.Errorf("渲染结果不正确: %s", got)
+	}
+
+	if _, err := RenderPrompt("{product} on {scene}", map[string]string{"product": "杯子"}); err == nil {
+		t.Error("缺少取值时应返回错误")
+	}
+}
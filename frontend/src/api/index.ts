@@ -360,14 +360,6 @@ export const api = {
     });
   },
 
-  // 增加生成计数
-  async incrementGenerationCount(): Promise<Response> {
-    const baseUrl = await getCachedApiUrl();
-    return fetch(`${baseUrl}/stats/increment-count`, {
-      method: 'POST',
-    });
-  },
-
   // 获取白底图历史记录
   async getWhiteBackgroundHistory(): Promise<Response> {
     const baseUrl = await getCachedApiUrl();
@@ -469,6 +461,8 @@ export const api = {
     const baseUrl = await getCachedApiUrl();
     const url = `${baseUrl}/generate`;
     const startTime = Date.now();
+    // 后端多图批次默认立即返回 task_id，需显式请求 SSE 推送进度
+    formData.set('stream', 'true');
     
     // 记录请求
     const formDataInfo: Record<string, string> = {};
@@ -13,7 +13,6 @@ import { useGenerationView } from '../hooks/useGenerationView';
 import { GenerationType } from '../type';
 import { useToast } from '../context/ToastContext';
 import { getImageAspectRatio } from '../utils/aspectRatio';
-import { buildLightShadowPrompt } from '../utils/promptBuilder';
 import { api } from '../api';
 
 export default function LightShadow() {
@@ -89,13 +88,12 @@ export default function LightShadow() {
       return;
     }
 
-    const prompt = buildLightShadowPrompt(productName.trim());
-
     try {
       const aspectRatio = await getImageAspectRatio(uploadedFile);
       
       const formData = new FormData();
-      formData.append('prompt', prompt);
+      // 提示词由后端按 light_shadow 模板渲染
+      formData.append('productName', productName.trim());
       formData.append('aspectRatio', aspectRatio);
       formData.append('imageSize', '2K');
       formData.append('type', GenerationType.LIGHT_SHADOW);
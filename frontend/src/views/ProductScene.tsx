@@ -13,7 +13,6 @@ import { useGenerationView } from '../hooks/useGenerationView';
 import { GenerationType } from '../type';
 import { useToast } from '../context/ToastContext';
 import { getImageAspectRatio } from '../utils/aspectRatio';
-import { buildProductScenePrompt } from '../utils/promptBuilder';
 import { api } from '../api';
 
 export default function ProductScene() {
@@ -99,7 +98,9 @@ export default function ProductScene() {
       const aspectRatio = await getImageAspectRatio(uploadedFile);
       
       const formData = new FormData();
-      formData.append('prompt', buildProductScenePrompt(productName.trim(), sceneDescription.trim()));
+      // 提示词由后端按 product_scene 模板渲染
+      formData.append('productName', productName.trim());
+      formData.append('scene', sceneDescription.trim());
       formData.append('aspectRatio', aspectRatio);
       formData.append('imageSize', '2K');
       formData.append('type', GenerationType.PRODUCT_SCENE);